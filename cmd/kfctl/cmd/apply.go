@@ -16,10 +16,14 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 
 	ep "github.com/jlewi/cloud-endpoints-controller/pkg"
 	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
 	"github.com/kubeflow/kfctl/v3/pkg/kfapp/coordinator"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	kfloaders "github.com/kubeflow/kfctl/v3/pkg/kfconfig/loaders"
 	"github.com/kubeflow/kfctl/v3/pkg/kfupgrade"
 	"github.com/kubeflow/kfctl/v3/pkg/utils"
 	log "github.com/sirupsen/logrus"
@@ -65,11 +69,23 @@ var applyCmd = &cobra.Command{
 		}
 		switch kind {
 		case string(kftypes.KFDEF):
-			kfApp, err = coordinator.NewLoadKfAppFromURI(configFilePath)
+			ctx, stop := interruptContext()
+			defer stop()
+			if applyCfg.GetBool(string(kftypes.LOCKED)) {
+				lock, err := readLock(configFilePath)
+				if err != nil {
+					return err
+				}
+				ctx = kfconfig.WithLock(ctx, lock)
+			}
+			app, err := coordinator.NewLoadKfAppFromURIContext(ctx, configFilePath)
 			if err != nil {
 				return fmt.Errorf("failed to build kfApp from URI %s: %v", configFilePath, err)
 			}
-			if err := kfApp.Apply(kftypes.ALL); err != nil {
+			kfApp = app
+			progress := newProgressDisplay()
+			defer progress.finish()
+			if err := app.ApplyContext(kftypes.WithProgressSink(ctx, progress), kftypes.ALL); err != nil {
 				return fmt.Errorf("failed to apply: %s", err)
 			}
 			log.Info("Applied the configuration Successfully!")
@@ -94,6 +110,20 @@ var applyCmd = &cobra.Command{
 	},
 }
 
+// readLock reads the LockFile of the app directory of the KfDef configFile.
+func readLock(configFile string) (*kfconfig.Lock, error) {
+	config, err := kfloaders.LoadConfigFromURI(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load %v: %v", configFile, err)
+	}
+	lockFile := filepath.Join(config.Spec.AppDir, kfconfig.LockFile)
+	lockBytes, err := ioutil.ReadFile(lockFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read lock %v; write it with kfctl build --%v: %v", lockFile, kftypes.LOCK, err)
+	}
+	return kfconfig.ParseLock(lockBytes)
+}
+
 func init() {
 	rootCmd.AddCommand(applyCmd)
 
@@ -115,10 +145,19 @@ func init() {
 	applyCmd.Flags().BoolP(string(kftypes.VERBOSE), "V", false,
 		string(kftypes.VERBOSE)+" output default is false")
 
+	// locked flag
+	applyCmd.Flags().Bool(string(kftypes.LOCKED), false,
+		"pin the repos and images of the KF App to the versions "+kfconfig.LockFile+" in the app directory locks them at, failing if they can't be, default is false")
+
 	applyCmd.Flags().StringVar(&kubeContext, "context", "", "Optional kubernetes context to use when applying resources. Currently not used by KFDef resources.")
 	bindErr := applyCfg.BindPFlag(string(kftypes.VERBOSE), applyCmd.Flags().Lookup(string(kftypes.VERBOSE)))
 	if bindErr != nil {
 		log.Errorf("Couldn't set flag --%v: %v", string(kftypes.VERBOSE), bindErr)
 		return
 	}
+	bindErr = applyCfg.BindPFlag(string(kftypes.LOCKED), applyCmd.Flags().Lookup(string(kftypes.LOCKED)))
+	if bindErr != nil {
+		log.Errorf("Couldn't set flag --%v: %v", string(kftypes.LOCKED), bindErr)
+		return
+	}
 }
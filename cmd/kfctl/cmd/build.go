@@ -15,10 +15,19 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
 
 	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
 	"github.com/kubeflow/kfctl/v3/pkg/kfapp/coordinator"
+	"github.com/kubeflow/kfctl/v3/pkg/kfapp/kustomize"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	kfloaders "github.com/kubeflow/kfctl/v3/pkg/kfconfig/loaders"
 	"github.com/kubeflow/kfctl/v3/pkg/kfupgrade"
 	"github.com/kubeflow/kfctl/v3/pkg/utils"
 	log "github.com/sirupsen/logrus"
@@ -45,10 +54,12 @@ var buildCmd = &cobra.Command{
 			return fmt.Errorf("Cannot determine the object kind: %v", err)
 		}
 
-		var kfApp kftypes.KfApp
+		ctx, stop := interruptContext()
+		defer stop()
+		var kfApp kftypes.KfAppContext
 		switch kind {
 		case string(kftypes.KFDEF):
-			kfApp, err = coordinator.NewLoadKfAppFromURI(configFilePath)
+			kfApp, err = coordinator.NewLoadKfAppFromURIContext(ctx, configFilePath)
 			if err != nil {
 				return fmt.Errorf("failed to build kfApp from URI %s: %v", configFilePath, err)
 			}
@@ -66,13 +77,109 @@ var buildCmd = &cobra.Command{
 			return fmt.Errorf("Unsupported object kind: %v", kind)
 		}
 
-		if buildCfg.GetBool(string(kftypes.DUMP)) == true {
-			kfApp.Dump(kftypes.ALL)
+		dump := buildCfg.GetBool(string(kftypes.DUMP))
+		merged := buildCfg.GetBool(string(kftypes.MERGED))
+		if getter, ok := kfApp.(coordinator.KfConfigGetter); ok {
+			// Keep stdout for the manifests or the merged KfDef when printing them.
+			out := os.Stdout
+			if dump || merged {
+				out = os.Stderr
+			}
+			printParameters(out, getter.GetKfConfig())
+			if merged {
+				// The status is left out so the output can be used as a KfDef.
+				config := getter.GetKfConfig().DeepCopy()
+				config.Status = kfconfig.Status{}
+				kfdefBytes, err := kfloaders.MarshalKfDef(*config)
+				if err != nil {
+					return fmt.Errorf("couldn't print the merged KfDef: %v", err)
+				}
+				fmt.Print(string(kfdefBytes))
+			}
+		}
+		if buildCfg.GetBool(string(kftypes.LOCK)) {
+			getter, ok := kfApp.(coordinator.KfConfigGetter)
+			if !ok {
+				return fmt.Errorf("couldn't read the config of the KF App built from %v", configFilePath)
+			}
+			if err := writeLock(ctx, getter.GetKfConfig()); err != nil {
+				return err
+			}
+		}
+		if dump == true {
+			kfApp.DumpContext(ctx, kftypes.ALL)
 		}
 		return nil
 	},
 }
 
+// writeLock writes the lock of the repos config was built from and of the images it renders to the LockFile of
+// its app directory.
+func writeLock(ctx context.Context, config *kfconfig.KfConfig) error {
+	images, err := kustomize.RenderedImages(config)
+	if err != nil {
+		return fmt.Errorf("couldn't list the images of the KF App: %v", err)
+	}
+	lock, err := config.CreateLock(ctx, images)
+	if err != nil {
+		return fmt.Errorf("couldn't lock the KF App: %v", err)
+	}
+	lockBytes, err := lock.Marshal()
+	if err != nil {
+		return err
+	}
+	lockFile := filepath.Join(config.Spec.AppDir, kfconfig.LockFile)
+	if err := ioutil.WriteFile(lockFile, lockBytes, 0644); err != nil {
+		return fmt.Errorf("couldn't write lock %v: %v", lockFile, err)
+	}
+	log.Infof("Locked %v repos and %v images in %v", len(lock.Repos), len(lock.Images), lockFile)
+	return nil
+}
+
+// printParameters prints the effective parameters of each application and whether they are global
+// or set by the application. Disabled applications are listed as such instead.
+func printParameters(out io.Writer, config *kfconfig.KfConfig) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	header := false
+	disabled := []string{}
+	for _, app := range config.Spec.Applications {
+		if !app.IsEnabled() {
+			disabled = append(disabled, app.Name)
+			continue
+		}
+		own := map[string]bool{}
+		if app.KustomizeConfig != nil {
+			for _, p := range app.KustomizeConfig.Parameters {
+				own[p.Name] = true
+			}
+		}
+		for _, p := range config.GetApplicationParameters(app) {
+			if !header {
+				fmt.Fprintln(w, "APPLICATION\tPARAMETER\tVALUE\tSOURCE")
+				header = true
+			}
+			source := "global"
+			if own[p.Name] {
+				source = "application"
+			}
+			value := p.Value
+			// Values read from the cluster are shown as references so secrets aren't printed.
+			if p.ValueFrom != nil && p.ValueFrom.ConfigMapKeyRef != nil {
+				value = fmt.Sprintf("<configMap %v/%v>", p.ValueFrom.ConfigMapKeyRef.Name, p.ValueFrom.ConfigMapKeyRef.Key)
+			} else if p.ValueFrom != nil && p.ValueFrom.SecretKeyRef != nil {
+				value = fmt.Sprintf("<secret %v/%v>", p.ValueFrom.SecretKeyRef.Name, p.ValueFrom.SecretKeyRef.Key)
+			} else if p.ValueFrom != nil && p.ValueFrom.KfDefSecretRef != nil {
+				value = fmt.Sprintf("<kfdef secret %v>", p.ValueFrom.KfDefSecretRef.Name)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", app.Name, p.Name, value, source)
+		}
+	}
+	w.Flush()
+	for _, name := range disabled {
+		fmt.Fprintf(out, "Application %v is disabled\n", name)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(buildCmd)
 
@@ -107,4 +214,22 @@ func init() {
 		log.Errorf("Couldn't set flag --%v: %v", string(kftypes.VERBOSE), bindErr)
 		return
 	}
+
+	// lock flag
+	buildCmd.Flags().Bool(string(kftypes.LOCK), false,
+		"write "+kfconfig.LockFile+", pinning the repos and images of the KF App, to the app directory, default is false")
+	bindErr = buildCfg.BindPFlag(string(kftypes.LOCK), buildCmd.Flags().Lookup(string(kftypes.LOCK)))
+	if bindErr != nil {
+		log.Errorf("Couldn't set flag --%v: %v", string(kftypes.LOCK), bindErr)
+		return
+	}
+
+	// merged flag
+	buildCmd.Flags().Bool(string(kftypes.MERGED), false,
+		"print the KfDef merged with the base it extends to stdout, default is false")
+	bindErr = buildCfg.BindPFlag(string(kftypes.MERGED), buildCmd.Flags().Lookup(string(kftypes.MERGED)))
+	if bindErr != nil {
+		log.Errorf("Couldn't set flag --%v: %v", string(kftypes.MERGED), bindErr)
+		return
+	}
 }
@@ -0,0 +1,212 @@
+// Copyright 2018 The Kubeflow Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	"github.com/kubeflow/kfctl/v3/pkg/kfapp/coordinator"
+	"github.com/kubeflow/kfctl/v3/pkg/kfapp/kustomize"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	kfloaders "github.com/kubeflow/kfctl/v3/pkg/kfconfig/loaders"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
+)
+
+var bundleCfg = viper.New()
+var bundleOutput string
+var bundleInput string
+var bundleDir string
+var bundleWithImages bool
+var bundleImageRegistry string
+
+// bundleCmd represents the bundle command
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Packages a KF App for air-gapped clusters",
+	Long: `Packages a KfDef, the repos it's built from and optionally the images it uses into a bundle
+that can be built and applied without network access.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		log.SetLevel(log.InfoLevel)
+		if bundleCfg.GetBool(string(kftypes.VERBOSE)) != true {
+			log.SetLevel(log.WarnLevel)
+		}
+	},
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create -f ${CONFIG} -o bundle.tar",
+	Short: "Creates a bundle of a KfDef",
+	Long: `Builds the KF App of a KfDef in the current directory, as kfctl build does, and writes a bundle of the
+KfDef and of every repo it synced to --output. With --images, the images of its manifests are pulled into
+the bundle as well, for linux/amd64, once rewritten by the imageOverrides of the KfDef.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if configFilePath == "" {
+			return fmt.Errorf("Must pass in -f configFile")
+		}
+		if bundleOutput == "" {
+			return fmt.Errorf("Must pass in -o bundle")
+		}
+		ctx, stop := interruptContext()
+		defer stop()
+		kfApp, err := coordinator.NewLoadKfAppFromURIContext(ctx, configFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to build kfApp from URI %s: %v", configFilePath, err)
+		}
+		getter, ok := kfApp.(coordinator.KfConfigGetter)
+		if !ok {
+			return fmt.Errorf("couldn't read the config of the KF App built from %v", configFilePath)
+		}
+		config := getter.GetKfConfig()
+
+		// The bundle holds the merged KfDef without its status, so it can be applied as it is.
+		merged := config.DeepCopy()
+		merged.Status = kfconfig.Status{}
+		kfdefBytes, err := kfloaders.MarshalKfDef(*merged)
+		if err != nil {
+			return fmt.Errorf("couldn't marshal the KfDef: %v", err)
+		}
+		var images map[string]string
+		if bundleWithImages {
+			if images, err = kustomize.RenderedImages(config); err != nil {
+				return fmt.Errorf("couldn't list the images of the KF App: %v", err)
+			}
+		}
+
+		f, err := os.Create(bundleOutput)
+		if err != nil {
+			return err
+		}
+		manifest, err := config.CreateBundle(ctx, f, kfdefBytes, images)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(bundleOutput)
+			return fmt.Errorf("couldn't create bundle %v: %v", bundleOutput, err)
+		}
+		fmt.Fprintf(os.Stdout, "Bundled %v repos and %v images to %v\n", len(manifest.Repos), len(manifest.Images),
+			bundleOutput)
+		return nil
+	},
+}
+
+var bundleLoadCmd = &cobra.Command{
+	Use:   "load -i bundle.tar -d ${DIR}",
+	Short: "Unpacks a bundle",
+	Long: `Unpacks a bundle into --dir and rewrites the KfDef of the bundle, ` + kfconfig.BundleKfDefFile + `, so that its
+repos are taken from the bundle. With --image-registry, its images are pulled by digest from that registry
+instead; the images of the bundle must be pushed there first, e.g. with the skopeo commands printed.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if bundleInput == "" {
+			return fmt.Errorf("Must pass in -i bundle")
+		}
+		if bundleDir == "" {
+			return fmt.Errorf("Must pass in -d directory")
+		}
+		f, err := os.Open(bundleInput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		manifest, err := kfconfig.LoadBundle(f, bundleDir)
+		if err != nil {
+			return fmt.Errorf("couldn't load bundle %v: %v", bundleInput, err)
+		}
+
+		kfdefFile := filepath.Join(bundleDir, kfconfig.BundleKfDefFile)
+		kfdefBytes, err := ioutil.ReadFile(kfdefFile)
+		if err != nil {
+			return err
+		}
+		kfdef := &kfdefv1.KfDef{}
+		if err := yaml.Unmarshal(kfdefBytes, kfdef); err != nil {
+			return fmt.Errorf("couldn't parse the KfDef of the bundle: %v", err)
+		}
+		if err := manifest.Apply(kfdef, bundleDir, bundleImageRegistry); err != nil {
+			return fmt.Errorf("couldn't apply bundle %v: %v", bundleInput, err)
+		}
+		if kfdefBytes, err = yaml.Marshal(kfdef); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(kfdefFile, kfdefBytes, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "Loaded %v repos; apply %v to deploy the KF App\n", len(manifest.Repos), kfdefFile)
+
+		if bundleImageRegistry != "" && len(manifest.Images) > 0 {
+			layout, err := filepath.Abs(filepath.Join(bundleDir, kfconfig.BundleImagesDir))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "Push the images of the bundle to %v with:\n", bundleImageRegistry)
+			pushed := map[string]bool{}
+			for _, image := range manifest.Images {
+				if pushed[image.Image] {
+					continue
+				}
+				pushed[image.Image] = true
+				mirror, err := image.Mirror(bundleImageRegistry)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stdout, "  skopeo copy oci:%v:%v docker://%v\n", layout, image.Image, mirror)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.AddCommand(bundleCreateCmd)
+	bundleCmd.AddCommand(bundleLoadCmd)
+
+	bundleCreateCmd.Flags().StringVarP(&configFilePath, string(kftypes.FILE), "f", "",
+		`Static config file to use. Can be either a local path:
+		export CONFIG=./kfctl_gcp_iap.yaml
+	or a URL:
+		export CONFIG=https://raw.githubusercontent.com/kubeflow/manifests/v1.0-branch/kfdef/kfctl_gcp_iap.v1.0.0.yaml
+	kfctl bundle create -f ${CONFIG} -o bundle.tar`)
+	bundleCreateCmd.Flags().StringVarP(&bundleOutput, "output", "o", "",
+		`File the bundle is written to.`)
+	bundleCreateCmd.Flags().BoolVar(&bundleWithImages, "images", false,
+		`Pull the images of the manifests into the bundle.`)
+
+	bundleLoadCmd.Flags().StringVarP(&bundleInput, "input", "i", "",
+		`Bundle to load.`)
+	bundleLoadCmd.Flags().StringVarP(&bundleDir, "dir", "d", "",
+		`Directory the bundle is unpacked to.`)
+	bundleLoadCmd.Flags().StringVar(&bundleImageRegistry, "image-registry", "",
+		`Registry the images of the bundle are pushed to, e.g. registry.example.com/kubeflow.`)
+
+	// verbose output
+	bundleCmd.PersistentFlags().BoolP(string(kftypes.VERBOSE), "V", false,
+		string(kftypes.VERBOSE)+" output default is false")
+	bindErr := bundleCfg.BindPFlag(string(kftypes.VERBOSE), bundleCmd.PersistentFlags().Lookup(string(kftypes.VERBOSE)))
+	if bindErr != nil {
+		log.Errorf("Couldn't set flag --%v: %v", string(kftypes.VERBOSE), bindErr)
+		return
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 The Kubeflow Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	kfloaders "github.com/kubeflow/kfctl/v3/pkg/kfconfig/loaders"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var cacheCfg = viper.New()
+var cacheDir string
+var cacheMaxSize string
+var cacheMaxAge time.Duration
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manages the shared cache of repos",
+	Long: `Manages the cache of repos shared by the KF Apps of this host.
+
+Repos are stored in the cache keyed by their digest, and the cache of each KF App links to them.
+The cache is in $` + kfconfig.SharedCacheDirEnv + ` if set.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		log.SetLevel(log.InfoLevel)
+		if cacheCfg.GetBool(string(kftypes.VERBOSE)) != true {
+			log.SetLevel(log.WarnLevel)
+		}
+	},
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the repos in the shared cache",
+	Long:  `Lists the repos in the shared cache, least recently used first.`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := kfconfig.NewSharedCache(cacheDir).List()
+		if err != nil {
+			return fmt.Errorf("couldn't list the cache: %v", err)
+		}
+		printCacheEntries(os.Stdout, entries)
+		return nil
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Removes repos from the shared cache",
+	Long: `Removes the repos not used for longer than --max-age, then the least recently used repos until the
+shared cache fits in --max-size. KF Apps linking to a removed repo fetch it again on their next build or apply.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var maxSize int64
+		if cacheMaxSize != "" {
+			q, err := resource.ParseQuantity(cacheMaxSize)
+			if err != nil {
+				return fmt.Errorf("invalid --max-size %v: %v", cacheMaxSize, err)
+			}
+			maxSize = q.Value()
+		}
+		if maxSize == 0 && cacheMaxAge == 0 {
+			return fmt.Errorf("Must pass in --max-size or --max-age")
+		}
+		removed, err := kfconfig.NewSharedCache(cacheDir).Prune(maxSize, cacheMaxAge, nil)
+		printCacheEntries(os.Stdout, removed)
+		if err != nil {
+			return fmt.Errorf("couldn't prune the cache: %v", err)
+		}
+		return nil
+	},
+}
+
+var cachePrefetchCmd = &cobra.Command{
+	Use:   "prefetch -f ${CONFIG}",
+	Short: "Fetches the repos of a KfDef into the shared cache",
+	Long: `Fetches the repos of a KfDef into the shared cache without building a KF App, e.g. to warm the cache
+before building bundles for air-gapped clusters.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if configFilePath == "" {
+			return fmt.Errorf("Must pass in -f configFile")
+		}
+		if cacheDir != "" {
+			// SyncCache uses the shared cache in the environment.
+			os.Setenv(kfconfig.SharedCacheDirEnv, cacheDir)
+		}
+		config, err := kfloaders.LoadConfigFromURI(configFilePath)
+		if err != nil {
+			return fmt.Errorf("couldn't load config file %v: %v", configFilePath, err)
+		}
+		appDir, err := ioutil.TempDir("", "kfctl-prefetch")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(appDir)
+		config.Spec.AppDir = appDir
+		if err := config.SyncCache(); err != nil {
+			return fmt.Errorf("couldn't fetch the repos of %v: %v", configFilePath, err)
+		}
+		for _, c := range config.Status.Caches {
+			fmt.Fprintf(os.Stdout, "Fetched %v\n", c.Name)
+		}
+		return nil
+	},
+}
+
+// printCacheEntries prints the key, size, last use and source of each entry of the shared cache.
+func printCacheEntries(out io.Writer, entries []kfconfig.SharedCacheEntry) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSIZE\tLAST USED\tSOURCE")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", e.Key, resource.NewQuantity(e.Size, resource.BinarySI),
+			e.LastUsed.Format(time.RFC3339), e.Source)
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	cacheCmd.AddCommand(cachePrefetchCmd)
+
+	cacheCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", "",
+		`Directory of the shared cache; defaults to $`+kfconfig.SharedCacheDirEnv+` or the cache directory of the user.`)
+
+	cachePruneCmd.Flags().StringVar(&cacheMaxSize, "max-size", "",
+		`Size the cache is pruned to, e.g. 10Gi.`)
+	cachePruneCmd.Flags().DurationVar(&cacheMaxAge, "max-age", 0,
+		`Repos not used for longer than this are removed, e.g. 168h.`)
+
+	cachePrefetchCmd.Flags().StringVarP(&configFilePath, string(kftypes.FILE), "f", "",
+		`Static config file to use. Can be either a local path:
+		export CONFIG=./kfctl_gcp_iap.yaml
+	or a URL:
+		export CONFIG=https://raw.githubusercontent.com/kubeflow/manifests/v1.0-branch/kfdef/kfctl_gcp_iap.v1.0.0.yaml
+	kfctl cache prefetch -f ${CONFIG}`)
+
+	// verbose output
+	cacheCmd.PersistentFlags().BoolP(string(kftypes.VERBOSE), "V", false,
+		string(kftypes.VERBOSE)+" output default is false")
+	bindErr := cacheCfg.BindPFlag(string(kftypes.VERBOSE), cacheCmd.PersistentFlags().Lookup(string(kftypes.VERBOSE)))
+	if bindErr != nil {
+		log.Errorf("Couldn't set flag --%v: %v", string(kftypes.VERBOSE), bindErr)
+		return
+	}
+}
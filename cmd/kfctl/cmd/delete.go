@@ -55,12 +55,15 @@ var deleteCmd = &cobra.Command{
 			forceDeleteAnn: annValue,
 		})
 
-		kfApp, err = coordinator.NewLoadKfAppFromURI(configFilePath)
-		if err != nil || kfApp == nil {
+		ctx, stop := interruptContext()
+		defer stop()
+		app, err := coordinator.NewLoadKfAppFromURIContext(ctx, configFilePath)
+		if err != nil || app == nil {
 			return fmt.Errorf("error loading kfapp: %v", err)
 		}
+		kfApp = app
 
-		deleteErr := kfApp.Delete(kftypes.ALL)
+		deleteErr := app.DeleteContext(ctx, kftypes.ALL)
 		if deleteErr != nil {
 			return fmt.Errorf("couldn't delete KfApp: %v", deleteErr)
 		}
@@ -0,0 +1,163 @@
+// Copyright 2018 The Kubeflow Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// progressDisplay renders the progress events of an apply.
+// On a terminal the status of the current application is redrawn in place; otherwise one line is written
+// per application once it is done, plus failures and retries as they happen.
+type progressDisplay struct {
+	out  io.Writer
+	live bool
+
+	mu          sync.Mutex
+	application string
+	applied     int
+	unchanged   int
+	failed      int
+	status      string
+}
+
+func newProgressDisplay() *progressDisplay {
+	return &progressDisplay{
+		out:  os.Stderr,
+		live: terminal.IsTerminal(int(os.Stderr.Fd())),
+	}
+}
+
+func (p *progressDisplay) Progress(e kftypes.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch e.Type {
+	case kftypes.RenderStarted:
+		p.application = e.Application
+		p.applied, p.unchanged, p.failed = 0, 0, 0
+		p.status = "rendering"
+	case kftypes.RenderFinished:
+		if e.Err != nil {
+			p.line(fmt.Sprintf("%v: render failed: %v", e.Application, e.Err))
+			return
+		}
+		p.status = "applying"
+	case kftypes.ObjectApplied, kftypes.ObjectUnchanged, kftypes.ObjectFailed:
+		switch e.Type {
+		case kftypes.ObjectApplied:
+			p.applied++
+		case kftypes.ObjectUnchanged:
+			p.unchanged++
+		default:
+			p.failed++
+		}
+		// kubectl prints a line for the object right after this event; clear the status line so
+		// it doesn't get mixed up with it. The next event redraws it.
+		p.clear()
+		return
+	case kftypes.RetryScheduled:
+		p.line(fmt.Sprintf("%v: %v; retrying in %.0fs", e.Application, firstLine(e.Err), e.RetryIn.Seconds()))
+		p.applied, p.unchanged, p.failed = 0, 0, 0
+		p.status = "retrying"
+	case kftypes.WaitingForReadiness:
+		p.status = fmt.Sprintf("waiting for %v %v", strings.ToLower(e.Kind), e.Name)
+	case kftypes.ApplicationApplied:
+		p.line(fmt.Sprintf("%v: applied (%v changed, %v unchanged)", e.Application, p.applied, p.unchanged))
+		p.application = ""
+		return
+	case kftypes.ApplicationDisabled:
+		p.line(fmt.Sprintf("%v: disabled", e.Application))
+		return
+	case kftypes.ApplicationSkipped:
+		p.line(fmt.Sprintf("%v: skipped (%v)", e.Application, e.Reason))
+		return
+	case kftypes.RepoRetryScheduled:
+		p.line(fmt.Sprintf("repo %v: %v; retrying in %.0fs", e.Repo, firstLine(e.Err), e.RetryIn.Seconds()))
+		return
+	case kftypes.RepoFetchProgress:
+		// Repos are fetched concurrently; the status line shows the last one making progress.
+		if p.live && p.application == "" {
+			fmt.Fprintf(p.out, "\r\033[Kfetching repo %v: %v", e.Repo, byteCount(e.Bytes, e.Total))
+		}
+		return
+	case kftypes.RepoFetched:
+		if e.Err != nil {
+			p.line(fmt.Sprintf("repo %v: fetch failed: %v", e.Repo, firstLine(e.Err)))
+		} else {
+			p.line(fmt.Sprintf("repo %v: fetched", e.Repo))
+		}
+		return
+	}
+	p.redraw()
+}
+
+// finish terminates the status line of an interrupted or failed apply.
+func (p *progressDisplay) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.live && p.application != "" {
+		// Keep the last status of the unfinished application on screen.
+		p.redraw()
+		fmt.Fprintln(p.out)
+	}
+	p.application = ""
+}
+
+// line prints a permanent line above the status line.
+func (p *progressDisplay) line(msg string) {
+	p.clear()
+	fmt.Fprintln(p.out, msg)
+	p.redraw()
+}
+
+// clear erases the status line.
+func (p *progressDisplay) clear() {
+	if p.live {
+		fmt.Fprint(p.out, "\r\033[K")
+	}
+}
+
+// redraw rewrites the status line of the current application.
+func (p *progressDisplay) redraw() {
+	if !p.live || p.application == "" {
+		return
+	}
+	fmt.Fprintf(p.out, "\r\033[K%v: %v (%v changed, %v unchanged, %v failed)",
+		p.application, p.status, p.applied, p.unchanged, p.failed)
+}
+
+// byteCount formats the bytes received out of total, -1 if unknown.
+func byteCount(bytes int64, total int64) string {
+	const mib = 1 << 20
+	if total <= 0 {
+		return fmt.Sprintf("%.1f MiB", float64(bytes)/mib)
+	}
+	return fmt.Sprintf("%.1f/%.1f MiB", float64(bytes)/mib, float64(total)/mib)
+}
+
+func firstLine(err error) string {
+	if err == nil {
+		return ""
+	}
+	return strings.SplitN(err.Error(), "\n", 2)[0]
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+)
+
+func TestProgressDisplay(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := &progressDisplay{out: out}
+	events := []kftypes.ProgressEvent{
+		{Type: kftypes.RepoFetched, Repo: "manifests"},
+		{Type: kftypes.RenderStarted, Application: "istio"},
+		{Type: kftypes.RenderFinished, Application: "istio"},
+		{Type: kftypes.ObjectFailed, Application: "istio", Kind: "Deployment", Name: "istiod"},
+		{Type: kftypes.RetryScheduled, Application: "istio", RetryIn: 5 * time.Second, Err: fmt.Errorf("webhook not ready\ndetails")},
+		{Type: kftypes.ObjectApplied, Application: "istio", Kind: "Deployment", Name: "istiod"},
+		{Type: kftypes.ObjectUnchanged, Application: "istio", Kind: "Service", Name: "istiod"},
+		{Type: kftypes.ObjectUnchanged, Application: "istio", Kind: "ConfigMap", Name: "mesh"},
+		{Type: kftypes.WaitingForReadiness, Application: "istio", Kind: "Deployment", Name: "istiod"},
+		{Type: kftypes.ApplicationApplied, Application: "istio"},
+		{Type: kftypes.ApplicationSkipped, Application: "gpu", Reason: "no GPU nodes"},
+		{Type: kftypes.ApplicationDisabled, Application: "legacy"},
+		{Type: kftypes.RenderStarted, Application: "broken"},
+		{Type: kftypes.RenderFinished, Application: "broken", Err: fmt.Errorf("no kustomization")},
+	}
+	for _, e := range events {
+		p.Progress(e)
+	}
+	p.finish()
+
+	// Without a terminal only permanent lines are written, and the counts restart with each retry.
+	expected := strings.Join([]string{
+		"repo manifests: fetched",
+		"istio: webhook not ready; retrying in 5s",
+		"istio: applied (1 changed, 2 unchanged)",
+		"gpu: skipped (no GPU nodes)",
+		"legacy: disabled",
+		"broken: render failed: no kustomization",
+	}, "\n") + "\n"
+	if out.String() != expected {
+		t.Errorf("Got output\n%v\nwant\n%v", out.String(), expected)
+	}
+}
+
+func TestProgressDisplayLive(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := &progressDisplay{out: out, live: true}
+	p.Progress(kftypes.ProgressEvent{Type: kftypes.RenderStarted, Application: "istio"})
+	p.Progress(kftypes.ProgressEvent{Type: kftypes.RenderFinished, Application: "istio"})
+	p.Progress(kftypes.ProgressEvent{Type: kftypes.ObjectApplied, Application: "istio", Kind: "Deployment", Name: "istiod"})
+	p.Progress(kftypes.ProgressEvent{Type: kftypes.WaitingForReadiness, Application: "istio", Kind: "Deployment", Name: "istiod"})
+	p.finish()
+
+	// The status line is redrawn in place and kept on screen when the apply is interrupted.
+	status := "\r\033[Kistio: waiting for deployment istiod (1 changed, 0 unchanged, 0 failed)"
+	if !strings.HasSuffix(out.String(), status+status+"\n") {
+		t.Errorf("Got output %q; want it to end with the status of istio", out.String())
+	}
+	if !strings.Contains(out.String(), "\r\033[Kistio: applying (0 changed, 0 unchanged, 0 failed)\r\033[K") {
+		t.Errorf("Got output %q; want the status line cleared before kubectl reports an object", out.String())
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 The Kubeflow Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	kfloaders "github.com/kubeflow/kfctl/v3/pkg/kfconfig/loaders"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var statusCfg = viper.New()
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows the status of the applications of a KF App",
+	Long:  `Shows the status of the applications of a KF App as recorded by its last apply`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.SetLevel(log.InfoLevel)
+		if statusCfg.GetBool(string(kftypes.VERBOSE)) != true {
+			log.SetLevel(log.WarnLevel)
+		}
+		if configFilePath == "" {
+			return fmt.Errorf("Must pass in -f configFile")
+		}
+		config, err := kfloaders.LoadConfigFromURI(configFilePath)
+		if err != nil {
+			return fmt.Errorf("couldn't load config file %v: %v", configFilePath, err)
+		}
+		printStatus(os.Stdout, config)
+		return nil
+	},
+}
+
+// printStatus prints the status of each application: disabled, applied with the hash of its desired state,
+// or pending if it hasn't been applied yet.
+func printStatus(out io.Writer, config *kfconfig.KfConfig) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "APPLICATION\tSTATUS\tHASH")
+	for _, app := range config.Spec.Applications {
+		status, ok := config.GetApplicationStatus(app.Name)
+		switch {
+		case !app.IsEnabled() && (!ok || status.Disabled):
+			fmt.Fprintf(w, "%v\tdisabled\t\n", app.Name)
+		case !app.IsEnabled():
+			fmt.Fprintf(w, "%v\tdisabled (resources not deleted yet)\t%v\n", app.Name, status.Hash)
+		case ok && status.Skipped:
+			fmt.Fprintf(w, "%v\tskipped (%v)\t\n", app.Name, status.Reason)
+		case ok && !status.Disabled:
+			fmt.Fprintf(w, "%v\tapplied\t%v\n", app.Name, status.Hash)
+		default:
+			fmt.Fprintf(w, "%v\tpending\t\n", app.Name)
+		}
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCfg.SetConfigName("app")
+	statusCfg.SetConfigType("yaml")
+
+	// Config file option
+	statusCmd.PersistentFlags().StringVarP(&configFilePath, string(kftypes.FILE), "f", "",
+		`Config file of the KF App, as updated by kfctl apply.`)
+
+	// verbose output
+	statusCmd.Flags().BoolP(string(kftypes.VERBOSE), "V", false,
+		string(kftypes.VERBOSE)+" output default is false")
+	bindErr := statusCfg.BindPFlag(string(kftypes.VERBOSE), statusCmd.Flags().Lookup(string(kftypes.VERBOSE)))
+	if bindErr != nil {
+		log.Errorf("Couldn't set flag --%v: %v", string(kftypes.VERBOSE), bindErr)
+		return
+	}
+}
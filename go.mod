@@ -39,6 +39,7 @@ require (
 	github.com/tektoncd/pipeline v0.10.1
 	github.com/tidwall/gjson v1.6.0
 	github.com/tidwall/pretty v1.0.1 // indirect
+	github.com/ulikunitz/xz v0.5.5
 	go.uber.org/zap v1.12.0 // indirect
 	golang.org/x/crypto v0.0.0
 	golang.org/x/net v0.0.0-20200501053045-e0ff5e5a1de5
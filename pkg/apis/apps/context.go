@@ -0,0 +1,81 @@
+// Copyright 2018 The Kubeflow Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apps
+
+import (
+	"context"
+)
+
+// KfAppContext is a KfApp whose operations stop when ctx is cancelled.
+// Implementations should return ctx.Err() (possibly wrapped) once they notice the cancellation and
+// should not leave temporary files behind.
+type KfAppContext interface {
+	KfApp
+	ApplyContext(ctx context.Context, resources ResourceEnum) error
+	DeleteContext(ctx context.Context, resources ResourceEnum) error
+	DumpContext(ctx context.Context, resources ResourceEnum) error
+	GenerateContext(ctx context.Context, resources ResourceEnum) error
+	InitContext(ctx context.Context, resources ResourceEnum) error
+}
+
+// WithContext returns app as a KfAppContext.
+// KfApps that don't implement KfAppContext, e.g. platforms and plugins, are wrapped in an adapter that
+// checks ctx before starting an operation; an operation that is already running can't be interrupted.
+func WithContext(app KfApp) KfAppContext {
+	if c, ok := app.(KfAppContext); ok {
+		return c
+	}
+	return &kfAppAdapter{KfApp: app}
+}
+
+// kfAppAdapter implements KfAppContext on top of a plain KfApp.
+type kfAppAdapter struct {
+	KfApp
+}
+
+func (a *kfAppAdapter) ApplyContext(ctx context.Context, resources ResourceEnum) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Apply(resources)
+}
+
+func (a *kfAppAdapter) DeleteContext(ctx context.Context, resources ResourceEnum) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Delete(resources)
+}
+
+func (a *kfAppAdapter) DumpContext(ctx context.Context, resources ResourceEnum) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Dump(resources)
+}
+
+func (a *kfAppAdapter) GenerateContext(ctx context.Context, resources ResourceEnum) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Generate(resources)
+}
+
+func (a *kfAppAdapter) InitContext(ctx context.Context, resources ResourceEnum) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Init(resources)
+}
@@ -0,0 +1,45 @@
+package apps
+
+import (
+	"context"
+	"testing"
+)
+
+type countingKfApp struct {
+	applied int
+}
+
+func (a *countingKfApp) Apply(resources ResourceEnum) error {
+	a.applied++
+	return nil
+}
+
+func (a *countingKfApp) Delete(resources ResourceEnum) error   { return nil }
+func (a *countingKfApp) Dump(resources ResourceEnum) error     { return nil }
+func (a *countingKfApp) Generate(resources ResourceEnum) error { return nil }
+func (a *countingKfApp) Init(resources ResourceEnum) error     { return nil }
+
+func TestWithContext(t *testing.T) {
+	app := &countingKfApp{}
+	adapted := WithContext(app)
+
+	if err := adapted.ApplyContext(context.Background(), ALL); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if app.applied != 1 {
+		t.Errorf("Apply was called %v times; want 1", app.applied)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := adapted.ApplyContext(ctx, ALL); err != context.Canceled {
+		t.Errorf("ApplyContext with a cancelled context got %v; want %v", err, context.Canceled)
+	}
+	if app.applied != 1 {
+		t.Errorf("Apply was called after the context was cancelled")
+	}
+
+	if WithContext(adapted) != adapted {
+		t.Errorf("WithContext wrapped a KfAppContext again")
+	}
+}
@@ -105,6 +105,9 @@ const (
 	FILE                  CliOption = "file"
 	FORCE_DELETION        CliOption = "force-deletion"
 	DUMP                  CliOption = "dump"
+	MERGED                CliOption = "merged"
+	LOCK                  CliOption = "lock"
+	LOCKED                CliOption = "locked"
 )
 
 //
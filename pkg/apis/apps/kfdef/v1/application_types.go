@@ -54,20 +54,171 @@ type KfDefSpec struct {
 	Plugins      []Plugin      `json:"plugins,omitempty"`
 	Secrets      []Secret      `json:"secrets,omitempty"`
 	Repos        []Repo        `json:"repos,omitempty"`
+	// ApplyPolicy is the default apply policy for all applications.
+	ApplyPolicy *ApplyPolicy `json:"applyPolicy,omitempty"`
+	// ImageOverrides rewrites the images of all applications, e.g. to pull them from a mirror registry.
+	ImageOverrides *ImageOverrides `json:"imageOverrides,omitempty"`
+	// GlobalParameters are set on every application; the parameters of an application override them.
+	GlobalParameters []NameValue `json:"globalParameters,omitempty"`
+	// Variables are user-defined variables that parameter values can refer to as ${<name>}, along with the
+	// cluster facts cluster.domain, cluster.version, cluster.defaultStorageClass, kfdef.name and kfdef.namespace.
+	// Their values may refer to cluster facts.
+	Variables []NameValue `json:"variables,omitempty"`
+	// Extends makes this KfDef declare only its differences with a base KfDef it is merged onto when loaded.
+	Extends *KfDefBase `json:"extends,omitempty"`
+	// Bundle is an offline bundle made by kfctl bundle create that the operator takes the repos, and
+	// optionally the images, of the KfDef from.
+	Bundle *BundleSource `json:"bundle,omitempty"`
+	// Lock is a lock written by kfctl build --lock that the operator pins the repos and images of the KfDef to.
+	Lock *LockSource `json:"lock,omitempty"`
+}
+
+// BundleSource locates an offline bundle. Exactly one of Path and ConfigMapKeyRef must be set.
+type BundleSource struct {
+	// Path of the bundle in the operator pod, e.g. on a PersistentVolumeClaim mounted into it.
+	Path string `json:"path,omitempty"`
+	// ConfigMapKeyRef refers to the binaryData key of a ConfigMap in the namespace of the KfDef holding the
+	// bundle. ConfigMaps are limited to 1MiB, so this only suits small bundles.
+	ConfigMapKeyRef *v1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// ImageRegistry is the registry the images of the bundle were pushed to; they're pulled from it instead of
+	// the registries they were bundled from. Images are left alone if it's empty.
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+}
+
+// LockSource locates the lock of a KfDef.
+type LockSource struct {
+	// ConfigMapKeyRef refers to the key of a ConfigMap in the namespace of the KfDef holding the lock, e.g.
+	// as created by kubectl create configmap --from-file=kfdef.lock.
+	ConfigMapKeyRef *v1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// KfDefBase refers to the KfDef a KfDef extends. Exactly one of URI and ConfigMapKeyRef must be set.
+type KfDefBase struct {
+	// URI of the base KfDef. Relative paths are resolved against the location of the extending KfDef.
+	URI string `json:"uri,omitempty"`
+	// ConfigMapKeyRef refers to the key of a ConfigMap in the namespace of the KfDef holding the base KfDef.
+	ConfigMapKeyRef *v1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// RemoveApplications are the applications of the base left out of the merged KfDef.
+	RemoveApplications []string `json:"removeApplications,omitempty"`
+	// CredentialsSecret refers to a Secret in the namespace of the KfDef holding the credentials URI is
+	// downloaded with, with the keys of the credentials Secrets of repos.
+	CredentialsSecret *v1.LocalObjectReference `json:"credentialsSecret,omitempty"`
 }
 
 // Application defines an application to install
 type Application struct {
 	Name            string           `json:"name,omitempty"`
 	KustomizeConfig *KustomizeConfig `json:"kustomizeConfig,omitempty"`
+	// Enabled set to false skips the application and deletes the resources it applied before.
+	// Applications are enabled by default.
+	Enabled *bool `json:"enabled,omitempty"`
+	// When holds conditions on the cluster that must all hold for the application to be applied.
+	When *ApplicationConditions `json:"when,omitempty"`
+}
+
+// ApplicationConditions are evaluated against the discovery API of the cluster before render.
+// Unset conditions always hold.
+type ApplicationConditions struct {
+	// APIResourceExists is a resource that must be served, as <group>/<version>/<kind>,
+	// e.g. route.openshift.io/v1/Route, or <version>/<kind> for the core group.
+	APIResourceExists string `json:"apiResourceExists,omitempty"`
+	// ClusterVersion constrains the Kubernetes version of the cluster, e.g. ">= 1.20".
+	// The operator is one of >=, >, <=, <, == and !=, and defaults to ==.
+	ClusterVersion string `json:"clusterVersion,omitempty"`
+	// Platform is the platform of the cluster, openshift or kubernetes.
+	Platform string `json:"platform,omitempty"`
+}
+
+// IsEnabled tells whether the application is rendered and applied.
+func (a *Application) IsEnabled() bool {
+	return a.Enabled == nil || *a.Enabled
 }
 
 type KustomizeConfig struct {
 	RepoRef    *RepoRef    `json:"repoRef,omitempty"`
 	Overlays   []string    `json:"overlays,omitempty"`
 	Parameters []NameValue `json:"parameters,omitempty"`
+	// PatchesStrategicMerge are strategic merge patches, written inline as YAML, applied on top of the application.
+	PatchesStrategicMerge []string `json:"patchesStrategicMerge,omitempty"`
+	// PatchesJson6902 are JSON patches applied to single objects of the application.
+	PatchesJson6902 []PatchJson6902 `json:"patchesJson6902,omitempty"`
+	// Images overrides the names, tags or digests of the images used by the application.
+	Images []Image `json:"images,omitempty"`
+	// Fields of the apply policy set here take precedence over the KfDef-wide ApplyPolicy.
+	ApplyPolicy `json:",inline"`
 }
 
+// PatchJson6902 is a JSON patch (RFC 6902) applied to the object identified by Target.
+type PatchJson6902 struct {
+	Target PatchTarget `json:"target"`
+	// Patch is the list of patch operations, as YAML or JSON.
+	Patch string `json:"patch"`
+}
+
+// PatchTarget identifies an object of an application by its name before any name prefix or suffix is added.
+type PatchTarget struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Image overrides an image used by an application.
+type Image struct {
+	// Name is the image name to override, without tag or digest.
+	Name string `json:"name"`
+	// NewName replaces the name of the image.
+	NewName string `json:"newName,omitempty"`
+	// NewTag replaces the tag of the image.
+	NewTag string `json:"newTag,omitempty"`
+	// Digest replaces the tag of the image with a digest; NewTag is ignored if it is set.
+	Digest string `json:"digest,omitempty"`
+}
+
+// ApplyPolicy controls how an application is applied to the cluster.
+type ApplyPolicy struct {
+	// Timeout bounds the total time spent applying the application, including retries and waiting.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Retry controls how failed applies are retried.
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Wait controls what to wait for once the resources have been applied.
+	Wait WaitPolicy `json:"wait,omitempty"`
+}
+
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of apply attempts; 0 means retry until the timeout.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// InitialInterval is the delay before the first retry; later delays grow exponentially.
+	InitialInterval *metav1.Duration `json:"initialInterval,omitempty"`
+	// MaxInterval caps the delay between two retries.
+	MaxInterval *metav1.Duration `json:"maxInterval,omitempty"`
+}
+
+// ImageOverrides rewrites the images of the containers, init containers and image parameters of all applications.
+// An image matching an exact mapping isn't rewritten by the prefix mappings.
+type ImageOverrides struct {
+	// Prefixes maps an image prefix to its replacement, e.g. gcr.io/kubeflow-images-public to
+	// registry.example.com/kubeflow. The longest matching prefix wins.
+	Prefixes map[string]string `json:"prefixes,omitempty"`
+	// Images maps an image to its replacement. An image given with a tag or digest only matches that tag or
+	// digest; otherwise the tag or digest of the matched image is kept unless the replacement sets one.
+	Images map[string]string `json:"images,omitempty"`
+	// Digests pins images, named as they are once rewritten and without a tag, to a digest such as sha256:<hex>.
+	Digests map[string]string `json:"digests,omitempty"`
+}
+
+type WaitPolicy string
+
+const (
+	// WaitNone doesn't wait once the resources have been applied.
+	WaitNone WaitPolicy = "none"
+	// WaitCreated waits until every resource exists in the cluster.
+	WaitCreated WaitPolicy = "created"
+	// WaitReady waits until workloads are available, jobs have completed and CRDs are established.
+	WaitReady WaitPolicy = "ready"
+)
+
 type RepoRef struct {
 	Name string `json:"name,omitempty"`
 	Path string `json:"path,omitempty"`
@@ -76,6 +227,17 @@ type RepoRef struct {
 type NameValue struct {
 	Name  string `json:"name,omitempty"`
 	Value string `json:"value,omitempty"`
+	// ValueFrom reads the value from a ConfigMap or Secret in the namespace of the KfDef instead of Value.
+	ValueFrom *ValueSource `json:"valueFrom,omitempty"`
+}
+
+// ValueSource refers to the key of a ConfigMap or Secret holding the value of a parameter, or to one of the
+// secrets of the KfDef. Exactly one of its fields must be set. The operator applies the KfDef again when the
+// ConfigMap or Secret changes if it is labeled kfctl.kubeflow.io/parameter-source.
+type ValueSource struct {
+	ConfigMapKeyRef *v1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	SecretKeyRef    *v1.SecretKeySelector    `json:"secretKeyRef,omitempty"`
+	KfDefSecretRef  *v1.LocalObjectReference `json:"kfdefSecretRef,omitempty"`
 }
 
 // Plugin can be used to customize the generation and deployment of Kubeflow
@@ -96,6 +258,19 @@ type Secret struct {
 type SecretSource struct {
 	LiteralSource *LiteralSource `json:"literalSource,omitempty"`
 	EnvSource     *EnvSource     `json:"envSource,omitempty"`
+	// SecretKeyRef reads the secret from a Kubernetes Secret in the namespace of the KfDef.
+	SecretKeyRef *v1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+	// Generated generates a random secret once, before the KfDef is rendered, and stores it in a Kubernetes Secret
+	// owned by the KfDef.
+	Generated *GeneratedSource `json:"generated,omitempty"`
+}
+
+// GeneratedSource describes a randomly generated secret.
+type GeneratedSource struct {
+	// Length of the secret; defaults to 32.
+	Length int `json:"length,omitempty"`
+	// Charset holds the characters the secret is drawn from; defaults to letters and digits.
+	Charset string `json:"charset,omitempty"`
 }
 
 type LiteralSource struct {
@@ -121,6 +296,62 @@ type Repo struct {
 	// Can use any URI understood by go-getter:
 	// https://github.com/hashicorp/go-getter/blob/master/README.md#installation-and-usage
 	URI string `json:"uri,omitempty"`
+	// Git clones the repository from git instead of fetching URI.
+	Git *GitSource `json:"git,omitempty"`
+	// PullSecret refers to a Secret of type kubernetes.io/dockerconfigjson in the namespace of the KfDef holding
+	// the credentials of the registry of an oci:// URI. Defaults to the docker config of the user.
+	PullSecret *v1.LocalObjectReference `json:"pullSecret,omitempty"`
+	// CredentialsSecret refers to a Secret in the namespace of the KfDef holding the credentials URI, or the URL
+	// of Git, is downloaded with over http(s): a bearer token, a username and password, a client certificate
+	// and key as tls.crt and tls.key, and a CA bundle as ca.crt. Defaults to the credentials kfctl reads from
+	// the environment or the netrc file of the user.
+	CredentialsSecret *v1.LocalObjectReference `json:"credentialsSecret,omitempty"`
+	// SHA256 is the hex-encoded sha256 checksum the archive downloaded from URI must have.
+	SHA256 string `json:"sha256,omitempty"`
+	// Signature is a detached signature the archive downloaded from URI must match.
+	Signature *RepoSignature `json:"signature,omitempty"`
+	// Refresh is when the repository is fetched again once synced. Defaults to $KFCTL_REPO_REFRESH, or else
+	// ifChanged.
+	Refresh RefreshPolicy `json:"refresh,omitempty"`
+	// Timeout bounds each attempt to fetch the repository. Defaults to $KFCTL_REPO_TIMEOUT, or else 10m.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Attempts is the number of times fetching the repository is attempted before giving up. Defaults to
+	// $KFCTL_REPO_ATTEMPTS, or else 3.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// RefreshPolicy is when a synced repository is fetched again.
+type RefreshPolicy string
+
+const (
+	// RefreshAlways fetches the repository on every sync.
+	RefreshAlways RefreshPolicy = "always"
+	// RefreshIfChanged fetches the repository again when a cheap check finds it changed: a conditional GET of
+	// its archive, git ls-remote of its ref or a HEAD of the manifest of its tag. Repositories pinned by checksum,
+	// commit or digest don't change.
+	RefreshIfChanged RefreshPolicy = "ifChanged"
+	// RefreshNever keeps the repository as first synced until its source changes.
+	RefreshNever RefreshPolicy = "never"
+)
+
+// RepoSignature is a detached signature of the archive of a Repo, checked before it's unpacked.
+type RepoSignature struct {
+	// URI of the signature, raw or base64-encoded. RSA and ECDSA signatures are of the sha256 digest of the
+	// archive, as made by openssl dgst -sha256 -sign; Ed25519 signatures are of the archive itself.
+	URI string `json:"uri,omitempty"`
+	// PublicKey is the PEM-encoded RSA, ECDSA or Ed25519 public key the signature is checked with.
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// GitSource is a git repository a Repo is cloned from.
+type GitSource struct {
+	// URL of the repository: any URL git can fetch from, including the path of a local repository.
+	URL string `json:"url,omitempty"`
+	// Ref is the branch, tag or commit to check out. Defaults to the HEAD of the repository.
+	// The commit a branch or tag resolves to is recorded; it is fetched again as the Refresh of the repo says.
+	Ref string `json:"ref,omitempty"`
+	// SubDir is the directory of the repository holding the manifests. Defaults to its root.
+	SubDir string `json:"subDir,omitempty"`
 }
 
 // KfDefStatus defines the observed state of KfDef
@@ -128,11 +359,45 @@ type KfDefStatus struct {
 	Conditions []KfDefCondition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 	// ReposCache is used to cache information about local caching of the URIs.
 	ReposCache []RepoCache `json:"reposCache,omitempty"`
+	// Applications records the state of each application as of its last successful apply.
+	Applications []ApplicationStatus `json:"applications,omitempty"`
+	// Variables records the values the variables and cluster facts referred to by parameters were resolved to.
+	// Values read from Secrets aren't recorded.
+	Variables []NameValue `json:"variables,omitempty"`
 }
 
 type RepoCache struct {
 	Name      string `json:"name,omitempty"`
 	LocalPath string `json:"localPath,string"`
+	// Ref is the git ref the cache was cloned at, or the oci:// URI it was pulled from.
+	Ref string `json:"ref,omitempty"`
+	// Commit is the commit Ref resolved to when the cache was cloned.
+	Commit string `json:"commit,omitempty"`
+	// Digest is the digest of the manifest of the OCI artifact the cache was pulled from, or the digest of the
+	// archive it was downloaded from.
+	Digest string `json:"digest,omitempty"`
+	// Verified is set when the archive was verified against the sha256 or signature of its repo.
+	Verified bool `json:"verified,omitempty"`
+	// Source is the URI, or git URL, the cache was fetched from.
+	Source string `json:"source,omitempty"`
+	// ETag and LastModified are the validators of the archive the cache was downloaded from, if it had any.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// FetchedAt is when the cache was fetched.
+	FetchedAt *metav1.Time `json:"fetchedAt,omitempty"`
+}
+
+// ApplicationStatus is the state of an application as of its last successful apply.
+type ApplicationStatus struct {
+	Name string `json:"name"`
+	// Hash aggregates the desired-state hashes of all objects of the application.
+	Hash string `json:"hash,omitempty"`
+	// Disabled is set once the application is disabled and its resources are deleted.
+	Disabled bool `json:"disabled,omitempty"`
+	// Skipped is set when the when conditions of the application didn't hold at the last apply.
+	Skipped bool `json:"skipped,omitempty"`
+	// Reason explains the outcome of the when conditions of the application.
+	Reason string `json:"reason,omitempty"`
 }
 
 type KfDefConditionType string
@@ -239,7 +504,16 @@ func (d *KfDef) SetPluginSpec(pluginKind string, spec interface{}) error {
 	return nil
 }
 
+// ReadSecretKeyRef reads the key ref refers to from a Secret of namespace, or of the namespace of the current
+// kubeconfig context if namespace is empty. It is set by the kfconfig package, which reads from the cluster.
+var ReadSecretKeyRef func(namespace string, ref *v1.SecretKeySelector) (string, error)
+
+// ReadGeneratedSecret returns the value of the generated secret secretName of the KfDef kfdefName stored in a
+// Secret of namespace. It is set by the kfconfig package, which reads from the cluster.
+var ReadGeneratedSecret func(namespace string, kfdefName string, secretName string) (string, error)
+
 // GetSecret returns the specified secret or an error if the secret isn't specified.
+// Secrets of a SecretKeyRef and generated secrets are read from the cluster.
 func (d *KfDef) GetSecret(name string) (string, error) {
 	for _, s := range d.Spec.Secrets {
 		if s.Name != name {
@@ -251,6 +525,18 @@ func (d *KfDef) GetSecret(name string) (string, error) {
 		if s.SecretSource.EnvSource != nil {
 			return os.Getenv(s.SecretSource.EnvSource.Name), nil
 		}
+		if s.SecretSource.SecretKeyRef != nil || s.SecretSource.Generated != nil {
+			if ReadSecretKeyRef == nil || ReadGeneratedSecret == nil {
+				return "", &kfapis.KfError{
+					Code:    int(kfapis.INTERNAL_ERROR),
+					Message: fmt.Sprintf("secret %v is read from the cluster but no reader is registered", name),
+				}
+			}
+			if s.SecretSource.SecretKeyRef != nil {
+				return ReadSecretKeyRef(d.Namespace, s.SecretSource.SecretKeyRef)
+			}
+			return ReadGeneratedSecret(d.Namespace, d.Name, s.Name)
+		}
 
 		return "", fmt.Errorf("No secret source provided for secret %v", name)
 	}
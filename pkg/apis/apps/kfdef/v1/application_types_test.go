@@ -19,6 +19,7 @@ import (
 	"github.com/ghodss/yaml"
 	"github.com/prometheus/common/log"
 	"io/ioutil"
+	v1 "k8s.io/api/core/v1"
 	"os"
 	"path"
 	"reflect"
@@ -157,9 +158,35 @@ func TestKfDef_GetSecret(t *testing.T) {
 						},
 					},
 				},
+				{
+					Name: "s3",
+					SecretSource: &SecretSource{
+						SecretKeyRef: &v1.SecretKeySelector{
+							LocalObjectReference: v1.LocalObjectReference{Name: "creds"},
+							Key:                  "password",
+						},
+					},
+				},
+				{
+					Name: "s4",
+					SecretSource: &SecretSource{
+						Generated: &GeneratedSource{},
+					},
+				},
 			},
 		},
 	}
+	d.Name = "kf"
+	d.Namespace = "kubeflow"
+
+	oldReadSecretKeyRef, oldReadGeneratedSecret := ReadSecretKeyRef, ReadGeneratedSecret
+	defer func() { ReadSecretKeyRef, ReadGeneratedSecret = oldReadSecretKeyRef, oldReadGeneratedSecret }()
+	ReadSecretKeyRef = func(namespace string, ref *v1.SecretKeySelector) (string, error) {
+		return namespace + "/" + ref.Name + "/" + ref.Key, nil
+	}
+	ReadGeneratedSecret = func(namespace string, kfdefName string, secretName string) (string, error) {
+		return namespace + "/" + kfdefName + "/" + secretName, nil
+	}
 
 	type testCase struct {
 		SecretName    string
@@ -175,6 +202,14 @@ func TestKfDef_GetSecret(t *testing.T) {
 			SecretName:    "s2",
 			ExpectedValue: "somesecret",
 		},
+		{
+			SecretName:    "s3",
+			ExpectedValue: "kubeflow/creds/password",
+		},
+		{
+			SecretName:    "s4",
+			ExpectedValue: "kubeflow/kf/s4",
+		},
 	}
 
 	os.Setenv("s2", "somesecret")
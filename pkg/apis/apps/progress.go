@@ -0,0 +1,113 @@
+// Copyright 2018 The Kubeflow Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apps
+
+import (
+	"context"
+	"time"
+)
+
+// ProgressEventType identifies the step reported by a ProgressEvent.
+type ProgressEventType string
+
+const (
+	// RenderStarted is sent before the manifests of an application are built.
+	RenderStarted ProgressEventType = "RenderStarted"
+	// RenderFinished is sent once the manifests of an application are built; Err is set if that failed.
+	RenderFinished ProgressEventType = "RenderFinished"
+	// ObjectApplied is sent for every object the api server created or configured.
+	ObjectApplied ProgressEventType = "ObjectApplied"
+	// ObjectUnchanged is sent for every applied object that was already up to date.
+	ObjectUnchanged ProgressEventType = "ObjectUnchanged"
+	// ObjectFailed is sent for every object that couldn't be applied in an attempt.
+	ObjectFailed ProgressEventType = "ObjectFailed"
+	// WaitingForReadiness is sent before waiting for an applied object to be created or ready.
+	WaitingForReadiness ProgressEventType = "WaitingForReadiness"
+	// RetryScheduled is sent when a failed apply of an application will be retried after RetryIn.
+	RetryScheduled ProgressEventType = "RetryScheduled"
+	// ApplicationApplied is sent once all objects of an application are applied and waited for.
+	ApplicationApplied ProgressEventType = "ApplicationApplied"
+	// ApplicationDisabled is sent once the resources of a disabled application are deleted.
+	ApplicationDisabled ProgressEventType = "ApplicationDisabled"
+	// ApplicationSkipped is sent for an application whose when conditions don't hold; Reason tells why.
+	ApplicationSkipped ProgressEventType = "ApplicationSkipped"
+	// RepoFetchStarted is sent before a repo is downloaded, cloned or pulled.
+	RepoFetchStarted ProgressEventType = "RepoFetchStarted"
+	// RepoFetchProgress is sent periodically while a repo is downloaded; Bytes were received out of Total.
+	RepoFetchProgress ProgressEventType = "RepoFetchProgress"
+	// RepoRetryScheduled is sent when a failed fetch of a repo will be retried after RetryIn.
+	RepoRetryScheduled ProgressEventType = "RepoRetryScheduled"
+	// RepoFetched is sent once a repo is fetched; Err is set if that failed.
+	RepoFetched ProgressEventType = "RepoFetched"
+)
+
+// ProgressEvent reports a step of a KfApp operation.
+type ProgressEvent struct {
+	Type ProgressEventType
+	Time time.Time
+	// Application is the name of the application the event is about.
+	Application string
+	// Kind, Namespace and Name identify the object of object and readiness events.
+	Kind      string
+	Namespace string
+	Name      string
+	// Operation is what kubectl did with an applied object, e.g. created or configured.
+	Operation string
+	// RetryIn is the delay before the next attempt of a RetryScheduled event.
+	RetryIn time.Duration
+	// Reason explains the outcome of the when conditions of a skipped application.
+	Reason string
+	// Repo is the name of the repo repo events are about.
+	Repo string
+	// Bytes and Total are the bytes of a repo received so far and expected in all, -1 if unknown.
+	Bytes int64
+	Total int64
+	Err   error
+}
+
+// ProgressSink receives the progress events of KfApp operations.
+// Events are delivered synchronously from the goroutine running the operation, so sinks should return quickly.
+// Repos are fetched concurrently but their events are delivered one at a time.
+type ProgressSink interface {
+	Progress(event ProgressEvent)
+}
+
+// ProgressSinkFunc adapts a function to a ProgressSink.
+type ProgressSinkFunc func(event ProgressEvent)
+
+func (f ProgressSinkFunc) Progress(event ProgressEvent) {
+	f(event)
+}
+
+type progressSinkKey struct{}
+
+// WithProgressSink returns a copy of ctx that carries sink.
+// KfAppContext operations called with the returned context report their progress to sink.
+func WithProgressSink(ctx context.Context, sink ProgressSink) context.Context {
+	return context.WithValue(ctx, progressSinkKey{}, sink)
+}
+
+// ReportProgress sends event to the sink carried by ctx. It is a noop if ctx has no sink.
+// Time defaults to now.
+func ReportProgress(ctx context.Context, event ProgressEvent) {
+	sink, ok := ctx.Value(progressSinkKey{}).(ProgressSink)
+	if !ok || sink == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	sink.Progress(event)
+}
@@ -0,0 +1,30 @@
+package apps
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReportProgress(t *testing.T) {
+	// Without a sink reporting is a noop.
+	ReportProgress(context.Background(), ProgressEvent{Type: RenderStarted})
+
+	events := []ProgressEvent{}
+	ctx := WithProgressSink(context.Background(), ProgressSinkFunc(func(e ProgressEvent) {
+		events = append(events, e)
+	}))
+	ReportProgress(ctx, ProgressEvent{Type: RenderStarted, Application: "app"})
+	ReportProgress(ctx, ProgressEvent{Type: ApplicationApplied, Application: "app"})
+
+	if len(events) != 2 {
+		t.Fatalf("Got %v events; want 2", len(events))
+	}
+	if events[0].Type != RenderStarted || events[1].Type != ApplicationApplied {
+		t.Errorf("Got events %v, %v; want %v, %v", events[0].Type, events[1].Type, RenderStarted, ApplicationApplied)
+	}
+	for _, e := range events {
+		if e.Time.IsZero() {
+			t.Errorf("Event %v has no time", e.Type)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package kfdef
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// bundlesDirName is the directory of the shared cache bundles are unpacked to, each in <digest>.
+	bundlesDirName = "bundles"
+)
+
+// applyBundle points the repos of kfdef, and its images if the bundle sets a registry, at the bundle it refers
+// to. The bundle is unpacked into the bundles directory of the shared cache unless the same bundle already is,
+// so that it survives the app directory being recreated on every reconcile.
+func applyBundle(kfdef *kfdefv1.KfDef) error {
+	source := kfdef.Spec.Bundle
+	if (source.Path == "") == (source.ConfigMapKeyRef == nil) {
+		return &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: "exactly one of the path and configMapKeyRef of the bundle must be set",
+		}
+	}
+	var content []byte
+	var digest string
+	var err error
+	if source.Path != "" {
+		digest, err = fileDigest(source.Path)
+	} else {
+		if content, err = kfconfig.ReadConfigMapBinaryKeyRef(kfdef.Namespace, source.ConfigMapKeyRef); err == nil {
+			sum := sha256.Sum256(content)
+			digest = hex.EncodeToString(sum[:])
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if ref := source.ConfigMapKeyRef; content == nil && ref != nil {
+		// ReadConfigMapBinaryKeyRef only returns no content for optional refs.
+		log.Infof("Bundle %v/%v doesn't exist; it's optional so the repos are fetched from their sources", ref.Name, ref.Key)
+		return nil
+	}
+
+	bundlesDir := path.Join(kfconfig.DefaultSharedCacheDir(), bundlesDirName)
+	dir := path.Join(bundlesDir, digest)
+	manifest, err := kfconfig.ReadBundleManifest(dir)
+	if err == nil {
+		// Mark the bundle as used so that pruneBundles keeps it.
+		now := time.Now()
+		os.Chtimes(dir, now, now)
+	} else {
+		log.Infof("Unpacking bundle %v to %v", digest, dir)
+		if err := os.MkdirAll(bundlesDir, 0755); err != nil {
+			return err
+		}
+		// The bundle is unpacked next to dir then renamed, so that KfDefs using the same bundle never see it
+		// partially unpacked.
+		staging, err := ioutil.TempDir(bundlesDir, "."+digest+"-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(staging)
+		var r io.Reader = bytes.NewReader(content)
+		if source.Path != "" {
+			f, err := os.Open(source.Path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		if _, err := kfconfig.LoadBundle(r, staging); err != nil {
+			return err
+		}
+		if err := os.Rename(staging, dir); err != nil {
+			// Another KfDef may have stored it in the meantime; otherwise dir holds a broken bundle.
+			if manifest, err = kfconfig.ReadBundleManifest(dir); err != nil {
+				os.RemoveAll(dir)
+				if err := os.Rename(staging, dir); err != nil {
+					return &kfapis.KfError{
+						Code:    int(kfapis.INTERNAL_ERROR),
+						Message: fmt.Sprintf("couldn't store bundle %v in %v: %v", digest, dir, err),
+					}
+				}
+			}
+		}
+		if manifest == nil {
+			if manifest, err = kfconfig.ReadBundleManifest(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return manifest.Apply(kfdef, dir, source.ImageRegistry)
+}
+
+// pruneBundles removes the bundles unpacked in the shared cache which weren't used for longer than maxAge,
+// along with the ones left over by interrupted unpacks.
+func pruneBundles(maxAge time.Duration) {
+	bundlesDir := path.Join(kfconfig.DefaultSharedCacheDir(), bundlesDirName)
+	entries, err := ioutil.ReadDir(bundlesDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if time.Since(e.ModTime()) <= maxAge {
+			continue
+		}
+		log.Infof("Pruning bundle %v unused since %v.", e.Name(), e.ModTime())
+		if err := os.RemoveAll(path.Join(bundlesDir, e.Name())); err != nil {
+			log.Errorf("Failed to prune bundle %v. Error: %v.", e.Name(), err)
+		}
+	}
+}
+
+// fileDigest returns the hex-encoded sha256 checksum of the file at p.
+func fileDigest(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't read bundle %v: %v", p, err),
+		}
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't read bundle %v: %v", p, err),
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
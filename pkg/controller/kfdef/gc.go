@@ -0,0 +1,136 @@
+package kfdef
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	kfloaders "github.com/kubeflow/kfctl/v3/pkg/kfconfig/loaders"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// cacheMaxSizeEnv limits the size of the shared cache, e.g. 10Gi. The size isn't limited by default.
+	cacheMaxSizeEnv = "KFCTL_CACHE_MAX_SIZE"
+	// cacheMaxAgeEnv is how long repos unused by any KfDef are kept in the shared cache, e.g. 72h.
+	cacheMaxAgeEnv = "KFCTL_CACHE_MAX_AGE"
+	// cacheGCIntervalEnv is how often the shared cache and the app directories are garbage collected.
+	cacheGCIntervalEnv = "KFCTL_CACHE_GC_INTERVAL"
+
+	defaultCacheMaxAge     = 7 * 24 * time.Hour
+	defaultCacheGCInterval = time.Hour
+	// operatorCacheDir is the shared cache of the operator unless $KFCTL_CACHE_DIR says otherwise.
+	// Namespaces can't start with a dot so it can't collide with an app directory.
+	operatorCacheDir = "/tmp/.kfctl-cache"
+)
+
+// appDirsRoot holds the app directory of each KfDef in <namespace>/<name>.
+var appDirsRoot = "/tmp"
+
+// addGarbageCollector adds a runnable to mgr which periodically prunes the shared cache of repos and removes
+// the app directories of KfDefs which no longer exist, e.g. because they were deleted while the operator was down.
+func addGarbageCollector(mgr manager.Manager) error {
+	if os.Getenv(kfconfig.SharedCacheDirEnv) == "" {
+		os.Setenv(kfconfig.SharedCacheDirEnv, operatorCacheDir)
+	}
+	maxSize := int64(0)
+	if s := os.Getenv(cacheMaxSizeEnv); s != "" {
+		q, err := resource.ParseQuantity(s)
+		if err != nil {
+			log.Errorf("Ignoring invalid %v %v: %v", cacheMaxSizeEnv, s, err)
+		} else {
+			maxSize = q.Value()
+		}
+	}
+	maxAge := kfconfig.DurationFromEnv(cacheMaxAgeEnv, defaultCacheMaxAge)
+	interval := kfconfig.DurationFromEnv(cacheGCIntervalEnv, defaultCacheGCInterval)
+
+	return mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			collectGarbage(mgr.GetAPIReader(), maxSize, maxAge)
+			select {
+			case <-stop:
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}))
+}
+
+// collectGarbage removes orphaned app directories, then prunes the shared cache so that the repos only they
+// used age out, along with the unused bundles. The repos of the KfDefs being applied or deleted are kept.
+func collectGarbage(reader client.Reader, maxSize int64, maxAge time.Duration) {
+	removeOrphanedAppDirs(reader)
+
+	var appDirs []string
+	for _, key := range inflight.keys() {
+		appDirs = append(appDirs, path.Join(appDirsRoot, key.Namespace, key.Name))
+	}
+	cache := kfconfig.NewSharedCache("")
+	removed, err := cache.Prune(maxSize, maxAge, cache.LinkedEntries(appDirs...))
+	if err != nil {
+		log.Errorf("Failed to prune the shared cache. Error: %v.", err)
+	}
+	for _, e := range removed {
+		log.Infof("Pruned %v (%v) from the shared cache.", e.Key, e.Source)
+	}
+	pruneBundles(maxAge)
+}
+
+// removeOrphanedAppDirs removes the app directories whose KfDef doesn't exist. Only directories holding the
+// config of a KfDef are considered, and those of KfDefs being applied are kept.
+func removeOrphanedAppDirs(reader client.Reader) {
+	namespaces, err := ioutil.ReadDir(appDirsRoot)
+	if err != nil {
+		log.Errorf("Failed to list the app directories. Error: %v.", err)
+		return
+	}
+	for _, ns := range namespaces {
+		if !ns.IsDir() {
+			continue
+		}
+		names, err := ioutil.ReadDir(path.Join(appDirsRoot, ns.Name()))
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			appDir := path.Join(appDirsRoot, ns.Name(), name.Name())
+			if !name.IsDir() || !isAppDir(appDir) {
+				continue
+			}
+			key := types.NamespacedName{Namespace: ns.Name(), Name: name.Name()}
+			if inflight.running(key) {
+				continue
+			}
+			err := reader.Get(context.TODO(), key, &kfdefv1.KfDef{})
+			if err == nil || !errors.IsNotFound(err) {
+				continue
+			}
+			log.Infof("Removing the app directory %v of deleted KfDef %v.", appDir, key)
+			if err := os.RemoveAll(appDir); err != nil {
+				log.Errorf("Failed to delete the app directory. Error: %v.", err)
+			}
+		}
+	}
+}
+
+// isAppDir returns whether dir holds the config of a KfDef.
+func isAppDir(dir string) bool {
+	for _, name := range []string{"config.yaml", kfloaders.MergedConfigFileName("config.yaml")} {
+		if _, err := os.Stat(path.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
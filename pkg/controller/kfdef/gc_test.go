@@ -0,0 +1,105 @@
+package kfdef
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-gc-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	defer func(old string) { appDirsRoot = old }(appDirsRoot)
+	appDirsRoot = path.Join(testDir, "apps")
+	cacheDir := path.Join(testDir, "cache")
+	defer os.Setenv(kfconfig.SharedCacheDirEnv, os.Getenv(kfconfig.SharedCacheDirEnv))
+	os.Setenv(kfconfig.SharedCacheDirEnv, cacheDir)
+
+	appDir := func(name string, config bool) string {
+		dir := path.Join(appDirsRoot, "kubeflow", name)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatalf("Failed to create %v; %v", dir, err)
+		}
+		if config {
+			if err := ioutil.WriteFile(path.Join(dir, "config.yaml"), []byte("kind: KfDef\n"), 0600); err != nil {
+				t.Fatalf("Failed to write the config of %v; %v", name, err)
+			}
+		}
+		return dir
+	}
+	live := appDir("live", true)
+	deleted := appDir("deleted", true)
+	running := appDir("running", true)
+	notApp := appDir("not-an-app", false)
+
+	cache := kfconfig.NewSharedCache("")
+	fill := func(key string) string {
+		_, content, err := cache.Fill("source-"+key, func(dir string) (string, error) {
+			return key, os.MkdirAll(dir, os.ModePerm)
+		})
+		if err != nil {
+			t.Fatalf("Failed to fill %v; %v", key, err)
+		}
+		old := time.Now().Add(-2 * time.Hour)
+		if err := os.Chtimes(filepath.Dir(content), old, old); err != nil {
+			t.Fatalf("Failed to age %v; %v", key, err)
+		}
+		return content
+	}
+	used := fill("used")
+	fill("unused")
+	if err := os.MkdirAll(path.Join(running, kfconfig.DefaultCacheDir), 0700); err != nil {
+		t.Fatalf("Failed to create the repo cache; %v", err)
+	}
+	if err := os.Symlink(used, path.Join(running, kfconfig.DefaultCacheDir, "manifests")); err != nil {
+		t.Fatalf("Failed to link the repo cache; %v", err)
+	}
+	bundle := path.Join(cacheDir, bundlesDirName, "sha256-old")
+	if err := os.MkdirAll(bundle, 0700); err != nil {
+		t.Fatalf("Failed to create the bundle; %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(bundle, old, old); err != nil {
+		t.Fatalf("Failed to age the bundle; %v", err)
+	}
+
+	scheme := runtime.NewScheme()
+	if err := kfdefv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("Failed to build the scheme; %v", err)
+	}
+	reader := fake.NewFakeClientWithScheme(scheme, &kfdefv1.KfDef{
+		ObjectMeta: metav1.ObjectMeta{Name: "live", Namespace: "kubeflow"},
+	})
+	// The KfDef of running is gone but its delete is still unwinding.
+	_, done := inflight.startDelete(&kfdefv1.KfDef{ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "kubeflow"}})
+	defer done()
+
+	collectGarbage(reader, 0, time.Hour)
+
+	for dir, kept := range map[string]bool{live: true, deleted: false, running: true, notApp: true} {
+		if _, err := os.Stat(dir); (err == nil) != kept {
+			t.Errorf("App directory %v kept: %v; want %v", dir, err == nil, kept)
+		}
+	}
+	if _, ok := cache.Lookup("used"); !ok {
+		t.Errorf("Entry linked from the app directory of a running operation was pruned")
+	}
+	if _, ok := cache.Lookup("unused"); ok {
+		t.Errorf("Unused entry older than the max age wasn't pruned")
+	}
+	if _, err := os.Stat(bundle); !os.IsNotExist(err) {
+		t.Errorf("Bundle older than the max age wasn't pruned; error %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package kfdef
+
+import (
+	"context"
+	"sync"
+
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// inflight keeps the apply or delete running for each KfDef so that editing or deleting the KfDef cancels it
+// instead of waiting for the stale apply to finish. Reconcile blocks while applying, so cancellation is
+// triggered from the watch predicates which run independently of the reconcile workers. A delete is only
+// cancelled once the KfDef is gone. A cancelled operation stays registered until it returns, so that it is
+// still reported running, and its files kept, while it unwinds.
+var inflight = &inflightOperations{ops: map[types.NamespacedName]*inflightOperation{}}
+
+type inflightOperation struct {
+	generation int64
+	deleting   bool
+	cancelled  bool
+	cancel     context.CancelFunc
+}
+
+type inflightOperations struct {
+	mu  sync.Mutex
+	ops map[types.NamespacedName]*inflightOperation
+}
+
+// start registers an apply for the current generation of instance and returns its context.
+// done must be called once the operation is over.
+func (o *inflightOperations) start(instance *kfdefv1.KfDef) (ctx context.Context, done func()) {
+	return o.register(instance, false)
+}
+
+// startDelete is start for a delete of instance.
+func (o *inflightOperations) startDelete(instance *kfdefv1.KfDef) (ctx context.Context, done func()) {
+	return o.register(instance, true)
+}
+
+func (o *inflightOperations) register(instance *kfdefv1.KfDef, deleting bool) (ctx context.Context, done func()) {
+	key := types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()}
+	ctx, cancel := context.WithCancel(context.Background())
+	op := &inflightOperation{generation: instance.GetGeneration(), deleting: deleting, cancel: cancel}
+
+	o.mu.Lock()
+	if previous, ok := o.ops[key]; ok {
+		previous.cancel()
+	}
+	o.ops[key] = op
+	o.mu.Unlock()
+
+	return ctx, func() {
+		o.mu.Lock()
+		if o.ops[key] == op {
+			delete(o.ops, key)
+		}
+		o.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancelStale cancels the apply running for key if it was started for an older generation of the KfDef
+// or if the KfDef is being deleted. Deletes keep running.
+func (o *inflightOperations) cancelStale(key types.NamespacedName, generation int64, deleted bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	op, ok := o.ops[key]
+	if !ok || op.deleting || (!deleted && op.generation == generation) {
+		return
+	}
+	if !op.cancelled {
+		log.Infof("Cancelling the in-flight apply of KfDef %v.%v.", key.Name, key.Namespace)
+	}
+	op.cancelled = true
+	op.cancel()
+}
+
+// cancel cancels the operation running for key, once the KfDef is gone.
+func (o *inflightOperations) cancel(key types.NamespacedName) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	op, ok := o.ops[key]
+	if !ok {
+		return
+	}
+	if !op.cancelled {
+		log.Infof("Cancelling the in-flight operation of KfDef %v.%v.", key.Name, key.Namespace)
+	}
+	op.cancelled = true
+	op.cancel()
+}
+
+// running returns whether an operation is running for key, including a cancelled one that hasn't returned yet.
+func (o *inflightOperations) running(key types.NamespacedName) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, ok := o.ops[key]
+	return ok
+}
+
+// keys returns the KfDefs operations are running for.
+func (o *inflightOperations) keys() []types.NamespacedName {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	keys := []types.NamespacedName{}
+	for key := range o.ops {
+		keys = append(keys, key)
+	}
+	return keys
+}
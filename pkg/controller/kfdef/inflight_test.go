@@ -0,0 +1,83 @@
+package kfdef
+
+import (
+	"testing"
+
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newInflightKfDef(generation int64) *kfdefv1.KfDef {
+	return &kfdefv1.KfDef{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubeflow", Namespace: "kubeflow", Generation: generation},
+	}
+}
+
+func TestInflightCancelStale(t *testing.T) {
+	ops := &inflightOperations{ops: map[types.NamespacedName]*inflightOperation{}}
+	key := types.NamespacedName{Name: "kubeflow", Namespace: "kubeflow"}
+	ctx, done := ops.start(newInflightKfDef(1))
+
+	// An event for the generation being applied leaves the apply running.
+	ops.cancelStale(key, 1, false)
+	if ctx.Err() != nil {
+		t.Fatalf("Apply of the current generation was cancelled")
+	}
+
+	ops.cancelStale(key, 2, false)
+	if ctx.Err() == nil {
+		t.Fatalf("Apply of a stale generation wasn't cancelled")
+	}
+	// The cancelled apply is still unwinding, so it is reported until it returns.
+	if !ops.running(key) || len(ops.keys()) != 1 {
+		t.Errorf("Cancelled apply isn't reported running before it returned")
+	}
+	done()
+	if ops.running(key) || len(ops.keys()) != 0 {
+		t.Errorf("Apply is reported running after it returned")
+	}
+}
+
+func TestInflightCancelDelete(t *testing.T) {
+	ops := &inflightOperations{ops: map[types.NamespacedName]*inflightOperation{}}
+	key := types.NamespacedName{Name: "kubeflow", Namespace: "kubeflow"}
+	ctx, done := ops.startDelete(newInflightKfDef(1))
+
+	// Deletes aren't stale; they are only cancelled once the KfDef is gone.
+	ops.cancelStale(key, 2, true)
+	if ctx.Err() != nil {
+		t.Fatalf("Delete was cancelled as stale")
+	}
+	ops.cancel(key)
+	if ctx.Err() == nil {
+		t.Fatalf("Delete wasn't cancelled")
+	}
+	if !ops.running(key) {
+		t.Errorf("Cancelled delete isn't reported running before it returned")
+	}
+	done()
+	if ops.running(key) {
+		t.Errorf("Delete is reported running after it returned")
+	}
+}
+
+func TestInflightRestart(t *testing.T) {
+	ops := &inflightOperations{ops: map[types.NamespacedName]*inflightOperation{}}
+	key := types.NamespacedName{Name: "kubeflow", Namespace: "kubeflow"}
+	first, firstDone := ops.start(newInflightKfDef(1))
+	second, secondDone := ops.start(newInflightKfDef(2))
+	if first.Err() == nil {
+		t.Fatalf("Starting a new apply didn't cancel the previous one")
+	}
+
+	// The previous apply returning doesn't unregister the new one.
+	firstDone()
+	if !ops.running(key) || second.Err() != nil {
+		t.Errorf("New apply was unregistered or cancelled when the previous one returned")
+	}
+	secondDone()
+	if ops.running(key) {
+		t.Errorf("Apply is reported running after it returned")
+	}
+}
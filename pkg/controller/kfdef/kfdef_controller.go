@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
 	kftypesv3 "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
@@ -44,6 +45,9 @@ const (
 	finalizer = "kfdef-finalizer.kfdef.apps.kubeflow.org"
 	// finalizerMaxRetries defines the maximum number of attempts to add finalizers.
 	finalizerMaxRetries = 10
+	// baseResyncPeriod is how often KfDefs extending a base read from a URI are re-rendered, since such
+	// bases can't be watched.
+	baseResyncPeriod = 10 * time.Minute
 	// deleteConfigMapLabel is the label for configMap used to trigger operator uninstall
 	// TODO: Label should be updated if addon name changes
 	deleteConfigMapLabel = "api.openshift.com/addon-managed-odh-delete"
@@ -66,6 +70,9 @@ var stop chan struct{}
 // AddToManager adds all Controllers to the Manager
 func AddToManager(m manager.Manager) error {
 	kfdefManager = m
+	if err := addGarbageCollector(kfdefManager); err != nil {
+		return err
+	}
 	return Add(kfdefManager)
 }
 
@@ -130,6 +137,12 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 	log.Infof("Controller added to watch on Kubeflow resources with known GVK.")
+
+	// Watch for changes to the ConfigMaps and Secrets parameters are read from
+	err = watchParameterSources(mgr, c)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -198,11 +211,20 @@ var kfdefPredicates = predicate.Funcs{
 	DeleteFunc: func(e event.DeleteEvent) bool {
 		object, _ := meta.Accessor(e.Object)
 		log.Infof("Got delete event for %v.%v.", object.GetName(), object.GetNamespace())
+		inflight.cancel(types.NamespacedName{Name: object.GetName(), Namespace: object.GetNamespace()})
 		return false
 	},
 	UpdateFunc: func(e event.UpdateEvent) bool {
 		object, _ := meta.Accessor(e.ObjectOld)
 		log.Infof("Got update event for %v.%v.", object.GetName(), object.GetNamespace())
+		// Abort an apply of the previous spec; the reconcile queued for this event applies the new one.
+		inflight.cancelStale(types.NamespacedName{Name: e.MetaNew.GetName(), Namespace: e.MetaNew.GetNamespace()},
+			e.MetaNew.GetGeneration(), e.MetaNew.GetDeletionTimestamp() != nil)
+		oldKfDef, oldOk := e.ObjectOld.(*kfdefv1.KfDef)
+		newKfDef, newOk := e.ObjectNew.(*kfdefv1.KfDef)
+		if oldOk && newOk && isStatusOnlyUpdate(oldKfDef, newKfDef) {
+			return false
+		}
 		return true
 	},
 }
@@ -413,7 +435,7 @@ func (r *ReconcileKfDef) Reconcile(request reconcile.Request) (reconcile.Result,
 		return reconcile.Result{Requeue: true}, nil
 	}
 
-	err = getReconcileStatus(instance, kfApply(instance))
+	err = getReconcileStatus(instance, kfApply(instance, r.newProgressRecorder(instance)))
 	if err == nil {
 		log.Infof("KubeFlow Deployment Completed.")
 		r.recorder.Eventf(instance, v1.EventTypeNormal, "KfDefCreationSuccessful",
@@ -452,47 +474,128 @@ func (r *ReconcileKfDef) Reconcile(request reconcile.Request) (reconcile.Result,
 		return reconcile.Result{}, err
 	}
 
+	if err == nil && instance.Spec.Extends != nil && instance.Spec.Extends.URI != "" {
+		return reconcile.Result{RequeueAfter: baseResyncPeriod}, nil
+	}
 	// If deployment created successfully - don't requeue
 	return reconcile.Result{}, err
 }
 
-// kfApply is equivalent of kfctl apply
-func kfApply(instance *kfdefv1.KfDef) error {
+// kfApply is equivalent of kfctl apply. Progress of the apply is reported to sink.
+func kfApply(instance *kfdefv1.KfDef, sink kftypesv3.ProgressSink) error {
 	log.Infof("Creating a new KubeFlow Deployment. KubeFlow.Namespace: %v.", instance.Namespace)
-	kfApp, err := kfLoadConfig(instance, "apply")
+	ctx, done := inflight.start(instance)
+	defer done()
+	if err := ensureGeneratedSecrets(instance); err != nil {
+		log.Errorf("Failed to generate the secrets of KfDef %v.%v. Error: %v.", instance.GetName(), instance.GetNamespace(), err)
+		return err
+	}
+	ctx, err := withLock(ctx, instance)
+	if err != nil {
+		log.Errorf("Failed to read the lock of KfDef %v.%v. Error: %v.", instance.GetName(), instance.GetNamespace(), err)
+		return err
+	}
+	kfApp, err := kfLoadConfig(ctx, instance, "apply")
 	if err != nil {
 		log.Errorf("Failed to load KfApp. Error: %v.", err)
 		return err
 	}
 	// Apply kfApp.
-	err = kfApp.Apply(kftypesv3.K8S)
+	err = kfApp.ApplyContext(kftypesv3.WithProgressSink(ctx, sink), kftypesv3.K8S)
+	if ctx.Err() != nil {
+		log.Warnf("Apply of KfDef %v.%v was cancelled.", instance.GetName(), instance.GetNamespace())
+	}
+	updateApplicationStatus(instance)
 	return err
 }
 
+// updateApplicationStatus replaces the application statuses, resolved variables and repo caches of instance by
+// those the apply recorded in the KfApp config, so that git repos stay at the commit they were cloned at. The
+// config is loaded from instance, so the statuses of applications the apply didn't reach carry over.
+func updateApplicationStatus(instance *kfdefv1.KfDef) {
+	configFileName := "config.yaml"
+	if instance.Spec.Extends != nil {
+		configFileName = kfloaders.MergedConfigFileName(configFileName)
+	}
+	configFilePath := path.Join("/tmp", instance.GetNamespace(), instance.GetName(), configFileName)
+	config, err := kfloaders.LoadConfigFromURI(configFilePath)
+	if err != nil {
+		log.Warnf("Failed to read the application status from %v. Error: %v.", configFilePath, err)
+		return
+	}
+	instance.Status.Applications = nil
+	for _, app := range config.Status.Applications {
+		instance.Status.Applications = append(instance.Status.Applications, kfdefv1.ApplicationStatus{
+			Name:     app.Name,
+			Hash:     app.Hash,
+			Disabled: app.Disabled,
+			Skipped:  app.Skipped,
+			Reason:   app.Reason,
+		})
+	}
+	instance.Status.Variables = nil
+	for _, v := range config.Status.Variables {
+		instance.Status.Variables = append(instance.Status.Variables, kfdefv1.NameValue{Name: v.Name, Value: v.Value})
+	}
+	instance.Status.ReposCache = nil
+	for _, cache := range config.Status.Caches {
+		instance.Status.ReposCache = append(instance.Status.ReposCache, kfdefv1.RepoCache{
+			Name:         cache.Name,
+			LocalPath:    cache.LocalPath,
+			Ref:          cache.Ref,
+			Commit:       cache.Commit,
+			Digest:       cache.Digest,
+			Verified:     cache.Verified,
+			Source:       cache.Source,
+			ETag:         cache.ETag,
+			LastModified: cache.LastModified,
+			FetchedAt:    cache.FetchedAt.DeepCopy(),
+		})
+	}
+}
+
 // kfDelete is equivalent of kfctl delete
 func kfDelete(instance *kfdefv1.KfDef) error {
 	log.Infof("Uninstall Kubeflow. KubeFlow.Namespace: %v.", instance.Namespace)
-	kfApp, err := kfLoadConfig(instance, "delete")
+	ctx, done := inflight.startDelete(instance)
+	defer done()
+	// The repos are synced at the versions they were applied at.
+	ctx, err := withLock(ctx, instance)
+	if err != nil {
+		log.Errorf("Failed to read the lock of KfDef %v.%v. Error: %v.", instance.GetName(), instance.GetNamespace(), err)
+		return err
+	}
+	kfApp, err := kfLoadConfig(ctx, instance, "delete")
 	if err != nil {
 		log.Errorf("Failed to load KfApp. Error: %v.", err)
 		return err
 	}
 	// Delete kfApp.
-	err = kfApp.Delete(kftypesv3.K8S)
+	err = kfApp.DeleteContext(ctx, kftypesv3.K8S)
 	return err
 }
 
-func kfLoadConfig(instance *kfdefv1.KfDef, action string) (kftypesv3.KfApp, error) {
-	// Define kfApp
-	kfdefBytes, _ := yaml.Marshal(instance)
-
-	// Make the kfApp directory
+func kfLoadConfig(ctx context.Context, instance *kfdefv1.KfDef, action string) (kftypesv3.KfAppContext, error) {
+	// Make the kfApp directory. Only the operator can read it since the rendered parameters may be read from
+	// Secrets.
 	kfAppDir := path.Join("/tmp", instance.GetNamespace(), instance.GetName())
-	if err := os.MkdirAll(kfAppDir, 0755); err != nil {
+	if err := os.MkdirAll(kfAppDir, 0700); err != nil {
 		log.Errorf("Failed to create the app directory. Error: %v.", err)
 		return nil, err
 	}
 
+	// Define kfApp
+	kfdefBytes, _ := yaml.Marshal(instance)
+	if instance.Spec.Bundle != nil {
+		// The repos are taken from the bundle; the KfDef itself is left as the user wrote it.
+		bundled := instance.DeepCopy()
+		if err := applyBundle(bundled); err != nil {
+			log.Errorf("Failed to load the bundle. Error: %v.", err)
+			return nil, err
+		}
+		kfdefBytes, _ = yaml.Marshal(bundled)
+	}
+
 	configFilePath := path.Join(kfAppDir, "config.yaml")
 	err := ioutil.WriteFile(configFilePath, kfdefBytes, 0644)
 	if err != nil {
@@ -522,7 +625,7 @@ func kfLoadConfig(instance *kfdefv1.KfDef, action string) (kftypesv3.KfApp, erro
 		})
 	}
 
-	kfApp, err := coordinator.NewLoadKfAppFromURI(configFilePath)
+	kfApp, err := coordinator.NewLoadKfAppFromURIContext(ctx, configFilePath)
 	if err != nil {
 		log.Errorf("failed to build kfApp from URI %v: Error: %v.", configFilePath, err)
 
@@ -0,0 +1,32 @@
+package kfdef
+
+import (
+	"context"
+
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+// withLock returns a copy of ctx carrying the lock kfdef refers to, if it refers to one, so that its repos and
+// images are pinned to the versions the lock locks them at.
+func withLock(ctx context.Context, kfdef *kfdefv1.KfDef) (context.Context, error) {
+	if kfdef.Spec.Lock == nil {
+		return ctx, nil
+	}
+	ref := kfdef.Spec.Lock.ConfigMapKeyRef
+	content, err := kfconfig.ReadConfigMapKeyRef(kfdef.Namespace, ref)
+	if err != nil {
+		return nil, err
+	}
+	if content == "" && ref.Optional != nil && *ref.Optional {
+		log.Infof("Lock %v/%v of KfDef %v.%v doesn't exist; it's optional so the KfDef isn't locked.",
+			ref.Name, ref.Key, kfdef.GetName(), kfdef.GetNamespace())
+		return ctx, nil
+	}
+	lock, err := kfconfig.ParseLock([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	return kfconfig.WithLock(ctx, lock), nil
+}
@@ -0,0 +1,197 @@
+package kfdef
+
+import (
+	"context"
+	"reflect"
+
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// parameterSourceField indexes the KfDefs by the ConfigMaps and Secrets they read from, as parameterSourceKey.
+const parameterSourceField = "kfctl.parameterSources"
+
+// watchParameterSources requeues the KfDefs having parameters or secrets read from a ConfigMap or Secret
+// whenever that object changes, so that the new value is rendered and applied. Only the ConfigMaps and Secrets
+// labeled with kfconfig.ParameterSourceLabel are watched, so the data of the other ones isn't cached, and the
+// changes of those no KfDef of their namespace reads from are dropped before they are queued. The KfDefs reading
+// from an object are looked up through an index of the cache of mgr.
+func watchParameterSources(mgr manager.Manager, c controller.Controller) error {
+	err := mgr.GetFieldIndexer().IndexField(&kfdefv1.KfDef{}, parameterSourceField, func(obj runtime.Object) []string {
+		instance, ok := obj.(*kfdefv1.KfDef)
+		if !ok {
+			return nil
+		}
+		return parameterSources(instance)
+	})
+	if err != nil {
+		return err
+	}
+
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = kfconfig.ParameterSourceLabel
+		}))
+	r := mgr.GetClient()
+	for _, informer := range []cache.Informer{factory.Core().V1().ConfigMaps().Informer(), factory.Core().V1().Secrets().Informer()} {
+		err := c.Watch(&source.Informer{Informer: informer}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+				requests := parameterReaders(r, a.Meta, a.Object)
+				for _, request := range requests {
+					log.Infof("Watch a change for the parameters of KfDef %v.%v.", request.Name, request.Namespace)
+				}
+				return requests
+			}),
+		}, parameterSourcePredicates(r))
+		if err != nil {
+			return err
+		}
+	}
+	return mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		factory.Start(stop)
+		<-stop
+		return nil
+	}))
+}
+
+// parameterSourceKey returns the key the KfDefs reading from the ConfigMap or Secret name are indexed by.
+func parameterSourceKey(name string, isSecret bool) string {
+	if isSecret {
+		return "Secret/" + name
+	}
+	return "ConfigMap/" + name
+}
+
+// parameterReaders returns the requests of the KfDefs of the namespace of obj that read from it, obj being a
+// ConfigMap or a Secret.
+func parameterReaders(r client.Client, meta metav1.Object, obj runtime.Object) []reconcile.Request {
+	_, isSecret := obj.(*v1.Secret)
+	kfdefs := &kfdefv1.KfDefList{}
+	err := r.List(context.TODO(), kfdefs, client.InNamespace(meta.GetNamespace()),
+		client.MatchingField(parameterSourceField, parameterSourceKey(meta.GetName(), isSecret)))
+	if err != nil {
+		log.Errorf("Failed to list the KfDefs of namespace %v. Error: %v.", meta.GetNamespace(), err)
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for i := range kfdefs.Items {
+		instance := &kfdefs.Items[i]
+		if instance.GetDeletionTimestamp() == nil {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()},
+			})
+		}
+	}
+	return requests
+}
+
+// parameterSources returns the keys of the Secrets and ConfigMaps a variable, global or application parameter
+// of instance is read from. Secrets referenced by the secrets of instance and the ConfigMaps holding its base
+// and its lock count as well.
+func parameterSources(instance *kfdefv1.KfDef) []string {
+	sources := sets.NewString()
+	if extends := instance.Spec.Extends; extends != nil && extends.ConfigMapKeyRef != nil {
+		sources.Insert(parameterSourceKey(extends.ConfigMapKeyRef.Name, false))
+	}
+	if lock := instance.Spec.Lock; lock != nil && lock.ConfigMapKeyRef != nil {
+		sources.Insert(parameterSourceKey(lock.ConfigMapKeyRef.Name, false))
+	}
+	for _, s := range instance.Spec.Secrets {
+		if s.SecretSource == nil {
+			continue
+		}
+		if s.SecretSource.SecretKeyRef != nil {
+			sources.Insert(parameterSourceKey(s.SecretSource.SecretKeyRef.Name, true))
+		}
+		if s.SecretSource.Generated != nil {
+			sources.Insert(parameterSourceKey(kfconfig.GeneratedSecretName(instance.Name, s.Name), true))
+		}
+	}
+	params := append([]kfdefv1.NameValue{}, instance.Spec.Variables...)
+	params = append(params, instance.Spec.GlobalParameters...)
+	for _, app := range instance.Spec.Applications {
+		if app.KustomizeConfig != nil {
+			params = append(params, app.KustomizeConfig.Parameters...)
+		}
+	}
+	for _, p := range params {
+		if p.ValueFrom == nil {
+			continue
+		}
+		if p.ValueFrom.SecretKeyRef != nil {
+			sources.Insert(parameterSourceKey(p.ValueFrom.SecretKeyRef.Name, true))
+		}
+		if p.ValueFrom.ConfigMapKeyRef != nil {
+			sources.Insert(parameterSourceKey(p.ValueFrom.ConfigMapKeyRef.Name, false))
+		}
+	}
+	return sources.List()
+}
+
+// ensureGeneratedSecrets creates the Secrets holding the generated secrets of instance that don't exist yet.
+// They are owned by instance, so they are kept across renders and operator restarts and deleted with it.
+func ensureGeneratedSecrets(instance *kfdefv1.KfDef) error {
+	owner := metav1.NewControllerRef(instance, kfdefv1.SchemeGroupVersion.WithKind("KfDef"))
+	for _, s := range instance.Spec.Secrets {
+		if s.SecretSource == nil || s.SecretSource.Generated == nil {
+			continue
+		}
+		source := (*kfconfig.GeneratedSource)(s.SecretSource.Generated)
+		if err := kfconfig.EnsureGeneratedSecret(instance.Namespace, instance.Name, s.Name, source, owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parameterSourcePredicates lets through the changes of the ConfigMaps and Secrets a KfDef reads from, the
+// KfDefs being looked up in the index of the cache of the manager, and ignores the updates that don't change
+// their data.
+func parameterSourcePredicates(r client.Client) predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return len(parameterReaders(r, e.Meta, e.Object)) > 0
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return len(parameterReaders(r, e.Meta, e.Object)) > 0
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			changed := true
+			switch old := e.ObjectOld.(type) {
+			case *v1.ConfigMap:
+				if updated, ok := e.ObjectNew.(*v1.ConfigMap); ok {
+					changed = !reflect.DeepEqual(old.Data, updated.Data) || !reflect.DeepEqual(old.BinaryData, updated.BinaryData)
+				}
+			case *v1.Secret:
+				if updated, ok := e.ObjectNew.(*v1.Secret); ok {
+					changed = !reflect.DeepEqual(old.Data, updated.Data)
+				}
+			}
+			return changed && len(parameterReaders(r, e.MetaNew, e.ObjectNew)) > 0
+		},
+		GenericFunc: func(_ event.GenericEvent) bool {
+			return false
+		},
+	}
+}
@@ -0,0 +1,174 @@
+package kfdef
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// indexedKfDefs lists KfDefs as the cache of the manager does with the parameter source index.
+type indexedKfDefs struct {
+	client.Client
+	items []kfdefv1.KfDef
+}
+
+func (c *indexedKfDefs) List(_ context.Context, list runtime.Object, opts ...client.ListOption) error {
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	key, ok := "", false
+	if listOpts.FieldSelector != nil {
+		key, ok = listOpts.FieldSelector.RequiresExactMatch(parameterSourceField)
+	}
+	kfdefs := list.(*kfdefv1.KfDefList)
+	for _, item := range c.items {
+		if listOpts.Namespace != "" && item.Namespace != listOpts.Namespace {
+			continue
+		}
+		if ok {
+			indexed := false
+			for _, source := range parameterSources(&item) {
+				indexed = indexed || source == key
+			}
+			if !indexed {
+				continue
+			}
+		}
+		kfdefs.Items = append(kfdefs.Items, item)
+	}
+	return nil
+}
+
+func newParameterKfDef(name string) kfdefv1.KfDef {
+	return kfdefv1.KfDef{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kubeflow"},
+		Spec: kfdefv1.KfDefSpec{
+			Extends: &kfdefv1.KfDefBase{ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: "base"},
+			}},
+			Secrets: []kfdefv1.Secret{
+				{
+					Name: "password",
+					SecretSource: &kfdefv1.SecretSource{SecretKeyRef: &v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: "creds"},
+					}},
+				},
+				{
+					Name:         "token",
+					SecretSource: &kfdefv1.SecretSource{Generated: &kfdefv1.GeneratedSource{}},
+				},
+			},
+			Applications: []kfdefv1.Application{
+				{
+					Name: "app",
+					KustomizeConfig: &kfdefv1.KustomizeConfig{
+						Parameters: []kfdefv1.NameValue{
+							{Name: "host", ValueFrom: &kfdefv1.ValueSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+								LocalObjectReference: v1.LocalObjectReference{Name: "params"},
+							}}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestParameterSources(t *testing.T) {
+	instance := newParameterKfDef("kf")
+	expected := []string{"ConfigMap/base", "ConfigMap/params", "Secret/creds", "Secret/kf-token"}
+	if sources := parameterSources(&instance); !reflect.DeepEqual(sources, expected) {
+		t.Errorf("Got parameter sources %v; want %v", sources, expected)
+	}
+}
+
+func TestParameterReaders(t *testing.T) {
+	deleting := newParameterKfDef("deleting")
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	other := newParameterKfDef("other")
+	other.Namespace = "other"
+	r := &indexedKfDefs{items: []kfdefv1.KfDef{newParameterKfDef("kf"), deleting, other}}
+
+	kf := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "kf", Namespace: "kubeflow"}}}
+	type testCase struct {
+		Name     string
+		Object   runtime.Object
+		Expected []reconcile.Request
+	}
+	cases := []testCase{
+		{
+			Name:     "configmap",
+			Object:   &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "kubeflow"}},
+			Expected: kf,
+		},
+		{
+			Name:     "generated-secret",
+			Object:   &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "kf-token", Namespace: "kubeflow"}},
+			Expected: kf,
+		},
+		{
+			// A Secret and a ConfigMap of the same name are different sources.
+			Name:     "secret-named-as-configmap",
+			Object:   &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "kubeflow"}},
+			Expected: []reconcile.Request{},
+		},
+		{
+			Name:     "unread",
+			Object:   &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unread", Namespace: "kubeflow"}},
+			Expected: []reconcile.Request{},
+		},
+	}
+	for _, c := range cases {
+		meta := c.Object.(metav1.Object)
+		if requests := parameterReaders(r, meta, c.Object); !reflect.DeepEqual(requests, c.Expected) {
+			t.Errorf("Case %v; got requests %v; want %v", c.Name, requests, c.Expected)
+		}
+	}
+}
+
+func TestParameterSourcePredicates(t *testing.T) {
+	r := &indexedKfDefs{items: []kfdefv1.KfDef{newParameterKfDef("kf")}}
+	predicates := parameterSourcePredicates(r)
+
+	read := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "kubeflow"}, Data: map[string]string{"host": "a"}}
+	unread := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unread", Namespace: "kubeflow"}}
+	if !predicates.Create(event.CreateEvent{Meta: read, Object: read}) {
+		t.Errorf("Creation of a ConfigMap a KfDef reads from was dropped")
+	}
+	if predicates.Create(event.CreateEvent{Meta: unread, Object: unread}) {
+		t.Errorf("Creation of a ConfigMap no KfDef reads from was let through")
+	}
+	if !predicates.Delete(event.DeleteEvent{Meta: read, Object: read}) {
+		t.Errorf("Deletion of a ConfigMap a KfDef reads from was dropped")
+	}
+
+	relabeled := read.DeepCopy()
+	relabeled.Labels = map[string]string{"team": "ml"}
+	if predicates.Update(event.UpdateEvent{MetaOld: read, ObjectOld: read, MetaNew: relabeled, ObjectNew: relabeled}) {
+		t.Errorf("Update leaving the data of a ConfigMap unchanged was let through")
+	}
+	updated := read.DeepCopy()
+	updated.Data["host"] = "b"
+	if !predicates.Update(event.UpdateEvent{MetaOld: read, ObjectOld: read, MetaNew: updated, ObjectNew: updated}) {
+		t.Errorf("Update of the data of a ConfigMap a KfDef reads from was dropped")
+	}
+
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "kubeflow"}, Data: map[string][]byte{"k": []byte("a")}}
+	rotated := secret.DeepCopy()
+	rotated.Data["k"] = []byte("b")
+	if !predicates.Update(event.UpdateEvent{MetaOld: secret, ObjectOld: secret, MetaNew: rotated, ObjectNew: rotated}) {
+		t.Errorf("Update of the data of a Secret a KfDef reads from was dropped")
+	}
+	if predicates.Generic(event.GenericEvent{Meta: read, Object: read}) {
+		t.Errorf("Generic event was let through")
+	}
+}
@@ -0,0 +1,117 @@
+package kfdef
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	kftypesv3 "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeploymentInProgress is the reason of the Pending condition set while the applications are applied.
+const DeploymentInProgress string = "Kubeflow Deployment in progress"
+
+// progressRecorder turns the progress of an apply into Kubernetes Events on the KfDef and keeps
+// its Pending condition up to date while the applications are applied.
+type progressRecorder struct {
+	r        *ReconcileKfDef
+	instance *kfdefv1.KfDef
+	applied  []string
+}
+
+func (r *ReconcileKfDef) newProgressRecorder(instance *kfdefv1.KfDef) *progressRecorder {
+	return &progressRecorder{r: r, instance: instance}
+}
+
+func (p *progressRecorder) Progress(e kftypesv3.ProgressEvent) {
+	switch e.Type {
+	case kftypesv3.RenderStarted:
+		msg := fmt.Sprintf("Applying application %v", e.Application)
+		if len(p.applied) > 0 {
+			msg += fmt.Sprintf("; applied: %v", strings.Join(p.applied, ", "))
+		}
+		p.setPending(msg)
+	case kftypesv3.RenderFinished:
+		if e.Err != nil {
+			p.r.recorder.Eventf(p.instance, corev1.EventTypeWarning, "RenderFailed",
+				"Failed to render application %v: %v", e.Application, e.Err)
+		}
+	case kftypesv3.ObjectFailed:
+		p.r.recorder.Eventf(p.instance, corev1.EventTypeWarning, "ApplyFailed",
+			"Failed to apply %v %v of application %v", e.Kind, objectName(e), e.Application)
+	case kftypesv3.RetryScheduled:
+		p.r.recorder.Eventf(p.instance, corev1.EventTypeWarning, "ApplyRetry",
+			"Retrying application %v in %.0f seconds: %v", e.Application, e.RetryIn.Seconds(), e.Err)
+	case kftypesv3.ApplicationApplied:
+		p.applied = append(p.applied, e.Application)
+		p.r.recorder.Eventf(p.instance, corev1.EventTypeNormal, "ApplicationApplied",
+			"Application %v applied", e.Application)
+	case kftypesv3.ApplicationDisabled:
+		p.r.recorder.Eventf(p.instance, corev1.EventTypeNormal, "ApplicationDisabled",
+			"Application %v disabled", e.Application)
+	case kftypesv3.ApplicationSkipped:
+		p.r.recorder.Eventf(p.instance, corev1.EventTypeNormal, "ApplicationSkipped",
+			"Application %v skipped: %v", e.Application, e.Reason)
+	case kftypesv3.RepoRetryScheduled:
+		p.r.recorder.Eventf(p.instance, corev1.EventTypeWarning, "RepoFetchRetry",
+			"Retrying to fetch repo %v in %.0f seconds: %v", e.Repo, e.RetryIn.Seconds(), e.Err)
+	case kftypesv3.RepoFetched:
+		if e.Err != nil {
+			p.r.recorder.Eventf(p.instance, corev1.EventTypeWarning, "RepoFetchFailed",
+				"Failed to fetch repo %v: %v", e.Repo, e.Err)
+		}
+	}
+}
+
+// setPending records msg in the Pending condition of the KfDef status.
+// Failing to do so doesn't affect the apply; the status is reconciled once it is done.
+func (p *progressRecorder) setPending(msg string) {
+	status := p.instance.DeepCopy()
+	now := metav1.Now()
+	pending := kfdefv1.KfDefCondition{
+		Type:           kfdefv1.Pending,
+		Status:         corev1.ConditionTrue,
+		LastUpdateTime: now,
+		Reason:         DeploymentInProgress,
+		Message:        msg,
+	}
+	conditions := []kfdefv1.KfDefCondition{}
+	for _, c := range status.Status.Conditions {
+		if c.Type == kfdefv1.Pending {
+			pending.LastTransitionTime = c.LastTransitionTime
+			continue
+		}
+		conditions = append(conditions, c)
+	}
+	if pending.LastTransitionTime.IsZero() {
+		pending.LastTransitionTime = now
+	}
+	status.Status.Conditions = append(conditions, pending)
+	if err := p.r.setKfDefStatus(status); err != nil {
+		log.Warnf("Failed to update the progress of KfDef %v.%v: %v.", p.instance.Name, p.instance.Namespace, err)
+		return
+	}
+	p.instance.Status.Conditions = status.Status.Conditions
+}
+
+func objectName(e kftypesv3.ProgressEvent) string {
+	if e.Namespace == "" {
+		return e.Name
+	}
+	return e.Namespace + "/" + e.Name
+}
+
+// isStatusOnlyUpdate tells whether an update of a KfDef changed nothing but its status, e.g. the progress
+// recorded while it is applied. Such updates must not trigger another reconcile.
+func isStatusOnlyUpdate(old, new *kfdefv1.KfDef) bool {
+	return reflect.DeepEqual(old.Spec, new.Spec) &&
+		reflect.DeepEqual(old.GetLabels(), new.GetLabels()) &&
+		reflect.DeepEqual(old.GetAnnotations(), new.GetAnnotations()) &&
+		reflect.DeepEqual(old.GetFinalizers(), new.GetFinalizers()) &&
+		old.GetDeletionTimestamp().Equal(new.GetDeletionTimestamp()) &&
+		!reflect.DeepEqual(old.Status, new.Status)
+}
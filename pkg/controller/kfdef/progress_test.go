@@ -0,0 +1,135 @@
+package kfdef
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	kftypesv3 "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIsStatusOnlyUpdate(t *testing.T) {
+	old := &kfdefv1.KfDef{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubeflow", Namespace: "kubeflow", Labels: map[string]string{"a": "b"}},
+		Spec:       kfdefv1.KfDefSpec{Version: "v1"},
+	}
+	type testCase struct {
+		Name     string
+		Update   func(*kfdefv1.KfDef)
+		Expected bool
+	}
+	cases := []testCase{
+		{
+			Name: "status",
+			Update: func(d *kfdefv1.KfDef) {
+				d.Status.Conditions = []kfdefv1.KfDefCondition{{Type: kfdefv1.Pending, Message: "Applying application istio"}}
+			},
+			Expected: true,
+		},
+		{
+			Name:     "nothing",
+			Update:   func(d *kfdefv1.KfDef) {},
+			Expected: false,
+		},
+		{
+			Name: "spec-and-status",
+			Update: func(d *kfdefv1.KfDef) {
+				d.Spec.Version = "v2"
+				d.Status.Conditions = []kfdefv1.KfDefCondition{{Type: kfdefv1.Pending}}
+			},
+			Expected: false,
+		},
+		{
+			Name: "labels-and-status",
+			Update: func(d *kfdefv1.KfDef) {
+				d.Labels = map[string]string{"a": "c"}
+				d.Status.Conditions = []kfdefv1.KfDefCondition{{Type: kfdefv1.Pending}}
+			},
+			Expected: false,
+		},
+		{
+			Name: "deletion-and-status",
+			Update: func(d *kfdefv1.KfDef) {
+				now := metav1.Now()
+				d.DeletionTimestamp = &now
+				d.Status.Conditions = []kfdefv1.KfDefCondition{{Type: kfdefv1.Pending}}
+			},
+			Expected: false,
+		},
+	}
+	for _, c := range cases {
+		updated := old.DeepCopy()
+		c.Update(updated)
+		if actual := isStatusOnlyUpdate(old, updated); actual != c.Expected {
+			t.Errorf("Case %v; got %v; want %v", c.Name, actual, c.Expected)
+		}
+	}
+}
+
+func TestProgressRecorder(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kfdefv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("Failed to build the scheme; %v", err)
+	}
+	instance := &kfdefv1.KfDef{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubeflow", Namespace: "kubeflow"},
+		Status: kfdefv1.KfDefStatus{Conditions: []kfdefv1.KfDefCondition{
+			{Type: kfdefv1.KfAvailable, Status: corev1.ConditionTrue},
+		}},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &ReconcileKfDef{client: fake.NewFakeClientWithScheme(scheme, instance.DeepCopy()), recorder: recorder}
+	p := r.newProgressRecorder(instance)
+
+	p.Progress(kftypesv3.ProgressEvent{Type: kftypesv3.RenderStarted, Application: "istio"})
+	p.Progress(kftypesv3.ProgressEvent{Type: kftypesv3.ObjectFailed, Application: "istio", Kind: "Deployment", Namespace: "istio-system", Name: "istiod"})
+	p.Progress(kftypesv3.ProgressEvent{Type: kftypesv3.RetryScheduled, Application: "istio", RetryIn: 5 * time.Second, Err: fmt.Errorf("webhook not ready")})
+	p.Progress(kftypesv3.ProgressEvent{Type: kftypesv3.ObjectUnchanged, Application: "istio", Kind: "Service", Name: "istiod"})
+	p.Progress(kftypesv3.ProgressEvent{Type: kftypesv3.ApplicationApplied, Application: "istio"})
+	p.Progress(kftypesv3.ProgressEvent{Type: kftypesv3.RenderStarted, Application: "pipelines"})
+
+	expectedEvents := []string{
+		"Warning ApplyFailed Failed to apply Deployment istio-system/istiod of application istio",
+		"Warning ApplyRetry Retrying application istio in 5 seconds: webhook not ready",
+		"Normal ApplicationApplied Application istio applied",
+	}
+	for _, expected := range expectedEvents {
+		select {
+		case event := <-recorder.Events:
+			if event != expected {
+				t.Errorf("Got event %q; want %q", event, expected)
+			}
+		default:
+			t.Errorf("Missing event %q", expected)
+		}
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("Got %v unexpected events", len(recorder.Events))
+	}
+
+	// The Pending condition tracks the application being applied; the other conditions are kept.
+	stored := &kfdefv1.KfDef{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "kubeflow", Namespace: "kubeflow"}, stored); err != nil {
+		t.Fatalf("Failed to get the KfDef; %v", err)
+	}
+	if len(stored.Status.Conditions) != 2 || stored.Status.Conditions[0].Type != kfdefv1.KfAvailable {
+		t.Fatalf("Got conditions %+v; want Available and Pending", stored.Status.Conditions)
+	}
+	pending := stored.Status.Conditions[1]
+	expectedMessage := "Applying application pipelines; applied: istio"
+	if pending.Type != kfdefv1.Pending || pending.Reason != DeploymentInProgress || pending.Message != expectedMessage {
+		t.Errorf("Got condition %+v; want Pending with message %q", pending, expectedMessage)
+	}
+	// The recorder keeps the conditions it stored, so the next update starts from them.
+	if n := len(instance.Status.Conditions); n != 2 || instance.Status.Conditions[n-1].Message != expectedMessage {
+		t.Errorf("Got recorded conditions %+v; want the stored ones", instance.Status.Conditions)
+	}
+}
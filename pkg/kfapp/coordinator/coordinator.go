@@ -17,6 +17,7 @@ limitations under the License.
 package coordinator
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -158,6 +159,11 @@ func isDirEmpty(dir string) bool {
 // NewLoadKfAppFromURI takes in a config file and constructs the KfApp
 // used by the build and apply semantics for kfctl
 func NewLoadKfAppFromURI(configFile string) (kftypesv3.KfApp, error) {
+	return NewLoadKfAppFromURIContext(context.Background(), configFile)
+}
+
+// NewLoadKfAppFromURIContext is NewLoadKfAppFromURI with the initial Init and Generate bound to ctx.
+func NewLoadKfAppFromURIContext(ctx context.Context, configFile string) (kftypesv3.KfAppContext, error) {
 	kfdef, err := kfconfigloaders.LoadConfigFromURI(configFile)
 	if err != nil {
 		return nil, &kfapis.KfError{
@@ -229,11 +235,11 @@ func NewLoadKfAppFromURI(configFile string) (kftypesv3.KfApp, error) {
 		c.PackageManagers[kftypesv3.KUSTOMIZE] = pkg
 	}
 
-	initErr := c.Init(kftypesv3.ALL)
+	initErr := c.InitContext(ctx, kftypesv3.ALL)
 	if initErr != nil {
 		return nil, fmt.Errorf("KfApp initiliazation failed: %v", initErr)
 	}
-	generateErr := c.Generate(kftypesv3.ALL)
+	generateErr := c.GenerateContext(ctx, kftypesv3.ALL)
 	if generateErr != nil {
 		return nil, fmt.Errorf("couldn't generate KfApp: %v", generateErr)
 	}
@@ -314,6 +320,11 @@ type KfDefGetterV1beta1 interface {
 	GetKfDefV1Beta1() *kfdefsv1beta1.KfDef
 }
 
+// KfConfigGetter returns the KfConfig used by an application.
+type KfConfigGetter interface {
+	GetKfConfig() *kfconfig.KfConfig
+}
+
 // Get reference to the plugin .
 type PluginGetter interface {
 	GetPlugin(name string) (kftypesv3.KfApp, bool)
@@ -339,6 +350,11 @@ func (kfapp *coordinator) GetKfDefV1Beta1() *kfdefsv1beta1.KfDef {
 	return kfdefIns
 }
 
+// GetKfConfig returns the KfConfig used by this application.
+func (kfapp *coordinator) GetKfConfig() *kfconfig.KfConfig {
+	return kfapp.KfDef
+}
+
 // GetPlatform returns the specified platform.
 func (kfapp *coordinator) GetPlugin(name string) (kftypesv3.KfApp, bool) {
 
@@ -351,8 +367,13 @@ func (kfapp *coordinator) GetPlugin(name string) (kftypesv3.KfApp, bool) {
 }
 
 func (kfapp *coordinator) Dump(resources kftypesv3.ResourceEnum) error {
+	return kfapp.DumpContext(context.Background(), resources)
+}
+
+// DumpContext is Dump bound to ctx.
+func (kfapp *coordinator) DumpContext(ctx context.Context, resources kftypesv3.ResourceEnum) error {
 	for packageManagerName, packageManager := range kfapp.PackageManagers {
-		err := packageManager.Dump(kftypesv3.K8S)
+		err := kftypesv3.WithContext(packageManager).DumpContext(ctx, kftypesv3.K8S)
 		if err != nil {
 			return &kfapis.KfError{
 				Code: int(kfapis.INTERNAL_ERROR),
@@ -365,11 +386,16 @@ func (kfapp *coordinator) Dump(resources kftypesv3.ResourceEnum) error {
 }
 
 func (kfapp *coordinator) Apply(resources kftypesv3.ResourceEnum) error {
+	return kfapp.ApplyContext(context.Background(), resources)
+}
+
+// ApplyContext is Apply bound to ctx. ctx is passed down to the cache sync, the platform and the package managers.
+func (kfapp *coordinator) ApplyContext(ctx context.Context, resources kftypesv3.ResourceEnum) error {
 	platform := func() error {
 		if kfapp.KfDef.Spec.Platform != "" {
 			platform := kfapp.Platforms[kfapp.KfDef.Spec.Platform]
 			if platform != nil {
-				platformErr := platform.Apply(resources)
+				platformErr := kftypesv3.WithContext(platform).ApplyContext(ctx, resources)
 				if platformErr != nil {
 					return &kfapis.KfError{
 						Code: int(kfapis.INTERNAL_ERROR),
@@ -390,7 +416,7 @@ func (kfapp *coordinator) Apply(resources kftypesv3.ResourceEnum) error {
 
 	k8s := func() error {
 		for packageManagerName, packageManager := range kfapp.PackageManagers {
-			packageManagerErr := packageManager.Apply(kftypesv3.K8S)
+			packageManagerErr := kftypesv3.WithContext(packageManager).ApplyContext(ctx, kftypesv3.K8S)
 			if packageManagerErr != nil {
 				return &kfapis.KfError{
 					Code: int(kfapis.INTERNAL_ERROR),
@@ -430,7 +456,7 @@ func (kfapp *coordinator) Apply(resources kftypesv3.ResourceEnum) error {
 		}
 	}
 
-	if err := kfapp.KfDef.SyncCache(); err != nil {
+	if err := kfapp.KfDef.SyncCacheContext(ctx); err != nil {
 		return &kfapis.KfError{
 			Code:    int(kfapis.INTERNAL_ERROR),
 			Message: fmt.Sprintf("could not sync cache. Error: %v", err),
@@ -460,11 +486,16 @@ func (kfapp *coordinator) Apply(resources kftypesv3.ResourceEnum) error {
 }
 
 func (kfapp *coordinator) Delete(resources kftypesv3.ResourceEnum) error {
+	return kfapp.DeleteContext(context.Background(), resources)
+}
+
+// DeleteContext is Delete bound to ctx. ctx is passed down to the cache sync, the platform and the package managers.
+func (kfapp *coordinator) DeleteContext(ctx context.Context, resources kftypesv3.ResourceEnum) error {
 	platform := func() error {
 		if kfapp.KfDef.Spec.Platform != "" {
 			platform := kfapp.Platforms[kfapp.KfDef.Spec.Platform]
 			if platform != nil {
-				platformErr := platform.Delete(resources)
+				platformErr := kftypesv3.WithContext(platform).DeleteContext(ctx, resources)
 				if platformErr != nil {
 					return &kfapis.KfError{
 						Code: int(kfapis.INTERNAL_ERROR),
@@ -485,7 +516,7 @@ func (kfapp *coordinator) Delete(resources kftypesv3.ResourceEnum) error {
 
 	k8s := func() error {
 		for packageManagerName, packageManager := range kfapp.PackageManagers {
-			packageManagerErr := packageManager.Delete(kftypesv3.K8S)
+			packageManagerErr := kftypesv3.WithContext(packageManager).DeleteContext(ctx, kftypesv3.K8S)
 			if packageManagerErr != nil {
 				return &kfapis.KfError{
 					Code: int(kfapis.INTERNAL_ERROR),
@@ -497,7 +528,7 @@ func (kfapp *coordinator) Delete(resources kftypesv3.ResourceEnum) error {
 		return nil
 	}
 
-	if err := kfapp.KfDef.SyncCache(); err != nil {
+	if err := kfapp.KfDef.SyncCacheContext(ctx); err != nil {
 		return &kfapis.KfError{
 			Code:    int(kfapis.INTERNAL_ERROR),
 			Message: fmt.Sprintf("could not sync cache. Error: %v", err),
@@ -530,11 +561,16 @@ func (kfapp *coordinator) Delete(resources kftypesv3.ResourceEnum) error {
 }
 
 func (kfapp *coordinator) Generate(resources kftypesv3.ResourceEnum) error {
+	return kfapp.GenerateContext(context.Background(), resources)
+}
+
+// GenerateContext is Generate bound to ctx. ctx is passed down to the cache sync, the platform and the package managers.
+func (kfapp *coordinator) GenerateContext(ctx context.Context, resources kftypesv3.ResourceEnum) error {
 	platform := func() error {
 		if kfapp.KfDef.Spec.Platform != "" {
 			platform := kfapp.Platforms[kfapp.KfDef.Spec.Platform]
 			if platform != nil {
-				platformErr := platform.Generate(resources)
+				platformErr := kftypesv3.WithContext(platform).GenerateContext(ctx, resources)
 				if platformErr != nil {
 					return &kfapis.KfError{
 						Code: int(kfapis.INTERNAL_ERROR),
@@ -563,7 +599,7 @@ func (kfapp *coordinator) Generate(resources kftypesv3.ResourceEnum) error {
 
 	k8s := func() error {
 		for packageManagerName, packageManager := range kfapp.PackageManagers {
-			packageManagerErr := packageManager.Generate(kftypesv3.K8S)
+			packageManagerErr := kftypesv3.WithContext(packageManager).GenerateContext(ctx, kftypesv3.K8S)
 			if packageManagerErr != nil {
 				return &kfapis.KfError{
 					Code: int(kfapis.INTERNAL_ERROR),
@@ -578,7 +614,7 @@ func (kfapp *coordinator) Generate(resources kftypesv3.ResourceEnum) error {
 	// Print out warning message if using usage reporting component.
 	usageReportWarn(kfapp.KfDef.Spec.Applications)
 
-	if err := kfapp.KfDef.SyncCache(); err != nil {
+	if err := kfapp.KfDef.SyncCacheContext(ctx); err != nil {
 		return &kfapis.KfError{
 			Code:    int(kfapis.INTERNAL_ERROR),
 			Message: fmt.Sprintf("could not sync cache. Error: %v", err),
@@ -600,11 +636,16 @@ func (kfapp *coordinator) Generate(resources kftypesv3.ResourceEnum) error {
 }
 
 func (kfapp *coordinator) Init(resources kftypesv3.ResourceEnum) error {
+	return kfapp.InitContext(context.Background(), resources)
+}
+
+// InitContext is Init bound to ctx.
+func (kfapp *coordinator) InitContext(ctx context.Context, resources kftypesv3.ResourceEnum) error {
 	platform := func() error {
 		if kfapp.KfDef.Spec.Platform != "" {
 			platform := kfapp.Platforms[kfapp.KfDef.Spec.Platform]
 			if platform != nil {
-				platformErr := platform.Init(resources)
+				platformErr := kftypesv3.WithContext(platform).InitContext(ctx, resources)
 				if platformErr != nil {
 					return &kfapis.KfError{
 						Code: int(kfapis.INTERNAL_ERROR),
@@ -633,7 +674,7 @@ func (kfapp *coordinator) Init(resources kftypesv3.ResourceEnum) error {
 
 	k8s := func() error {
 		for packageManagerName, packageManager := range kfapp.PackageManagers {
-			packageManagerErr := packageManager.Init(kftypesv3.K8S)
+			packageManagerErr := kftypesv3.WithContext(packageManager).InitContext(ctx, kftypesv3.K8S)
 			if packageManagerErr != nil {
 				return &kfapis.KfError{
 					Code: int(kfapis.INTERNAL_ERROR),
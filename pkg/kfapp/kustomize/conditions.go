@@ -0,0 +1,302 @@
+// Copyright 2018 The Kubeflow Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kustomize
+
+import (
+	"fmt"
+	"strings"
+
+	kfapisv3 "github.com/kubeflow/kfctl/v3/pkg/apis"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	storagev1 "k8s.io/client-go/kubernetes/typed/storage/v1"
+)
+
+const (
+	// PlatformOpenShift is the platform of clusters serving the OpenShift APIs.
+	PlatformOpenShift = "openshift"
+	// PlatformKubernetes is the platform of the other clusters.
+	PlatformKubernetes = "kubernetes"
+)
+
+// openShiftGroups are API groups only served by OpenShift clusters.
+var openShiftGroups = []string{"config.openshift.io", "route.openshift.io"}
+
+// clusterFacts answers the when conditions of applications and the cluster facts variables refer to from the
+// APIs of a cluster. Answers are cached for the lifetime of the facts, i.e. one apply.
+type clusterFacts struct {
+	discovery discovery.DiscoveryInterface
+	// dynamic and storage read the cluster facts that aren't answered by discovery.
+	dynamic  dynamic.Interface
+	storage  storagev1.StorageV1Interface
+	version  *version.Version
+	platform string
+	groups   *metav1.APIGroupList
+	// served caches whether <group>/<version>/<kind> is served.
+	served map[string]bool
+}
+
+func newClusterFacts(client discovery.DiscoveryInterface) *clusterFacts {
+	return &clusterFacts{
+		discovery: client,
+		served:    map[string]bool{},
+	}
+}
+
+// clusterFacts returns the facts of the cluster of the KfDef, creating them on first use.
+func (kustomize *kustomize) clusterFacts() (*clusterFacts, error) {
+	if kustomize.facts != nil {
+		return kustomize.facts, nil
+	}
+	kustomize.initK8sClients()
+	if kustomize.restConfig == nil {
+		return nil, &kfapisv3.KfError{
+			Code:    int(kfapisv3.INVALID_ARGUMENT),
+			Message: "applications have when conditions or parameters refer to cluster facts but no cluster is configured",
+		}
+	}
+	client, err := discovery.NewDiscoveryClientForConfig(kustomize.restConfig)
+	if err != nil {
+		return nil, &kfapisv3.KfError{
+			Code:    int(kfapisv3.INTERNAL_ERROR),
+			Message: fmt.Sprintf("failed to create discovery client: %v", err),
+		}
+	}
+	facts := newClusterFacts(client)
+	if facts.dynamic, err = dynamic.NewForConfig(kustomize.restConfig); err != nil {
+		return nil, &kfapisv3.KfError{
+			Code:    int(kfapisv3.INTERNAL_ERROR),
+			Message: fmt.Sprintf("failed to create dynamic client: %v", err),
+		}
+	}
+	if facts.storage, err = storagev1.NewForConfig(kustomize.restConfig); err != nil {
+		return nil, &kfapisv3.KfError{
+			Code:    int(kfapisv3.INTERNAL_ERROR),
+			Message: fmt.Sprintf("failed to create storage/v1 client: %v", err),
+		}
+	}
+	kustomize.facts = facts
+	return kustomize.facts, nil
+}
+
+// evaluateWhen tells whether the when conditions of app hold, along with the reason why. Applications without
+// conditions are always included and get no reason.
+func (kustomize *kustomize) evaluateWhen(app kfconfig.Application) (bool, string, error) {
+	if app.When == nil {
+		return true, "", nil
+	}
+	facts, err := kustomize.clusterFacts()
+	if err != nil {
+		return false, "", err
+	}
+	included, reason, err := facts.evaluate(app.When)
+	if err != nil {
+		return false, "", &kfapisv3.KfError{
+			Code:    int(kfapisv3.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't evaluate the when conditions of application %v: %v", app.Name, err),
+		}
+	}
+	return included, reason, nil
+}
+
+// evaluate tells whether all conditions hold. The reason lists the outcome of every condition up to the first
+// one that doesn't hold.
+func (f *clusterFacts) evaluate(conditions *kfconfig.ApplicationConditions) (bool, string, error) {
+	reasons := []string{}
+	check := func(holds bool, reason string, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+		reasons = append(reasons, reason)
+		return holds, nil
+	}
+
+	if conditions.APIResourceExists != "" {
+		if holds, err := check(f.apiResourceExists(conditions.APIResourceExists)); !holds || err != nil {
+			return false, strings.Join(reasons, "; "), err
+		}
+	}
+	if conditions.ClusterVersion != "" {
+		if holds, err := check(f.clusterVersion(conditions.ClusterVersion)); !holds || err != nil {
+			return false, strings.Join(reasons, "; "), err
+		}
+	}
+	if conditions.Platform != "" {
+		if holds, err := check(f.isPlatform(conditions.Platform)); !holds || err != nil {
+			return false, strings.Join(reasons, "; "), err
+		}
+	}
+	return true, strings.Join(reasons, "; "), nil
+}
+
+// apiResourceExists tells whether the resource <group>/<version>/<kind> is served. The kind may also be
+// given as the plural resource name.
+func (f *clusterFacts) apiResourceExists(resource string) (bool, string, error) {
+	i := strings.LastIndex(resource, "/")
+	if i <= 0 || i == len(resource)-1 {
+		return false, "", fmt.Errorf("apiResourceExists %q isn't of the form <group>/<version>/<kind>", resource)
+	}
+	groupVersion, kind := resource[:i], resource[i+1:]
+	served, ok := f.served[resource]
+	if !ok {
+		groupServed, err := f.groupVersionServed(groupVersion)
+		if err != nil {
+			return false, "", err
+		}
+		if groupServed {
+			list, err := f.discovery.ServerResourcesForGroupVersion(groupVersion)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return false, "", fmt.Errorf("couldn't discover the resources of %v: %v", groupVersion, err)
+			}
+			if list != nil {
+				for _, r := range list.APIResources {
+					if r.Kind == kind || r.Name == kind {
+						served = true
+						break
+					}
+				}
+			}
+		}
+		f.served[resource] = served
+	}
+	if !served {
+		return false, fmt.Sprintf("API resource %v isn't served", resource), nil
+	}
+	return true, fmt.Sprintf("API resource %v is served", resource), nil
+}
+
+// clusterVersion tells whether the version of the cluster satisfies constraint. Only the components given in
+// the constraint are compared, so "== 1.20" holds for every 1.20 patch release.
+func (f *clusterFacts) clusterVersion(constraint string) (bool, string, error) {
+	op, want := "==", strings.TrimSpace(constraint)
+	for _, o := range []string{">=", "<=", "==", "!=", ">", "<"} {
+		if strings.HasPrefix(want, o) {
+			op, want = o, strings.TrimSpace(want[len(o):])
+			break
+		}
+	}
+	wantVersion, err := version.ParseGeneric(want)
+	if err != nil {
+		return false, "", fmt.Errorf("invalid clusterVersion %q: %v", constraint, err)
+	}
+	clusterVersion, err := f.serverVersion()
+	if err != nil {
+		return false, "", err
+	}
+	have := clusterVersion.Components()
+	if len(have) > len(wantVersion.Components()) {
+		have = have[:len(wantVersion.Components())]
+	}
+	parts := make([]string, len(have))
+	for i, c := range have {
+		parts[i] = fmt.Sprint(c)
+	}
+	cmp, err := wantVersion.Compare(strings.Join(parts, "."))
+	if err != nil {
+		return false, "", err
+	}
+	// cmp compares the wanted version to the one of the cluster, so it is negated.
+	cmp = -cmp
+	var holds bool
+	switch op {
+	case ">=":
+		holds = cmp >= 0
+	case "<=":
+		holds = cmp <= 0
+	case ">":
+		holds = cmp > 0
+	case "<":
+		holds = cmp < 0
+	case "==":
+		holds = cmp == 0
+	case "!=":
+		holds = cmp != 0
+	}
+	if !holds {
+		return false, fmt.Sprintf("cluster version %v doesn't satisfy %v %v", clusterVersion, op, want), nil
+	}
+	return true, fmt.Sprintf("cluster version %v satisfies %v %v", clusterVersion, op, want), nil
+}
+
+// groupVersionServed tells whether groupVersion, e.g. apps/v1 or v1, is served.
+func (f *clusterFacts) groupVersionServed(groupVersion string) (bool, error) {
+	groups, err := f.serverGroups()
+	if err != nil {
+		return false, err
+	}
+	for _, g := range groups.Groups {
+		for _, v := range g.Versions {
+			if v.GroupVersion == groupVersion {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// serverGroups returns the API groups of the cluster, discovering them on first use.
+func (f *clusterFacts) serverGroups() (*metav1.APIGroupList, error) {
+	if f.groups == nil {
+		groups, err := f.discovery.ServerGroups()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't discover the API groups of the cluster: %v", err)
+		}
+		f.groups = groups
+	}
+	return f.groups, nil
+}
+
+// serverVersion returns the Kubernetes version of the cluster, discovering it on first use.
+func (f *clusterFacts) serverVersion() (*version.Version, error) {
+	if f.version == nil {
+		info, err := f.discovery.ServerVersion()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get the version of the cluster: %v", err)
+		}
+		if f.version, err = version.ParseGeneric(info.GitVersion); err != nil {
+			return nil, fmt.Errorf("couldn't parse the version of the cluster %q: %v", info.GitVersion, err)
+		}
+	}
+	return f.version, nil
+}
+
+// isPlatform tells whether the cluster is of platform, openshift or kubernetes.
+func (f *clusterFacts) isPlatform(platform string) (bool, string, error) {
+	if platform != PlatformOpenShift && platform != PlatformKubernetes {
+		return false, "", fmt.Errorf("unknown platform %q; must be %v or %v", platform, PlatformOpenShift, PlatformKubernetes)
+	}
+	if f.platform == "" {
+		groups, err := f.serverGroups()
+		if err != nil {
+			return false, "", err
+		}
+		f.platform = PlatformKubernetes
+		for _, g := range groups.Groups {
+			for _, openShiftGroup := range openShiftGroups {
+				if g.Name == openShiftGroup {
+					f.platform = PlatformOpenShift
+				}
+			}
+		}
+	}
+	if f.platform != platform {
+		return false, fmt.Sprintf("platform is %v, not %v", f.platform, platform), nil
+	}
+	return true, fmt.Sprintf("platform is %v", platform), nil
+}
@@ -0,0 +1,134 @@
+package kustomize
+
+import (
+	"testing"
+
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClusterFacts_Evaluate(t *testing.T) {
+	client := &fakediscovery.FakeDiscovery{
+		Fake: &k8stesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "route.openshift.io/v1",
+					APIResources: []metav1.APIResource{{Name: "routes", Kind: "Route"}},
+				},
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{{Name: "pods", Kind: "Pod"}},
+				},
+			},
+		},
+		FakedServerVersion: &version.Info{GitVersion: "v1.20.4+k3s1"},
+	}
+
+	type testCase struct {
+		Name       string
+		Conditions kfconfig.ApplicationConditions
+		Expected   bool
+		Reason     string
+		Err        bool
+	}
+	cases := []testCase{
+		{
+			Name:       "resource-served",
+			Conditions: kfconfig.ApplicationConditions{APIResourceExists: "route.openshift.io/v1/Route"},
+			Expected:   true,
+			Reason:     "API resource route.openshift.io/v1/Route is served",
+		},
+		{
+			Name:       "core-resource-by-name",
+			Conditions: kfconfig.ApplicationConditions{APIResourceExists: "v1/pods"},
+			Expected:   true,
+			Reason:     "API resource v1/pods is served",
+		},
+		{
+			Name:       "resource-not-served",
+			Conditions: kfconfig.ApplicationConditions{APIResourceExists: "monitoring.coreos.com/v1/ServiceMonitor"},
+			Expected:   false,
+			Reason:     "API resource monitoring.coreos.com/v1/ServiceMonitor isn't served",
+		},
+		{
+			Name:       "version-at-least",
+			Conditions: kfconfig.ApplicationConditions{ClusterVersion: ">= 1.20"},
+			Expected:   true,
+			Reason:     "cluster version 1.20.4 satisfies >= 1.20",
+		},
+		{
+			Name:       "version-equal-minor",
+			Conditions: kfconfig.ApplicationConditions{ClusterVersion: "1.20"},
+			Expected:   true,
+			Reason:     "cluster version 1.20.4 satisfies == 1.20",
+		},
+		{
+			Name:       "version-too-old",
+			Conditions: kfconfig.ApplicationConditions{ClusterVersion: ">1.20.4"},
+			Expected:   false,
+			Reason:     "cluster version 1.20.4 doesn't satisfy > 1.20.4",
+		},
+		{
+			Name:       "invalid-version",
+			Conditions: kfconfig.ApplicationConditions{ClusterVersion: ">= latest"},
+			Err:        true,
+		},
+		{
+			Name: "all-hold",
+			Conditions: kfconfig.ApplicationConditions{
+				APIResourceExists: "route.openshift.io/v1/Route",
+				ClusterVersion:    "< 1.21",
+			},
+			Expected: true,
+			Reason:   "API resource route.openshift.io/v1/Route is served; cluster version 1.20.4 satisfies < 1.21",
+		},
+		{
+			Name: "first-failing",
+			Conditions: kfconfig.ApplicationConditions{
+				APIResourceExists: "route.openshift.io/v1/Route",
+				ClusterVersion:    "!= 1.20",
+			},
+			Expected: false,
+			Reason:   "API resource route.openshift.io/v1/Route is served; cluster version 1.20.4 doesn't satisfy != 1.20",
+		},
+		{
+			Name:       "openshift",
+			Conditions: kfconfig.ApplicationConditions{Platform: "openshift"},
+			Expected:   true,
+			Reason:     "platform is openshift",
+		},
+		{
+			Name:       "not-kubernetes",
+			Conditions: kfconfig.ApplicationConditions{Platform: "kubernetes"},
+			Expected:   false,
+			Reason:     "platform is openshift, not kubernetes",
+		},
+		{
+			Name:       "unknown-platform",
+			Conditions: kfconfig.ApplicationConditions{Platform: "mesos"},
+			Err:        true,
+		},
+	}
+
+	facts := newClusterFacts(client)
+	for _, c := range cases {
+		conditions := c.Conditions
+		included, reason, err := facts.evaluate(&conditions)
+		if c.Err {
+			if err == nil {
+				t.Errorf("Case %v: expected an error", c.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case %v: unexpected error %v", c.Name, err)
+			continue
+		}
+		if included != c.Expected || reason != c.Reason {
+			t.Errorf("Case %v: got %v (%v); want %v (%v)", c.Name, included, reason, c.Expected, c.Reason)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2018 The Kubeflow Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kustomize
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	kfapisv3 "github.com/kubeflow/kfctl/v3/pkg/apis"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	"github.com/kubeflow/kfctl/v3/pkg/utils"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// imageRewriter rewrites image references according to the ImageOverrides of a KfDef.
+type imageRewriter struct {
+	overrides *kfconfig.ImageOverrides
+}
+
+// newImageRewriter returns a rewriter for overrides, or nil if there is nothing to override.
+func newImageRewriter(overrides *kfconfig.ImageOverrides) (*imageRewriter, error) {
+	if overrides == nil || (len(overrides.Prefixes) == 0 && len(overrides.Images) == 0 && len(overrides.Digests) == 0) {
+		return nil, nil
+	}
+	for image, digest := range overrides.Digests {
+		if parts := strings.SplitN(digest, ":", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, &kfapisv3.KfError{
+				Code:    int(kfapisv3.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("digest %q of image %v isn't of the form <algorithm>:<hex>", digest, image),
+			}
+		}
+	}
+	return &imageRewriter{overrides: overrides}, nil
+}
+
+// splitImage splits an image reference into its name, tag and digest.
+func splitImage(ref string) (name string, tag string, digest string) {
+	name = ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, digest = name[:i], name[i+1:]
+	}
+	// A colon before the last slash separates the port of the registry, not a tag.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	return name, tag, digest
+}
+
+func joinImage(name string, tag string, digest string) string {
+	if digest != "" {
+		return name + "@" + digest
+	}
+	if tag != "" {
+		return name + ":" + tag
+	}
+	return name
+}
+
+// rewrite returns the image that ref is replaced with.
+func (r *imageRewriter) rewrite(ref string) string {
+	name, tag, digest := splitImage(ref)
+
+	if replacement, ok := r.overrides.Images[ref]; ok && ref != name {
+		name, tag, digest = splitImage(replacement)
+	} else if replacement, ok := r.overrides.Images[name]; ok {
+		newName, newTag, newDigest := splitImage(replacement)
+		name = newName
+		if newTag != "" || newDigest != "" {
+			tag, digest = newTag, newDigest
+		}
+	} else {
+		longest := ""
+		for prefix := range r.overrides.Prefixes {
+			if strings.HasPrefix(name, prefix) && len(prefix) > len(longest) {
+				longest = prefix
+			}
+		}
+		if longest != "" {
+			name = r.overrides.Prefixes[longest] + name[len(longest):]
+		}
+	}
+
+	if pinned, ok := r.overrides.Digests[name]; ok {
+		tag, digest = "", pinned
+	}
+	return joinImage(name, tag, digest)
+}
+
+// rewriteObject rewrites the images of all containers and init containers found in obj, whatever its kind,
+// as well as the image parameters of ConfigMaps, i.e. the entries whose key ends with "image".
+func (r *imageRewriter) rewriteObject(obj *unstructured.Unstructured) {
+	mapImages(obj, r.rewrite)
+}
+
+// pinImages replaces the images of obj, as rewriteObject finds them, with the digests lock pins them to. Images
+// lock doesn't pin are an error, since the object wouldn't be rendered as it was when lock was created.
+func pinImages(obj *unstructured.Unstructured, lock *kfconfig.Lock) error {
+	unlocked := ""
+	mapImages(obj, func(image string) string {
+		pinned, ok := lock.Image(image)
+		if !ok {
+			unlocked = image
+			return image
+		}
+		return pinned
+	})
+	if unlocked != "" {
+		return &kfapisv3.KfError{
+			Code:    int(kfapisv3.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("image %v of %v %v isn't locked", unlocked, obj.GetKind(), obj.GetName()),
+		}
+	}
+	return nil
+}
+
+// mapImages replaces the images of obj, as rewriteObject finds them, with what f returns for them.
+func mapImages(obj *unstructured.Unstructured, f func(image string) string) {
+	if obj.GetKind() == "ConfigMap" {
+		data, _, _ := unstructured.NestedStringMap(obj.Object, "data")
+		changed := false
+		for key, value := range data {
+			if value != "" && strings.HasSuffix(strings.ToLower(key), "image") {
+				if rewritten := f(value); rewritten != value {
+					data[key] = rewritten
+					changed = true
+				}
+			}
+		}
+		if changed {
+			unstructured.SetNestedStringMap(obj.Object, data, "data")
+		}
+		return
+	}
+	for key, value := range obj.Object {
+		if key != "metadata" && key != "status" {
+			mapContainerImages(value, f)
+		}
+	}
+}
+
+// mapContainerImages walks v looking for containers and initContainers lists. Walking the whole object
+// covers the pod templates of workloads as well as those of custom resources such as TFJobs.
+func mapContainerImages(v interface{}, f func(image string) string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, value := range t {
+			if key == "containers" || key == "initContainers" {
+				if containers, ok := value.([]interface{}); ok {
+					for _, c := range containers {
+						if container, ok := c.(map[string]interface{}); ok {
+							if image, ok := container["image"].(string); ok && image != "" {
+								container["image"] = f(image)
+							}
+						}
+					}
+				}
+			}
+			mapContainerImages(value, f)
+		}
+	case []interface{}:
+		for _, value := range t {
+			mapContainerImages(value, f)
+		}
+	}
+}
+
+// RenderedImages returns the images of the enabled applications of the KfApp built from kfDef, mapped to the
+// images they're replaced with once its ImageOverrides are applied. Applications are included whatever their
+// when conditions, so that the images are known before the cluster they'll be applied to is.
+func RenderedImages(kfDef *kfconfig.KfConfig) (map[string]string, error) {
+	rewriter, err := newImageRewriter(kfDef.Spec.ImageOverrides)
+	if err != nil {
+		return nil, err
+	}
+	images := map[string]string{}
+	collect := func(image string) string {
+		images[image] = image
+		if rewriter != nil {
+			images[image] = rewriter.rewrite(image)
+		}
+		return image
+	}
+	kustomizeDir := path.Join(kfDef.Spec.AppDir, outputDir)
+	for _, app := range kfDef.Spec.Applications {
+		if !app.IsEnabled() {
+			continue
+		}
+		objects, err := evaluateObjects(path.Join(kustomizeDir, app.Name), utils.InstallOrder)
+		if err != nil {
+			return nil, &kfapisv3.KfError{
+				Code:    int(kfapisv3.INTERNAL_ERROR),
+				Message: fmt.Sprintf("error evaluating kustomization manifest for %v: %v", app.Name, err),
+			}
+		}
+		if err := objects(func(obj *unstructured.Unstructured) error {
+			mapImages(obj, collect)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,162 @@
+package kustomize
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestImageRewriter_rewrite(t *testing.T) {
+	overrides := &kfconfig.ImageOverrides{
+		Prefixes: map[string]string{
+			"gcr.io/kubeflow-images-public":          "registry.local:5000/kubeflow",
+			"gcr.io/kubeflow-images-public/pipeline": "registry.local:5000/pipelines",
+		},
+		Images: map[string]string{
+			"gcr.io/ml-pipeline/api-server":         "registry.local:5000/api-server",
+			"docker.io/library/busybox:1.28":        "registry.local:5000/busybox:1.31",
+			"gcr.io/kubeflow-images-public/jupyter": "registry.local:5000/notebooks/jupyter:v2",
+		},
+		Digests: map[string]string{
+			"registry.local:5000/kubeflow/admission-webhook": "sha256:abc",
+		},
+	}
+	r, err := newImageRewriter(overrides)
+	if err != nil {
+		t.Fatalf("newImageRewriter error: %v", err)
+	}
+
+	testCases := map[string]string{
+		// Prefix mappings; the longest prefix wins.
+		"gcr.io/kubeflow-images-public/profile-controller:v1.0": "registry.local:5000/kubeflow/profile-controller:v1.0",
+		"gcr.io/kubeflow-images-public/pipeline/ui:0.2":         "registry.local:5000/pipelines/ui:0.2",
+		// Exact mappings keep the tag unless the replacement sets one and take precedence over prefixes.
+		"gcr.io/ml-pipeline/api-server:0.2.5":      "registry.local:5000/api-server:0.2.5",
+		"gcr.io/ml-pipeline/api-server@sha256:def": "registry.local:5000/api-server@sha256:def",
+		"gcr.io/kubeflow-images-public/jupyter:v1": "registry.local:5000/notebooks/jupyter:v2",
+		"docker.io/library/busybox:1.28":           "registry.local:5000/busybox:1.31",
+		"docker.io/library/busybox:1.30":           "docker.io/library/busybox:1.30",
+		// Digests pin the rewritten image.
+		"gcr.io/kubeflow-images-public/admission-webhook:v1": "registry.local:5000/kubeflow/admission-webhook@sha256:abc",
+		"quay.io/other/image":                                "quay.io/other/image",
+	}
+	for in, expected := range testCases {
+		if actual := r.rewrite(in); actual != expected {
+			t.Errorf("rewrite(%v) = %v, want %v", in, actual, expected)
+		}
+	}
+}
+
+func TestNewImageRewriter(t *testing.T) {
+	if r, err := newImageRewriter(&kfconfig.ImageOverrides{}); r != nil || err != nil {
+		t.Errorf("newImageRewriter of empty overrides = %v, %v; want nil, nil", r, err)
+	}
+	overrides := &kfconfig.ImageOverrides{
+		Digests: map[string]string{"gcr.io/kubeflow/image": "abc"},
+	}
+	if _, err := newImageRewriter(overrides); err == nil {
+		t.Errorf("newImageRewriter with an invalid digest should fail")
+	}
+}
+
+func TestImageRewriter_rewriteObject(t *testing.T) {
+	r, err := newImageRewriter(&kfconfig.ImageOverrides{
+		Prefixes: map[string]string{"gcr.io/": "mirror.local/"},
+	})
+	if err != nil {
+		t.Fatalf("newImageRewriter error: %v", err)
+	}
+
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":        "gcr.io/not-an-image",
+			"annotations": map[string]interface{}{"image": "gcr.io/kept"},
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"initContainers": []interface{}{
+						map[string]interface{}{"name": "init", "image": "gcr.io/init:v1"},
+					},
+					"containers": []interface{}{
+						map[string]interface{}{"name": "main", "image": "gcr.io/main:v1"},
+					},
+				},
+			},
+		},
+	}}
+	r.rewriteObject(deployment)
+	containers, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "containers")
+	initContainers, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "initContainers")
+	if image := containers[0].(map[string]interface{})["image"]; image != "mirror.local/main:v1" {
+		t.Errorf("container image = %v, want mirror.local/main:v1", image)
+	}
+	if image := initContainers[0].(map[string]interface{})["image"]; image != "mirror.local/init:v1" {
+		t.Errorf("init container image = %v, want mirror.local/init:v1", image)
+	}
+	if deployment.GetName() != "gcr.io/not-an-image" || deployment.GetAnnotations()["image"] != "gcr.io/kept" {
+		t.Errorf("metadata shouldn't be rewritten: %v", deployment.Object["metadata"])
+	}
+
+	configMap := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "parameters"},
+		"data": map[string]interface{}{
+			"notebookImage": "gcr.io/notebook:v1",
+			"registry":      "gcr.io/",
+		},
+	}}
+	r.rewriteObject(configMap)
+	data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+	expected := map[string]string{
+		"notebookImage": "mirror.local/notebook:v1",
+		"registry":      "gcr.io/",
+	}
+	if diff := cmp.Diff(expected, data); diff != "" {
+		t.Errorf("ConfigMap data is different from expected. (-want, +got):\n%s", diff)
+	}
+}
+
+func TestPinImages(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	lock := &kfconfig.Lock{
+		Version: kfconfig.LockVersion,
+		Images: []kfconfig.LockedImage{
+			{Reference: "gcr.io/main:v1", Image: "mirror.local:5000/main:v1", Digest: digest},
+		},
+	}
+	newDeployment := func(image string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "main"},
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{"name": "main", "image": image},
+						},
+					},
+				},
+			},
+		}}
+	}
+
+	deployment := newDeployment("gcr.io/main:v1")
+	if err := pinImages(deployment, lock); err != nil {
+		t.Fatalf("pinImages error: %v", err)
+	}
+	containers, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "containers")
+	if image := containers[0].(map[string]interface{})["image"]; image != "mirror.local:5000/main@"+digest {
+		t.Errorf("container image = %v, want mirror.local:5000/main@%v", image, digest)
+	}
+
+	if err := pinImages(newDeployment("gcr.io/main:v2"), lock); err == nil {
+		t.Errorf("expected an error pinning an image that isn't locked")
+	}
+}
@@ -19,6 +19,7 @@ package kustomize
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
@@ -26,6 +27,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -46,8 +48,11 @@ import (
 	log "github.com/sirupsen/logrus"
 	crdclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	rbacv1 "k8s.io/client-go/kubernetes/typed/rbac/v1"
@@ -55,6 +60,7 @@ import (
 	"sigs.k8s.io/kustomize/v3/k8sdeps/kunstruct"
 	"sigs.k8s.io/kustomize/v3/k8sdeps/transformer"
 	"sigs.k8s.io/kustomize/v3/pkg/fs"
+	"sigs.k8s.io/kustomize/v3/pkg/gvk"
 	"sigs.k8s.io/kustomize/v3/pkg/image"
 	"sigs.k8s.io/kustomize/v3/pkg/loader"
 	"sigs.k8s.io/kustomize/v3/pkg/plugins"
@@ -107,11 +113,19 @@ type kustomize struct {
 	restConfig       *rest.Config
 	// when set to true, apply() will skip local kube config, directly build config from restConfig
 	configOverwrite bool
+	// facts caches the discovery of the cluster the when conditions of applications are evaluated against.
+	facts *clusterFacts
+	// variables caches the values of the variables and cluster facts parameters refer to.
+	variables map[string]string
 }
 
 const (
 	defaultUserId = "anonymous"
 	outputDir     = "kustomize"
+	// defaultApplyTimeout bounds applying an application unless its apply policy sets a timeout.
+	defaultApplyTimeout = 10 * time.Minute
+	// defaultDeleteTimeout bounds waiting for a deleted resource to go away unless the apply policy sets a timeout.
+	defaultDeleteTimeout = 5 * time.Minute
 )
 
 // Setter defines an interface for modifying the plugin.
@@ -119,6 +133,9 @@ type Setter interface {
 	SetK8sRestConfig(r *rest.Config)
 }
 
+// blank assignment to verify that kustomize honors context cancellation
+var _ kftypesv3.KfAppContext = &kustomize{}
+
 // GetKfApp is the common entry point for all implementations of the KfApp interface
 func GetKfApp(kfdef *kfconfig.KfConfig) kftypesv3.KfApp {
 	_kustomize := &kustomize{
@@ -148,9 +165,13 @@ func (kustomize *kustomize) initK8sClients() error {
 	return nil
 }
 
-func (kustomize *kustomize) render(app kfconfig.Application) ([]byte, error) {
+// render evaluates the kustomize package of app and returns its resources as a stream in install order.
+// Resources are converted to unstructured objects one at a time while the stream is consumed; the
+// KfDef-wide image overrides are applied to each object, or the images are pinned to their digests if ctx
+// carries a lock, and, when installed by the operator, the KfDef annotation is injected on the way out.
+func (kustomize *kustomize) render(ctx context.Context, app kfconfig.Application) (utils.ObjectStream, error) {
 	kustomizeDir := path.Join(kustomize.kfDef.Spec.AppDir, outputDir)
-	resMap, err := EvaluateKustomizeManifest(path.Join(kustomizeDir, app.Name))
+	objects, err := evaluateObjects(path.Join(kustomizeDir, app.Name), utils.InstallOrder)
 	if err != nil {
 		log.Errorf("Error evaluating kustomization manifest for %v: %v", app.Name, err)
 		return nil, &kfapisv3.KfError{
@@ -159,7 +180,30 @@ func (kustomize *kustomize) render(app kfconfig.Application) ([]byte, error) {
 		}
 	}
 
-	sortResourceByKind(resMap, utils.InstallOrder)
+	images, err := newImageRewriter(kustomize.kfDef.Spec.ImageOverrides)
+	if err != nil {
+		return nil, err
+	}
+	if lock := kfconfig.LockFrom(ctx); lock != nil {
+		// The lock pins the images as they were rendered, overrides included.
+		evaluated := objects
+		objects = func(visit func(*unstructured.Unstructured) error) error {
+			return evaluated(func(obj *unstructured.Unstructured) error {
+				if err := pinImages(obj, lock); err != nil {
+					return err
+				}
+				return visit(obj)
+			})
+		}
+	} else if images != nil {
+		evaluated := objects
+		objects = func(visit func(*unstructured.Unstructured) error) error {
+			return evaluated(func(obj *unstructured.Unstructured) error {
+				images.rewriteObject(obj)
+				return visit(obj)
+			})
+		}
+	}
 
 	// check to set owner references for resources if installed through kubeflow operator
 	annotations := kustomize.kfDef.GetAnnotations()
@@ -169,62 +213,111 @@ func (kustomize *kustomize) render(app kfconfig.Application) ([]byte, error) {
 			setOperatorAnnotation = setOperatorBool
 		}
 	}
+	if !setOperatorAnnotation {
+		return objects, nil
+	}
 
-	//TODO this should be streamed
-	var data []byte
-	if setOperatorAnnotation {
-		// retrieve the UID of the KfDef resource using dynamic client
-		config, _ := rest.InClusterConfig()
-		dyn, err := dynamic.NewForConfig(config)
-		if err != nil {
-			return nil, &kfapisv3.KfError{
-				Code:    int(kfapisv3.INTERNAL_ERROR),
-				Message: fmt.Sprintf("failed to create dynamic client: %v", err),
-			}
+	// retrieve the UID of the KfDef resource using dynamic client
+	config, _ := rest.InClusterConfig()
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, &kfapisv3.KfError{
+			Code:    int(kfapisv3.INTERNAL_ERROR),
+			Message: fmt.Sprintf("failed to create dynamic client: %v", err),
 		}
-		kfDefRes := schema.GroupVersionResource{Group: "kfdef.apps.kubeflow.org", Version: "v1", Resource: "kfdefs"}
-		instance, err := dyn.Resource(kfDefRes).Namespace(kustomize.kfDef.GetNamespace()).Get(kustomize.kfDef.GetName(), metav1.GetOptions{})
-		if err != nil {
-			return nil, &kfapisv3.KfError{
-				Code:    int(kfapisv3.INTERNAL_ERROR),
-				Message: fmt.Sprintf("failed to get the KfDef object: %v", err),
-			}
+	}
+	kfDefRes := schema.GroupVersionResource{Group: "kfdef.apps.kubeflow.org", Version: "v1", Resource: "kfdefs"}
+	instance, err := dyn.Resource(kfDefRes).Namespace(kustomize.kfDef.GetNamespace()).Get(kustomize.kfDef.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, &kfapisv3.KfError{
+			Code:    int(kfapisv3.INTERNAL_ERROR),
+			Message: fmt.Sprintf("failed to get the KfDef object: %v", err),
 		}
-		data, err = GenerateYamlWithOperatorAnnotation(resMap, instance)
-		if err != nil {
-			return nil, &kfapisv3.KfError{
-				Code:    int(kfapisv3.INTERNAL_ERROR),
-				Message: fmt.Sprintf("can not encode component %v as yaml: %v", app.Name, err),
+	}
+	annotate := operatorAnnotator(instance)
+	return func(visit func(*unstructured.Unstructured) error) error {
+		return objects(func(obj *unstructured.Unstructured) error {
+			if err := annotate(obj); err != nil {
+				return &kfapisv3.KfError{
+					Code:    int(kfapisv3.INTERNAL_ERROR),
+					Message: fmt.Sprintf("can not annotate component %v: %v", app.Name, err),
+				}
 			}
-		}
-	} else {
-		data, err = resMap.AsYaml()
-		if err != nil {
-			return nil, &kfapisv3.KfError{
-				Code:    int(kfapisv3.INTERNAL_ERROR),
-				Message: fmt.Sprintf("can not encode component %v as yaml: %v", app.Name, err),
+			return visit(obj)
+		})
+	}, nil
+}
+
+// evaluateObjects evaluates the kustomize dir compDir and returns a stream over the resources sorted by order.
+// Only the resource pointers are sorted; each resource is handed out as the content produced by kustomize,
+// without a copy or a round trip through YAML, and released once visited. The stream can thus only be
+// consumed once.
+func evaluateObjects(compDir string, order utils.SortOrder) (utils.ObjectStream, error) {
+	resMap, err := EvaluateKustomizeManifest(compDir)
+	if err != nil {
+		return nil, err
+	}
+	resources := utils.SortByKind(resMap.Resources(), order)
+	consumed := false
+	return func(visit func(*unstructured.Unstructured) error) error {
+		if consumed {
+			return fmt.Errorf("resources of %v were already consumed", compDir)
+		}
+		consumed = true
+		for i, res := range resources {
+			obj := &unstructured.Unstructured{Object: res.Map()}
+			resources[i] = nil
+			if err := visit(obj); err != nil {
+				return err
 			}
 		}
-	}
-	return data, nil
+		return nil
+	}, nil
 }
 
 // Dump prints the kustomize generated resources to stdout
 func (kustomize *kustomize) Dump(resources kftypesv3.ResourceEnum) error {
+	return kustomize.DumpContext(context.Background(), resources)
+}
+
+// DumpContext is Dump which stops between applications once ctx is cancelled.
+func (kustomize *kustomize) DumpContext(ctx context.Context, resources kftypesv3.ResourceEnum) error {
 
 	applications := make(map[string]bool)
 	for _, app := range kustomize.kfDef.Spec.Applications {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if applications[app.Name] == true {
 			// if the application name already
 			continue
 		}
 		applications[app.Name] = true
+		if !app.IsEnabled() {
+			log.Infof("Application %v is disabled; skipping it", app.Name)
+			continue
+		}
+		included, reason, err := kustomize.evaluateWhen(app)
+		if err != nil {
+			return err
+		}
+		if !included {
+			log.Infof("Skipping application %v: %v", app.Name, reason)
+			continue
+		}
 
-		data, err := kustomize.render(app)
+		kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{Type: kftypesv3.RenderStarted, Application: app.Name})
+		objects, err := kustomize.render(ctx, app)
+		kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{Type: kftypesv3.RenderFinished, Application: app.Name, Err: err})
 		if err != nil {
 			return err
 		}
-		fmt.Println(string(data))
+		if err := utils.WriteObjects(os.Stdout, objects); err != nil {
+			return &kfapisv3.KfError{
+				Code:    int(kfapisv3.INTERNAL_ERROR),
+				Message: fmt.Sprintf("can not encode component %v as yaml: %v", app.Name, err),
+			}
+		}
 		fmt.Println("---")
 	}
 	return nil
@@ -232,6 +325,12 @@ func (kustomize *kustomize) Dump(resources kftypesv3.ResourceEnum) error {
 
 // Apply deploys kustomize generated resources to the kubenetes api server
 func (kustomize *kustomize) Apply(resources kftypesv3.ResourceEnum) error {
+	return kustomize.ApplyContext(context.Background(), resources)
+}
+
+// ApplyContext is Apply bound to ctx. Cancelling ctx stops retries and readiness waits and skips the
+// remaining applications; an apply already sent to the api server isn't rolled back.
+func (kustomize *kustomize) ApplyContext(ctx context.Context, resources kftypesv3.ResourceEnum) error {
 	var restConfig *rest.Config = nil
 	if kustomize.configOverwrite && kustomize.restConfig != nil {
 		restConfig = kustomize.restConfig
@@ -240,6 +339,10 @@ func (kustomize *kustomize) Apply(resources kftypesv3.ResourceEnum) error {
 	if err != nil {
 		return err
 	}
+	namespaces, err := newNamespaceResolver(apply)
+	if err != nil {
+		return err
+	}
 
 	// Read clusterName and write to KfDef.
 	kubeconfig := kftypesv3.GetKubeConfig()
@@ -247,48 +350,110 @@ func (kustomize *kustomize) Apply(resources kftypesv3.ResourceEnum) error {
 		log.Warnf("Unable to load .kubeconfig.")
 	} else {
 		currentCtx := kubeconfig.CurrentContext
-		if ctx, ok := kubeconfig.Contexts[currentCtx]; !ok || ctx == nil {
+		if kubeCtx, ok := kubeconfig.Contexts[currentCtx]; !ok || kubeCtx == nil {
 			log.Errorf("Cannot find current-context in kubeconfig.")
 		} else {
-			log.Infof("Log cluster name into KfDef: %v", ctx.Cluster)
-			kustomize.kfDef.ClusterName = ctx.Cluster
+			log.Infof("Log cluster name into KfDef: %v", kubeCtx.Cluster)
+			kustomize.kfDef.ClusterName = kubeCtx.Cluster
 		}
 	}
 
+	// kubeclient is used to compare the rendered objects with the cluster so that unchanged ones
+	// aren't applied again. Without it every object is applied.
+	var kubeclient client.Client
+	kustomize.initK8sClients()
+	if c, err := client.New(kustomize.restConfig, client.Options{}); err == nil {
+		kubeclient = c
+	} else {
+		log.Warnf("Couldn't create a k8s client to compare applications with the cluster: %v", err)
+	}
+
 	applications := make(map[string]bool)
+	// applied holds the keys of the objects of the enabled applications, which disabled ones must keep.
+	applied := map[string]bool{}
+	disabled := []kfconfig.Application{}
 	for _, app := range kustomize.kfDef.Spec.Applications {
+		if err := ctx.Err(); err != nil {
+			log.Warnf("Apply cancelled before application %v: %v", app.Name, err)
+			return err
+		}
 		if applications[app.Name] == true {
 			// if the application name already
 			continue
 		}
 		applications[app.Name] = true
+		if !app.IsEnabled() {
+			disabled = append(disabled, app)
+			continue
+		}
+		// Skipped applications keep the resources they applied before, unlike disabled ones.
+		included, reason, err := kustomize.evaluateWhen(app)
+		if err != nil {
+			return err
+		}
+		if !included {
+			log.Infof("Skipping application %v: %v", app.Name, reason)
+			kustomize.kfDef.SetApplicationStatus(kfconfig.ApplicationStatus{Name: app.Name, Skipped: true, Reason: reason})
+			kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{Type: kftypesv3.ApplicationSkipped, Application: app.Name, Reason: reason})
+			continue
+		}
 
 		log.Infof("Deploying application %v", app.Name)
-		data, err := kustomize.render(app)
+		kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{Type: kftypesv3.RenderStarted, Application: app.Name})
+		objects, err := kustomize.render(ctx, app)
+		kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{Type: kftypesv3.RenderFinished, Application: app.Name, Err: err})
 		if err != nil {
 			return err
 		}
 
-		// TODO(https://github.com/kubeflow/manifests/issues/806): Bump the timeout because cert-manager takes
+		// TODO(https://github.com/kubeflow/manifests/issues/806): The default timeout is high because cert-manager takes
 		// a long time to start. Any application that needs to create a certificate will fail because it won't
 		// be able to create certificates if cert-manager is unavailable. We should try to identify Permanent Errors
 		// and return a PermanentError to avoid retrying and taking 10 minutes to fail.
-		b := utils.NewDefaultBackoff()
-		b.MaxElapsedTime = 10 * time.Minute
-		err = backoff.RetryNotify(
-			func() error {
-				return apply.Apply(data)
-			},
-			b,
-			func(e error, duration time.Duration) {
-				log.Warnf("Encountered error applying application %v: %v", app.Name, e)
-				log.Warnf("Will retry in %.0f seconds.", duration.Seconds())
-			})
+		// Applications can shorten or extend it through their apply policy.
+		policy := kustomize.kfDef.GetApplyPolicy(app)
+		timeout := defaultApplyTimeout
+		if policy.Timeout != nil {
+			timeout = policy.Timeout.Duration
+		}
+		deadline := time.Now().Add(timeout)
+
+		// Only send the objects whose desired state changed or which drifted from it.
+		staged, err := kustomize.stageObjects(ctx, kubeclient, namespaces, app.Name, objects, applied)
 		if err != nil {
+			return err
+		}
+		// An application whose objects are all up to date, and whose desired state is the one last applied and
+		// waited for, needs neither an apply nor a wait.
+		if previous, _ := kustomize.kfDef.GetApplicationStatus(app.Name); len(staged.pending) == 0 && previous.Hash == staged.hash {
+			log.Infof("Application %v is up to date; skipping apply", app.Name)
+			kustomize.kfDef.SetApplicationStatus(kfconfig.ApplicationStatus{Name: app.Name, Hash: staged.hash, Reason: reason})
+			kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{Type: kftypesv3.ApplicationApplied, Application: app.Name})
+			staged.remove()
+			continue
+		}
+		if len(staged.pending) > 0 {
+			err = kustomize.applyPending(ctx, apply, app.Name, staged, policy, timeout)
+		}
+		staged.remove()
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Warnf("Apply of application %v cancelled: %v", app.Name, ctx.Err())
+				return ctx.Err()
+			}
 			log.Errorf("Permanently failed applying application %v: %v", app.Name, err)
 			return err
 		}
+		if err := kustomize.waitForApplication(ctx, app, staged.objects, policy.Wait, deadline); err != nil {
+			log.Errorf("Application %v didn't become %v in time: %v", app.Name, policy.Wait, err)
+			return err
+		}
+		kustomize.kfDef.SetApplicationStatus(kfconfig.ApplicationStatus{Name: app.Name, Hash: staged.hash, Reason: reason})
 		log.Infof("Successfully applied application %v", app.Name)
+		kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{Type: kftypesv3.ApplicationApplied, Application: app.Name})
+	}
+	if err := kustomize.deleteDisabledApplications(ctx, kubeclient, disabled, applied); err != nil {
+		return err
 	}
 
 	// Default user namespace when multi-tenancy enabled
@@ -306,13 +471,371 @@ func (kustomize *kustomize) Apply(resources kftypesv3.ResourceEnum) error {
 			}
 		}
 		return nil
-	}, b)
+	}, backoff.WithContext(b, ctx))
 	if err != nil {
 		log.Warnf("Default namespace creation skipped")
 	}
 	return nil
 }
 
+// namespaceResolver resolves the namespace objects are applied to.
+type namespaceResolver struct {
+	// defaultNamespace is the namespace kubectl applies namespaced objects without a namespace to.
+	defaultNamespace string
+	// mapper tells cluster scoped kinds apart. If nil, every kind is taken as namespaced.
+	mapper meta.RESTMapper
+}
+
+// newNamespaceResolver returns a resolver matching the namespace resolution of apply.
+func newNamespaceResolver(apply *utils.Apply) (namespaceResolver, error) {
+	namespace, err := apply.DefaultNamespace()
+	if err != nil {
+		return namespaceResolver{}, &kfapisv3.KfError{
+			Code:    int(kfapisv3.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't resolve the default namespace: %v", err),
+		}
+	}
+	mapper, err := apply.RESTMapper()
+	if err != nil {
+		return namespaceResolver{}, &kfapisv3.KfError{
+			Code:    int(kfapisv3.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't create a REST mapper: %v", err),
+		}
+	}
+	return namespaceResolver{defaultNamespace: namespace, mapper: mapper}, nil
+}
+
+// namespace returns the namespace obj is applied to, which is empty for cluster scoped kinds.
+// Kinds the mapper doesn't know yet, such as those of CRDs applied along with obj, are taken as namespaced.
+func (r namespaceResolver) namespace(obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+	if r.mapper != nil {
+		mapping, err := r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err == nil && mapping.Scope.Name() == meta.RESTScopeNameRoot {
+			return ""
+		}
+	}
+	if namespace := obj.GetNamespace(); namespace != "" {
+		return namespace
+	}
+	return r.defaultNamespace
+}
+
+// objectRef identifies an object of an application once the object itself has been written out.
+type objectRef struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+	name      string
+}
+
+// unstructured returns a bare object carrying the identity of ref.
+func (ref objectRef) unstructured() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(ref.gvk)
+	obj.SetNamespace(ref.namespace)
+	obj.SetName(ref.name)
+	return obj
+}
+
+// stagedApplication is an application whose objects have been rendered and compared with the cluster.
+type stagedApplication struct {
+	// file holds the manifests of the pending objects, if any.
+	file string
+	// pending refers to the objects in file, which need to be applied.
+	pending []objectRef
+	// objects refers to every object of the application.
+	objects []objectRef
+	// hash is the aggregate hash of the desired state of the application.
+	hash string
+}
+
+// remove removes the manifests file of the staged application.
+func (staged *stagedApplication) remove() {
+	if staged.file == "" {
+		return
+	}
+	if err := os.Remove(staged.file); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Couldn't remove %v: %v", staged.file, err)
+	}
+	staged.file = ""
+}
+
+// stageObjects consumes the objects of the application once: every object is annotated with the hash of its
+// desired state and, unless its live copy carries the same hash and hasn't drifted, written to the manifests
+// file of the returned staged application. Objects left out are reported unchanged. Only a reference to each
+// object is kept, along with the aggregate hash of the application, which is compared to the one recorded in
+// its status to tell whether its desired state changed since it was last applied. The key of every object is
+// added to keys. References carry the namespace kubectl applies the object to, as resolved by namespaces.
+// If kubeclient is nil or an object can't be fetched, the object is pending.
+func (kustomize *kustomize) stageObjects(ctx context.Context, kubeclient client.Client, namespaces namespaceResolver,
+	appName string, objects utils.ObjectStream, keys map[string]bool) (*stagedApplication, error) {
+	staged := &stagedApplication{}
+	hashes := map[string]string{}
+	var file *os.File
+	var writer *utils.ObjectWriter
+	err := objects(func(obj *unstructured.Unstructured) error {
+		hash, err := utils.SetDesiredHash(obj)
+		if err != nil {
+			return &kfapisv3.KfError{
+				Code:    int(kfapisv3.INTERNAL_ERROR),
+				Message: fmt.Sprintf("can not hash component %v: %v", appName, err),
+			}
+		}
+		key := objectKey(obj)
+		hashes[key] = hash
+		keys[key] = true
+
+		namespace := namespaces.namespace(obj)
+		ref := objectRef{gvk: obj.GroupVersionKind(), namespace: namespace, name: obj.GetName()}
+		staged.objects = append(staged.objects, ref)
+		if kubeclient != nil {
+			live := &unstructured.Unstructured{}
+			live.SetGroupVersionKind(obj.GroupVersionKind())
+			getErr := kubeclient.Get(ctx, k8stypes.NamespacedName{Name: obj.GetName(), Namespace: namespace}, live)
+			if getErr == nil && utils.IsUpToDate(live, obj) {
+				kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{
+					Type:        kftypesv3.ObjectUnchanged,
+					Application: appName,
+					Kind:        obj.GetKind(),
+					Namespace:   live.GetNamespace(),
+					Name:        obj.GetName(),
+					Operation:   "skipped",
+				})
+				return nil
+			}
+		}
+
+		if file == nil {
+			if file, err = ioutil.TempFile("", "kfctl-apply-"); err != nil {
+				return &kfapisv3.KfError{
+					Code:    int(kfapisv3.INTERNAL_ERROR),
+					Message: fmt.Sprintf("can not stage component %v: %v", appName, err),
+				}
+			}
+			staged.file = file.Name()
+			writer = utils.NewObjectWriter(file)
+		}
+		if err := writer.Write(obj); err != nil {
+			return &kfapisv3.KfError{
+				Code:    int(kfapisv3.INTERNAL_ERROR),
+				Message: fmt.Sprintf("can not stage component %v: %v", appName, err),
+			}
+		}
+		staged.pending = append(staged.pending, ref)
+		return nil
+	})
+	if file != nil {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = &kfapisv3.KfError{
+				Code:    int(kfapisv3.INTERNAL_ERROR),
+				Message: fmt.Sprintf("can not stage component %v: %v", appName, closeErr),
+			}
+		}
+	}
+	if err != nil {
+		staged.remove()
+		return nil, err
+	}
+	staged.hash = utils.AggregateHash(hashes)
+	return staged, nil
+}
+
+// objectKey identifies obj among the objects of a KfDef.
+func objectKey(obj *unstructured.Unstructured) string {
+	return strings.Join([]string{obj.GetKind(), obj.GetNamespace(), obj.GetName()}, "/")
+}
+
+// deleteDisabledApplications deletes the resources that the disabled applications apps applied before, in
+// reverse application order and in uninstall order within each application. Objects in keep, those of the
+// enabled applications, are left in place. Applications are recorded as disabled in the status, so their
+// resources are only deleted once and a later enable applies them again.
+func (kustomize *kustomize) deleteDisabledApplications(ctx context.Context, kubeclient client.Client,
+	apps []kfconfig.Application, keep map[string]bool) error {
+	kustomizeDir := path.Join(kustomize.kfDef.Spec.AppDir, outputDir)
+	for idx := range apps {
+		app := apps[len(apps)-1-idx]
+		if status, ok := kustomize.kfDef.GetApplicationStatus(app.Name); ok && !status.Disabled {
+			if kubeclient == nil {
+				return &kfapisv3.KfError{
+					Code:    int(kfapisv3.INTERNAL_ERROR),
+					Message: fmt.Sprintf("no k8s client to delete the resources of disabled application %v", app.Name),
+				}
+			}
+			log.Infof("Application %v is disabled; deleting its resources", app.Name)
+			objects, err := evaluateObjects(path.Join(kustomizeDir, app.Name), utils.UninstallOrder)
+			if err != nil {
+				return &kfapisv3.KfError{
+					Code:    int(kfapisv3.INTERNAL_ERROR),
+					Message: fmt.Sprintf("error evaluating kustomization manifest for %v: %v", app.Name, err),
+				}
+			}
+			timeout := defaultDeleteTimeout
+			if policy := kustomize.kfDef.GetApplyPolicy(app); policy.Timeout != nil {
+				timeout = policy.Timeout.Duration
+			}
+			err = objects(func(obj *unstructured.Unstructured) error {
+				if keep[objectKey(obj)] {
+					return nil
+				}
+				return utils.DeleteObject(ctx, obj, kubeclient, timeout, kustomize.installedByOperator())
+			})
+			if err != nil {
+				return &kfapisv3.KfError{
+					Code:    int(kfapisv3.INTERNAL_ERROR),
+					Message: fmt.Sprintf("couldn't delete the resources of disabled application %v: %v", app.Name, err),
+				}
+			}
+		}
+		kustomize.kfDef.SetApplicationStatus(kfconfig.ApplicationStatus{Name: app.Name, Disabled: true})
+		kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{Type: kftypesv3.ApplicationDisabled, Application: app.Name})
+	}
+	return nil
+}
+
+// installedByOperator tells whether the KfDef is handled by the Kubeflow operator.
+func (kustomize *kustomize) installedByOperator() bool {
+	if byOperatorAnn, ok := kustomize.kfDef.GetAnnotations()[strings.Join([]string{utils.KfDefAnnotation, utils.InstallByOperator}, "/")]; ok {
+		if byOperatorAnnBol, err := strconv.ParseBool(byOperatorAnn); err == nil {
+			return byOperatorAnnBol
+		}
+	}
+	return false
+}
+
+// applyPending applies the pending objects of staged with retries as configured by policy, bounded by timeout.
+func (kustomize *kustomize) applyPending(ctx context.Context, apply *utils.Apply, appName string,
+	staged *stagedApplication, policy kfconfig.ApplyPolicy, timeout time.Duration) error {
+	return backoff.RetryNotify(
+		func() error {
+			return applyWithProgress(ctx, apply, appName, staged.file, staged.pending)
+		},
+		backoff.WithContext(applyBackOff(policy, timeout), ctx),
+		func(e error, duration time.Duration) {
+			log.Warnf("Encountered error applying application %v: %v", appName, e)
+			log.Warnf("Will retry in %.0f seconds.", duration.Seconds())
+			kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{
+				Type:        kftypesv3.RetryScheduled,
+				Application: appName,
+				RetryIn:     duration,
+				Err:         e,
+			})
+		})
+}
+
+// applyWithProgress applies the manifests of file and reports the outcome of every object to the progress sink
+// of ctx. Objects of refs kubectl didn't report back when the apply fails are reported as failed with the
+// apply error.
+func applyWithProgress(ctx context.Context, apply *utils.Apply, appName string, file string, refs []objectRef) error {
+	objectKey := func(kind string, name string) string {
+		return kind + "/" + name
+	}
+	reported := map[string]bool{}
+	apply.SetObserver(func(obj runtime.Object, operation string) {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		reported[objectKey(kind, accessor.GetName())] = true
+		eventType := kftypesv3.ObjectApplied
+		if operation == "unchanged" {
+			eventType = kftypesv3.ObjectUnchanged
+		}
+		kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{
+			Type:        eventType,
+			Application: appName,
+			Kind:        kind,
+			Namespace:   accessor.GetNamespace(),
+			Name:        accessor.GetName(),
+			Operation:   operation,
+		})
+	})
+	defer apply.SetObserver(nil)
+
+	applyErr := apply.ApplyFile(file)
+	if applyErr == nil {
+		return nil
+	}
+	for _, ref := range refs {
+		if !reported[objectKey(ref.gvk.Kind, ref.name)] {
+			kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{
+				Type:        kftypesv3.ObjectFailed,
+				Application: appName,
+				Kind:        ref.gvk.Kind,
+				Namespace:   ref.namespace,
+				Name:        ref.name,
+				Err:         applyErr,
+			})
+		}
+	}
+	return applyErr
+}
+
+// applyBackOff returns the retry backoff for an application apply bounded by timeout.
+// Intervals and the number of attempts default to utils.NewDefaultBackoff unless the policy overrides them.
+func applyBackOff(policy kfconfig.ApplyPolicy, timeout time.Duration) backoff.BackOff {
+	b := utils.NewDefaultBackoff()
+	b.MaxElapsedTime = timeout
+	if policy.Retry == nil {
+		return b
+	}
+	if policy.Retry.InitialInterval != nil {
+		b.InitialInterval = policy.Retry.InitialInterval.Duration
+	}
+	if policy.Retry.MaxInterval != nil {
+		b.MaxInterval = policy.Retry.MaxInterval.Duration
+	}
+	if policy.Retry.MaxAttempts > 0 {
+		return backoff.WithMaxRetries(b, uint64(policy.Retry.MaxAttempts-1))
+	}
+	return b
+}
+
+// waitForApplication blocks until every object of the application exists (WaitCreated) or is ready (WaitReady).
+// It is a noop for WaitNone.
+func (kustomize *kustomize) waitForApplication(ctx context.Context, app kfconfig.Application, objects []objectRef,
+	wait kfconfig.WaitPolicy, deadline time.Time) error {
+	if wait == "" || wait == kfconfig.WaitNone {
+		return nil
+	}
+	if wait != kfconfig.WaitCreated && wait != kfconfig.WaitReady {
+		return &kfapisv3.KfError{
+			Code:    int(kfapisv3.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("unknown wait policy %v for application %v", wait, app.Name),
+		}
+	}
+	kustomize.initK8sClients()
+	kubeclient, err := client.New(kustomize.restConfig, client.Options{})
+	if err != nil {
+		return &kfapisv3.KfError{
+			Code:    int(kfapisv3.INTERNAL_ERROR),
+			Message: fmt.Sprintf("error initializing k8s client: %v", err),
+		}
+	}
+
+	log.Infof("Waiting for application %v to be %v", app.Name, wait)
+	for _, ref := range objects {
+		target := ref.unstructured()
+		kftypesv3.ReportProgress(ctx, kftypesv3.ProgressEvent{
+			Type:        kftypesv3.WaitingForReadiness,
+			Application: app.Name,
+			Kind:        target.GetKind(),
+			Namespace:   target.GetNamespace(),
+			Name:        target.GetName(),
+		})
+		if err := utils.WaitForObject(ctx, target, kubeclient, wait == kfconfig.WaitReady, time.Until(deadline)); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return &kfapisv3.KfError{
+				Code:    int(kfapisv3.INTERNAL_ERROR),
+				Message: err.Error(),
+			}
+		}
+	}
+	return nil
+}
+
 // deleteGlobalResources is called from Delete and deletes CRDs, ClusterRoles, ClusterRoleBindings
 func (kustomize *kustomize) deleteGlobalResources() error {
 	if err := kustomize.initK8sClients(); err != nil {
@@ -365,6 +888,12 @@ func (kustomize *kustomize) deleteGlobalResources() error {
 
 // Delete is called from 'kfctl delete ...'. Will delete all resources deployed from the Apply method
 func (kustomize *kustomize) Delete(resources kftypesv3.ResourceEnum) error {
+	return kustomize.DeleteContext(context.Background(), resources)
+}
+
+// DeleteContext is Delete bound to ctx. Cancelling ctx stops waiting for deleted resources to go away
+// and leaves the remaining applications and the namespace in place.
+func (kustomize *kustomize) DeleteContext(ctx context.Context, resources kftypesv3.ResourceEnum) error {
 	annotations := kustomize.kfDef.GetAnnotations()
 	forceDelete := false
 	if forceDel, ok := annotations[strings.Join([]string{utils.KfDefAnnotation, utils.ForceDelete}, "/")]; ok {
@@ -377,12 +906,7 @@ func (kustomize *kustomize) Delete(resources kftypesv3.ResourceEnum) error {
 	}
 
 	// Get bool value indicating whether this func is called from kubeflow operator
-	byOperator := false
-	if byOperatorAnn, ok := annotations[strings.Join([]string{utils.KfDefAnnotation, utils.InstallByOperator}, "/")]; ok {
-		if byOperatorAnnBol, err := strconv.ParseBool(byOperatorAnn); err == nil {
-			byOperator = byOperatorAnnBol
-		}
-	}
+	byOperator := kustomize.installedByOperator()
 
 	// Get kubeconfig for cluster and initialize clients
 	msg := ""
@@ -391,12 +915,12 @@ func (kustomize *kustomize) Delete(resources kftypesv3.ResourceEnum) error {
 		msg = "unable to load .kubeconfig."
 	} else {
 		currentCtx := kubeconfig.CurrentContext
-		if ctx, ok := kubeconfig.Contexts[currentCtx]; !ok || ctx == nil {
+		if kubeCtx, ok := kubeconfig.Contexts[currentCtx]; !ok || kubeCtx == nil {
 			msg = "cannot find current-context in kubeconfig."
 		} else {
-			if kustomize.kfDef.ClusterName != ctx.Cluster {
+			if kustomize.kfDef.ClusterName != kubeCtx.Cluster {
 				msg = fmt.Sprintf("cluster name doesn't match: KfDef(%v) v.s. current-context(%v)",
-					kustomize.kfDef.ClusterName, ctx.Cluster)
+					kustomize.kfDef.ClusterName, kubeCtx.Cluster)
 			}
 		}
 	}
@@ -424,8 +948,13 @@ func (kustomize *kustomize) Delete(resources kftypesv3.ResourceEnum) error {
 	errList := []error{}
 	for idx := range kustomize.kfDef.Spec.Applications {
 		app := &kustomize.kfDef.Spec.Applications[len(kustomize.kfDef.Spec.Applications)-1-idx]
+		if err := ctx.Err(); err != nil {
+			log.Warnf("Delete cancelled before application %v: %v", app.Name, err)
+			return err
+		}
 		log.Infof("Deleting application %v", app.Name)
-		resMap, err := EvaluateKustomizeManifest(path.Join(kustomizeDir, app.Name))
+		// Sort resources by kind to make sure we don't experience namespace terminating hanging.
+		objects, err := evaluateObjects(path.Join(kustomizeDir, app.Name), utils.UninstallOrder)
 		if err != nil {
 			log.Errorf("Error evaluating kustomization manifest for %v: %v", app.Name, err)
 			return &kfapisv3.KfError{
@@ -433,34 +962,32 @@ func (kustomize *kustomize) Delete(resources kftypesv3.ResourceEnum) error {
 				Message: fmt.Sprintf("error evaluating kustomization manifest for %v: %v", app.Name, err),
 			}
 		}
-
-		// Sort resources by kind to make sure we don't experience namespace terminating hanging.
-		sortResourceByKind(resMap, utils.UninstallOrder)
-
-		yamlBytes, err := resMap.AsYaml()
-		if err != nil {
-			return &kfapisv3.KfError{
-				Code:    int(kfapisv3.INTERNAL_ERROR),
-				Message: fmt.Sprintf("error evaluating kustomization manifest for %v: %v", app.Name, err),
-			}
+		timeout := defaultDeleteTimeout
+		if policy := kustomize.kfDef.GetApplyPolicy(*app); policy.Timeout != nil {
+			timeout = policy.Timeout.Duration
 		}
-		resources, err := utils.SplitYAML(yamlBytes)
-		if err != nil {
-			return &kfapisv3.KfError{
-				Code:    int(kfapisv3.INTERNAL_ERROR),
-				Message: fmt.Sprintf("error splitting yaml: %v", err),
+		err = objects(func(obj *unstructured.Unstructured) error {
+			err := utils.DeleteObject(ctx, obj, kubeclient, timeout, byOperator)
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-		}
-		for _, r := range resources {
-			err := utils.DeleteResource(r, kubeclient, 5*time.Minute, byOperator)
 			if err != nil {
 				msg := fmt.Sprintf("error evaluating kustomization manifest for %v: %v", app.Name, err)
 				errList = append(errList, errors.New(msg))
 				log.Warn(msg)
 			}
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			msg := fmt.Sprintf("error deleting the resources of application %v: %v", app.Name, err)
+			errList = append(errList, errors.New(msg))
+			log.Warn(msg)
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	aggrError := errutil.NewAggregate(errList)
 	if aggrError != nil {
 		return &kfapisv3.KfError{
@@ -505,24 +1032,15 @@ func (kustomize *kustomize) Delete(resources kftypesv3.ResourceEnum) error {
 	return nil
 }
 
-// sortResourceByKind does in-place sort of resources by Kind.
-func sortResourceByKind(resMap resmap.ResMap, order utils.SortOrder) {
-	resourcesInUninstallOrder := utils.SortByKind(resMap.Resources(), order)
-
-	// Need to remove existing resource and append them in order.
-	allIdsToRemove := resMap.AllIds()
-	for _, idToRemove := range allIdsToRemove {
-		resMap.Remove(idToRemove)
-	}
-
-	for _, resourceToAdd := range resourcesInUninstallOrder {
-		resMap.Append(resourceToAdd)
-	}
-}
-
 // Generate is called from 'kfctl generate ...' and produces yaml output files under <deployment>/kustomize.
 // One yaml file per component
 func (kustomize *kustomize) Generate(resources kftypesv3.ResourceEnum) error {
+	return kustomize.GenerateContext(context.Background(), resources)
+}
+
+// GenerateContext is Generate bound to ctx. If ctx is cancelled half way the kustomize directory is removed
+// so that the next Generate doesn't mistake it for a complete one.
+func (kustomize *kustomize) GenerateContext(ctx context.Context, resources kftypesv3.ResourceEnum) error {
 	generate := func() error {
 		kustomizeDir := path.Join(kustomize.kfDef.Spec.AppDir, outputDir)
 
@@ -548,10 +1066,16 @@ func (kustomize *kustomize) Generate(resources kftypesv3.ResourceEnum) error {
 			}
 		}
 
+		// Parameters only read generated secrets, so create the missing ones before resolving them. The operator
+		// has already created them owned by the KfDef.
+		if err := kustomize.kfDef.EnsureGeneratedSecrets(nil); err != nil {
+			return err
+		}
+
 		_, ok := kustomize.kfDef.GetRepoCache(kftypesv3.ManifestsRepoName)
 		if !ok {
 			log.Infof("Repo %v not listed in KfDef.Status; Resync'ing cache", kftypesv3.ManifestsRepoName)
-			if err := kustomize.kfDef.SyncCache(); err != nil {
+			if err := kustomize.kfDef.SyncCacheContext(ctx); err != nil {
 				log.Errorf("Syncing the cached failed: %v", err)
 				return errors.WithStack(err)
 			}
@@ -566,6 +1090,10 @@ func (kustomize *kustomize) Generate(resources kftypesv3.ResourceEnum) error {
 		// determine whether we are using the new pattern of using kustomize to build stacks.
 		// hasStack := kustomize.kfDef.UsingStacks()
 		for _, app := range kustomize.kfDef.Spec.Applications {
+			if err := ctx.Err(); err != nil {
+				_ = os.RemoveAll(kustomizeDir)
+				return err
+			}
 			log.Infof("Processing application: %v", app.Name)
 
 			if app.KustomizeConfig == nil {
@@ -612,21 +1140,40 @@ func (kustomize *kustomize) Generate(resources kftypesv3.ResourceEnum) error {
 
 				// Path to the stack inside the cache.
 				stacksCacheDir := filepath.Join("../..", appPath)
-				if _, err := createStackAppKustomization(stackAppDir, stacksCacheDir); err != nil {
+				if _, err := createStackAppKustomization(stackAppDir, stacksCacheDir, app.KustomizeConfig); err != nil {
 					return errors.WithStack(fmt.Errorf("There was a problem building the kustomize app for the Kubeflow application stack; %v ", err))
 				}
+				params, err := kustomize.resolveParameters(kustomize.kfDef.GetApplicationParameters(app))
+				if err != nil {
+					return err
+				}
+				if err := kustomize.setStackParameters(app, stackAppDir, params); err != nil {
+					return err
+				}
 			} else {
 				// TODO(jlewi): This code path should eventually go away once we are fully migrated to the use
 				// of stacks.
-				// Copy the component to kustomizeDir
-				if err := copy.Copy(appPath, path.Join(kustomizeDir, app.Name)); err != nil {
+				// Copy the component to kustomizeDir. The repo cache links to an entry of the shared cache, so copy
+				// what the link points to; copying the link itself would make the writes below land in the entry.
+				realAppPath, err := filepath.EvalSymlinks(appPath)
+				if err != nil {
+					return &kfapisv3.KfError{
+						Code:    int(kfapisv3.INTERNAL_ERROR),
+						Message: fmt.Sprintf("couldn't resolve application %s at %v: %v", app.Name, appPath, err),
+					}
+				}
+				if err := copy.Copy(realAppPath, path.Join(kustomizeDir, app.Name)); err != nil {
 					return &kfapisv3.KfError{
 						Code:    int(kfapisv3.INTERNAL_ERROR),
 						Message: fmt.Sprintf("couldn't copy application %s: %v", app.Name, err),
 					}
 				}
+				params, err := kustomize.resolveParameters(kustomize.kfDef.GetApplicationParameters(app))
+				if err != nil {
+					return err
+				}
 				if err := GenerateKustomizationFile(kustomize.kfDef, kustomizeDir, app.Name,
-					app.KustomizeConfig.Overlays, app.KustomizeConfig.Parameters); err != nil {
+					app.KustomizeConfig.Overlays, params, app.KustomizeConfig); err != nil {
 					return &kfapisv3.KfError{
 						Code:    int(kfapisv3.INTERNAL_ERROR),
 						Message: fmt.Sprintf("couldn't generate kustomization file for component %s: %v", app.Name, err),
@@ -653,11 +1200,12 @@ func (kustomize *kustomize) Generate(resources kftypesv3.ResourceEnum) error {
 // createStackAppKustomization generates a kustomization.yaml file suitable for the kubeflow application stack.
 // stackAppDir is the directory to create for the kustomize package.
 // basePath is the path to the kustomize package to use as the base package.
+// config provides the inline patches and images of the application; it may be nil.
 //
 // Returns the path to the kusotmizationFile.
 //
 // If the kustomization.yaml already exists then the changes are merged in.
-func createStackAppKustomization(stackAppDir string, basePath string) (string, error) {
+func createStackAppKustomization(stackAppDir string, basePath string, config *kfconfig.KustomizeConfig) (string, error) {
 	kustomizationFile := filepath.Join(stackAppDir, kftypesv3.KustomizationFile)
 
 	if _, err := os.Stat(stackAppDir); err == nil {
@@ -707,6 +1255,9 @@ func createStackAppKustomization(stackAppDir string, basePath string) (string, e
 	if !hasBasePath {
 		kustomization.Resources = append(kustomization.Resources, basePath)
 	}
+	if err := addInlinePatches(kustomization, stackAppDir, config); err != nil {
+		return "", err
+	}
 	yaml, err := yaml.Marshal(kustomization)
 
 	if err != nil {
@@ -724,10 +1275,199 @@ func createStackAppKustomization(stackAppDir string, basePath string) (string, e
 	return kustomizationFile, nil
 }
 
+// setStackParameters sets params, the effective parameters of the stack application app, in the <app>-config
+// ConfigMap of stackAppDir. As with params.env files, only the parameters the ConfigMap already declares are set.
+func (kustomize *kustomize) setStackParameters(app kfconfig.Application, stackAppDir string, params []kfconfig.NameValue) error {
+	if len(params) == 0 {
+		return nil
+	}
+
+	resMap, err := EvaluateKustomizeManifest(stackAppDir)
+	if err != nil {
+		return &kfapisv3.KfError{
+			Code:    int(kfapisv3.INTERNAL_ERROR),
+			Message: fmt.Sprintf("error evaluating kustomization manifest for %v: %v", app.Name, err),
+		}
+	}
+	declared := map[string]bool{}
+	for _, res := range resMap.Resources() {
+		if res.GetKind() != "ConfigMap" || res.GetOriginalName() != app.Name+"-config" {
+			continue
+		}
+		data, _, _ := unstructured.NestedStringMap(res.Map(), "data")
+		for name := range data {
+			declared[name] = true
+		}
+	}
+
+	for _, p := range params {
+		if !declared[p.Name] {
+			log.Infof("Application %v doesn't declare parameter %v; skipping it", app.Name, p.Name)
+			continue
+		}
+		if err := kustomize.kfDef.SetApplicationParameter(app.Name, p.Name, p.Value); err != nil {
+			return &kfapisv3.KfError{
+				Code:    int(kfapisv3.INTERNAL_ERROR),
+				Message: fmt.Sprintf("couldn't set parameter %v of application %v: %v", p.Name, app.Name, err),
+			}
+		}
+	}
+	return nil
+}
+
+// inlinePatchPrefix prefixes the files the inline strategic merge patches of a KustomizeConfig are written to.
+const inlinePatchPrefix = "kfdef-patch-"
+
+// inlineStateFile records, next to a kustomization, the JSON patches and images addInlinePatches added to it
+// and the images of the manifests they replaced, so that the next call can tell them from those of the manifests.
+const inlineStateFile = "kfdef-inline.yaml"
+
+type inlineState struct {
+	PatchesJson6902 []types.PatchJson6902 `json:"patchesJson6902,omitempty"`
+	Images          []image.Image         `json:"images,omitempty"`
+	ReplacedImages  []image.Image         `json:"replacedImages,omitempty"`
+}
+
+// addInlinePatches writes the inline patches and images of config into kustomization, whose directory is dir.
+// They are appended to those of the manifests, and the ones added by a previous call are removed first so that
+// regenerating the kustomization reflects the current KfDef: images replace the entries of the same name, and
+// an entry of the manifests is restored once the KfDef no longer overrides it.
+func addInlinePatches(kustomization *types.Kustomization, dir string, config *kfconfig.KustomizeConfig) error {
+	stateFile := filepath.Join(dir, inlineStateFile)
+	previous := &inlineState{}
+	if data, err := ioutil.ReadFile(stateFile); err == nil {
+		if err := yaml.Unmarshal(data, previous); err != nil {
+			return errors.WithStack(errors.Wrapf(err, "Failed to unmarshal %v", stateFile))
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.WithStack(errors.Wrapf(err, "Failed to read %v", stateFile))
+	}
+
+	var patches []types.PatchStrategicMerge
+	for _, p := range kustomization.PatchesStrategicMerge {
+		if strings.HasPrefix(string(p), inlinePatchPrefix) {
+			if err := os.Remove(filepath.Join(dir, string(p))); err != nil && !os.IsNotExist(err) {
+				return errors.WithStack(errors.Wrapf(err, "Failed to remove stale patch %v", p))
+			}
+			continue
+		}
+		patches = append(patches, p)
+	}
+	var jsonPatches []types.PatchJson6902
+	for _, p := range kustomization.PatchesJson6902 {
+		if !containsJSONPatch(previous.PatchesJson6902, p) {
+			jsonPatches = append(jsonPatches, p)
+		}
+	}
+	var images []image.Image
+	for _, img := range kustomization.Images {
+		if !containsImage(previous.Images, img) {
+			images = append(images, img)
+		}
+	}
+	images = append(images, previous.ReplacedImages...)
+
+	state := &inlineState{}
+	if config != nil {
+		// kustomize v3.2 only reads strategic merge patches from files.
+		for i, patch := range config.PatchesStrategicMerge {
+			name := fmt.Sprintf("%v%v.yaml", inlinePatchPrefix, i)
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(patch), 0644); err != nil {
+				return &kfapisv3.KfError{
+					Code:    int(kfapisv3.INTERNAL_ERROR),
+					Message: fmt.Sprintf("couldn't write patch %v: %v", filepath.Join(dir, name), err),
+				}
+			}
+			patches = append(patches, types.PatchStrategicMerge(name))
+		}
+		for _, patch := range config.PatchesJson6902 {
+			state.PatchesJson6902 = append(state.PatchesJson6902, types.PatchJson6902{
+				Target: &types.PatchTarget{
+					Gvk: gvk.Gvk{
+						Group:   patch.Target.Group,
+						Version: patch.Target.Version,
+						Kind:    patch.Target.Kind,
+					},
+					Namespace: patch.Target.Namespace,
+					Name:      patch.Target.Name,
+				},
+				Patch: patch.Patch,
+			})
+		}
+		jsonPatches = append(jsonPatches, state.PatchesJson6902...)
+		for _, img := range config.Images {
+			override := image.Image{
+				Name:    img.Name,
+				NewName: img.NewName,
+				NewTag:  img.NewTag,
+				Digest:  img.Digest,
+			}
+			state.Images = append(state.Images, override)
+			replaced := false
+			for i := range images {
+				if images[i].Name == img.Name {
+					if !containsImage(state.Images[:len(state.Images)-1], images[i]) {
+						state.ReplacedImages = append(state.ReplacedImages, images[i])
+					}
+					images[i] = override
+					replaced = true
+				}
+			}
+			if !replaced {
+				images = append(images, override)
+			}
+		}
+	}
+
+	if reflect.DeepEqual(state, &inlineState{}) {
+		if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(errors.Wrapf(err, "Failed to remove %v", stateFile))
+		}
+	} else {
+		data, err := yaml.Marshal(state)
+		if err != nil {
+			return errors.WithStack(errors.Wrapf(err, "Failed to marshal %v", stateFile))
+		}
+		if err := ioutil.WriteFile(stateFile, data, 0644); err != nil {
+			return &kfapisv3.KfError{
+				Code:    int(kfapisv3.INTERNAL_ERROR),
+				Message: fmt.Sprintf("couldn't write %v: %v", stateFile, err),
+			}
+		}
+	}
+	kustomization.PatchesStrategicMerge = patches
+	kustomization.PatchesJson6902 = jsonPatches
+	kustomization.Images = images
+	return nil
+}
+
+func containsJSONPatch(patches []types.PatchJson6902, patch types.PatchJson6902) bool {
+	for _, p := range patches {
+		if reflect.DeepEqual(p, patch) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsImage(images []image.Image, img image.Image) bool {
+	for _, i := range images {
+		if i == img {
+			return true
+		}
+	}
+	return false
+}
+
 // Init is called from 'kfctl init ...' and creates a <deployment> directory with an app.yaml file that
 // holds deployment information like components, parameters
 func (kustomize *kustomize) Init(resources kftypesv3.ResourceEnum) error {
-	return nil
+	return kustomize.InitContext(context.Background(), resources)
+}
+
+// InitContext is Init bound to ctx.
+func (kustomize *kustomize) InitContext(ctx context.Context, resources kftypesv3.ResourceEnum) error {
+	return ctx.Err()
 }
 
 // mapDirs is a recursive method that will return a map of component -> path-to-kustomization.yaml
@@ -1215,8 +1955,10 @@ func MergeKustomizations(kfDef *kfconfig.KfConfig, compDir string, overlayParams
 // for KfDef. Presumably this is because of the code in coordinator which is using it to generate
 // KfDef from overlays. But this function is also used to generate the manifests for the individual
 // kustomize packages.
+//
+// The inline patches and images of config, which may be nil, are added on top of the overlays.
 func GenerateKustomizationFile(kfDef *kfconfig.KfConfig, root string,
-	compPath string, overlays []string, params []kfconfig.NameValue) error {
+	compPath string, overlays []string, params []kfconfig.NameValue, config *kfconfig.KustomizeConfig) error {
 
 	moveToFront := func(item string, list []string) []string {
 		olen := len(list)
@@ -1274,6 +2016,9 @@ func GenerateKustomizationFile(kfDef *kfconfig.KfConfig, root string,
 			kustomization.PatchesStrategicMerge = nil
 		}
 	}
+	if err := addInlinePatches(kustomization, compDir, config); err != nil {
+		return err
+	}
 	buf, bufErr := yaml.Marshal(kustomization)
 	if bufErr != nil {
 		return bufErr
@@ -1350,13 +2095,17 @@ func readLines(path string) ([]string, error) {
 	return lines, scanner.Err()
 }
 
-// writeLines writes a string array to the given file - one line per array entry.
+// writeLines writes a string array to the given file - one line per array entry. Only the owner can read the
+// file since parameters may be read from Secrets.
 func writeLines(lines []string, path string) error {
-	file, err := os.Create(path)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+	if err := file.Chmod(0600); err != nil {
+		return err
+	}
 
 	w := bufio.NewWriter(file)
 	for _, line := range lines {
@@ -1390,75 +2139,71 @@ func CreateKustomizationMaps() map[MapType]map[string]bool {
 }
 
 // GenerateYamlWithOperatorAnnotation adds operator info to the annotation to every resource
-// some code copied from ResMap.AsYaml() func
+// and returns the resources as a YAML stream.
 func GenerateYamlWithOperatorAnnotation(resMap resmap.ResMap, instance *unstructured.Unstructured) ([]byte, error) {
-	addAnnotation := true
-	firstObj := true
-	var b []byte
-	buf := bytes.NewBuffer(b)
-	for _, res := range resMap.Resources() {
-		y, err := res.AsYAML()
-		if err != nil {
-			return nil, err
-		}
-		m := &unstructured.Unstructured{}
-		if err = yaml.Unmarshal(y, m); err != nil {
-			return nil, err
+	annotate := operatorAnnotator(instance)
+	buf := &bytes.Buffer{}
+	err := utils.WriteObjects(buf, func(visit func(*unstructured.Unstructured) error) error {
+		for _, res := range resMap.Resources() {
+			obj := &unstructured.Unstructured{Object: res.Map()}
+			if err := annotate(obj); err != nil {
+				return err
+			}
+			if err := visit(obj); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// operatorAnnotator returns a function that marks an object as owned by the KfDef instance.
+// Namespaces that already exist and were not created for this KfDef, as well as the profiles CRD
+// which holds user data, are left untouched so uninstalling doesn't remove them.
+func operatorAnnotator(instance *unstructured.Unstructured) func(*unstructured.Unstructured) error {
+	kfdefAnn := strings.Join([]string{utils.KfDefAnnotation, utils.KfDefInstance}, "/")
+	kfdefCr := strings.Join([]string{instance.GetName(), instance.GetNamespace()}, ".")
+	var corev1client corev1.CoreV1Interface
 
+	return func(m *unstructured.Unstructured) error {
 		anns := m.GetAnnotations()
 		if anns == nil {
 			anns = map[string]string{}
 		}
-		kfdefAnn := strings.Join([]string{utils.KfDefAnnotation, utils.KfDefInstance}, "/")
-		kfdefCr := strings.Join([]string{instance.GetName(), instance.GetNamespace()}, ".")
 
 		if m.GetKind() == "Namespace" {
-			config, _ := rest.InClusterConfig()
-			corev1client, err := corev1.NewForConfig(config)
-			if err != nil {
-				return nil, &kfapisv3.KfError{
-					Code:    int(kfapisv3.INTERNAL_ERROR),
-					Message: fmt.Sprintf("failed to create corev1 client: %v", err),
+			if corev1client == nil {
+				config, _ := rest.InClusterConfig()
+				c, err := corev1.NewForConfig(config)
+				if err != nil {
+					return &kfapisv3.KfError{
+						Code:    int(kfapisv3.INTERNAL_ERROR),
+						Message: fmt.Sprintf("failed to create corev1 client: %v", err),
+					}
 				}
+				corev1client = c
 			}
-			_, err = corev1client.Namespaces().Get(m.GetName(), metav1.GetOptions{})
+			_, err := corev1client.Namespaces().Get(m.GetName(), metav1.GetOptions{})
 			if err == nil {
 				log.Infof("Namespace %v already exists.", m.GetName())
 
-				_, found := anns[kfdefAnn]
-				if !found || anns[kfdefAnn] != kfdefCr {
+				if owner, found := anns[kfdefAnn]; !found || owner != kfdefCr {
 					// if the namespace is not created by this operator, should not append the annotation
-					addAnnotation = false
+					return nil
 				}
 			}
 		} else if m.GetKind() == "CustomResourceDefinition" && m.GetName() == "profiles.kubeflow.org" {
 			// profiles will contain user info and data, should not remove during uninstall
-			addAnnotation = false
+			return nil
 		}
 
-		if addAnnotation {
-			anns[kfdefAnn] = kfdefCr
-			m.SetAnnotations(anns)
-		}
-		out, err := yaml.Marshal(m)
-		if err != nil {
-			return nil, err
-		}
-		if addAnnotation {
-			log.Infof("KfDef annotation added for resource %v.%v", m.GetName(), m.GetNamespace())
-		}
-
-		if firstObj {
-			firstObj = false
-		} else {
-			if _, err = buf.WriteString("---\n"); err != nil {
-				return nil, err
-			}
-		}
-		if _, err = buf.Write(out); err != nil {
-			return nil, err
-		}
+		anns[kfdefAnn] = kfdefCr
+		m.SetAnnotations(anns)
+		log.Infof("KfDef annotation added for resource %v.%v", m.GetName(), m.GetNamespace())
+		return nil
 	}
-	return buf.Bytes(), nil
 }
@@ -1,20 +1,32 @@
 package kustomize
 
 import (
+	"context"
 	"github.com/ghodss/yaml"
 	"github.com/google/go-cmp/cmp"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"sigs.k8s.io/kustomize/v3/pkg/gvk"
+	"sigs.k8s.io/kustomize/v3/pkg/image"
 	"sigs.k8s.io/kustomize/v3/pkg/types"
 	"strings"
 	"testing"
 
+	kftypesv3 "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
 	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	"github.com/kubeflow/kfctl/v3/pkg/utils"
 	"github.com/otiai10/copy"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 // This test tests that GenerateKustomizationFile will produce correct kustomization.yaml
@@ -69,7 +81,7 @@ func TestGenerateKustomizationFile(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to copy package to temp dir: %v", err)
 		}
-		err = GenerateKustomizationFile(c.kfDef, testDir, packageName, c.overlays, c.params)
+		err = GenerateKustomizationFile(c.kfDef, testDir, packageName, c.overlays, c.params, nil)
 		if err != nil {
 			t.Fatalf("Failed to GenerateKustomizationFile: %v", err)
 		}
@@ -189,7 +201,7 @@ func TestCreateStackAppKustomization(t *testing.T) {
 			}
 		}
 
-		kustomizationFile, err := createStackAppKustomization(testDir, c.BasePath)
+		kustomizationFile, err := createStackAppKustomization(testDir, c.BasePath, nil)
 
 		if err != nil {
 			t.Fatalf("Failed to create kustomization.yaml for Kubeflow apps stack: %v", err)
@@ -213,3 +225,396 @@ func TestCreateStackAppKustomization(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateStackAppKustomizationInlinePatches(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "testCreateStackAppKustomizationInlinePatches-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	if err := copy.Copy("testdata/operator/base", filepath.Join(testDir, "base")); err != nil {
+		t.Fatalf("Failed to copy package to temp dir: %v", err)
+	}
+	appDir := filepath.Join(testDir, "app")
+
+	config := &kfconfig.KustomizeConfig{
+		PatchesStrategicMerge: []string{`
+apiVersion: v1
+kind: Service
+metadata:
+  name: fake-service
+spec:
+  type: NodePort
+`},
+		PatchesJson6902: []kfconfig.PatchJson6902{
+			{
+				Target: kfconfig.PatchTarget{
+					Version: "v1",
+					Kind:    "Service",
+					Name:    "fake-service",
+				},
+				Patch: `[{"op": "replace", "path": "/spec/ports/0/port", "value": 9090}]`,
+			},
+		},
+		Images: []kfconfig.Image{
+			{
+				Name:   "gcr.io/kubeflow/fake",
+				NewTag: "v2",
+			},
+		},
+	}
+	// Generating twice must not duplicate the patches.
+	for i := 0; i < 2; i++ {
+		if _, err := createStackAppKustomization(appDir, "../base", config); err != nil {
+			t.Fatalf("Failed to create kustomization.yaml: %v", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(appDir, "kustomization.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read kustomization.yaml: %v", err)
+	}
+	kustomization := &types.Kustomization{}
+	if err := yaml.Unmarshal(data, kustomization); err != nil {
+		t.Fatalf("Failed to unmarshal kustomization.yaml: %v", err)
+	}
+	if len(kustomization.PatchesStrategicMerge) != 1 || len(kustomization.PatchesJson6902) != 1 ||
+		len(kustomization.Images) != 1 {
+		t.Fatalf("Unexpected patches or images in kustomization.yaml:\n%s", data)
+	}
+
+	resMap, err := EvaluateKustomizeManifest(appDir)
+	if err != nil {
+		t.Fatalf("Failed to evaluate manifest: %v", err)
+	}
+	out, err := resMap.AsYaml()
+	if err != nil {
+		t.Fatalf("Failed to generate yaml: %v", err)
+	}
+	svc := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(out, &svc.Object); err != nil {
+		t.Fatalf("Failed to unmarshal service: %v", err)
+	}
+	if svcType, _, _ := unstructured.NestedString(svc.Object, "spec", "type"); svcType != "NodePort" {
+		t.Errorf("spec.type = %q, want NodePort", svcType)
+	}
+	ports, _, _ := unstructured.NestedSlice(svc.Object, "spec", "ports")
+	if len(ports) != 1 || ports[0].(map[string]interface{})["port"] != float64(9090) {
+		t.Errorf("spec.ports = %v, want port 9090", ports)
+	}
+
+	// Removing the patches from the config removes them from the kustomization.
+	if _, err := createStackAppKustomization(appDir, "../base", &kfconfig.KustomizeConfig{}); err != nil {
+		t.Fatalf("Failed to create kustomization.yaml: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(appDir, inlinePatchPrefix+"0.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Stale patch file wasn't removed: %v", err)
+	}
+	if _, err := EvaluateKustomizeManifest(appDir); err != nil {
+		t.Fatalf("Failed to evaluate manifest without patches: %v", err)
+	}
+}
+
+func TestDeleteDisabledApplications(t *testing.T) {
+	appDir, err := ioutil.TempDir("", "testDeleteDisabledApplications")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(appDir)
+	manifestDir := path.Join(appDir, outputDir, "app")
+	if err := os.MkdirAll(manifestDir, os.ModePerm); err != nil {
+		t.Fatalf("Failed to create %v: %v", manifestDir, err)
+	}
+	manifests := map[string]string{
+		"kustomization.yaml": "resources:\n- configmaps.yaml\n",
+		"configmaps.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+  namespace: kubeflow
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: shared-config
+  namespace: kubeflow
+`,
+	}
+	for name, content := range manifests {
+		if err := ioutil.WriteFile(path.Join(manifestDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+
+	disabled := false
+	app := kfconfig.Application{Name: "app", Enabled: &disabled}
+	config := &kfconfig.KfConfig{
+		Spec: kfconfig.KfConfigSpec{
+			AppDir:       appDir,
+			Applications: []kfconfig.Application{app},
+		},
+		Status: kfconfig.Status{
+			Applications: []kfconfig.ApplicationStatus{{Name: "app", Hash: "abc"}},
+		},
+	}
+	kubeclient := fake.NewFakeClient(
+		&corev1.ConfigMap{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "app-config", Namespace: "kubeflow"}},
+		&corev1.ConfigMap{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "shared-config", Namespace: "kubeflow"}},
+	)
+	k := &kustomize{kfDef: config}
+	// shared-config is also an object of an enabled application.
+	keep := map[string]bool{"ConfigMap/kubeflow/shared-config": true}
+	if err := k.deleteDisabledApplications(context.Background(), kubeclient, []kfconfig.Application{app}, keep); err != nil {
+		t.Fatalf("deleteDisabledApplications failed: %v", err)
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := kubeclient.Get(context.Background(), k8stypes.NamespacedName{Name: "app-config", Namespace: "kubeflow"}, cm); !apierrors.IsNotFound(err) {
+		t.Errorf("ConfigMap app-config of the disabled application wasn't deleted; error %v", err)
+	}
+	if err := kubeclient.Get(context.Background(), k8stypes.NamespacedName{Name: "shared-config", Namespace: "kubeflow"}, cm); err != nil {
+		t.Errorf("ConfigMap shared-config of an enabled application was deleted; error %v", err)
+	}
+	status, ok := config.GetApplicationStatus("app")
+	if !ok || !status.Disabled || status.Hash != "" {
+		t.Errorf("Status of app is %+v; want it disabled", status)
+	}
+
+	// Once disabled, resources aren't deleted again so no client is needed.
+	if err := k.deleteDisabledApplications(context.Background(), nil, []kfconfig.Application{app}, keep); err != nil {
+		t.Errorf("deleteDisabledApplications of an already disabled application failed: %v", err)
+	}
+}
+
+func TestStageObjects(t *testing.T) {
+	compDir, err := ioutil.TempDir("", "testStageObjects")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(compDir)
+	manifests := map[string]string{
+		"kustomization.yaml": "resources:\n- configmap.yaml\n",
+		"configmap.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+  namespace: kubeflow
+`,
+	}
+	for name, content := range manifests {
+		if err := ioutil.WriteFile(path.Join(compDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+
+	objects, err := evaluateObjects(compDir, utils.InstallOrder)
+	if err != nil {
+		t.Fatalf("evaluateObjects failed: %v", err)
+	}
+	k := &kustomize{kfDef: &kfconfig.KfConfig{}}
+	keys := map[string]bool{}
+	staged, err := k.stageObjects(context.Background(), nil, namespaceResolver{defaultNamespace: "default"}, "app",
+		objects, keys)
+	if err != nil {
+		t.Fatalf("stageObjects failed: %v", err)
+	}
+	defer staged.remove()
+
+	// Without a client every object is pending and written to the manifests file exactly once, annotated.
+	want := objectRef{gvk: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, namespace: "kubeflow", name: "app-config"}
+	if !reflect.DeepEqual(staged.pending, []objectRef{want}) || !reflect.DeepEqual(staged.objects, []objectRef{want}) {
+		t.Errorf("Got pending %+v and objects %+v; want %+v", staged.pending, staged.objects, want)
+	}
+	if !keys["ConfigMap/kubeflow/app-config"] {
+		t.Errorf("Got keys %v; want the key of app-config recorded", keys)
+	}
+	if staged.hash == "" {
+		t.Errorf("Got no application hash")
+	}
+	manifest, err := ioutil.ReadFile(staged.file)
+	if err != nil {
+		t.Fatalf("Failed to read the staged manifests: %v", err)
+	}
+	if n := strings.Count(string(manifest), "kind: ConfigMap"); n != 1 {
+		t.Errorf("Staged manifests hold %v ConfigMaps; want 1:\n%s", n, manifest)
+	}
+	if !strings.Contains(string(manifest), utils.DesiredHashAnnotation) {
+		t.Errorf("Staged manifests lack the desired hash annotation:\n%s", manifest)
+	}
+
+	// The objects are handed out without copies, so the stream can't be consumed again.
+	if err := objects(func(*unstructured.Unstructured) error { return nil }); err == nil {
+		t.Errorf("Consuming the objects again succeeded; want an error")
+	}
+
+	file := staged.file
+	staged.remove()
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Staged manifests %v weren't removed; error %v", file, err)
+	}
+}
+
+func TestNamespaceResolver(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, meta.RESTScopeRoot)
+	resolver := namespaceResolver{defaultNamespace: "context-ns", mapper: mapper}
+	cases := []struct {
+		apiVersion string
+		kind       string
+		namespace  string
+		want       string
+	}{
+		{apiVersion: "v1", kind: "ConfigMap", namespace: "kubeflow", want: "kubeflow"},
+		// Namespaced objects without a namespace go where kubectl applies them.
+		{apiVersion: "v1", kind: "ConfigMap", want: "context-ns"},
+		// Cluster scoped objects have no namespace, even if the manifest sets one.
+		{apiVersion: "rbac.authorization.k8s.io/v1", kind: "ClusterRole", namespace: "kubeflow", want: ""},
+		// Unknown kinds, e.g. of CRDs not created yet, are taken as namespaced.
+		{apiVersion: "example.com/v1", kind: "Widget", want: "context-ns"},
+	}
+	for _, c := range cases {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(c.apiVersion)
+		obj.SetKind(c.kind)
+		obj.SetNamespace(c.namespace)
+		if got := resolver.namespace(obj); got != c.want {
+			t.Errorf("Namespace of %v in %q is %q; want %q", c.kind, c.namespace, got, c.want)
+		}
+	}
+}
+
+func TestAddInlinePatchesReconcile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testAddInlinePatchesReconcile")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	manifestPatch := types.PatchJson6902{
+		Target: &types.PatchTarget{Gvk: gvk.Gvk{Version: "v1", Kind: "Service"}, Name: "fake-service"},
+		Patch:  `[{"op": "add", "path": "/metadata/labels", "value": {}}]`,
+	}
+	manifestImage := image.Image{Name: "gcr.io/kubeflow/fake", NewTag: "v1"}
+	kustomization := &types.Kustomization{
+		PatchesJson6902: []types.PatchJson6902{manifestPatch},
+		Images:          []image.Image{manifestImage},
+	}
+	config := &kfconfig.KustomizeConfig{
+		PatchesJson6902: []kfconfig.PatchJson6902{{
+			Target: kfconfig.PatchTarget{Version: "v1", Kind: "Service", Name: "fake-service"},
+			Patch:  `[{"op": "replace", "path": "/spec/ports/0/port", "value": 9090}]`,
+		}},
+		Images: []kfconfig.Image{
+			{Name: "gcr.io/kubeflow/fake", NewTag: "v2"},
+			{Name: "gcr.io/kubeflow/other", NewTag: "v2"},
+		},
+	}
+	// Generating twice must keep the patch and image of the manifests and not duplicate those of the KfDef.
+	for i := 0; i < 2; i++ {
+		if err := addInlinePatches(kustomization, dir, config); err != nil {
+			t.Fatalf("addInlinePatches failed: %v", err)
+		}
+	}
+	if len(kustomization.PatchesJson6902) != 2 || !reflect.DeepEqual(kustomization.PatchesJson6902[0], manifestPatch) {
+		t.Errorf("Got JSON patches %+v; want the one of the manifests followed by the one of the KfDef",
+			kustomization.PatchesJson6902)
+	}
+	expected := []image.Image{{Name: "gcr.io/kubeflow/fake", NewTag: "v2"}, {Name: "gcr.io/kubeflow/other", NewTag: "v2"}}
+	if !reflect.DeepEqual(kustomization.Images, expected) {
+		t.Errorf("Got images %+v; want %+v", kustomization.Images, expected)
+	}
+
+	// Removing the overrides from the KfDef restores the manifests.
+	if err := addInlinePatches(kustomization, dir, &kfconfig.KustomizeConfig{}); err != nil {
+		t.Fatalf("addInlinePatches failed: %v", err)
+	}
+	if !reflect.DeepEqual(kustomization.PatchesJson6902, []types.PatchJson6902{manifestPatch}) {
+		t.Errorf("Got JSON patches %+v; want only the one of the manifests", kustomization.PatchesJson6902)
+	}
+	if !reflect.DeepEqual(kustomization.Images, []image.Image{manifestImage}) {
+		t.Errorf("Got images %+v; want only the one of the manifests", kustomization.Images)
+	}
+	if _, err := os.Stat(filepath.Join(dir, inlineStateFile)); !os.IsNotExist(err) {
+		t.Errorf("Stale %v wasn't removed: %v", inlineStateFile, err)
+	}
+}
+
+func TestGenerateLeavesSharedCacheEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testGenerateLeavesSharedCacheEntry")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// The repo cache of the AppDir links to an entry of the shared cache, as for OCI repos and git repos
+	// without a subDir.
+	entry := path.Join(dir, "shared", "sources", "oci-abc", "content")
+	manifests := map[string]string{
+		"base/kustomization.yaml": "resources:\n- configmap.yaml\nconfigMapGenerator:\n- name: app-parameters\n  env: params.env\n",
+		"base/configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app-config\n",
+		"base/params.env":         "namespace=\npassword=\n",
+	}
+	if err := os.MkdirAll(path.Join(entry, "base"), os.ModePerm); err != nil {
+		t.Fatalf("Failed to create %v: %v", entry, err)
+	}
+	for name, content := range manifests {
+		if err := ioutil.WriteFile(path.Join(entry, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+	appDir := path.Join(dir, "app")
+	localPath := path.Join(appDir, ".cache", "manifests")
+	if err := os.MkdirAll(path.Dir(localPath), os.ModePerm); err != nil {
+		t.Fatalf("Failed to create %v: %v", path.Dir(localPath), err)
+	}
+	if err := os.Symlink(entry, localPath); err != nil {
+		t.Fatalf("Failed to link %v: %v", localPath, err)
+	}
+
+	config := &kfconfig.KfConfig{
+		Spec: kfconfig.KfConfigSpec{
+			AppDir: appDir,
+			Applications: []kfconfig.Application{{
+				Name: "app",
+				KustomizeConfig: &kfconfig.KustomizeConfig{
+					RepoRef:    &kfconfig.RepoRef{Name: "manifests"},
+					Parameters: []kfconfig.NameValue{{Name: "password", Value: "secretvalue"}},
+				},
+			}},
+		},
+		Status: kfconfig.Status{
+			Caches: []kfconfig.Cache{{Name: "manifests", LocalPath: localPath}},
+		},
+	}
+	config.Namespace = "kubeflow"
+	k := &kustomize{kfDef: config}
+	if err := k.Generate(kftypesv3.K8S); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for name, content := range manifests {
+		actual, err := ioutil.ReadFile(path.Join(entry, name))
+		if err != nil || string(actual) != content {
+			t.Errorf("Cache entry file %v changed; got %q (%v); want %q", name, actual, err, content)
+		}
+	}
+	files := 0
+	filepath.Walk(entry, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files++
+		}
+		return nil
+	})
+	if files != len(manifests) {
+		t.Errorf("Cache entry holds %v files; want %v", files, len(manifests))
+	}
+	generated := path.Join(appDir, outputDir, "app")
+	if fi, err := os.Lstat(generated); err != nil || fi.Mode()&os.ModeSymlink != 0 {
+		t.Fatalf("Application %v isn't a copied directory; error %v", generated, err)
+	}
+	params, err := ioutil.ReadFile(path.Join(generated, "base", "params.env"))
+	if err != nil || !strings.Contains(string(params), "password=secretvalue") {
+		t.Errorf("Parameters weren't written to the generated application; got %q (%v)", params, err)
+	}
+}
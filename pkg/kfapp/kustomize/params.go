@@ -0,0 +1,82 @@
+// Copyright 2018 The Kubeflow Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kustomize
+
+import (
+	"fmt"
+
+	kfapisv3 "github.com/kubeflow/kfctl/v3/pkg/apis"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+// resolveParameters returns params with their values read from their sources, as resolveValueSources does,
+// and the references to variables in them substituted.
+func (kustomize *kustomize) resolveParameters(params []kfconfig.NameValue) ([]kfconfig.NameValue, error) {
+	resolved, err := kustomize.resolveValueSources(params)
+	if err != nil {
+		return nil, err
+	}
+	for i := range resolved {
+		value, err := kustomize.substituteVariables(resolved[i].Value, true)
+		if err != nil {
+			return nil, &kfapisv3.KfError{
+				Code:    int(kfapisv3.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("couldn't resolve the value of parameter %v: %v", resolved[i].Name, err),
+			}
+		}
+		resolved[i].Value = value
+	}
+	return resolved, nil
+}
+
+// resolveValueSources returns params with the values of the parameters set from a ConfigMap or Secret read from
+// the cluster: kfctl reads them from the cluster of the current kubeconfig context and the operator from its
+// own cluster. They are read from the namespace of the KfDef, or of the current context if it has none.
+// Parameters whose optional source doesn't exist are left out so their defaults apply.
+// Parameters referring to a secret of the KfDef get its value, whatever its source.
+func (kustomize *kustomize) resolveValueSources(params []kfconfig.NameValue) ([]kfconfig.NameValue, error) {
+	resolved := make([]kfconfig.NameValue, 0, len(params))
+	for _, p := range params {
+		if p.ValueFrom == nil {
+			resolved = append(resolved, p)
+			continue
+		}
+		if p.ValueFrom.KfDefSecretRef != nil {
+			value, err := kustomize.kfDef.GetSecret(p.ValueFrom.KfDefSecretRef.Name)
+			if err != nil {
+				return nil, &kfapisv3.KfError{
+					Code:    int(kfapisv3.INVALID_ARGUMENT),
+					Message: fmt.Sprintf("couldn't resolve the value of parameter %v: %v", p.Name, err),
+				}
+			}
+			resolved = append(resolved, kfconfig.NameValue{Name: p.Name, Value: value})
+			continue
+		}
+		value, found, err := kfconfig.ReadValueSource(kustomize.kfDef.Namespace, p.ValueFrom)
+		if err != nil {
+			return nil, &kfapisv3.KfError{
+				Code:    int(kfapisv3.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("couldn't resolve the value of parameter %v: %v", p.Name, err),
+			}
+		}
+		if !found {
+			log.Infof("Optional source of parameter %v doesn't exist; skipping it", p.Name)
+			continue
+		}
+		resolved = append(resolved, kfconfig.NameValue{Name: p.Name, Value: value})
+	}
+	return resolved, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 The Kubeflow Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kustomize
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// Cluster facts parameter values can refer to.
+	ClusterDomainFact              = "cluster.domain"
+	ClusterVersionFact             = "cluster.version"
+	ClusterDefaultStorageClassFact = "cluster.defaultStorageClass"
+	KfDefNameFact                  = "kfdef.name"
+	KfDefNamespaceFact             = "kfdef.namespace"
+
+	defaultStorageClassAnnotation     = "storageclass.kubernetes.io/is-default-class"
+	betaDefaultStorageClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+)
+
+// variableReference matches ${<name>}; $${<name>} escapes it to a literal ${<name>}.
+var variableReference = regexp.MustCompile(`\$?\$\{([A-Za-z0-9_.-]+)\}`)
+
+// openshiftIngress is the cluster-wide ingress configuration of OpenShift holding the domain of its routes.
+var openshiftIngress = schema.GroupVersionResource{Group: "config.openshift.io", Version: "v1", Resource: "ingresses"}
+
+// substituteVariables returns value with its references to variables substituted. The user-defined variables
+// of the KfDef are only substituted if userVariables is set; cluster facts always are.
+func (kustomize *kustomize) substituteVariables(value string, userVariables bool) (string, error) {
+	var substituteErr error
+	substituted := variableReference.ReplaceAllStringFunc(value, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		if substituteErr != nil {
+			return ref
+		}
+		name := variableReference.FindStringSubmatch(ref)[1]
+		resolved, err := kustomize.variable(name, userVariables)
+		if err != nil {
+			substituteErr = err
+			return ref
+		}
+		return resolved
+	})
+	if substituteErr != nil {
+		return "", substituteErr
+	}
+	return substituted, nil
+}
+
+// variable returns the value of the variable or cluster fact name. User-defined variables take precedence
+// over cluster facts.
+func (kustomize *kustomize) variable(name string, userVariables bool) (string, error) {
+	if value, ok := kustomize.variables[name]; ok {
+		return value, nil
+	}
+	if userVariables {
+		for _, v := range kustomize.kfDef.Spec.Variables {
+			if v.Name != name {
+				continue
+			}
+			resolved, err := kustomize.resolveValueSources([]kfconfig.NameValue{v})
+			if err != nil {
+				return "", err
+			}
+			value := ""
+			if len(resolved) > 0 {
+				value = resolved[0].Value
+			}
+			if value, err = kustomize.substituteVariables(value, false); err != nil {
+				return "", fmt.Errorf("couldn't resolve variable %v: %v", name, err)
+			}
+			secret := v.ValueFrom != nil && (v.ValueFrom.SecretKeyRef != nil || v.ValueFrom.KfDefSecretRef != nil)
+			kustomize.setVariable(name, value, !secret)
+			return value, nil
+		}
+	}
+	return kustomize.fact(name)
+}
+
+// fact returns the value of the cluster fact name. When the cluster can't answer, the value recorded by the
+// last render is used so that rendering stays reproducible.
+func (kustomize *kustomize) fact(name string) (string, error) {
+	var value string
+	var err error
+	switch name {
+	case KfDefNameFact:
+		value = kustomize.kfDef.Name
+	case KfDefNamespaceFact:
+		value, err = kfconfig.ResolveNamespace(kustomize.kfDef.Namespace)
+		if err != nil {
+			return "", err
+		}
+	case ClusterDomainFact, ClusterVersionFact, ClusterDefaultStorageClassFact:
+		value, err = kustomize.clusterFact(name)
+		if err != nil {
+			recorded, ok := kustomize.kfDef.GetResolvedVariable(name)
+			if !ok {
+				return "", err
+			}
+			log.Warnf("Using the recorded value %q of %v: %v", recorded, name, err)
+			value = recorded
+		}
+	default:
+		return "", fmt.Errorf("undefined variable %v", name)
+	}
+	kustomize.setVariable(name, value, true)
+	return value, nil
+}
+
+// clusterFact reads the cluster fact name from the cluster.
+func (kustomize *kustomize) clusterFact(name string) (string, error) {
+	facts, err := kustomize.clusterFacts()
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case ClusterDomainFact:
+		return facts.ingressDomain()
+	case ClusterVersionFact:
+		clusterVersion, err := facts.serverVersion()
+		if err != nil {
+			return "", err
+		}
+		return clusterVersion.String(), nil
+	default:
+		return facts.defaultStorageClass()
+	}
+}
+
+// setVariable caches the value of variable name and records it in the status of the KfDef if record is set.
+func (kustomize *kustomize) setVariable(name string, value string, record bool) {
+	if kustomize.variables == nil {
+		kustomize.variables = map[string]string{}
+	}
+	kustomize.variables[name] = value
+	if record {
+		kustomize.kfDef.SetResolvedVariable(name, value)
+	}
+}
+
+// ingressDomain returns the domain of the routes of an OpenShift cluster.
+func (f *clusterFacts) ingressDomain() (string, error) {
+	ingress, err := f.dynamic.Resource(openshiftIngress).Get("cluster", metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the ingress configuration of the cluster: %v", err)
+	}
+	domain, found, err := unstructured.NestedString(ingress.Object, "spec", "domain")
+	if err != nil || !found || domain == "" {
+		return "", fmt.Errorf("the ingress configuration of the cluster has no domain")
+	}
+	return domain, nil
+}
+
+// defaultStorageClass returns the name of the default StorageClass of the cluster.
+func (f *clusterFacts) defaultStorageClass() (string, error) {
+	classes, err := f.storage.StorageClasses().List(metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("couldn't list the storage classes of the cluster: %v", err)
+	}
+	for _, class := range classes.Items {
+		if class.Annotations[defaultStorageClassAnnotation] == "true" ||
+			class.Annotations[betaDefaultStorageClassAnnotation] == "true" {
+			return class.Name, nil
+		}
+	}
+	return "", fmt.Errorf("the cluster has no default storage class")
+}
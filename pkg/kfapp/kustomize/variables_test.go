@@ -0,0 +1,145 @@
+package kustomize
+
+import (
+	"testing"
+
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newFakeClusterFacts(domain string) *clusterFacts {
+	facts := newClusterFacts(&fakediscovery.FakeDiscovery{
+		Fake:               &k8stesting.Fake{},
+		FakedServerVersion: &version.Info{GitVersion: "v1.20.4+k3s1"},
+	})
+	var objects []runtime.Object
+	if domain != "" {
+		objects = append(objects, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "config.openshift.io/v1",
+			"kind":       "Ingress",
+			"metadata":   map[string]interface{}{"name": "cluster"},
+			"spec":       map[string]interface{}{"domain": domain},
+		}})
+	}
+	facts.dynamic = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+	facts.storage = kubefake.NewSimpleClientset(
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "slow"}},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{
+			Name:        "fast",
+			Annotations: map[string]string{defaultStorageClassAnnotation: "true"},
+		}},
+	).StorageV1()
+	return facts
+}
+
+func TestKustomize_ResolveParametersVariables(t *testing.T) {
+	type testCase struct {
+		Name     string
+		Domain   string
+		Recorded []kfconfig.NameValue
+		Params   []kfconfig.NameValue
+		Expected []kfconfig.NameValue
+		Status   []kfconfig.NameValue
+		Err      bool
+	}
+	cases := []testCase{
+		{
+			Name:   "facts-and-variables",
+			Domain: "apps.example.com",
+			Params: []kfconfig.NameValue{
+				{Name: "host", Value: "dashboard.${cluster.domain}"},
+				{Name: "url", Value: "https://${endpoint}/${kfdef.namespace}"},
+				{Name: "storageClass", Value: "${cluster.defaultStorageClass}"},
+				{Name: "version", Value: "${cluster.version}"},
+				{Name: "plain", Value: "value"},
+			},
+			Expected: []kfconfig.NameValue{
+				{Name: "host", Value: "dashboard.apps.example.com"},
+				{Name: "url", Value: "https://kf.apps.example.com/kubeflow"},
+				{Name: "storageClass", Value: "fast"},
+				{Name: "version", Value: "1.20.4"},
+				{Name: "plain", Value: "value"},
+			},
+			Status: []kfconfig.NameValue{
+				{Name: "cluster.domain", Value: "apps.example.com"},
+				{Name: "endpoint", Value: "kf.apps.example.com"},
+				{Name: "kfdef.namespace", Value: "kubeflow"},
+				{Name: "cluster.defaultStorageClass", Value: "fast"},
+				{Name: "cluster.version", Value: "1.20.4"},
+			},
+		},
+		{
+			Name:     "escaped",
+			Params:   []kfconfig.NameValue{{Name: "template", Value: "$${kfdef.name}-${kfdef.name}"}},
+			Expected: []kfconfig.NameValue{{Name: "template", Value: "${kfdef.name}-kubeflow"}},
+			Status:   []kfconfig.NameValue{{Name: "kfdef.name", Value: "kubeflow"}},
+		},
+		{
+			Name:     "recorded-fallback",
+			Recorded: []kfconfig.NameValue{{Name: "cluster.domain", Value: "apps.recorded.com"}},
+			Params:   []kfconfig.NameValue{{Name: "host", Value: "dashboard.${cluster.domain}"}},
+			Expected: []kfconfig.NameValue{{Name: "host", Value: "dashboard.apps.recorded.com"}},
+			Status:   []kfconfig.NameValue{{Name: "cluster.domain", Value: "apps.recorded.com"}},
+		},
+		{
+			Name:   "undefined",
+			Params: []kfconfig.NameValue{{Name: "host", Value: "${undefined}"}},
+			Err:    true,
+		},
+		{
+			Name:   "fact-unavailable",
+			Params: []kfconfig.NameValue{{Name: "host", Value: "${cluster.domain}"}},
+			Err:    true,
+		},
+	}
+
+	for _, c := range cases {
+		kfDef := &kfconfig.KfConfig{
+			Spec: kfconfig.KfConfigSpec{
+				Variables: []kfconfig.NameValue{{Name: "endpoint", Value: "kf.${cluster.domain}"}},
+			},
+			Status: kfconfig.Status{Variables: c.Recorded},
+		}
+		kfDef.Name = "kubeflow"
+		kfDef.Namespace = "kubeflow"
+		kustomize := &kustomize{kfDef: kfDef, facts: newFakeClusterFacts(c.Domain)}
+
+		actual, err := kustomize.resolveParameters(c.Params)
+		if c.Err {
+			if err == nil {
+				t.Errorf("Case %v; expected an error got none", c.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case %v; unexpected error: %v", c.Name, err)
+			continue
+		}
+		if len(actual) != len(c.Expected) {
+			t.Errorf("Case %v; got %v want %v", c.Name, actual, c.Expected)
+			continue
+		}
+		for i := range c.Expected {
+			if actual[i].Name != c.Expected[i].Name || actual[i].Value != c.Expected[i].Value {
+				t.Errorf("Case %v; got %v want %v", c.Name, actual[i], c.Expected[i])
+			}
+		}
+		if len(kfDef.Status.Variables) != len(c.Status) {
+			t.Errorf("Case %v; got status %v want %v", c.Name, kfDef.Status.Variables, c.Status)
+			continue
+		}
+		for i := range c.Status {
+			if kfDef.Status.Variables[i] != c.Status[i] {
+				t.Errorf("Case %v; got status %v want %v", c.Name, kfDef.Status.Variables[i], c.Status[i])
+			}
+		}
+	}
+}
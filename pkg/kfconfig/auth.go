@@ -0,0 +1,294 @@
+package kfconfig
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/homedir"
+)
+
+// Keys of the Secrets holding the credentials repos and base KfDefs are downloaded with.
+const (
+	CredentialsTokenKey      = "token"
+	CredentialsUsernameKey   = "username"
+	CredentialsPasswordKey   = "password"
+	CredentialsClientCertKey = v1.TLSCertKey
+	CredentialsClientKeyKey  = v1.TLSPrivateKeyKey
+	CredentialsCABundleKey   = "ca.crt"
+)
+
+// Environment variables kfctl reads credentials from when no Secret is referred to. The client certificate,
+// key and CA bundle are paths of PEM files. The credentials are only sent to the comma-separated hosts of
+// KFCTL_AUTH_HOSTS; they aren't used at all if it isn't set.
+const (
+	AuthTokenEnv      = "KFCTL_AUTH_TOKEN"
+	AuthUsernameEnv   = "KFCTL_AUTH_USERNAME"
+	AuthPasswordEnv   = "KFCTL_AUTH_PASSWORD"
+	AuthClientCertEnv = "KFCTL_AUTH_CLIENT_CERT"
+	AuthClientKeyEnv  = "KFCTL_AUTH_CLIENT_KEY"
+	AuthCABundleEnv   = "KFCTL_AUTH_CA_BUNDLE"
+	AuthHostsEnv      = "KFCTL_AUTH_HOSTS"
+	netrcEnv          = "NETRC"
+)
+
+// readCredentialsSecret reads the Secret credentials are read from.
+var readCredentialsSecret = ReadSecret
+
+// Credentials authenticate the downloads from a private server: with a bearer token or basic auth, and with a
+// client certificate. CABundle is the PEM bundle the certificate of the server is verified against.
+type Credentials struct {
+	// Host is the host the credentials are sent to.
+	Host       string
+	Token      string
+	Username   string
+	Password   string
+	ClientCert []byte
+	ClientKey  []byte
+	CABundle   []byte
+}
+
+// ReadCredentials returns the credentials uri is downloaded with: those of the Secret of namespace secret
+// refers to if it is set, or else those of the environment or of the netrc file for the host of uri.
+// It returns nil if there are none.
+func ReadCredentials(namespace string, secret *v1.LocalObjectReference, uri string) (*Credentials, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		if secret != nil {
+			return nil, fmt.Errorf("credentials Secret %v only applies to http(s) URIs; got %v", secret.Name, uri)
+		}
+		return nil, nil
+	}
+	host := u.Host
+	if secret != nil {
+		data, err := readCredentialsSecret(namespace, secret.Name)
+		if err != nil {
+			return nil, err
+		}
+		return &Credentials{
+			Host:       host,
+			Token:      string(data[CredentialsTokenKey]),
+			Username:   string(data[CredentialsUsernameKey]),
+			Password:   string(data[CredentialsPasswordKey]),
+			ClientCert: data[CredentialsClientCertKey],
+			ClientKey:  data[CredentialsClientKeyKey],
+			CABundle:   data[CredentialsCABundleKey],
+		}, nil
+	}
+	if creds, err := environmentCredentials(host); creds != nil || err != nil {
+		return creds, err
+	}
+	return netrcCredentials(host)
+}
+
+// environmentCredentials returns the credentials of the environment for host, if host is one of KFCTL_AUTH_HOSTS.
+func environmentCredentials(host string) (*Credentials, error) {
+	found := false
+	for _, h := range strings.Split(os.Getenv(AuthHostsEnv), ",") {
+		if h = strings.TrimSpace(h); h != "" && h == host {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	creds := &Credentials{
+		Host:     host,
+		Token:    os.Getenv(AuthTokenEnv),
+		Username: os.Getenv(AuthUsernameEnv),
+		Password: os.Getenv(AuthPasswordEnv),
+	}
+	for env, content := range map[string]*[]byte{
+		AuthClientCertEnv: &creds.ClientCert,
+		AuthClientKeyEnv:  &creds.ClientKey,
+		AuthCABundleEnv:   &creds.CABundle,
+	} {
+		if file := os.Getenv(env); file != "" {
+			var err error
+			if *content, err = ioutil.ReadFile(file); err != nil {
+				return nil, fmt.Errorf("couldn't read %v: %v", env, err)
+			}
+		}
+	}
+	if creds.Token == "" && creds.Username == "" && creds.ClientCert == nil && creds.CABundle == nil {
+		return nil, nil
+	}
+	return creds, nil
+}
+
+// netrcCredentials returns the login of host in the netrc file of the user, if any.
+func netrcCredentials(host string) (*Credentials, error) {
+	file := os.Getenv(netrcEnv)
+	if file == "" {
+		file = filepath.Join(homedir.HomeDir(), ".netrc")
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	machine := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		machine = h
+	}
+
+	// A netrc file is a sequence of tokens: machine <name> or default, followed by login and password.
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Split(bufio.ScanWords)
+	var tokens []string
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+	var matched, fallback *Credentials
+	var current *Credentials
+	for i := 0; i < len(tokens); i++ {
+		next := ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+		switch tokens[i] {
+		case "machine":
+			current = nil
+			if next == machine || next == host {
+				current = &Credentials{Host: host}
+				if matched == nil {
+					matched = current
+				}
+			}
+			i++
+		case "default":
+			current = &Credentials{Host: host}
+			if fallback == nil {
+				fallback = current
+			}
+		case "login":
+			if current != nil {
+				current.Username = next
+			}
+			i++
+		case "password":
+			if current != nil {
+				current.Password = next
+			}
+			i++
+		case "account", "macdef":
+			i++
+		}
+	}
+	if matched != nil {
+		return matched, nil
+	}
+	return fallback, nil
+}
+
+// authorization returns the Authorization header the credentials send, if any.
+func (c *Credentials) authorization() string {
+	switch {
+	case c == nil:
+		return ""
+	case c.Token != "":
+		return "Bearer " + c.Token
+	case c.Username != "":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+	}
+	return ""
+}
+
+// tlsConfig returns the TLS configuration presenting the client certificate of the credentials and verifying
+// the server against their CA bundle, or nil if they set neither.
+func (c *Credentials) tlsConfig() (*tls.Config, error) {
+	if c == nil || c.ClientCert == nil && c.CABundle == nil {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if c.ClientCert != nil {
+		cert, err := tls.X509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if c.CABundle != nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(c.CABundle) {
+			return nil, fmt.Errorf("the CA bundle has no PEM certificate")
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// HTTPClient returns a client downloading with the credentials, which may be nil.
+func (c *Credentials) HTTPClient() (*http.Client, error) {
+	client := newDownloadClient()
+	config, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	client.Transport.(*http.Transport).TLSClientConfig = config
+	return client, nil
+}
+
+// Download returns the content of uri downloaded with the credentials, which may be nil.
+func Download(ctx context.Context, uri string, creds *Credentials) ([]byte, error) {
+	client, err := creds.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return download(ctx, client, uri, creds)
+}
+
+// gitEnv returns the environment making git authenticate with the credentials, writing the files git needs to
+// dir. Configuring git through the environment keeps the credentials out of its command line; it requires
+// git 2.31 or later.
+func (c *Credentials) gitEnv(dir string) ([]string, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var config []string
+	if authorization := c.authorization(); authorization != "" {
+		config = append(config, "http.extraHeader", "Authorization: "+authorization)
+	}
+	for _, file := range []struct {
+		key     string
+		name    string
+		content []byte
+	}{
+		{key: "http.sslCert", name: "tls.crt", content: c.ClientCert},
+		{key: "http.sslKey", name: "tls.key", content: c.ClientKey},
+		{key: "http.sslCAInfo", name: "ca.crt", content: c.CABundle},
+	} {
+		if file.content == nil {
+			continue
+		}
+		p := filepath.Join(dir, file.name)
+		if err := ioutil.WriteFile(p, file.content, 0600); err != nil {
+			return nil, err
+		}
+		config = append(config, file.key, p)
+	}
+	env := []string{fmt.Sprintf("GIT_CONFIG_COUNT=%v", len(config)/2)}
+	for i := 0; i < len(config); i += 2 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%v=%v", i/2, config[i]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%v=%v", i/2, config[i+1]))
+	}
+	return env, nil
+}
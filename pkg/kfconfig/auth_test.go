@@ -0,0 +1,249 @@
+package kfconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// setEnv sets the environment variables of env for the duration of a test, unsetting those with empty values.
+func setEnv(t *testing.T, env map[string]string) func() {
+	previous := map[string]*string{}
+	for key, value := range env {
+		if old, ok := os.LookupEnv(key); ok {
+			previous[key] = &old
+		} else {
+			previous[key] = nil
+		}
+		if value == "" {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, value)
+		}
+	}
+	return func() {
+		for key, value := range previous {
+			if value == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *value)
+			}
+		}
+	}
+}
+
+func TestReadCredentials(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-credentials")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	netrc := path.Join(testDir, "netrc")
+	ioutil.WriteFile(netrc, []byte("machine gitlab.example.com\n  login netrc-user\n  password netrc-pass\n"+
+		"default login anonymous password guest\n"), 0600)
+
+	defer func(read func(string, string) (map[string][]byte, error)) { readCredentialsSecret = read }(readCredentialsSecret)
+	readCredentialsSecret = func(namespace string, name string) (map[string][]byte, error) {
+		if namespace != "kubeflow" || name != "gitlab" {
+			t.Fatalf("Unexpected Secret %v/%v", namespace, name)
+		}
+		return map[string][]byte{"token": []byte("secret-token"), "ca.crt": []byte("ca")}, nil
+	}
+
+	type testCase struct {
+		Name     string
+		Env      map[string]string
+		Secret   string
+		URI      string
+		Expected *Credentials
+		Err      bool
+	}
+	cases := []testCase{
+		{
+			Name:     "secret",
+			Secret:   "gitlab",
+			URI:      "https://gitlab.example.com/manifests.tar.gz",
+			Expected: &Credentials{Host: "gitlab.example.com", Token: "secret-token", CABundle: []byte("ca")},
+		},
+		{
+			Name:     "environment",
+			Env:      map[string]string{AuthTokenEnv: "env-token", AuthHostsEnv: "github.com, gitlab.example.com"},
+			URI:      "https://gitlab.example.com/manifests.tar.gz",
+			Expected: &Credentials{Host: "gitlab.example.com", Token: "env-token"},
+		},
+		{
+			Name:     "environment-other-host",
+			Env:      map[string]string{AuthTokenEnv: "env-token", AuthHostsEnv: "github.com"},
+			URI:      "https://gitlab.example.com:8443/manifests.tar.gz",
+			Expected: &Credentials{Host: "gitlab.example.com:8443", Username: "netrc-user", Password: "netrc-pass"},
+		},
+		{
+			Name:     "environment-no-hosts",
+			Env:      map[string]string{AuthTokenEnv: "env-token"},
+			URI:      "https://other.example.com/manifests.tar.gz",
+			Expected: &Credentials{Host: "other.example.com", Username: "anonymous", Password: "guest"},
+		},
+		{
+			Name:     "netrc-default",
+			URI:      "https://other.example.com/manifests.tar.gz",
+			Expected: &Credentials{Host: "other.example.com", Username: "anonymous", Password: "guest"},
+		},
+		{
+			Name: "not-http",
+			URI:  "/tmp/manifests.tar.gz",
+		},
+		{
+			Name:   "secret-not-http",
+			Secret: "gitlab",
+			URI:    "/tmp/manifests.tar.gz",
+			Err:    true,
+		},
+	}
+	for _, c := range cases {
+		env := map[string]string{AuthTokenEnv: "", AuthHostsEnv: "", netrcEnv: netrc}
+		for key, value := range c.Env {
+			env[key] = value
+		}
+		restore := setEnv(t, env)
+		config := &KfConfig{}
+		config.Namespace = "kubeflow"
+		var secret *v1.LocalObjectReference
+		if c.Secret != "" {
+			secret = &v1.LocalObjectReference{Name: c.Secret}
+		}
+		actual, err := ReadCredentials(config.Namespace, secret, c.URI)
+		restore()
+		if c.Err {
+			if err == nil {
+				t.Errorf("Case %v; expected an error got none", c.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case %v; unexpected error: %v", c.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, c.Expected) {
+			t.Errorf("Case %v; got %+v; want %+v", c.Name, actual, c.Expected)
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate and key for host, PEM-encoded.
+func testCertificate(t *testing.T, host string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key; %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate; %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key; %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestSyncCacheCredentials(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-sync-credentials")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	clientCert, clientKey := testCertificate(t, "kfctl")
+	clientPool := x509.NewCertPool()
+	clientPool.AppendCertsFromPEM(clientCert)
+	archive := testArchive("private")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(archive)
+	}))
+	server.TLS = &tls.Config{ClientCAs: clientPool, ClientAuth: tls.RequireAndVerifyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	defer func(read func(string, string) (map[string][]byte, error)) { readCredentialsSecret = read }(readCredentialsSecret)
+	secrets := map[string]map[string][]byte{
+		"complete": {"token": []byte("t0ken"), "tls.crt": clientCert, "tls.key": clientKey, "ca.crt": serverCA},
+		"no-token": {"tls.crt": clientCert, "tls.key": clientKey, "ca.crt": serverCA},
+		"no-cert":  {"token": []byte("t0ken"), "ca.crt": serverCA},
+		"no-ca":    {"token": []byte("t0ken"), "tls.crt": clientCert, "tls.key": clientKey},
+	}
+	readCredentialsSecret = func(namespace string, name string) (map[string][]byte, error) {
+		return secrets[name], nil
+	}
+	restore := setEnv(t, map[string]string{AuthTokenEnv: "", AuthHostsEnv: "", netrcEnv: path.Join(testDir, "missing")})
+	defer restore()
+
+	for _, secret := range []string{"complete", "no-token", "no-cert", "no-ca"} {
+		config := &KfConfig{
+			Spec: KfConfigSpec{
+				AppDir: path.Join(testDir, secret),
+				Repos: []Repo{{
+					Name:              "manifests",
+					URI:               server.URL + "/manifests.tar.gz",
+					CredentialsSecret: &v1.LocalObjectReference{Name: secret},
+				}},
+			},
+		}
+		err := config.SyncCache()
+		if secret != "complete" {
+			if err == nil {
+				t.Errorf("Secret %v; expected an error got none", secret)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Secret %v; unexpected error: %v", secret, err)
+			continue
+		}
+		content, err := ioutil.ReadFile(path.Join(testDir, secret, DefaultCacheDir, "manifests", "kustomize", "version"))
+		if err != nil || string(content) != "private" {
+			t.Errorf("Secret %v; got content %q (%v); want %q", secret, content, err, "private")
+		}
+	}
+
+	// The credentials aren't sent to other hosts.
+	creds := &Credentials{Host: "other.example.com", Token: "t0ken", ClientCert: clientCert, ClientKey: clientKey,
+		CABundle: serverCA}
+	if _, err := Download(context.Background(), server.URL, creds); err == nil {
+		t.Errorf("Credentials of %v sent to %v", creds.Host, host)
+	}
+}
@@ -0,0 +1,601 @@
+package kfconfig
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BundleManifestFile is the file of a bundle listing the repos and images it holds.
+	BundleManifestFile = "bundle.json"
+	// BundleKfDefFile is the file of a bundle holding its KfDef.
+	BundleKfDefFile = "kfdef.yaml"
+	// BundleVersion is the version of the format of the bundles kfctl creates and loads.
+	BundleVersion = "v1"
+	// BundleImagesDir is the directory of a bundle holding its images as an OCI image layout.
+	BundleImagesDir = "images"
+
+	bundleReposDir              = "repos"
+	imageManifestAccept         = ociManifestMediaType + ", " + dockerManifestMediaType + ", " + ociIndexMediaType + ", " + dockerManifestListMediaType
+	ociIndexMediaType           = "application/vnd.oci.image.index.v1+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociRefNameAnnotation        = "org.opencontainers.image.ref.name"
+	ociLayoutFile               = "oci-layout"
+	ociLayoutVersion            = "1.0.0"
+	ociIndexFile                = "index.json"
+	dockerHubRegistry           = "docker.io"
+	dockerHubAPIRegistry        = "registry-1.docker.io"
+	dockerHubCredentialsHost    = "index.docker.io"
+	bundledImageOS              = "linux"
+	bundledImageArchitecture    = "amd64"
+)
+
+// BundleManifest lists the contents of a bundle: a tar archive holding a KfDef, the repos it was built from and
+// optionally the images its manifests refer to, so that it can be built and applied without network access.
+type BundleManifest struct {
+	Version string        `json:"version"`
+	Repos   []BundleRepo  `json:"repos,omitempty"`
+	Images  []BundleImage `json:"images,omitempty"`
+}
+
+// BundleRepo is a repo of a bundle.
+type BundleRepo struct {
+	Name string `json:"name"`
+	// Archive is the path of the tar.gz archive of the repo in the bundle.
+	Archive string `json:"archive"`
+	// Digest is the sha256 digest of Archive.
+	Digest string `json:"digest"`
+	// Source is the URI, or the git URL, the repo was synced from.
+	Source string `json:"source,omitempty"`
+	// Revision is the commit or the digest the repo was synced at, if it was recorded.
+	Revision string `json:"revision,omitempty"`
+}
+
+// BundleImage is an image of a bundle.
+type BundleImage struct {
+	// Reference is the image as the manifests refer to it.
+	Reference string `json:"reference"`
+	// Image is the image that was pulled: Reference rewritten by the image overrides of the KfDef. It's the name
+	// of the image in the OCI image layout of the bundle.
+	Image string `json:"image"`
+	// Digest is the digest of the manifest of the image.
+	Digest string `json:"digest"`
+}
+
+// ociIndex is an OCI image index, or a docker manifest list.
+type ociIndex struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType,omitempty"`
+	Manifests     []ociIndexDescriptor `json:"manifests"`
+}
+
+type ociIndexDescriptor struct {
+	ociDescriptor
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociPlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+}
+
+// CreateBundle writes a bundle of the KfDef kfdef, built as c, to out. c must have synced its repos: the
+// directory each was synced to is archived. images maps the images the manifests refer to to the images
+// they're rewritten to; those are pulled into the bundle, for linux/amd64 when they're multi-platform.
+func (c *KfConfig) CreateBundle(ctx context.Context, out io.Writer, kfdef []byte, images map[string]string) (*BundleManifest, error) {
+	stage, err := ioutil.TempDir("", "kfctl-bundle")
+	if err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't create a directory to stage the bundle: %v", err),
+		}
+	}
+	defer os.RemoveAll(stage)
+
+	manifest := &BundleManifest{Version: BundleVersion}
+	if err := ioutil.WriteFile(filepath.Join(stage, BundleKfDefFile), kfdef, 0644); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't write the KfDef of the bundle: %v", err),
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(stage, bundleReposDir), os.ModePerm); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't create the repos directory of the bundle: %v", err),
+		}
+	}
+	for _, r := range c.Spec.Repos {
+		repo, err := c.bundleRepo(r, stage)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Repos = append(manifest.Repos, *repo)
+	}
+
+	if len(images) > 0 {
+		layout := filepath.Join(stage, BundleImagesDir)
+		if manifest.Images, err = c.bundleImages(ctx, images, layout); err != nil {
+			return nil, err
+		}
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't marshal the manifest of the bundle: %v", err),
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(stage, BundleManifestFile), manifestBytes, 0644); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't write the manifest of the bundle: %v", err),
+		}
+	}
+	tw := tar.NewWriter(out)
+	if err := writeTarDir(tw, stage, ""); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't write the bundle: %v", err),
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't write the bundle: %v", err),
+		}
+	}
+	return manifest, nil
+}
+
+// bundleRepo archives the directory r was synced to into the repos directory of the bundle staged in stage.
+// The archive unpacks to a single directory named after r, so that it's synced to the same layout.
+func (c *KfConfig) bundleRepo(r Repo, stage string) (*BundleRepo, error) {
+	cache, ok := c.getCache(r.Name)
+	if !ok || cache.LocalPath == "" {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("repo %v isn't synced; it must be synced before it's bundled", r.Name),
+		}
+	}
+	// The directory is a link into the shared cache when it was populated from it.
+	dir, err := filepath.EvalSymlinks(cache.LocalPath)
+	if err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't read the cache of repo %v: %v", r.Name, err),
+		}
+	}
+	archive := filepath.ToSlash(filepath.Join(bundleReposDir, r.Name+".tar.gz"))
+	log.Infof("Bundling repo %v from %v", r.Name, dir)
+	digest, err := writeRepoArchive(dir, r.Name, filepath.Join(stage, archive))
+	if err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't archive repo %v: %v", r.Name, err),
+		}
+	}
+	repo := &BundleRepo{
+		Name:     r.Name,
+		Archive:  archive,
+		Digest:   digest,
+		Source:   r.URI,
+		Revision: cache.Commit,
+	}
+	if r.Git != nil {
+		repo.Source = r.Git.URL
+	}
+	if repo.Revision == "" {
+		repo.Revision = cache.Digest
+	}
+	return repo, nil
+}
+
+// writeRepoArchive writes dir as a tar.gz archive to file, under the directory name, and returns its digest.
+// The archive only depends on the content of dir, so a repo bundled twice has the same digest.
+func writeRepoArchive(dir string, name string, file string) (string, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	gw := gzip.NewWriter(io.MultiWriter(f, hash))
+	tw := tar.NewWriter(gw)
+	if err := writeTarDir(tw, dir, name); err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gw.Close(); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return sha256DigestPrefix + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeTarDir writes the files of dir to tw under prefix, in lexical order and without their times and owners.
+// Git metadata is left out.
+func writeTarDir(tw *tar.Writer, dir string, prefix string) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		name := filepath.ToSlash(filepath.Join(prefix, rel))
+		if name == "." {
+			return nil
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.ModTime = time.Unix(0, 0)
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		header.Format = tar.FormatPAX
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// bundleImages pulls the images images are rewritten to into the OCI image layout layout and returns them.
+func (c *KfConfig) bundleImages(ctx context.Context, images map[string]string, layout string) ([]BundleImage, error) {
+	if err := os.MkdirAll(filepath.Join(layout, "blobs", "sha256"), os.ModePerm); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't create the images directory of the bundle: %v", err),
+		}
+	}
+	references := []string{}
+	for reference := range images {
+		references = append(references, reference)
+	}
+	sort.Strings(references)
+
+	index := &ociIndex{SchemaVersion: 2}
+	digests := map[string]string{}
+	bundled := []BundleImage{}
+	for _, reference := range references {
+		image := images[reference]
+		if _, ok := digests[image]; !ok {
+			log.Infof("Bundling image %v", image)
+			descriptor, err := c.pullImage(ctx, image, layout)
+			if err != nil {
+				return nil, &kfapis.KfError{
+					Code:    int(kfapis.INVALID_ARGUMENT),
+					Message: fmt.Sprintf("couldn't pull image %v: %v", image, err),
+				}
+			}
+			digests[image] = descriptor.Digest
+			index.Manifests = append(index.Manifests, ociIndexDescriptor{
+				ociDescriptor: *descriptor,
+				Annotations:   map[string]string{ociRefNameAnnotation: image},
+			})
+		}
+		bundled = append(bundled, BundleImage{Reference: reference, Image: image, Digest: digests[image]})
+	}
+
+	indexBytes, err := json.Marshal(index)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(layout, ociIndexFile), indexBytes, 0644)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(layout, ociLayoutFile),
+			[]byte(fmt.Sprintf(`{"imageLayoutVersion":"%v"}`, ociLayoutVersion)), 0644)
+	}
+	if err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't write the image layout of the bundle: %v", err),
+		}
+	}
+	return bundled, nil
+}
+
+// parseImageReference parses image, e.g. gcr.io/kubeflow/notebook:v1 or busybox@sha256:<digest>, as docker
+// does: an image without a registry is on Docker Hub and one without a tag is at latest.
+func parseImageReference(image string) (*ociReference, error) {
+	registry, rest := dockerHubRegistry, image
+	if slash := strings.Index(image, "/"); slash > 0 {
+		if first := image[:slash]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			registry, rest = first, image[slash+1:]
+		}
+	}
+	name, digest := rest, ""
+	if at := strings.Index(rest, "@"); at >= 0 {
+		name, digest = rest[:at], rest[at:]
+	}
+	if registry == dockerHubRegistry {
+		registry = dockerHubAPIRegistry
+		if !strings.Contains(name, "/") {
+			name = "library/" + name
+		}
+	}
+	// Images pinned by digest alone have no tag.
+	if strings.LastIndex(name, ":") <= strings.LastIndex(name, "/") {
+		name += ":" + defaultOCITag
+	}
+	rest = name + digest
+	return parseOCIReference(OCIScheme + registry + "/" + rest)
+}
+
+// imageRepository returns the repository of image in its registry, e.g. library/busybox for busybox.
+func imageRepository(image string) (string, error) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+	return ref.repository, nil
+}
+
+// Mirror returns the image pushed to registry, by the digest it was bundled at.
+func (i BundleImage) Mirror(registry string) (string, error) {
+	repository, err := imageRepository(i.Image)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(registry, "/") + "/" + repository + "@" + i.Digest, nil
+}
+
+// imageClient returns a client of the registry of the image ref, authenticated with the docker credentials of
+// that registry if there are any.
+func (c *KfConfig) imageClient(ref *ociReference) (*registryClient, error) {
+	client := &registryClient{client: &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}}
+	credentialsHost := ref.registry
+	if credentialsHost == dockerHubAPIRegistry {
+		credentialsHost = dockerHubCredentialsHost
+	}
+	var err error
+	if client.username, client.password, err = c.registryCredentials(Repo{}, credentialsHost); err != nil {
+		return nil, fmt.Errorf("couldn't read the credentials of registry %v: %v", ref.registry, err)
+	}
+	return client, nil
+}
+
+// pullImage pulls image, for linux/amd64 if it's multi-platform, into the OCI image layout layout and returns
+// the descriptor of its manifest. Blobs the layout already has aren't pulled again.
+func (c *KfConfig) pullImage(ctx context.Context, image string, layout string) (*ociDescriptor, error) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return nil, err
+	}
+	client, err := c.imageClient(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	accept := imageManifestAccept
+	body, header, err := client.get(ctx, ref.baseURL()+"/manifests/"+ref.manifestReference(), accept)
+	if err != nil {
+		return nil, err
+	}
+	if ref.digest != "" && sha256Digest(body) != ref.digest {
+		return nil, fmt.Errorf("manifest has digest %v; want %v", sha256Digest(body), ref.digest)
+	}
+	mediaType := manifestMediaType(body, header)
+	if mediaType == ociIndexMediaType || mediaType == dockerManifestListMediaType {
+		index := &ociIndex{}
+		if err := json.Unmarshal(body, index); err != nil {
+			return nil, fmt.Errorf("couldn't parse manifest list: %v", err)
+		}
+		digest := ""
+		for _, m := range index.Manifests {
+			if m.Platform != nil && m.Platform.OS == bundledImageOS && m.Platform.Architecture == bundledImageArchitecture {
+				digest = m.Digest
+				break
+			}
+		}
+		if digest == "" {
+			return nil, fmt.Errorf("image has no manifest for %v/%v", bundledImageOS, bundledImageArchitecture)
+		}
+		if body, header, err = client.get(ctx, ref.baseURL()+"/manifests/"+digest, accept); err != nil {
+			return nil, err
+		}
+		if sha256Digest(body) != digest {
+			return nil, fmt.Errorf("manifest has digest %v; want %v", sha256Digest(body), digest)
+		}
+		mediaType = manifestMediaType(body, header)
+	}
+	if mediaType != ociManifestMediaType && mediaType != dockerManifestMediaType {
+		return nil, fmt.Errorf("manifest has unsupported media type %v", mediaType)
+	}
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(body, manifest); err != nil {
+		return nil, fmt.Errorf("couldn't parse manifest: %v", err)
+	}
+
+	for _, blob := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+		if err := client.pullBlob(ctx, ref, blob.Digest, layout); err != nil {
+			return nil, err
+		}
+	}
+	descriptor := &ociDescriptor{MediaType: mediaType, Digest: sha256Digest(body), Size: int64(len(body))}
+	if err := ioutil.WriteFile(ociBlobPath(layout, descriptor.Digest), body, 0644); err != nil {
+		return nil, err
+	}
+	return descriptor, nil
+}
+
+// pullBlob pulls the blob digest of ref into the OCI image layout layout unless it's already there.
+func (rc *registryClient) pullBlob(ctx context.Context, ref *ociReference, digest string, layout string) error {
+	if !strings.HasPrefix(digest, sha256DigestPrefix) {
+		return fmt.Errorf("blob %v isn't a %v digest", digest, strings.TrimSuffix(sha256DigestPrefix, ":"))
+	}
+	file := ociBlobPath(layout, digest)
+	if _, err := os.Stat(file); err == nil {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), "blob")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := rc.fetchBlob(ctx, ref, digest, f, nil); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+// ociBlobPath returns the path of the blob digest in the OCI image layout layout.
+func ociBlobPath(layout string, digest string) string {
+	return filepath.Join(layout, "blobs", "sha256", strings.TrimPrefix(digest, sha256DigestPrefix))
+}
+
+// manifestMediaType returns the media type of the manifest body, as it declares it or else as the registry
+// serves it.
+func manifestMediaType(body []byte, header http.Header) string {
+	var typed struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(body, &typed); err == nil && typed.MediaType != "" {
+		return typed.MediaType
+	}
+	return strings.TrimSpace(strings.SplitN(header.Get("Content-Type"), ";", 2)[0])
+}
+
+// LoadBundle unpacks the bundle r reads into dir and returns its manifest.
+func LoadBundle(r io.Reader, dir string) (*BundleManifest, error) {
+	// Bundles can hold many images, so they aren't bounded by the size limit of repo archives.
+	if err := extractArchiveReaderLimit(r, dir, 0); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't unpack the bundle: %v", err),
+		}
+	}
+	return ReadBundleManifest(dir)
+}
+
+// ReadBundleManifest reads the manifest of the bundle unpacked in dir.
+func ReadBundleManifest(dir string) (*BundleManifest, error) {
+	manifestBytes, err := ioutil.ReadFile(filepath.Join(dir, BundleManifestFile))
+	if err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't read the manifest of the bundle: %v", err),
+		}
+	}
+	manifest := &BundleManifest{}
+	if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't parse the manifest of the bundle: %v", err),
+		}
+	}
+	if manifest.Version != BundleVersion {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("bundle has version %q; kfctl only loads bundles of version %v", manifest.Version, BundleVersion),
+		}
+	}
+	return manifest, nil
+}
+
+// Apply points the repos of kfdef at their archives in the bundle unpacked in dir, pinned by their digests.
+// When registry is set, the images of the bundle are rewritten to the images pushed there by digest.
+func (m *BundleManifest) Apply(kfdef *kfdefv1.KfDef, dir string, registry string) error {
+	bundled := map[string]BundleRepo{}
+	for _, repo := range m.Repos {
+		bundled[repo.Name] = repo
+	}
+	for i := range kfdef.Spec.Repos {
+		r := &kfdef.Spec.Repos[i]
+		repo, ok := bundled[r.Name]
+		if !ok {
+			return &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("repo %v isn't in the bundle", r.Name),
+			}
+		}
+		if repo.Archive == "" || escapesRoot(repo.Archive) {
+			return &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("archive %v of repo %v must be a path inside the bundle", repo.Archive, r.Name),
+			}
+		}
+		archive, err := filepath.Abs(filepath.Join(dir, filepath.FromSlash(repo.Archive)))
+		if err != nil {
+			return &kfapis.KfError{
+				Code:    int(kfapis.INTERNAL_ERROR),
+				Message: fmt.Sprintf("couldn't resolve the archive of repo %v: %v", r.Name, err),
+			}
+		}
+		r.URI = "file:" + filepath.ToSlash(archive)
+		r.SHA256 = strings.TrimPrefix(repo.Digest, sha256DigestPrefix)
+		r.Git, r.Signature, r.PullSecret, r.CredentialsSecret = nil, nil, nil, nil
+	}
+
+	if registry == "" || len(m.Images) == 0 {
+		return nil
+	}
+	if kfdef.Spec.ImageOverrides == nil {
+		kfdef.Spec.ImageOverrides = &kfdefv1.ImageOverrides{}
+	}
+	if kfdef.Spec.ImageOverrides.Images == nil {
+		kfdef.Spec.ImageOverrides.Images = map[string]string{}
+	}
+	for _, image := range m.Images {
+		mirror, err := image.Mirror(registry)
+		if err != nil {
+			return &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("invalid image %v of the bundle: %v", image.Image, err),
+			}
+		}
+		kfdef.Spec.ImageOverrides.Images[image.Reference] = mirror
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package kfconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	cases := []struct {
+		Image    string
+		Expected *ociReference
+	}{
+		{
+			Image:    "busybox",
+			Expected: &ociReference{registry: dockerHubAPIRegistry, repository: "library/busybox", tag: "latest"},
+		},
+		{
+			Image:    "kubeflow/notebook:v1",
+			Expected: &ociReference{registry: dockerHubAPIRegistry, repository: "kubeflow/notebook", tag: "v1"},
+		},
+		{
+			Image:    "gcr.io/kubeflow-images-public/notebook:v1",
+			Expected: &ociReference{registry: "gcr.io", repository: "kubeflow-images-public/notebook", tag: "v1"},
+		},
+		{
+			Image:    "localhost:5000/notebook@" + digest,
+			Expected: &ociReference{registry: "localhost:5000", repository: "notebook", tag: "latest", digest: digest},
+		},
+	}
+	for _, c := range cases {
+		actual, err := parseImageReference(c.Image)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", c.Image, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, c.Expected) {
+			t.Errorf("%v: got %+v; want %+v", c.Image, actual, c.Expected)
+		}
+	}
+}
+
+// pushImage publishes a linux/amd64 image as tag of registry, behind an image index, and returns the digest of
+// its manifest.
+func (r *fakeRegistry) pushImage(t *testing.T, tag string) string {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layer := testArchive(tag)
+	r.blobs[sha256Digest(config)] = config
+	r.blobs[sha256Digest(layer)] = layer
+	manifest, err := json.Marshal(ociManifest{
+		MediaType: ociManifestMediaType,
+		Config:    ociDescriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: sha256Digest(config), Size: int64(len(config))},
+		Layers:    []ociDescriptor{{MediaType: ociLayerMediaType, Digest: sha256Digest(layer), Size: int64(len(layer))}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest; %v", err)
+	}
+	digest := sha256Digest(manifest)
+	r.manifests[digest] = manifest
+	index, err := json.Marshal(ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociIndexMediaType,
+		Manifests: []ociIndexDescriptor{{
+			ociDescriptor: ociDescriptor{MediaType: ociManifestMediaType, Digest: digest, Size: int64(len(manifest))},
+			Platform:      &ociPlatform{OS: "linux", Architecture: "amd64"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal index; %v", err)
+	}
+	r.manifests[tag] = index
+	return digest
+}
+
+func TestBundle(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-bundle")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(testArchive(path.Base(req.URL.Path)))
+	}))
+	defer server.Close()
+	registry := newFakeRegistry()
+	defer registry.server.Close()
+	host := strings.TrimPrefix(registry.server.URL, "http://")
+	dockerConfig := fmt.Sprintf(`{"auths": {"%v": {"auth": "a2ZjdGw6c2VjcmV0"}}}`, host)
+	if err := ioutil.WriteFile(path.Join(testDir, "config.json"), []byte(dockerConfig), 0600); err != nil {
+		t.Fatalf("Failed to write docker config; %v", err)
+	}
+	defer os.Setenv(dockerConfigDirEnv, os.Getenv(dockerConfigDirEnv))
+	os.Setenv(dockerConfigDirEnv, testDir)
+
+	imageDigest := registry.pushImage(t, "v1")
+	image := host + "/manifests:v1"
+	config := &KfConfig{
+		Spec: KfConfigSpec{
+			AppDir: path.Join(testDir, "app"),
+			Repos:  []Repo{{Name: "manifests", URI: server.URL + "/v1"}},
+		},
+	}
+	if err := config.SyncCache(); err != nil {
+		t.Fatalf("Could not sync cache; %v", err)
+	}
+
+	var bundle bytes.Buffer
+	created, err := config.CreateBundle(context.Background(), &bundle, []byte("kind: KfDef\n"), map[string]string{"notebook:v1": image})
+	if err != nil {
+		t.Fatalf("Could not create bundle; %v", err)
+	}
+	if len(created.Repos) != 1 || created.Repos[0].Archive != "repos/manifests.tar.gz" || created.Repos[0].Source != server.URL+"/v1" {
+		t.Errorf("Repos: got %+v; want manifests", created.Repos)
+	}
+	expectedImages := []BundleImage{{Reference: "notebook:v1", Image: image, Digest: imageDigest}}
+	if !reflect.DeepEqual(created.Images, expectedImages) {
+		t.Errorf("Images: got %+v; want %+v", created.Images, expectedImages)
+	}
+	// Bundling the same repo again gives the same archive.
+	again, err := config.CreateBundle(context.Background(), ioutil.Discard, []byte("kind: KfDef\n"), nil)
+	if err != nil {
+		t.Fatalf("Could not create bundle again; %v", err)
+	}
+	if again.Repos[0].Digest != created.Repos[0].Digest {
+		t.Errorf("Digest: got %v bundling again; want %v", again.Repos[0].Digest, created.Repos[0].Digest)
+	}
+
+	bundleDir := path.Join(testDir, "bundle")
+	loaded, err := LoadBundle(&bundle, bundleDir)
+	if err != nil {
+		t.Fatalf("Could not load bundle; %v", err)
+	}
+	if !reflect.DeepEqual(loaded, created) {
+		t.Errorf("Manifest: got %+v; want %+v", loaded, created)
+	}
+	if _, err := os.Stat(ociBlobPath(path.Join(bundleDir, BundleImagesDir), imageDigest)); err != nil {
+		t.Errorf("Image manifest isn't in the bundle; %v", err)
+	}
+	index, err := ioutil.ReadFile(path.Join(bundleDir, BundleImagesDir, ociIndexFile))
+	if err != nil || !strings.Contains(string(index), `"`+ociRefNameAnnotation+`":"`+image+`"`) {
+		t.Errorf("Index: got %s (%v); want it to name %v", index, err, image)
+	}
+
+	kfdef := &kfdefv1.KfDef{
+		Spec: kfdefv1.KfDefSpec{
+			Repos: []kfdefv1.Repo{{Name: "manifests", URI: server.URL + "/v1", Git: &kfdefv1.GitSource{URL: "unused"}}},
+		},
+	}
+	if err := loaded.Apply(kfdef, bundleDir, "mirror.example.com/kubeflow"); err != nil {
+		t.Fatalf("Could not apply bundle; %v", err)
+	}
+	repo := kfdef.Spec.Repos[0]
+	expectedURI := "file:" + path.Join(bundleDir, "repos", "manifests.tar.gz")
+	if repo.URI != expectedURI || sha256DigestPrefix+repo.SHA256 != created.Repos[0].Digest || repo.Git != nil {
+		t.Errorf("Repo: got %+v; want %v at %v", repo, expectedURI, created.Repos[0].Digest)
+	}
+	expectedOverrides := map[string]string{"notebook:v1": "mirror.example.com/kubeflow/manifests@" + imageDigest}
+	if kfdef.Spec.ImageOverrides == nil || !reflect.DeepEqual(kfdef.Spec.ImageOverrides.Images, expectedOverrides) {
+		t.Errorf("ImageOverrides: got %+v; want %v", kfdef.Spec.ImageOverrides, expectedOverrides)
+	}
+
+	// The repos are synced from the bundle alone, to the same content as the directory they were bundled from.
+	server.Close()
+	offline := &KfConfig{
+		Spec: KfConfigSpec{
+			AppDir: path.Join(testDir, "offline"),
+			Repos:  []Repo{{Name: "manifests", URI: repo.URI, SHA256: repo.SHA256}},
+		},
+	}
+	if err := offline.SyncCache(); err != nil {
+		t.Fatalf("Could not sync cache from the bundle; %v", err)
+	}
+	cache := offline.Status.Caches[0]
+	content, err := ioutil.ReadFile(path.Join(cache.LocalPath, "version"))
+	if err != nil || string(content) != "v1" {
+		t.Errorf("Got content %q (%v); want v1", content, err)
+	}
+
+	missing := &kfdefv1.KfDef{Spec: kfdefv1.KfDefSpec{Repos: []kfdefv1.Repo{{Name: "other"}}}}
+	if err := loaded.Apply(missing, bundleDir, ""); err == nil {
+		t.Errorf("Expected an error applying a bundle without repo other")
+	}
+	for _, archive := range []string{"/etc/passwd", "../manifests.tar.gz", "repos/../../manifests.tar.gz"} {
+		escaping := &BundleManifest{Repos: []BundleRepo{{Name: "manifests", Archive: archive}}}
+		kfdef := &kfdefv1.KfDef{Spec: kfdefv1.KfDefSpec{Repos: []kfdefv1.Repo{{Name: "manifests"}}}}
+		if err := escaping.Apply(kfdef, bundleDir, ""); err == nil {
+			t.Errorf("Expected an error applying a bundle with archive %v", archive)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package kfconfig
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// SharedCacheDirEnv overrides the directory of the shared cache.
+	SharedCacheDirEnv = "KFCTL_CACHE_DIR"
+
+	sharedCacheSourcesDir = "sources"
+	sharedCacheStagingDir = "staging"
+	sharedCacheContentDir = "content"
+	sharedCacheSourceFile = "source"
+	// stagingMaxAge is the age past which a staging directory is assumed to be left over by an interrupted fetch.
+	stagingMaxAge = time.Hour
+)
+
+// SharedCache holds the sources of repos keyed by their digest, so that the AppDirs and KfDefs of a host using
+// the same sources share a single copy of them. The cache of a repo in an AppDir links to its entry.
+//
+// Entries are immutable once stored; using an entry marks it as used, which Prune relies on to evict entries.
+type SharedCache struct {
+	Dir string
+}
+
+// SharedCacheEntry describes an entry of the shared cache.
+type SharedCacheEntry struct {
+	// Key is the kind of the source followed by a hash of its scope and its digest, e.g. git-<scope>-<commit> or
+	// archive-<scope>-sha256-<hex>.
+	Key string
+	// Source is the URI the entry was fetched from.
+	Source   string
+	Size     int64
+	LastUsed time.Time
+}
+
+// DefaultSharedCacheDir returns the directory of the shared cache: $KFCTL_CACHE_DIR, or else kfctl in the
+// cache directory of the user, falling back to the temporary directory for users without a home.
+func DefaultSharedCacheDir() string {
+	if dir := os.Getenv(SharedCacheDirEnv); dir != "" {
+		return dir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "kfctl")
+	}
+	return filepath.Join(os.TempDir(), "kfctl-cache")
+}
+
+// NewSharedCache returns the shared cache in dir, or in the default directory if dir is empty.
+func NewSharedCache(dir string) *SharedCache {
+	if dir == "" {
+		dir = DefaultSharedCacheDir()
+	}
+	return &SharedCache{Dir: dir}
+}
+
+// sharedCacheKey returns the key of a source of kind with digest, fetched within scope. Entries are only shared
+// between fetches of the same scope; see cacheScope.
+func sharedCacheKey(kind string, scope string, digest string) string {
+	sum := sha256.Sum256([]byte(scope))
+	return kind + "-" + hex.EncodeToString(sum[:6]) + "-" + strings.Replace(digest, ":", "-", 1)
+}
+
+// cacheScope returns the scope of the entries of the shared cache r fetches from source: the source itself and,
+// for repos with credentials of their own, the Secrets they're read from. A KfDef naming a commit or digest only
+// reuses what was fetched from the same source with the same credentials, never what the KfDef of another
+// namespace fetched with its own.
+func (c *KfConfig) cacheScope(r Repo, source string) string {
+	scope := source
+	for _, secret := range []*v1.LocalObjectReference{r.CredentialsSecret, r.PullSecret} {
+		if secret != nil {
+			scope += "\n" + c.Namespace + "/" + secret.Name
+		}
+	}
+	return scope
+}
+
+func (s *SharedCache) entryDir(key string) string {
+	return filepath.Join(s.Dir, sharedCacheSourcesDir, key)
+}
+
+// Lookup returns the content of the entry key and marks it as used, if the cache has it.
+func (s *SharedCache) Lookup(key string) (string, bool) {
+	dir := s.entryDir(key)
+	content := filepath.Join(dir, sharedCacheContentDir)
+	if fi, err := os.Stat(content); err != nil || !fi.IsDir() {
+		return "", false
+	}
+	now := time.Now()
+	if err := os.Chtimes(dir, now, now); err != nil {
+		log.Warnf("Couldn't mark cache entry %v as used: %v", key, err)
+	}
+	return content, true
+}
+
+// Fill fetches source into the cache and returns its key and the content of its entry. fetch writes the content
+// to the directory it's given and returns its key; the entry is only stored once fetch succeeded, so a failed or
+// interrupted fetch leaves nothing behind. An entry already stored under the same key is kept.
+func (s *SharedCache) Fill(source string, fetch func(dir string) (string, error)) (string, string, error) {
+	stagingRoot := filepath.Join(s.Dir, sharedCacheStagingDir)
+	if err := os.MkdirAll(stagingRoot, os.ModePerm); err != nil {
+		return "", "", err
+	}
+	staging, err := ioutil.TempDir(stagingRoot, "")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(staging)
+
+	key, err := fetch(filepath.Join(staging, sharedCacheContentDir))
+	if err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(staging, sharedCacheSourceFile), []byte(source), 0644); err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(filepath.Join(s.Dir, sharedCacheSourcesDir), os.ModePerm); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(staging, s.entryDir(key)); err != nil {
+		if _, ok := s.Lookup(key); !ok {
+			return "", "", err
+		}
+		log.Infof("Cache entry %v already exists; using it", key)
+	}
+	content, ok := s.Lookup(key)
+	if !ok {
+		return "", "", fmt.Errorf("cache entry %v disappeared once stored", key)
+	}
+	return key, content, nil
+}
+
+// List returns the entries of the cache, least recently used first.
+func (s *SharedCache) List() ([]SharedCacheEntry, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(s.Dir, sharedCacheSourcesDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []SharedCacheEntry
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		dir := s.entryDir(info.Name())
+		source, _ := ioutil.ReadFile(filepath.Join(dir, sharedCacheSourceFile))
+		size, err := dirSize(dir)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, SharedCacheEntry{
+			Key:      info.Name(),
+			Source:   string(source),
+			Size:     size,
+			LastUsed: info.ModTime(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.Before(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Prune removes the entries not used for longer than maxAge, then the least recently used entries until the
+// cache fits in maxSize bytes, and returns the removed entries. Zero limits are ignored. The entries of keep,
+// e.g. those LinkedEntries returns for the AppDirs being applied, are never removed. Staging directories left
+// over by interrupted fetches are removed as well.
+//
+// AppDirs linking to a removed entry fetch their repo again on their next sync.
+func (s *SharedCache) Prune(maxSize int64, maxAge time.Duration, keep map[string]bool) ([]SharedCacheEntry, error) {
+	if staging, err := ioutil.ReadDir(filepath.Join(s.Dir, sharedCacheStagingDir)); err == nil {
+		for _, info := range staging {
+			if time.Since(info.ModTime()) > stagingMaxAge {
+				os.RemoveAll(filepath.Join(s.Dir, sharedCacheStagingDir, info.Name()))
+			}
+		}
+	}
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	var removed []SharedCacheEntry
+	for _, e := range entries {
+		expired := maxAge > 0 && time.Since(e.LastUsed) > maxAge
+		oversized := maxSize > 0 && total > maxSize
+		if keep[e.Key] || (!expired && !oversized) {
+			continue
+		}
+		if err := os.RemoveAll(s.entryDir(e.Key)); err != nil {
+			return removed, err
+		}
+		total -= e.Size
+		removed = append(removed, e)
+	}
+	return removed, nil
+}
+
+// LinkedEntries returns the keys of the entries the repo caches of appDirs link to.
+func (s *SharedCache) LinkedEntries(appDirs ...string) map[string]bool {
+	keys := map[string]bool{}
+	sources := filepath.Join(s.Dir, sharedCacheSourcesDir)
+	for _, appDir := range appDirs {
+		infos, err := ioutil.ReadDir(filepath.Join(appDir, DefaultCacheDir))
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			target, err := os.Readlink(filepath.Join(appDir, DefaultCacheDir, info.Name()))
+			if err != nil {
+				continue
+			}
+			if rel, err := filepath.Rel(sources, target); err == nil && !strings.HasPrefix(rel, "..") {
+				keys[strings.Split(filepath.ToSlash(rel), "/")[0]] = true
+			}
+		}
+	}
+	return keys
+}
+
+// Remove removes the entry key.
+func (s *SharedCache) Remove(key string) error {
+	return os.RemoveAll(s.entryDir(key))
+}
+
+// dirSize returns the total size of the files under dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// linkCache makes cacheDir, the cache of a repo in an AppDir, link to content, the entry of the shared cache.
+func linkCache(content string, cacheDir string) error {
+	if err := os.RemoveAll(cacheDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cacheDir), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Symlink(content, cacheDir)
+}
+
+// populateCache makes cacheDir link to the entry of the shared cache holding source: the entry key if the cache
+// has it, or else the one fetch fills. key is empty when it can't be known before fetching.
+func populateCache(source string, key string, cacheDir string, fetch func(dir string) (string, error)) error {
+	cache := NewSharedCache("")
+	if key != "" {
+		if content, ok := cache.Lookup(key); ok {
+			log.Infof("Using cache entry %v for %v", key, source)
+			return linkCache(content, cacheDir)
+		}
+	}
+	key, content, err := cache.Fill(source, fetch)
+	if err != nil {
+		if _, ok := err.(*kfapis.KfError); ok {
+			return err
+		}
+		return &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't store %v in the cache %v: %v", source, cache.Dir, err),
+		}
+	}
+	log.Infof("Stored %v as cache entry %v", source, key)
+	return linkCache(content, cacheDir)
+}
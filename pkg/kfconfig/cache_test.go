@@ -0,0 +1,174 @@
+package kfconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// TestMain points the shared cache at a temporary directory so tests don't share entries with the host, and
+// shortens the backoff of failed fetches.
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "kfctl-shared-cache")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create temp dir; %v\n", err)
+		os.Exit(1)
+	}
+	os.Setenv(SharedCacheDirEnv, dir)
+	repoRetryBackoff = 10 * time.Millisecond
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestSharedCache(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-shared-cache-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	cache := NewSharedCache(path.Join(testDir, "cache"))
+
+	fill := func(key string, content string) string {
+		_, dir, err := cache.Fill("source-"+key, func(dir string) (string, error) {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return "", err
+			}
+			return key, ioutil.WriteFile(path.Join(dir, "file"), []byte(content), 0644)
+		})
+		if err != nil {
+			t.Fatalf("Failed to fill %v; %v", key, err)
+		}
+		return dir
+	}
+	age := func(key string, d time.Duration) {
+		then := time.Now().Add(-d)
+		if err := os.Chtimes(cache.entryDir(key), then, then); err != nil {
+			t.Fatalf("Failed to age %v; %v", key, err)
+		}
+	}
+	keys := func(entries []SharedCacheEntry) []string {
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+		return keys
+	}
+
+	if _, ok := cache.Lookup("a"); ok {
+		t.Fatalf("Lookup of an empty cache: expected a miss")
+	}
+	dir := fill("a", "aaaa")
+	if content, ok := cache.Lookup("a"); !ok || content != dir {
+		t.Fatalf("Lookup: got %v, %v; want %v, true", content, ok, dir)
+	}
+
+	// A failed fetch stores nothing.
+	if _, _, err := cache.Fill("broken", func(dir string) (string, error) {
+		return "", fmt.Errorf("broken")
+	}); err == nil {
+		t.Fatalf("Fill: expected an error got none")
+	}
+
+	// An entry stored again under the same key keeps its content.
+	if again := fill("a", "other"); again != dir {
+		t.Fatalf("Fill again: got %v; want %v", again, dir)
+	}
+	if actual, _ := ioutil.ReadFile(path.Join(dir, "file")); string(actual) != "aaaa" {
+		t.Fatalf("Fill again: got content %q; want %q", actual, "aaaa")
+	}
+
+	fill("b", "bbbb")
+	fill("c", "cccc")
+	age("a", 3*time.Hour)
+	age("b", 2*time.Hour)
+	age("c", time.Hour)
+	entries, err := cache.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if !reflect.DeepEqual(keys(entries), []string{"a", "b", "c"}) {
+		t.Fatalf("List: got %v; want least recently used first", keys(entries))
+	}
+	if entries[0].Source != "source-a" || entries[0].Size < 4 {
+		t.Fatalf("List: got %+v", entries[0])
+	}
+
+	// Using an entry makes it the most recently used.
+	cache.Lookup("a")
+	removed, err := cache.Prune(0, 90*time.Minute, nil)
+	if err != nil {
+		t.Fatalf("Prune by age: %v", err)
+	}
+	if !reflect.DeepEqual(keys(removed), []string{"b"}) {
+		t.Fatalf("Prune by age: removed %v; want [b]", keys(removed))
+	}
+
+	removed, err = cache.Prune(entries[0].Size, 0, nil)
+	if err != nil {
+		t.Fatalf("Prune by size: %v", err)
+	}
+	if !reflect.DeepEqual(keys(removed), []string{"c"}) {
+		t.Fatalf("Prune by size: removed %v; want [c]", keys(removed))
+	}
+
+	// A repo links to its entry, and is fetched again once the entry is pruned.
+	cacheDir := filepath.Join(testDir, "app", DefaultCacheDir, "repo")
+	if err := linkCache(dir, cacheDir); err != nil {
+		t.Fatalf("linkCache: %v", err)
+	}
+	if actual, err := ioutil.ReadFile(path.Join(cacheDir, "file")); err != nil || string(actual) != "aaaa" {
+		t.Fatalf("linkCache: got content %q (%v); want %q", actual, err, "aaaa")
+	}
+	// The entries linked to by the AppDirs being applied are kept.
+	appDir := filepath.Join(testDir, "app")
+	if linked := cache.LinkedEntries(appDir, filepath.Join(testDir, "other")); !reflect.DeepEqual(linked, map[string]bool{"a": true}) {
+		t.Fatalf("LinkedEntries: got %v; want a", linked)
+	}
+	if removed, err := cache.Prune(1, 0, cache.LinkedEntries(appDir)); err != nil || len(removed) != 0 {
+		t.Fatalf("Prune of a linked entry: removed %v (%v); want none", keys(removed), err)
+	}
+	if _, err := cache.Prune(1, 0, nil); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Fatalf("Prune: expected the link to the entry to dangle; got %v", err)
+	}
+}
+
+func TestSharedCacheKeyScope(t *testing.T) {
+	commit := "8c8de581d89350a78957dd841b0ee0e84bc08985"
+	public := Repo{Name: "manifests", Git: &GitSource{URL: "https://example.com/manifests.git"}}
+	private := public
+	private.CredentialsSecret = &v1.LocalObjectReference{Name: "git-creds"}
+	inA := &KfConfig{}
+	inA.Namespace = "a"
+	inB := &KfConfig{}
+	inB.Namespace = "b"
+	key := func(c *KfConfig, r Repo, source string) string {
+		return sharedCacheKey("git", c.cacheScope(r, source), commit)
+	}
+
+	if key(inA, public, public.Git.URL) != key(inB, public, public.Git.URL) {
+		t.Errorf("Repos without credentials of their own should share entries across namespaces")
+	}
+	if key(inA, private, private.Git.URL) == key(inB, private, private.Git.URL) {
+		t.Errorf("Repos fetched with the credentials of different namespaces share entry %v", key(inA, private, private.Git.URL))
+	}
+	if key(inA, private, private.Git.URL) == key(inA, public, public.Git.URL) {
+		t.Errorf("Repos fetched with and without credentials share entry %v", key(inA, public, public.Git.URL))
+	}
+	if key(inA, public, public.Git.URL) == key(inA, public, "https://example.com/other.git") {
+		t.Errorf("Repos fetched from different sources share entry %v", key(inA, public, public.Git.URL))
+	}
+	if key(inA, private, private.Git.URL) != key(inA, private, private.Git.URL) {
+		t.Errorf("Keys of the same scope differ")
+	}
+}
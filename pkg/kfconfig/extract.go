@@ -0,0 +1,410 @@
+package kfconfig
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ulikunitz/xz"
+)
+
+// Limits on the archives repos are unpacked from, guarding against decompression bombs.
+var (
+	// maxArchiveSize bounds the total size of the files of an archive once unpacked.
+	maxArchiveSize int64 = 2 << 30
+	// maxArchiveEntries bounds the number of entries of an archive.
+	maxArchiveEntries = 100000
+)
+
+// maxSymlinkDepth bounds the symlinks followed to resolve a path, as the kernel does.
+const maxSymlinkDepth = 40
+
+// archiveEntry is an entry of a tar or zip archive.
+type archiveEntry struct {
+	name string
+	// mode holds the permissions and type of the entry; hardlinks are regular files with a linkname.
+	mode     os.FileMode
+	linkname string
+	hardlink bool
+	content  io.Reader
+}
+
+// extractor unpacks the entries of an archive into root, refusing any that would be written outside of it.
+type extractor struct {
+	root string
+	// maxSize bounds the total size of the files unpacked; it's unlimited if zero.
+	maxSize int64
+	entries int
+	size    int64
+	// dirs are the directories of the archive and their modes, applied once all entries are unpacked so that
+	// read-only directories can still be populated.
+	dirs map[string]os.FileMode
+	// symlinks are the symlinks unpacked, checked again once all entries are unpacked since later entries can
+	// change what they resolve to.
+	symlinks []archiveEntry
+}
+
+// extractArchive unpacks archive into dir; see extractArchiveReader.
+func extractArchive(archive []byte, dir string) error {
+	return extractArchiveReader(bytes.NewReader(archive), dir)
+}
+
+// extractArchiveReader unpacks the archive read from r into dir as it's read. The format of the archive, a gzip,
+// bzip2 or xz compressed or plain tar archive or a zip archive, is detected from its content. Zip archives are
+// read from their end, so unless r is a file they're spooled to a temporary file first.
+// Entries escaping dir, including through symlinks, are rejected; symlinks and hardlinks are recreated as long
+// as they point inside dir.
+func extractArchiveReader(r io.Reader, dir string) error {
+	return extractArchiveReaderLimit(r, dir, maxArchiveSize)
+}
+
+// extractArchiveReaderLimit is extractArchiveReader with the total size of the files unpacked bounded by
+// maxSize, or unlimited if it's zero.
+func extractArchiveReaderLimit(r io.Reader, dir string, maxSize int64) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	e := &extractor{root: root, maxSize: maxSize, dirs: map[string]os.FileMode{}}
+
+	br := bufio.NewReaderSize(r, 512)
+	magic, err := br.Peek(262)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		err = e.extractZipReader(r, br)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		var zr io.Reader
+		if zr, err = gzip.NewReader(br); err == nil {
+			err = e.extractTar(zr)
+		}
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		err = e.extractTar(bzip2.NewReader(br))
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		var xr io.Reader
+		if xr, err = xz.NewReader(br); err == nil {
+			err = e.extractTar(xr)
+		}
+	case len(magic) >= 262 && string(magic[257:262]) == "ustar":
+		err = e.extractTar(br)
+	default:
+		return fmt.Errorf("unsupported archive format; want a tar, tar.gz, tar.bz2, tar.xz or zip archive")
+	}
+	if err != nil {
+		return err
+	}
+	if err := e.checkSymlinks(); err != nil {
+		return err
+	}
+	for dir, mode := range e.dirs {
+		if err := os.Chmod(dir, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entry := archiveEntry{
+			name:     header.Name,
+			mode:     os.FileMode(header.Mode).Perm(),
+			linkname: header.Linkname,
+			content:  tr,
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			entry.mode |= os.ModeDir
+		case tar.TypeReg, tar.TypeRegA:
+		case tar.TypeSymlink:
+			entry.mode |= os.ModeSymlink
+		case tar.TypeLink:
+			entry.hardlink = true
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			log.Warnf("Skipping archive entry %v of unsupported type %v", header.Name, string(header.Typeflag))
+			continue
+		}
+		if err := e.extract(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// extractZipReader unpacks the zip archive read from r, of which br buffers the start.
+func (e *extractor) extractZipReader(r io.Reader, br *bufio.Reader) error {
+	if r, ok := r.(*bytes.Reader); ok {
+		return e.extractZip(r, r.Size())
+	}
+	if f, ok := r.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			return e.extractZip(f, fi.Size())
+		}
+	}
+	spool, err := ioutil.TempFile("", "kfctl-zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, io.LimitReader(br, maxArchiveSize+1))
+	if err != nil {
+		return err
+	}
+	if size > maxArchiveSize {
+		return fmt.Errorf("archive is larger than %v bytes", maxArchiveSize)
+	}
+	return e.extractZip(spool, size)
+}
+
+func (e *extractor) extractZip(archive io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(archive, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if !mode.IsDir() && !mode.IsRegular() && mode&os.ModeSymlink == 0 {
+			log.Warnf("Skipping archive entry %v of unsupported mode %v", f.Name, mode)
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		entry := archiveEntry{name: f.Name, mode: mode.Perm() | mode&(os.ModeDir|os.ModeSymlink), content: rc}
+		if mode&os.ModeSymlink != 0 {
+			// The target of a symlink is the content of its entry.
+			target, err := readLimited(rc, int64(os.Getpagesize()))
+			if err != nil {
+				rc.Close()
+				return err
+			}
+			entry.linkname = string(target)
+		}
+		err = e.extract(entry)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extract unpacks entry into the root of e.
+func (e *extractor) extract(entry archiveEntry) error {
+	e.entries++
+	if e.entries > maxArchiveEntries {
+		return fmt.Errorf("archive has more than %v entries", maxArchiveEntries)
+	}
+	target, err := e.resolve(entry.name)
+	if err != nil {
+		return err
+	}
+	if target == e.root {
+		if entry.mode.IsDir() {
+			return nil
+		}
+		return fmt.Errorf("archive entry %v isn't a directory", entry.name)
+	}
+	if err := e.mkdirAll(filepath.Dir(target)); err != nil {
+		return err
+	}
+	// Replace whatever an earlier entry left at target rather than write through it.
+	if fi, err := os.Lstat(target); err == nil && !(fi.IsDir() && entry.mode.IsDir()) {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case entry.mode.IsDir():
+		if err := os.MkdirAll(target, 0700); err != nil {
+			return err
+		}
+		e.dirs[target] = entry.mode.Perm() | 0700
+	case entry.mode&os.ModeSymlink != 0:
+		if filepath.IsAbs(entry.linkname) {
+			return fmt.Errorf("symlink %v points to the absolute path %v", entry.name, entry.linkname)
+		}
+		if _, err := e.walk(filepath.Dir(target), entry.linkname, 0); err != nil {
+			return fmt.Errorf("symlink %v points to %v outside of the archive: %v", entry.name, entry.linkname, err)
+		}
+		e.symlinks = append(e.symlinks, archiveEntry{name: target, linkname: entry.linkname})
+		return os.Symlink(entry.linkname, target)
+	case entry.hardlink:
+		source, err := e.resolve(entry.linkname)
+		if err != nil {
+			return err
+		}
+		if fi, err := os.Lstat(source); err != nil || !fi.Mode().IsRegular() {
+			return fmt.Errorf("hardlink %v points to %v which isn't a file of the archive", entry.name, entry.linkname)
+		}
+		return os.Link(source, target)
+	default:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, entry.mode.Perm()|0600)
+		if err != nil {
+			return err
+		}
+		content := entry.content
+		if e.maxSize > 0 {
+			content = io.LimitReader(content, e.maxSize-e.size+1)
+		}
+		n, err := io.Copy(f, content)
+		e.size += n
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if e.maxSize > 0 && e.size > e.maxSize {
+			return fmt.Errorf("archive is larger than %v bytes once unpacked", e.maxSize)
+		}
+	}
+	return nil
+}
+
+// checkSymlinks checks that the symlinks unpacked still point inside the root in the final tree: a symlink
+// checked as it was unpacked may have gone through directories later entries replaced by symlinks.
+func (e *extractor) checkSymlinks() error {
+	for _, link := range e.symlinks {
+		if fi, err := os.Lstat(link.name); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// A later entry replaced it.
+			continue
+		}
+		if _, err := e.walk(filepath.Dir(link.name), link.linkname, 0); err != nil {
+			rel, _ := filepath.Rel(e.root, link.name)
+			return fmt.Errorf("symlink %v points to %v outside of the archive: %v", rel, link.linkname, err)
+		}
+	}
+	return nil
+}
+
+// resolve returns the path name unpacks to, with the symlinks of its parent directories followed, or an error if
+// it is outside of the root. Names with .. components are rejected outright.
+func (e *extractor) resolve(name string) (string, error) {
+	slashed := strings.TrimSuffix(filepath.ToSlash(name), "/")
+	if slashed == "" || escapesRoot(name) {
+		return "", fmt.Errorf("archive entry %q escapes the archive root", name)
+	}
+	dir, base := path.Split(slashed)
+	parent, err := e.walk(e.root, dir, 0)
+	if err != nil {
+		return "", fmt.Errorf("archive entry %v escapes the archive root: %v", name, err)
+	}
+	if base == "." {
+		return parent, nil
+	}
+	return filepath.Join(parent, base), nil
+}
+
+// escapesRoot tells whether the relative path name may lead out of the directory it is relative to, being
+// absolute or having .. components.
+func escapesRoot(name string) bool {
+	slashed := filepath.ToSlash(name)
+	if path.IsAbs(slashed) || filepath.IsAbs(name) {
+		return true
+	}
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// walk returns the path rel leads to from dir, following the symlinks unpacked so far, or an error if it leaves
+// the root at any step.
+func (e *extractor) walk(dir string, rel string, depth int) (string, error) {
+	if depth > maxSymlinkDepth {
+		return "", fmt.Errorf("too many levels of symlinks")
+	}
+	current := dir
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+		default:
+			next := filepath.Join(current, part)
+			if fi, err := os.Lstat(next); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				link, err := os.Readlink(next)
+				if err != nil {
+					return "", err
+				}
+				if filepath.IsAbs(link) {
+					return "", fmt.Errorf("symlink %v points to the absolute path %v", next, link)
+				}
+				if next, err = e.walk(current, link, depth+1); err != nil {
+					return "", err
+				}
+			}
+			current = next
+		}
+		if !e.inRoot(current) {
+			return "", fmt.Errorf("%v leaves the archive root", rel)
+		}
+	}
+	return current, nil
+}
+
+// mkdirAll creates dir, a directory under the root resolved by resolve, and its missing parents.
+func (e *extractor) mkdirAll(dir string) error {
+	if fi, err := os.Stat(dir); err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("%v isn't a directory", dir)
+		}
+		return nil
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// inRoot tells whether p is the root or lexically below it.
+func (e *extractor) inRoot(p string) bool {
+	rel, err := filepath.Rel(e.root, filepath.Clean(p))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// readLimited reads r, failing if it holds more than limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, fmt.Errorf("entry is larger than %v bytes", limit)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,197 @@
+package kfconfig
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+// tarEntry is an entry of a test archive: a file with content, a directory if name ends with /, or a link.
+type tarEntry struct {
+	name     string
+	content  string
+	symlink  string
+	hardlink string
+	mode     int64
+}
+
+func testTar(entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: e.mode, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		if h.Mode == 0 {
+			h.Mode = 0644
+		}
+		switch {
+		case e.symlink != "":
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, e.symlink, 0
+		case e.hardlink != "":
+			h.Typeflag, h.Linkname, h.Size = tar.TypeLink, e.hardlink, 0
+		case e.name[len(e.name)-1] == '/':
+			h.Typeflag = tar.TypeDir
+		}
+		tw.WriteHeader(h)
+		tw.Write([]byte(e.content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestExtractArchive(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-extract")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	valid := []tarEntry{
+		{name: "manifests/"},
+		{name: "manifests/kustomization.yaml", content: "resources: []"},
+		{name: "manifests/readonly/", mode: 0555},
+		{name: "manifests/readonly/file", content: "ro"},
+		{name: "manifests/link.yaml", symlink: "kustomization.yaml"},
+		{name: "manifests/hard.yaml", hardlink: "manifests/kustomization.yaml"},
+		{name: "manifests/dirlink", symlink: "readonly"},
+		{name: "manifests/dirlink/through", content: "through"},
+	}
+	plain := testTar(valid)
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write(plain)
+	gzw.Close()
+	var xzBuf bytes.Buffer
+	xzw, _ := xz.NewWriter(&xzBuf)
+	xzw.Write(plain)
+	xzw.Close()
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, _ := zw.Create("manifests/kustomization.yaml")
+	w.Write([]byte("resources: []"))
+	zh := &zip.FileHeader{Name: "manifests/link.yaml"}
+	zh.SetMode(os.ModeSymlink | 0777)
+	w, _ = zw.CreateHeader(zh)
+	w.Write([]byte("kustomization.yaml"))
+	zw.Close()
+
+	type testCase struct {
+		Name    string
+		Archive []byte
+		// Files maps the files expected once unpacked to their content.
+		Files map[string]string
+		Err   bool
+	}
+	validFiles := map[string]string{
+		"manifests/kustomization.yaml": "resources: []",
+		"manifests/link.yaml":          "resources: []",
+		"manifests/hard.yaml":          "resources: []",
+		"manifests/readonly/file":      "ro",
+		"manifests/readonly/through":   "through",
+	}
+	cases := []testCase{
+		{Name: "tar", Archive: plain, Files: validFiles},
+		{Name: "tar.gz", Archive: gz.Bytes(), Files: validFiles},
+		{Name: "tar.xz", Archive: xzBuf.Bytes(), Files: validFiles},
+		{
+			Name:    "zip",
+			Archive: zipBuf.Bytes(),
+			Files:   map[string]string{"manifests/link.yaml": "resources: []"},
+		},
+		{Name: "traversal", Archive: testTar([]tarEntry{{name: "../escaped", content: "x"}}), Err: true},
+		{Name: "absolute", Archive: testTar([]tarEntry{{name: "/tmp/escaped", content: "x"}}), Err: true},
+		{Name: "symlink-out", Archive: testTar([]tarEntry{{name: "link", symlink: "../../etc"}}), Err: true},
+		{Name: "symlink-absolute", Archive: testTar([]tarEntry{{name: "link", symlink: "/etc"}}), Err: true},
+		{
+			// s/.. is the root lexically but the parent of the root once s is followed.
+			Name:    "symlink-chain",
+			Archive: testTar([]tarEntry{{name: "d/", content: ""}, {name: "d/s", symlink: ".."}, {name: "d/c", symlink: "s/.."}}),
+			Err:     true,
+		},
+		{
+			// x is checked while d doesn't exist yet; once d links to the root and e exists, x resolves to the
+			// parent of the root.
+			Name: "symlink-retargeted",
+			Archive: testTar([]tarEntry{
+				{name: "x", symlink: "d/e/../.."}, {name: "d", symlink: "."}, {name: "e/"},
+			}),
+			Err: true,
+		},
+		{
+			Name:    "write-through-symlink",
+			Archive: testTar([]tarEntry{{name: "self", symlink: "."}, {name: "self/../escaped", content: "x"}}),
+			Err:     true,
+		},
+		{Name: "hardlink-out", Archive: testTar([]tarEntry{{name: "hard", hardlink: "../../etc/passwd"}}), Err: true},
+		{Name: "unknown-format", Archive: []byte("not an archive"), Err: true},
+	}
+	if _, err := exec.LookPath("bzip2"); err == nil {
+		cmd := exec.Command("bzip2", "-c")
+		cmd.Stdin = bytes.NewReader(plain)
+		if out, err := cmd.Output(); err == nil {
+			cases = append(cases, testCase{Name: "tar.bz2", Archive: out, Files: validFiles})
+		}
+	}
+
+	for _, c := range cases {
+		dir := path.Join(testDir, c.Name, "root")
+		err := extractArchive(c.Archive, dir)
+		if c.Err {
+			if err == nil {
+				t.Errorf("Case %v; expected an error got none", c.Name)
+			}
+			if _, err := os.Lstat(path.Join(testDir, c.Name, "escaped")); err == nil {
+				t.Errorf("Case %v; a file was written outside of the root", c.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case %v; unexpected error: %v", c.Name, err)
+			continue
+		}
+		for name, expected := range c.Files {
+			actual, err := ioutil.ReadFile(path.Join(dir, name))
+			if err != nil || string(actual) != expected {
+				t.Errorf("Case %v; %v: got %q (%v); want %q", c.Name, name, actual, err, expected)
+			}
+		}
+		if c.Files["manifests/readonly/file"] != "" {
+			fi, err := os.Stat(path.Join(dir, "manifests/readonly"))
+			if err != nil || fi.Mode().Perm() != 0755 {
+				t.Errorf("Case %v; got mode %v (%v) of the read-only directory; want %v", c.Name, fi.Mode().Perm(), err, os.FileMode(0755))
+			}
+		}
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-extract-limits")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	defer func(size int64, entries int) {
+		maxArchiveSize, maxArchiveEntries = size, entries
+	}(maxArchiveSize, maxArchiveEntries)
+	maxArchiveSize, maxArchiveEntries = 10, 2
+
+	if err := extractArchive(testTar([]tarEntry{{name: "a", content: "12345"}, {name: "b", content: "12345"}}),
+		path.Join(testDir, "fits")); err != nil {
+		t.Errorf("Archive within the limits; unexpected error: %v", err)
+	}
+	if err := extractArchive(testTar([]tarEntry{{name: "a", content: "12345"}, {name: "b", content: "123456"}}),
+		path.Join(testDir, "size")); err == nil {
+		t.Errorf("Archive over the size limit; expected an error got none")
+	}
+	if err := extractArchive(testTar([]tarEntry{{name: "a"}, {name: "b"}, {name: "c"}}),
+		path.Join(testDir, "entries")); err == nil {
+		t.Errorf("Archive over the entry limit; expected an error got none")
+	}
+}
@@ -0,0 +1,228 @@
+package kfconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RepoRefreshEnv is the refresh policy of the repos that don't set one.
+const RepoRefreshEnv = "KFCTL_REPO_REFRESH"
+
+// refreshPolicy returns the refresh policy of r: its own, or else that of the environment, or else ifChanged.
+func (r Repo) refreshPolicy() (RefreshPolicy, error) {
+	policy := r.Refresh
+	if policy == "" {
+		policy = RefreshPolicy(os.Getenv(RepoRefreshEnv))
+	}
+	switch policy {
+	case "":
+		return RefreshIfChanged, nil
+	case RefreshAlways, RefreshIfChanged, RefreshNever:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid refresh policy %q; must be one of %v, %v or %v", policy,
+		RefreshAlways, RefreshIfChanged, RefreshNever)
+}
+
+// cacheRecordFile returns the file the cache of the repo synced to cacheDir is recorded in.
+func cacheRecordFile(cacheDir string) string {
+	return cacheDir + ".json"
+}
+
+// writeCacheRecord records cache, the cache of the repo synced to cacheDir, next to it.
+func writeCacheRecord(cacheDir string, cache *Cache) error {
+	record, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cacheRecordFile(cacheDir), record, 0644)
+}
+
+// recordedCache returns the cache of the repo name synced to cacheDir: as recorded in the status, or else as
+// recorded next to cacheDir, e.g. when the status of a KfDef was lost or another KfDef is built in the same
+// AppDir.
+func (c *KfConfig) recordedCache(name string, cacheDir string) (Cache, bool) {
+	if cache, ok := c.getCache(name); ok {
+		return cache, true
+	}
+	record, err := ioutil.ReadFile(cacheRecordFile(cacheDir))
+	if err != nil {
+		return Cache{}, false
+	}
+	cache := Cache{}
+	if err := json.Unmarshal(record, &cache); err != nil || cache.Name != name {
+		return Cache{}, false
+	}
+	return cache, true
+}
+
+// sameSource tells whether cache was fetched from source. Caches recorded before their source was are assumed
+// to be.
+func sameSource(cache Cache, source string) bool {
+	return cache.Source == "" || cache.Source == source
+}
+
+// archiveChanged tells whether the archive of r must be fetched again over cache. Under ifChanged, archives
+// pinned by checksum don't change and the others are checked with a GET conditional on the validators
+// recorded in cache; local directories are copied again. The cache is kept if the check fails.
+func (c *KfConfig) archiveChanged(ctx context.Context, r Repo, cache Cache) bool {
+	switch policy, _ := r.refreshPolicy(); policy {
+	case RefreshNever:
+		return false
+	case RefreshAlways:
+		return true
+	}
+	if r.SHA256 != "" {
+		return false
+	}
+	if fi, err := os.Stat(r.URI); err == nil && fi.IsDir() {
+		return true
+	}
+	if cache.ETag == "" && cache.LastModified == "" {
+		log.Infof("Repo %v has no ETag or Last-Modified to check it against; fetching it again", r.Name)
+		return true
+	}
+	creds, err := ReadCredentials(c.Namespace, r.CredentialsSecret, r.URI)
+	if err != nil {
+		log.Warnf("Couldn't read the credentials of repo %v to check if it changed; keeping its cache: %v", r.Name, err)
+		return false
+	}
+	client, err := creds.HTTPClient()
+	if err != nil {
+		log.Warnf("Invalid credentials of repo %v; keeping its cache: %v", r.Name, err)
+		return false
+	}
+	changed, err := archiveModified(ctx, client, r.URI, creds, cache)
+	if err != nil {
+		log.Warnf("Couldn't check if repo %v changed; keeping its cache: %v", r.Name, err)
+		return false
+	}
+	if changed {
+		log.Infof("Repo %v changed since it was fetched", r.Name)
+	}
+	return changed
+}
+
+// archiveModified tells whether the archive at uri changed since it was downloaded to cache, with a GET
+// conditional on the ETag and Last-Modified of cache. The body of the response isn't read.
+func archiveModified(ctx context.Context, client *http.Client, uri string, creds *Credentials, cache Cache) (bool, error) {
+	req, err := newDownloadRequest(uri, creds)
+	if err != nil {
+		return false, err
+	}
+	if cache.ETag != "" {
+		req.Header.Set("If-None-Match", cache.ETag)
+	}
+	if cache.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cache.LastModified)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+		// Servers ignoring the conditions may still send the same validator.
+		if etag := resp.Header.Get("ETag"); etag != "" && etag == cache.ETag {
+			return false, nil
+		}
+		return true, nil
+	}
+	return false, statusError("GET", uri, resp)
+}
+
+// gitChanged tells whether the git repository of r must be cloned again over cache. Under ifChanged, a ref
+// naming a full commit doesn't change and the others are resolved with git ls-remote. The cache is kept if the
+// check fails.
+func gitChanged(ctx context.Context, r Repo, cache Cache, env []string) bool {
+	switch policy, _ := r.refreshPolicy(); policy {
+	case RefreshNever:
+		return false
+	case RefreshAlways:
+		return true
+	}
+	if gitCommitRegex.MatchString(r.Git.Ref) {
+		return false
+	}
+	commit, err := remoteCommit(ctx, r.Git.URL, r.Git.Ref, env)
+	if err != nil {
+		log.Warnf("Couldn't check if repo %v changed; keeping its cache: %v", r.Name, err)
+		return false
+	}
+	if commit == "" {
+		// Abbreviated commits can't be resolved remotely but don't change either.
+		if commitish.MatchString(r.Git.Ref) && strings.HasPrefix(cache.Commit, strings.ToLower(r.Git.Ref)) {
+			return false
+		}
+		log.Warnf("Couldn't find ref %v of repo %v; keeping its cache", r.Git.Ref, r.Name)
+		return false
+	}
+	if commit != cache.Commit {
+		log.Infof("Repo %v changed since it was cloned: %v is at %v", r.Name, r.Git.Ref, commit)
+		return true
+	}
+	return false
+}
+
+// remoteCommit returns the commit ref, or HEAD if it's empty, is at in the repository at url, or an empty
+// string if it has no such ref. Refs are resolved as git fetch does: tags before branches.
+func remoteCommit(ctx context.Context, url string, ref string, env []string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	out, err := runGitEnv(ctx, env, "", "ls-remote", "--", url, ref)
+	if err != nil {
+		return "", err
+	}
+	commits := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			commits[fields[1]] = fields[0]
+		}
+	}
+	for _, name := range []string{ref, "refs/" + ref, "refs/tags/" + ref, "refs/heads/" + ref} {
+		// Annotated tags are listed along with the commit they point to.
+		if commit, ok := commits[name+"^{}"]; ok {
+			return commit, nil
+		}
+		if commit, ok := commits[name]; ok {
+			return commit, nil
+		}
+	}
+	return "", nil
+}
+
+// ociChanged tells whether the OCI artifact of r must be pulled again over cache. Under ifChanged, a reference
+// pinned by digest doesn't change and the digest of a tag is looked up with a HEAD of its manifest. The cache is
+// kept if the check fails.
+func ociChanged(ctx context.Context, r Repo, ref *ociReference, client *registryClient, cache Cache) bool {
+	switch policy, _ := r.refreshPolicy(); policy {
+	case RefreshNever:
+		return false
+	case RefreshAlways:
+		return true
+	}
+	if ref.digest != "" {
+		return false
+	}
+	digest, err := client.manifestDigest(ctx, ref, ociManifestMediaType+", "+dockerManifestMediaType)
+	if err != nil {
+		log.Warnf("Couldn't check if repo %v changed; keeping its cache: %v", r.Name, err)
+		return false
+	}
+	if digest != cache.Digest {
+		log.Infof("Repo %v changed since it was pulled: %v is at %v", r.Name, ref.tag, digest)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package kfconfig
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSyncCacheRefresh(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-sync-refresh")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// The server serves the archive of version with it as its ETag, as a branch tarball moving along would. A
+	// changed archive is downloaded twice: once by the check and once by the fetch.
+	version := "v1"
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		etag := `"` + version + `"`
+		w.Header().Set("ETag", etag)
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Write(testArchive(version))
+	}))
+	defer server.Close()
+
+	config := &KfConfig{
+		Spec: KfConfigSpec{
+			AppDir: path.Join(testDir, "app"),
+			Repos:  []Repo{{Name: "manifests", URI: server.URL + "/master.tar.gz"}},
+		},
+	}
+	check := func(step string, content string, count int) {
+		if err := config.SyncCache(); err != nil {
+			t.Fatalf("%v: could not sync cache; %v", step, err)
+		}
+		cache := config.Status.Caches[0]
+		if cache.Source != config.Spec.Repos[0].URI || cache.FetchedAt == nil {
+			t.Fatalf("%v: got cache %+v; want its source and fetch time recorded", step, cache)
+		}
+		actual, err := ioutil.ReadFile(path.Join(cache.LocalPath, "version"))
+		if err != nil || string(actual) != content {
+			t.Fatalf("%v: got content %q (%v); want %q", step, actual, err, content)
+		}
+		if downloads != count {
+			t.Fatalf("%v: got %v downloads; want %v", step, downloads, count)
+		}
+	}
+
+	check("fetch", "v1", 1)
+	if etag := config.Status.Caches[0].ETag; etag != `"v1"` {
+		t.Fatalf("Got ETag %v; want \"v1\"", etag)
+	}
+	check("unchanged", "v1", 1)
+	version = "v2"
+	check("changed", "v2", 3)
+
+	// The cache recorded next to the cache directory is used when the status is lost.
+	config.Status.Caches = nil
+	check("status lost", "v2", 3)
+
+	version = "v3"
+	config.Spec.Repos[0].Refresh = RefreshNever
+	check("never", "v2", 3)
+	defer os.Setenv(RepoRefreshEnv, os.Getenv(RepoRefreshEnv))
+	os.Setenv(RepoRefreshEnv, string(RefreshNever))
+	config.Spec.Repos[0].Refresh = ""
+	check("never from the environment", "v2", 3)
+
+	os.Setenv(RepoRefreshEnv, string(RefreshAlways))
+	check("always", "v3", 4)
+	check("always unchanged", "v3", 5)
+
+	config.Spec.Repos[0].Refresh = "sometimes"
+	if err := config.SyncCache(); err == nil {
+		t.Fatalf("invalid policy: expected an error got none")
+	}
+}
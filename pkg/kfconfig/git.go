@@ -0,0 +1,205 @@
+package kfconfig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// commitish matches refs that may name a commit: servers don't necessarily let clients fetch those directly.
+var commitish = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// gitCommitRegex matches full commit ids, which name the same content wherever they're cloned from.
+var gitCommitRegex = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// syncGitRepo clones the git repository of r into cacheDir and returns its cache, recording the commit it was
+// cloned at. An existing clone is kept as long as the URL and ref of the repository don't change, until its
+// refresh policy says otherwise; the recorded commit is cloned again if the policy is never.
+func (c *KfConfig) syncGitRepo(ctx context.Context, r Repo, cacheDir string) (*Cache, error) {
+	if r.URI != "" {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("repo %v sets both uri and git", r.Name),
+		}
+	}
+	if r.Git.URL == "" {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("repo %v has no git url", r.Name),
+		}
+	}
+	// git would take a URL or ref starting with a dash for one of its options, e.g. --upload-pack.
+	if strings.HasPrefix(r.Git.URL, "-") || strings.HasPrefix(r.Git.Ref, "-") {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("the git url and ref of repo %v can't start with -", r.Name),
+		}
+	}
+	env, cleanup, err := c.gitCredentialsEnv(r)
+	if err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't read the credentials of repo %v: %v", r.Name, err),
+		}
+	}
+	defer cleanup()
+
+	rev := r.Git.Ref
+	cache, ok := c.recordedCache(r.Name, cacheDir)
+	if ok && cache.Commit != "" && cache.Ref == r.Git.Ref && sameSource(cache, r.Git.URL) {
+		if _, err := os.Stat(cacheDir); err == nil && cache.LocalPath != "" && !gitChanged(ctx, r, cache, env) {
+			log.Infof("%v is up to date at commit %v; not resyncing", cacheDir, cache.Commit)
+			return &cache, nil
+		}
+		if policy, _ := r.refreshPolicy(); policy == RefreshNever {
+			rev = cache.Commit
+		}
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	if err := os.RemoveAll(cacheDir); err != nil {
+		log.Errorf("There was a problem deleting directory %v; error %v", cacheDir, err)
+		return nil, err
+	}
+	// A rev naming a commit can be looked up in the shared cache before cloning.
+	commit := ""
+	key := ""
+	if gitCommitRegex.MatchString(rev) {
+		commit = strings.ToLower(rev)
+		key = sharedCacheKey("git", c.cacheScope(r, r.Git.URL), commit)
+	}
+	log.Infof("Cloning %v at %v to %v", r.Git.URL, rev, cacheDir)
+	err = populateCache(r.Git.URL, key, cacheDir, func(dir string) (string, error) {
+		reportProgress(ctx, kftypes.ProgressEvent{Type: kftypes.RepoFetchStarted, Repo: r.Name})
+		// git reports no more than that it failed, so all failures are retried.
+		err := retryFetch(ctx, r, dir, func(ctx context.Context) error {
+			var err error
+			if commit, err = cloneGitRepo(ctx, r.Git.URL, rev, dir, env); err != nil {
+				return &transientError{err}
+			}
+			return nil
+		})
+		reportProgress(ctx, kftypes.ProgressEvent{Type: kftypes.RepoFetched, Repo: r.Name, Err: err})
+		if err != nil {
+			return "", &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("couldn't clone repo %v from %v at %v: %v", r.Name, r.Git.URL, rev, err),
+			}
+		}
+		return sharedCacheKey("git", c.cacheScope(r, r.Git.URL), commit), nil
+	})
+	if err != nil {
+		os.RemoveAll(cacheDir)
+		return nil, err
+	}
+
+	localPath := cacheDir
+	if r.Git.SubDir != "" {
+		subDir := filepath.Clean(r.Git.SubDir)
+		if filepath.IsAbs(subDir) || subDir == ".." || strings.HasPrefix(subDir, ".."+string(filepath.Separator)) {
+			os.RemoveAll(cacheDir)
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("subDir %v of repo %v isn't inside the repository", r.Git.SubDir, r.Name),
+			}
+		}
+		localPath = filepath.Join(cacheDir, subDir)
+		if fi, err := os.Stat(localPath); err != nil || !fi.IsDir() {
+			os.RemoveAll(cacheDir)
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("repo %v has no directory %v at commit %v", r.Name, r.Git.SubDir, commit),
+			}
+		}
+	}
+
+	log.Infof("Clone succeeded; LocalPath %v at commit %v", localPath, commit)
+	now := metav1.Now()
+	return &Cache{
+		Name:      r.Name,
+		LocalPath: localPath,
+		Ref:       r.Git.Ref,
+		Commit:    commit,
+		Source:    r.Git.URL,
+		FetchedAt: &now,
+	}, nil
+}
+
+// gitCredentialsEnv returns the environment making git authenticate with the credentials of r, and a function
+// removing the files it refers to.
+func (c *KfConfig) gitCredentialsEnv(r Repo) ([]string, func(), error) {
+	noop := func() {}
+	creds, err := ReadCredentials(c.Namespace, r.CredentialsSecret, r.Git.URL)
+	if err != nil || creds == nil {
+		return nil, noop, err
+	}
+	dir, err := ioutil.TempDir("", "kfctl-git-credentials")
+	if err != nil {
+		return nil, noop, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	env, err := creds.gitEnv(dir)
+	if err != nil {
+		cleanup()
+		return nil, noop, err
+	}
+	return env, cleanup, nil
+}
+
+// cloneGitRepo checks out rev of the repository at url into dir and returns the commit it resolved to.
+// Only rev is fetched unless the server refuses to serve a commit directly, in which case all its branches
+// and tags are. env is added to the environment of the git commands talking to the server.
+func cloneGitRepo(ctx context.Context, url string, rev string, dir string, env []string) (string, error) {
+	if _, err := runGit(ctx, "", "init", "--quiet", dir); err != nil {
+		return "", err
+	}
+	checkout := "FETCH_HEAD"
+	if _, err := runGitEnv(ctx, env, dir, "fetch", "--quiet", "--depth", "1", "--", url, rev); err != nil {
+		if !commitish.MatchString(rev) {
+			return "", err
+		}
+		log.Infof("Couldn't fetch commit %v directly; fetching all refs of %v", rev, url)
+		if _, err := runGitEnv(ctx, env, dir, "fetch", "--quiet", "--", url,
+			"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"); err != nil {
+			return "", err
+		}
+		checkout = rev
+	}
+	if _, err := runGit(ctx, dir, "-c", "advice.detachedHead=false", "checkout", "--quiet", "--detach",
+		checkout+"^{commit}"); err != nil {
+		return "", err
+	}
+	return runGit(ctx, dir, "rev-parse", "HEAD")
+}
+
+// runGit runs git with args in dir and returns its trimmed output. git never prompts for credentials.
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	return runGitEnv(ctx, nil, dir, args...)
+}
+
+// runGitEnv is runGit with env added to the environment of git.
+func runGitEnv(ctx context.Context, env []string, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %v: %v: %v", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
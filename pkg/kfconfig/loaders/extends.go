@@ -0,0 +1,326 @@
+package loaders
+
+import (
+	"fmt"
+	netUrl "net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	kfdeftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	"github.com/kubeflow/kfctl/v3/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxExtendsDepth bounds the chain of bases a KfDef may extend.
+const maxExtendsDepth = 10
+
+// readBaseConfigMap reads a base KfDef from the key of a ConfigMap. It returns nil if the ref is optional and
+// the ConfigMap or its key doesn't exist.
+var readBaseConfigMap = kfconfig.ReadConfigMapBinaryKeyRef
+
+// resolveExtends merges the v1 KfDef obj, read from location, onto the base it extends, if any, after
+// resolving the bases of that base. chain holds the locations of the KfDefs extending obj.
+func resolveExtends(obj map[string]interface{}, location string, chain []string) (map[string]interface{}, error) {
+	kfdef := &kfdeftypes.KfDef{}
+	if err := convertObject(obj, kfdef); err != nil {
+		return nil, err
+	}
+	if kfdef.Spec.Extends == nil {
+		return obj, nil
+	}
+	chain = append(chain, location)
+	if len(chain) > maxExtendsDepth {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("KfDef extends more than %v bases: %v", maxExtendsDepth, strings.Join(chain, " -> ")),
+		}
+	}
+
+	baseBytes, baseLocation, err := readBase(kfdef, location)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range chain {
+		if l == baseLocation {
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("KfDef extends itself: %v -> %v", strings.Join(chain, " -> "), baseLocation),
+			}
+		}
+	}
+	if baseBytes == nil && kfdef.Spec.Extends.ConfigMapKeyRef != nil {
+		// The ConfigMap of an optional base doesn't exist: the KfDef extends an empty base.
+		log.Infof("Base KfDef %v doesn't exist; it's optional so KfDef %v extends an empty base", baseLocation, location)
+		merged := map[string]interface{}{}
+		if err := convertObject(mergeKfDefs(&kfdeftypes.KfDef{}, kfdef), &merged); err != nil {
+			return nil, err
+		}
+		return merged, nil
+	}
+	var baseObj map[string]interface{}
+	if err := yaml.Unmarshal(baseBytes, &baseObj); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("invalid base KfDef %v: %v", baseLocation, err),
+		}
+	}
+	if apiVersion, _ := baseObj["apiVersion"].(string); apiVersion != kfdeftypes.SchemeGroupVersion.String() {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("base KfDef %v must be of apiVersion %v, got %v", baseLocation, kfdeftypes.SchemeGroupVersion, apiVersion),
+		}
+	}
+	if baseObj, err = resolveExtends(baseObj, baseLocation, chain); err != nil {
+		return nil, err
+	}
+	base := &kfdeftypes.KfDef{}
+	if err := convertObject(baseObj, base); err != nil {
+		return nil, err
+	}
+
+	merged := map[string]interface{}{}
+	if err := convertObject(mergeKfDefs(base, kfdef), &merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// readBase returns the contents of the base of kfdef, read from location, along with its location.
+func readBase(kfdef *kfdeftypes.KfDef, location string) ([]byte, string, error) {
+	extends := kfdef.Spec.Extends
+	switch {
+	case extends.URI != "" && extends.ConfigMapKeyRef == nil:
+		uri, err := resolveBaseURI(extends.URI, location)
+		if err != nil {
+			return nil, "", err
+		}
+		contents, _, err := readConfigFileWithCredentials(uri, kfdef.Namespace, extends.CredentialsSecret)
+		return contents, uri, err
+	case extends.URI == "" && extends.ConfigMapKeyRef != nil:
+		ref := extends.ConfigMapKeyRef
+		contents, err := readBaseConfigMap(kfdef.Namespace, ref)
+		return contents, fmt.Sprintf("configmap:%v/%v/%v", kfdef.Namespace, ref.Name, ref.Key), err
+	}
+	return nil, "", &kfapis.KfError{
+		Code:    int(kfapis.INVALID_ARGUMENT),
+		Message: fmt.Sprintf("extends of KfDef %v must set exactly one of uri and configMapKeyRef", location),
+	}
+}
+
+// resolveBaseURI resolves uri against location, the location of the KfDef extending it.
+func resolveBaseURI(uri string, location string) (string, error) {
+	isRemote, err := utils.IsRemoteFile(uri)
+	if err != nil {
+		return "", err
+	}
+	if isRemote || filepath.IsAbs(uri) || strings.HasPrefix(location, "configmap:") {
+		return uri, nil
+	}
+	if isRemoteLocation, err := utils.IsRemoteFile(location); err == nil && isRemoteLocation {
+		locationUrl, err := netUrl.Parse(location)
+		if err != nil {
+			return "", &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("couldn't parse %v: %v", location, err),
+			}
+		}
+		relativeUrl, err := netUrl.Parse(uri)
+		if err != nil {
+			return "", &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("couldn't parse %v: %v", uri, err),
+			}
+		}
+		return locationUrl.ResolveReference(relativeUrl).String(), nil
+	}
+	return filepath.Join(filepath.Dir(location), uri), nil
+}
+
+// convertObject converts in to out through YAML.
+func convertObject(in interface{}, out interface{}) error {
+	bytes, err := yaml.Marshal(in)
+	if err != nil {
+		return &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("could not marshal kfdef into bytes: %v", err),
+		}
+	}
+	if err := yaml.Unmarshal(bytes, out); err != nil {
+		return &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("could not unpack kfdef: %v", err),
+		}
+	}
+	return nil
+}
+
+// mergeKfDefs returns the KfDef overlay extending base declares:
+//   - metadata and status are those of overlay, with the labels and annotations of base it doesn't set;
+//   - applications are those of base in order, less the ones overlay removes, followed by the new ones of
+//     overlay; an application of both is merged by mergeApplications;
+//   - repos, secrets, plugins, global parameters and variables are merged by name, those of overlay
+//     replacing those of base of the same name;
+//   - the version, apply policy and image overrides set by overlay take precedence; image overrides are
+//     merged by image.
+func mergeKfDefs(base *kfdeftypes.KfDef, overlay *kfdeftypes.KfDef) *kfdeftypes.KfDef {
+	merged := overlay.DeepCopy()
+	merged.Spec.Extends = nil
+	merged.Labels = mergeStringMaps(base.Labels, overlay.Labels)
+	merged.Annotations = mergeStringMaps(base.Annotations, overlay.Annotations)
+
+	if merged.Spec.Version == "" {
+		merged.Spec.Version = base.Spec.Version
+	}
+	if merged.Spec.ApplyPolicy == nil && base.Spec.ApplyPolicy != nil {
+		merged.Spec.ApplyPolicy = base.Spec.ApplyPolicy.DeepCopy()
+	}
+	if base.Spec.ImageOverrides != nil {
+		overrides := &kfdeftypes.ImageOverrides{}
+		if overlay.Spec.ImageOverrides != nil {
+			overrides = overlay.Spec.ImageOverrides
+		}
+		merged.Spec.ImageOverrides = &kfdeftypes.ImageOverrides{
+			Prefixes: mergeStringMaps(base.Spec.ImageOverrides.Prefixes, overrides.Prefixes),
+			Images:   mergeStringMaps(base.Spec.ImageOverrides.Images, overrides.Images),
+			Digests:  mergeStringMaps(base.Spec.ImageOverrides.Digests, overrides.Digests),
+		}
+	}
+
+	removed := map[string]bool{}
+	for _, name := range overlay.Spec.Extends.RemoveApplications {
+		removed[name] = true
+	}
+	overlayApps := map[string]kfdeftypes.Application{}
+	for _, app := range overlay.Spec.Applications {
+		overlayApps[app.Name] = app
+	}
+	merged.Spec.Applications = nil
+	merging := map[string]bool{}
+	for _, app := range base.Spec.Applications {
+		if removed[app.Name] {
+			continue
+		}
+		if o, ok := overlayApps[app.Name]; ok {
+			merged.Spec.Applications = append(merged.Spec.Applications, mergeApplications(app, o))
+			merging[app.Name] = true
+			continue
+		}
+		merged.Spec.Applications = append(merged.Spec.Applications, *app.DeepCopy())
+	}
+	for _, app := range overlay.Spec.Applications {
+		if !merging[app.Name] {
+			merged.Spec.Applications = append(merged.Spec.Applications, *app.DeepCopy())
+		}
+	}
+
+	repos := append(append([]kfdeftypes.Repo{}, base.Spec.Repos...), overlay.Spec.Repos...)
+	merged.Spec.Repos = nil
+	for _, i := range mergeByName(len(repos), func(i int) string { return repos[i].Name }) {
+		merged.Spec.Repos = append(merged.Spec.Repos, *repos[i].DeepCopy())
+	}
+
+	secrets := append(append([]kfdeftypes.Secret{}, base.Spec.Secrets...), overlay.Spec.Secrets...)
+	merged.Spec.Secrets = nil
+	for _, i := range mergeByName(len(secrets), func(i int) string { return secrets[i].Name }) {
+		merged.Spec.Secrets = append(merged.Spec.Secrets, *secrets[i].DeepCopy())
+	}
+
+	plugins := append(append([]kfdeftypes.Plugin{}, base.Spec.Plugins...), overlay.Spec.Plugins...)
+	merged.Spec.Plugins = nil
+	for _, i := range mergeByName(len(plugins), func(i int) string { return plugins[i].Name }) {
+		merged.Spec.Plugins = append(merged.Spec.Plugins, *plugins[i].DeepCopy())
+	}
+
+	merged.Spec.GlobalParameters = mergeNameValues(base.Spec.GlobalParameters, overlay.Spec.GlobalParameters)
+	merged.Spec.Variables = mergeNameValues(base.Spec.Variables, overlay.Spec.Variables)
+	return merged
+}
+
+// mergeApplications merges the application overlay declares onto the application base of the same name:
+// the repo, overlays, enabled flag and when conditions overlay sets replace those of base, parameters and
+// images are merged by name, patches are appended to those of base and the apply policy overlay sets
+// replaces the one of base.
+func mergeApplications(base kfdeftypes.Application, overlay kfdeftypes.Application) kfdeftypes.Application {
+	merged := *base.DeepCopy()
+	if overlay.Enabled != nil {
+		enabled := *overlay.Enabled
+		merged.Enabled = &enabled
+	}
+	if overlay.When != nil {
+		merged.When = overlay.When.DeepCopy()
+	}
+	if overlay.KustomizeConfig == nil {
+		return merged
+	}
+	o := overlay.KustomizeConfig.DeepCopy()
+	if merged.KustomizeConfig == nil {
+		merged.KustomizeConfig = o
+		return merged
+	}
+	config := merged.KustomizeConfig
+	if o.RepoRef != nil {
+		config.RepoRef = o.RepoRef
+	}
+	if len(o.Overlays) > 0 {
+		config.Overlays = o.Overlays
+	}
+	config.Parameters = mergeNameValues(config.Parameters, o.Parameters)
+	config.PatchesStrategicMerge = append(config.PatchesStrategicMerge, o.PatchesStrategicMerge...)
+	config.PatchesJson6902 = append(config.PatchesJson6902, o.PatchesJson6902...)
+	images := append(config.Images, o.Images...)
+	config.Images = nil
+	for _, i := range mergeByName(len(images), func(i int) string { return images[i].Name }) {
+		config.Images = append(config.Images, images[i])
+	}
+	if !reflect.DeepEqual(o.ApplyPolicy, kfdeftypes.ApplyPolicy{}) {
+		config.ApplyPolicy = o.ApplyPolicy
+	}
+	return merged
+}
+
+// mergeNameValues returns the values of base, replaced by those of overlay of the same name, followed by the
+// other values of overlay.
+func mergeNameValues(base []kfdeftypes.NameValue, overlay []kfdeftypes.NameValue) []kfdeftypes.NameValue {
+	values := append(append([]kfdeftypes.NameValue{}, base...), overlay...)
+	var merged []kfdeftypes.NameValue
+	for _, i := range mergeByName(len(values), func(i int) string { return values[i].Name }) {
+		merged = append(merged, *values[i].DeepCopy())
+	}
+	return merged
+}
+
+// mergeByName returns the indexes of the entries to keep when merging a list of n entries by name, the name of
+// entry i being name(i): for each name, in the order it first appears, the index of its last entry. Entries of
+// an overlay appended to those of its base thus replace them in place.
+func mergeByName(n int, name func(i int) string) []int {
+	positions := map[string]int{}
+	var indexes []int
+	for i := 0; i < n; i++ {
+		if position, ok := positions[name(i)]; ok {
+			indexes[position] = i
+			continue
+		}
+		positions[name(i)] = len(indexes)
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// mergeStringMaps returns the entries of base and overlay, those of overlay taking precedence.
+func mergeStringMaps(base map[string]string, overlay map[string]string) map[string]string {
+	if len(base) == 0 && len(overlay) == 0 {
+		return overlay
+	}
+	merged := map[string]string{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		merged[k] = v
+	}
+	return merged
+}
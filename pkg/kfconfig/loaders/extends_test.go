@@ -0,0 +1,112 @@
+package loaders
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestLoadConfigFromURI_Extends(t *testing.T) {
+	wd, _ := os.Getwd()
+	config, err := LoadConfigFromURI(path.Join(wd, "testdata", "extends", "derived.yaml"))
+	if err != nil {
+		t.Fatalf("Error loading derived KfDef: %v", err)
+	}
+
+	names := []string{}
+	for _, app := range config.Spec.Applications {
+		names = append(names, app.Name)
+	}
+	if want := []string{"odh-common", "jupyterhub", "grafana"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Merged applications are %v; want %v", names, want)
+	}
+	jupyterhub := config.Spec.Applications[1].KustomizeConfig
+	if jupyterhub.RepoRef == nil || jupyterhub.RepoRef.Path != "jupyterhub/jupyterhub" {
+		t.Errorf("RepoRef of the base wasn't kept: %+v", jupyterhub.RepoRef)
+	}
+	if want := []string{"gpu"}; !reflect.DeepEqual(jupyterhub.Overlays, want) {
+		t.Errorf("Overlays are %v; want %v", jupyterhub.Overlays, want)
+	}
+	wantParams := []kfconfig.NameValue{
+		{Name: "s3_endpoint_url", Value: "s3.odh.com"},
+		{Name: "storage_class", Value: "gp2"},
+	}
+	if !reflect.DeepEqual(jupyterhub.Parameters, wantParams) {
+		t.Errorf("Parameters are %+v; want %+v", jupyterhub.Parameters, wantParams)
+	}
+	if len(config.Spec.Repos) != 1 || !strings.HasSuffix(config.Spec.Repos[0].URI, "v1.0") {
+		t.Errorf("Repo of the base wasn't replaced: %+v", config.Spec.Repos)
+	}
+	if config.Spec.Version != "v1.0" || len(config.Spec.GlobalParameters) != 1 {
+		t.Errorf("Version or global parameters of the base weren't kept: %v, %+v", config.Spec.Version, config.Spec.GlobalParameters)
+	}
+	if config.Name != "odh-staging" || config.Labels["tier"] != "base" || config.Labels["env"] != "staging" {
+		t.Errorf("Metadata wasn't merged: %v, %v", config.Name, config.Labels)
+	}
+	if config.Spec.ConfigFileName != "derived.merged.yaml" {
+		t.Errorf("ConfigFileName is %v; want derived.merged.yaml", config.Spec.ConfigFileName)
+	}
+}
+
+func TestLoadConfigFromURI_ExtendsConfigMap(t *testing.T) {
+	wd, _ := os.Getwd()
+	base, err := ioutil.ReadFile(path.Join(wd, "testdata", "extends", "base.yaml"))
+	if err != nil {
+		t.Fatalf("Error reading base KfDef: %v", err)
+	}
+	oldRead := readBaseConfigMap
+	readBaseConfigMap = func(namespace string, ref *v1.ConfigMapKeySelector) ([]byte, error) {
+		if namespace != "opendatahub" || ref.Name != "odh-base" || ref.Key != "kfdef.yaml" {
+			t.Errorf("Unexpected ConfigMap %v/%v key %v", namespace, ref.Name, ref.Key)
+		}
+		return base, nil
+	}
+	defer func() { readBaseConfigMap = oldRead }()
+
+	obj := map[string]interface{}{
+		"apiVersion": "kfdef.apps.kubeflow.org/v1",
+		"kind":       "KfDef",
+		"metadata":   map[string]interface{}{"name": "odh", "namespace": "opendatahub"},
+		"spec": map[string]interface{}{
+			"extends": map[string]interface{}{
+				"configMapKeyRef": map[string]interface{}{"name": "odh-base", "key": "kfdef.yaml"},
+			},
+		},
+	}
+	merged, err := resolveExtends(obj, "config.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error resolving base from ConfigMap: %v", err)
+	}
+	config, err := V1{}.LoadKfConfig(merged)
+	if err != nil {
+		t.Fatalf("Error converting merged KfDef: %v", err)
+	}
+	if len(config.Spec.Applications) != 3 || config.Namespace != "opendatahub" {
+		t.Errorf("Got %v applications in namespace %v; want the 3 of the base in opendatahub",
+			len(config.Spec.Applications), config.Namespace)
+	}
+
+	// A KfDef extending an optional base which doesn't exist extends an empty base.
+	base = nil
+	merged, err = resolveExtends(obj, "config.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error resolving a missing optional base: %v", err)
+	}
+	if config, err = (V1{}).LoadKfConfig(merged); err != nil || len(config.Spec.Applications) != 0 {
+		t.Errorf("Got config %+v (%v); want the KfDef alone", config, err)
+	}
+}
+
+func TestLoadConfigFromURI_ExtendsCycle(t *testing.T) {
+	wd, _ := os.Getwd()
+	_, err := LoadConfigFromURI(path.Join(wd, "testdata", "extends", "cycle.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "extends itself") {
+		t.Errorf("Expected a cycle error; got %v", err)
+	}
+}
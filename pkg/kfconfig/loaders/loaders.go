@@ -1,6 +1,7 @@
 package loaders
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -16,6 +17,7 @@ import (
 	"github.com/kubeflow/kfctl/v3/pkg/kfconfig"
 	"github.com/kubeflow/kfctl/v3/pkg/utils"
 	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
 )
 
 type Loader interface {
@@ -41,68 +43,18 @@ func isValidUrl(toTest string) bool {
 // It will set the AppDir and ConfigFilename in kfconfig:
 //   AppDir = cwd if configFile is remote, or it will be the dir of configFile.
 //   ConfigFilename = the file name of configFile.
+// A v1 KfDef extending a base KfDef is merged onto it; see mergeKfDefs. Its ConfigFilename is then
+// MergedConfigFileName(configFile) so that writing the merged KfDef back doesn't replace the extending one.
 func LoadConfigFromURI(configFile string) (*kfconfig.KfConfig, error) {
 	if configFile == "" {
 		return nil, fmt.Errorf("config file must be the URI of a KfDef spec")
 	}
 
-	isRemoteFile, err := utils.IsRemoteFile(configFile)
+	configFileBytes, isRemoteFile, err := readConfigFile(configFile)
 	if err != nil {
 		return nil, err
 	}
 
-	// appFile is configFile if configFile is local.
-	// Otherwise (configFile is remote), appFile points to a downloaded copy of configFile in tmp.
-	appFile := configFile
-	// If config is remote, download it to a temp dir.
-	if isRemoteFile {
-		// TODO(jlewi): We should check if configFile doesn't specify a protocol or the protocol
-		// is file:// then we can just read it rather than fetching with go-getter.
-		appDir, err := ioutil.TempDir("", "")
-		if err != nil {
-			return nil, fmt.Errorf("Create a temporary directory to copy the file to.")
-		}
-		// Open config file
-		//
-		// TODO(jlewi): Should we use hashicorp go-getter.GetAny here? We use that to download
-		// the tarballs for the repos. Maybe we should use that here as well to be consistent.
-		appFile = path.Join(appDir, "tmp_app.yaml")
-
-		log.Infof("Downloading %v to %v", configFile, appFile)
-		configFileUri, err := netUrl.Parse(configFile)
-		if err != nil {
-			log.Errorf("Could not parse configFile url")
-		}
-		if isValidUrl(configFile) {
-			errGet := gogetter.GetFile(appFile, configFile)
-			if errGet != nil {
-				return nil, &kfapis.KfError{
-					Code:    int(kfapis.INVALID_ARGUMENT),
-					Message: fmt.Sprintf("could not fetch specified config %s: %v", configFile, errGet),
-				}
-			}
-		} else {
-			g := new(gogetter.FileGetter)
-			g.Copy = true
-			errGet := g.GetFile(appFile, configFileUri)
-			if errGet != nil {
-				return nil, &kfapis.KfError{
-					Code:    int(kfapis.INVALID_ARGUMENT),
-					Message: fmt.Sprintf("could not fetch specified config %s: %v", configFile, err),
-				}
-			}
-		}
-	}
-
-	// Read contents
-	configFileBytes, err := ioutil.ReadFile(appFile)
-	if err != nil {
-		return nil, &kfapis.KfError{
-			Code:    int(kfapis.INTERNAL_ERROR),
-			Message: fmt.Sprintf("could not read from config file %s: %v", configFile, err),
-		}
-	}
-
 	// Check API version.
 	var obj map[string]interface{}
 	if err = yaml.Unmarshal(configFileBytes, &obj); err != nil {
@@ -126,6 +78,16 @@ func LoadConfigFromURI(configFile string) (*kfconfig.KfConfig, error) {
 		}
 	}
 
+	extends := false
+	if apiVersionSeparated[1] == "v1" {
+		if spec, ok := obj["spec"].(map[string]interface{}); ok && spec["extends"] != nil {
+			extends = true
+		}
+		if obj, err = resolveExtends(obj, configFile, nil); err != nil {
+			return nil, err
+		}
+	}
+
 	// Add this check because kfctl binary can not properly install Kubeflow using v1alpha1 configuration.
 	// See https://github.com/kubeflow/kubeflow/issues/4371.
 	if apiVersionSeparated[1] == "v1alpha1" {
@@ -177,9 +139,103 @@ func LoadConfigFromURI(configFile string) (*kfconfig.KfConfig, error) {
 		kfconfig.Spec.AppDir = filepath.Dir(configFile)
 	}
 	kfconfig.Spec.ConfigFileName = filepath.Base(configFile)
+	if extends {
+		kfconfig.Spec.ConfigFileName = MergedConfigFileName(kfconfig.Spec.ConfigFileName)
+	}
 	return kfconfig, nil
 }
 
+// MergedConfigFileName returns the name of the file the KfDef merged from the KfDef of fileName extending a
+// base is written to, e.g. kfdef.merged.yaml for kfdef.yaml.
+func MergedConfigFileName(fileName string) string {
+	ext := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + ".merged" + ext
+}
+
+// readConfigFile reads the contents of configFile, a remote URI or a local file, and tells whether it is remote.
+func readConfigFile(configFile string) ([]byte, bool, error) {
+	return readConfigFileWithCredentials(configFile, "", nil)
+}
+
+// readConfigFileWithCredentials is readConfigFile downloading http(s) URIs with the credentials of the Secret
+// of namespace credentialsSecret refers to, or else with those of the environment or netrc file of the user.
+func readConfigFileWithCredentials(configFile string, namespace string,
+	credentialsSecret *v1.LocalObjectReference) ([]byte, bool, error) {
+	isRemoteFile, err := utils.IsRemoteFile(configFile)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// appFile is configFile if configFile is local.
+	// Otherwise (configFile is remote), appFile points to a downloaded copy of configFile in tmp.
+	appFile := configFile
+	// If config is remote, download it to a temp dir.
+	if isRemoteFile {
+		// TODO(jlewi): We should check if configFile doesn't specify a protocol or the protocol
+		// is file:// then we can just read it rather than fetching with go-getter.
+		appDir, err := ioutil.TempDir("", "")
+		if err != nil {
+			return nil, false, fmt.Errorf("Create a temporary directory to copy the file to.")
+		}
+		// Open config file
+		//
+		// TODO(jlewi): Should we use hashicorp go-getter.GetAny here? We use that to download
+		// the tarballs for the repos. Maybe we should use that here as well to be consistent.
+		appFile = path.Join(appDir, "tmp_app.yaml")
+
+		log.Infof("Downloading %v to %v", configFile, appFile)
+		configFileUri, err := netUrl.Parse(configFile)
+		if err != nil {
+			log.Errorf("Could not parse configFile url")
+		}
+		if isValidUrl(configFile) {
+			errGet := getConfigFile(appFile, configFile, namespace, credentialsSecret)
+			if errGet != nil {
+				return nil, false, &kfapis.KfError{
+					Code:    int(kfapis.INVALID_ARGUMENT),
+					Message: fmt.Sprintf("could not fetch specified config %s: %v", configFile, errGet),
+				}
+			}
+		} else {
+			g := new(gogetter.FileGetter)
+			g.Copy = true
+			errGet := g.GetFile(appFile, configFileUri)
+			if errGet != nil {
+				return nil, false, &kfapis.KfError{
+					Code:    int(kfapis.INVALID_ARGUMENT),
+					Message: fmt.Sprintf("could not fetch specified config %s: %v", configFile, err),
+				}
+			}
+		}
+	}
+
+	// Read contents
+	configFileBytes, err := ioutil.ReadFile(appFile)
+	if err != nil {
+		return nil, false, &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("could not read from config file %s: %v", configFile, err),
+		}
+	}
+	return configFileBytes, isRemoteFile, nil
+}
+
+// getConfigFile downloads configFile to appFile, with credentials for http(s) URIs if there are any.
+func getConfigFile(appFile string, configFile string, namespace string, credentialsSecret *v1.LocalObjectReference) error {
+	creds, err := kfconfig.ReadCredentials(namespace, credentialsSecret, configFile)
+	if err != nil {
+		return err
+	}
+	if creds == nil {
+		return gogetter.GetFile(appFile, configFile)
+	}
+	contents, err := kfconfig.Download(context.Background(), configFile, creds)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(appFile, contents, 0644)
+}
+
 func isCwdEmpty() string {
 	cwd, _ := os.Getwd()
 	files, _ := ioutil.ReadDir(cwd)
@@ -203,6 +259,23 @@ func WriteConfigToFile(config kfconfig.KfConfig) error {
 		}
 	}
 	filename := filepath.Join(config.Spec.AppDir, config.Spec.ConfigFileName)
+	kfdefBytes, err := MarshalKfDef(config)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filename, kfdefBytes, 0644)
+	if err != nil {
+		return &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("error when writing KfDef: %v", err),
+		}
+	}
+	return nil
+}
+
+// MarshalKfDef returns config as the YAML of a KfDef of the apiVersion of config.
+func MarshalKfDef(config kfconfig.KfConfig) ([]byte, error) {
 	converters := map[string]Loader{
 		"v1alpha1": V1alpha1{},
 		"v1beta1":  V1beta1{},
@@ -210,7 +283,7 @@ func WriteConfigToFile(config kfconfig.KfConfig) error {
 	}
 	apiVersionSeparated := strings.Split(config.APIVersion, "/")
 	if len(apiVersionSeparated) < 2 || apiVersionSeparated[0] != Api {
-		return &kfapis.KfError{
+		return nil, &kfapis.KfError{
 			Code:    int(kfapis.INVALID_ARGUMENT),
 			Message: fmt.Sprintf("invalid config: apiVersion must be in the format of %v/<version>, got %v", Api, config.APIVersion),
 		}
@@ -218,7 +291,7 @@ func WriteConfigToFile(config kfconfig.KfConfig) error {
 
 	converter, ok := converters[apiVersionSeparated[1]]
 	if !ok {
-		return &kfapis.KfError{
+		return nil, &kfapis.KfError{
 			Code:    int(kfapis.INVALID_ARGUMENT),
 			Message: fmt.Sprintf("invalid config: unable to find converter for version %v", config.APIVersion),
 		}
@@ -226,25 +299,17 @@ func WriteConfigToFile(config kfconfig.KfConfig) error {
 
 	var kfdef interface{}
 	if err := converter.LoadKfDef(config, &kfdef); err != nil {
-		return &kfapis.KfError{
+		return nil, &kfapis.KfError{
 			Code:    int(kfapis.INVALID_ARGUMENT),
 			Message: fmt.Sprintf("error when loading KfDef: %v", err),
 		}
 	}
 	kfdefBytes, err := yaml.Marshal(kfdef)
 	if err != nil {
-		return &kfapis.KfError{
+		return nil, &kfapis.KfError{
 			Code:    int(kfapis.INVALID_ARGUMENT),
 			Message: fmt.Sprintf("error when marshaling KfDef: %v", err),
 		}
 	}
-
-	err = ioutil.WriteFile(filename, kfdefBytes, 0644)
-	if err != nil {
-		return &kfapis.KfError{
-			Code:    int(kfapis.INTERNAL_ERROR),
-			Message: fmt.Sprintf("error when writing KfDef: %v", err),
-		}
-	}
-	return nil
+	return kfdefBytes, nil
 }
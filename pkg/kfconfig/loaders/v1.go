@@ -47,7 +47,12 @@ func (v V1) LoadKfConfig(def interface{}) (*kfconfig.KfConfig, error) {
 	config.Spec.Version = kfdef.Spec.Version
 	for _, app := range kfdef.Spec.Applications {
 		application := kfconfig.Application{
-			Name: app.Name,
+			Name:    app.Name,
+			Enabled: app.Enabled,
+		}
+		if app.When != nil {
+			when := kfconfig.ApplicationConditions(*app.When)
+			application.When = &when
 		}
 		if app.KustomizeConfig != nil {
 			kconfig := &kfconfig.KustomizeConfig{
@@ -66,17 +71,39 @@ func (v V1) LoadKfConfig(def interface{}) (*kfconfig.KfConfig, error) {
 				}
 			}
 			for _, param := range app.KustomizeConfig.Parameters {
-				p := kfconfig.NameValue{
-					Name:  param.Name,
-					Value: param.Value,
-				}
-				kconfig.Parameters = append(kconfig.Parameters, p)
+				kconfig.Parameters = append(kconfig.Parameters, nameValueToKfConfig(param))
+			}
+			kconfig.PatchesStrategicMerge = app.KustomizeConfig.PatchesStrategicMerge
+			for _, patch := range app.KustomizeConfig.PatchesJson6902 {
+				kconfig.PatchesJson6902 = append(kconfig.PatchesJson6902, kfconfig.PatchJson6902{
+					Target: kfconfig.PatchTarget(patch.Target),
+					Patch:  patch.Patch,
+				})
+			}
+			for _, image := range app.KustomizeConfig.Images {
+				kconfig.Images = append(kconfig.Images, kfconfig.Image(image))
 			}
+			kconfig.ApplyPolicy = applyPolicyToKfConfig(app.KustomizeConfig.ApplyPolicy)
 			application.KustomizeConfig = kconfig
 		}
 		config.Spec.Applications = append(config.Spec.Applications, application)
 	}
 
+	if kfdef.Spec.ApplyPolicy != nil {
+		policy := applyPolicyToKfConfig(*kfdef.Spec.ApplyPolicy)
+		config.Spec.ApplyPolicy = &policy
+	}
+	if kfdef.Spec.ImageOverrides != nil {
+		overrides := kfconfig.ImageOverrides(*kfdef.Spec.ImageOverrides)
+		config.Spec.ImageOverrides = &overrides
+	}
+	for _, param := range kfdef.Spec.GlobalParameters {
+		config.Spec.GlobalParameters = append(config.Spec.GlobalParameters, nameValueToKfConfig(param))
+	}
+	for _, variable := range kfdef.Spec.Variables {
+		config.Spec.Variables = append(config.Spec.Variables, nameValueToKfConfig(variable))
+	}
+
 	for _, plugin := range kfdef.Spec.Plugins {
 		p := kfconfig.Plugin{
 			Name:      plugin.Name,
@@ -125,6 +152,13 @@ func (v V1) LoadKfConfig(def interface{}) (*kfconfig.KfConfig, error) {
 				Name: secret.SecretSource.EnvSource.Name,
 			}
 		}
+		if secret.SecretSource.SecretKeyRef != nil {
+			src.SecretKeyRef = secret.SecretSource.SecretKeyRef.DeepCopy()
+		}
+		if secret.SecretSource.Generated != nil {
+			generated := kfconfig.GeneratedSource(*secret.SecretSource.Generated)
+			src.Generated = &generated
+		}
 		s.SecretSource = src
 		config.Spec.Secrets = append(config.Spec.Secrets, s)
 	}
@@ -134,6 +168,34 @@ func (v V1) LoadKfConfig(def interface{}) (*kfconfig.KfConfig, error) {
 			Name: repo.Name,
 			URI:  repo.URI,
 		}
+		if repo.Git != nil {
+			r.Git = &kfconfig.GitSource{
+				URL:    repo.Git.URL,
+				Ref:    repo.Git.Ref,
+				SubDir: repo.Git.SubDir,
+			}
+		}
+		if repo.PullSecret != nil {
+			pullSecret := *repo.PullSecret
+			r.PullSecret = &pullSecret
+		}
+		if repo.CredentialsSecret != nil {
+			credentialsSecret := *repo.CredentialsSecret
+			r.CredentialsSecret = &credentialsSecret
+		}
+		r.SHA256 = repo.SHA256
+		if repo.Signature != nil {
+			r.Signature = &kfconfig.RepoSignature{
+				URI:       repo.Signature.URI,
+				PublicKey: repo.Signature.PublicKey,
+			}
+		}
+		r.Refresh = kfconfig.RefreshPolicy(repo.Refresh)
+		if repo.Timeout != nil {
+			timeout := *repo.Timeout
+			r.Timeout = &timeout
+		}
+		r.Attempts = repo.Attempts
 		config.Spec.Repos = append(config.Spec.Repos, r)
 	}
 
@@ -150,11 +212,31 @@ func (v V1) LoadKfConfig(def interface{}) (*kfconfig.KfConfig, error) {
 	}
 	for _, cache := range kfdef.Status.ReposCache {
 		c := kfconfig.Cache{
-			Name:      cache.Name,
-			LocalPath: cache.LocalPath,
+			Name:         cache.Name,
+			LocalPath:    cache.LocalPath,
+			Ref:          cache.Ref,
+			Commit:       cache.Commit,
+			Digest:       cache.Digest,
+			Verified:     cache.Verified,
+			Source:       cache.Source,
+			ETag:         cache.ETag,
+			LastModified: cache.LastModified,
+			FetchedAt:    cache.FetchedAt.DeepCopy(),
 		}
 		config.Status.Caches = append(config.Status.Caches, c)
 	}
+	for _, app := range kfdef.Status.Applications {
+		config.Status.Applications = append(config.Status.Applications, kfconfig.ApplicationStatus{
+			Name:     app.Name,
+			Hash:     app.Hash,
+			Disabled: app.Disabled,
+			Skipped:  app.Skipped,
+			Reason:   app.Reason,
+		})
+	}
+	for _, variable := range kfdef.Status.Variables {
+		config.Status.Variables = append(config.Status.Variables, nameValueToKfConfig(variable))
+	}
 
 	return config, nil
 }
@@ -172,7 +254,12 @@ func (v V1) LoadKfDef(config kfconfig.KfConfig, out interface{}) error {
 
 	for _, app := range config.Spec.Applications {
 		application := kfdeftypes.Application{
-			Name: app.Name,
+			Name:    app.Name,
+			Enabled: app.Enabled,
+		}
+		if app.When != nil {
+			when := kfdeftypes.ApplicationConditions(*app.When)
+			application.When = &when
 		}
 		if app.KustomizeConfig != nil {
 			kconfig := &kfdeftypes.KustomizeConfig{
@@ -186,17 +273,39 @@ func (v V1) LoadKfDef(config kfconfig.KfConfig, out interface{}) error {
 				kconfig.RepoRef = kref
 			}
 			for _, param := range app.KustomizeConfig.Parameters {
-				p := kfdeftypes.NameValue{
-					Name:  param.Name,
-					Value: param.Value,
-				}
-				kconfig.Parameters = append(kconfig.Parameters, p)
+				kconfig.Parameters = append(kconfig.Parameters, nameValueToKfDef(param))
 			}
+			kconfig.PatchesStrategicMerge = app.KustomizeConfig.PatchesStrategicMerge
+			for _, patch := range app.KustomizeConfig.PatchesJson6902 {
+				kconfig.PatchesJson6902 = append(kconfig.PatchesJson6902, kfdeftypes.PatchJson6902{
+					Target: kfdeftypes.PatchTarget(patch.Target),
+					Patch:  patch.Patch,
+				})
+			}
+			for _, image := range app.KustomizeConfig.Images {
+				kconfig.Images = append(kconfig.Images, kfdeftypes.Image(image))
+			}
+			kconfig.ApplyPolicy = applyPolicyToKfDef(app.KustomizeConfig.ApplyPolicy)
 			application.KustomizeConfig = kconfig
 		}
 		kfdef.Spec.Applications = append(kfdef.Spec.Applications, application)
 	}
 
+	if config.Spec.ApplyPolicy != nil {
+		policy := applyPolicyToKfDef(*config.Spec.ApplyPolicy)
+		kfdef.Spec.ApplyPolicy = &policy
+	}
+	if config.Spec.ImageOverrides != nil {
+		overrides := kfdeftypes.ImageOverrides(*config.Spec.ImageOverrides)
+		kfdef.Spec.ImageOverrides = &overrides
+	}
+	for _, param := range config.Spec.GlobalParameters {
+		kfdef.Spec.GlobalParameters = append(kfdef.Spec.GlobalParameters, nameValueToKfDef(param))
+	}
+	for _, variable := range config.Spec.Variables {
+		kfdef.Spec.Variables = append(kfdef.Spec.Variables, nameValueToKfDef(variable))
+	}
+
 	for _, plugin := range config.Spec.Plugins {
 		p := kfdeftypes.Plugin{
 			Spec: plugin.Spec,
@@ -218,6 +327,14 @@ func (v V1) LoadKfDef(config kfconfig.KfConfig, out interface{}) error {
 					Name: secret.SecretSource.EnvSource.Name,
 				}
 			}
+			// Unlike literals, references to Kubernetes Secrets are safe to store.
+			if secret.SecretSource.SecretKeyRef != nil {
+				s.SecretSource.SecretKeyRef = secret.SecretSource.SecretKeyRef.DeepCopy()
+			}
+			if secret.SecretSource.Generated != nil {
+				generated := kfdeftypes.GeneratedSource(*secret.SecretSource.Generated)
+				s.SecretSource.Generated = &generated
+			}
 		}
 		kfdef.Spec.Secrets = append(kfdef.Spec.Secrets, s)
 	}
@@ -227,6 +344,34 @@ func (v V1) LoadKfDef(config kfconfig.KfConfig, out interface{}) error {
 			Name: repo.Name,
 			URI:  repo.URI,
 		}
+		if repo.Git != nil {
+			r.Git = &kfdeftypes.GitSource{
+				URL:    repo.Git.URL,
+				Ref:    repo.Git.Ref,
+				SubDir: repo.Git.SubDir,
+			}
+		}
+		if repo.PullSecret != nil {
+			pullSecret := *repo.PullSecret
+			r.PullSecret = &pullSecret
+		}
+		if repo.CredentialsSecret != nil {
+			credentialsSecret := *repo.CredentialsSecret
+			r.CredentialsSecret = &credentialsSecret
+		}
+		r.SHA256 = repo.SHA256
+		if repo.Signature != nil {
+			r.Signature = &kfdeftypes.RepoSignature{
+				URI:       repo.Signature.URI,
+				PublicKey: repo.Signature.PublicKey,
+			}
+		}
+		r.Refresh = kfdeftypes.RefreshPolicy(repo.Refresh)
+		if repo.Timeout != nil {
+			timeout := *repo.Timeout
+			r.Timeout = &timeout
+		}
+		r.Attempts = repo.Attempts
 		kfdef.Spec.Repos = append(kfdef.Spec.Repos, r)
 	}
 
@@ -244,11 +389,31 @@ func (v V1) LoadKfDef(config kfconfig.KfConfig, out interface{}) error {
 
 	for _, cache := range config.Status.Caches {
 		c := kfdeftypes.RepoCache{
-			Name:      cache.Name,
-			LocalPath: cache.LocalPath,
+			Name:         cache.Name,
+			LocalPath:    cache.LocalPath,
+			Ref:          cache.Ref,
+			Commit:       cache.Commit,
+			Digest:       cache.Digest,
+			Verified:     cache.Verified,
+			Source:       cache.Source,
+			ETag:         cache.ETag,
+			LastModified: cache.LastModified,
+			FetchedAt:    cache.FetchedAt.DeepCopy(),
 		}
 		kfdef.Status.ReposCache = append(kfdef.Status.ReposCache, c)
 	}
+	for _, app := range config.Status.Applications {
+		kfdef.Status.Applications = append(kfdef.Status.Applications, kfdeftypes.ApplicationStatus{
+			Name:     app.Name,
+			Hash:     app.Hash,
+			Disabled: app.Disabled,
+			Skipped:  app.Skipped,
+			Reason:   app.Reason,
+		})
+	}
+	for _, variable := range config.Status.Variables {
+		kfdef.Status.Variables = append(kfdef.Status.Variables, nameValueToKfDef(variable))
+	}
 
 	kfdefBytes, err := yaml.Marshal(kfdef)
 	if err != nil {
@@ -268,3 +433,57 @@ func (v V1) LoadKfDef(config kfconfig.KfConfig, out interface{}) error {
 		}
 	}
 }
+
+func applyPolicyToKfConfig(policy kfdeftypes.ApplyPolicy) kfconfig.ApplyPolicy {
+	p := kfconfig.ApplyPolicy{
+		Timeout: policy.Timeout,
+		Wait:    kfconfig.WaitPolicy(policy.Wait),
+	}
+	if policy.Retry != nil {
+		p.Retry = &kfconfig.RetryPolicy{
+			MaxAttempts:     policy.Retry.MaxAttempts,
+			InitialInterval: policy.Retry.InitialInterval,
+			MaxInterval:     policy.Retry.MaxInterval,
+		}
+	}
+	return p
+}
+
+func applyPolicyToKfDef(policy kfconfig.ApplyPolicy) kfdeftypes.ApplyPolicy {
+	p := kfdeftypes.ApplyPolicy{
+		Timeout: policy.Timeout,
+		Wait:    kfdeftypes.WaitPolicy(policy.Wait),
+	}
+	if policy.Retry != nil {
+		p.Retry = &kfdeftypes.RetryPolicy{
+			MaxAttempts:     policy.Retry.MaxAttempts,
+			InitialInterval: policy.Retry.InitialInterval,
+			MaxInterval:     policy.Retry.MaxInterval,
+		}
+	}
+	return p
+}
+
+func nameValueToKfConfig(param kfdeftypes.NameValue) kfconfig.NameValue {
+	p := kfconfig.NameValue{
+		Name:  param.Name,
+		Value: param.Value,
+	}
+	if param.ValueFrom != nil {
+		source := kfconfig.ValueSource(*param.ValueFrom.DeepCopy())
+		p.ValueFrom = &source
+	}
+	return p
+}
+
+func nameValueToKfDef(param kfconfig.NameValue) kfdeftypes.NameValue {
+	p := kfdeftypes.NameValue{
+		Name:  param.Name,
+		Value: param.Value,
+	}
+	if param.ValueFrom != nil {
+		source := kfdeftypes.ValueSource(*param.ValueFrom.DeepCopy())
+		p.ValueFrom = &source
+	}
+	return p
+}
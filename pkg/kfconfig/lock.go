@@ -0,0 +1,248 @@
+package kfconfig
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// LockFile is the file of the app directory kfctl build writes the lock of a KfDef to, and kfctl apply
+	// --locked reads it from.
+	LockFile = "kfdef.lock"
+	// LockVersion is the version of the format of the locks kfctl writes and reads.
+	LockVersion = "v1"
+)
+
+// Lock pins the repos a KfDef is built from and the images it renders to the versions they had when it was
+// created, so that the KfDef is built the same way whenever and wherever it's applied.
+type Lock struct {
+	Version string        `json:"version"`
+	Repos   []LockedRepo  `json:"repos,omitempty"`
+	Images  []LockedImage `json:"images,omitempty"`
+}
+
+// LockedRepo pins a repo: git repos to a commit, OCI artifacts to the digest of their manifest and archives to
+// their sha256 digest.
+type LockedRepo struct {
+	Name string `json:"name"`
+	// Source is the URI, or git URL, the repo was fetched from.
+	Source string `json:"source"`
+	Commit string `json:"commit,omitempty"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// LockedImage pins an image of the manifests to the digest of the image it was rendered as.
+type LockedImage struct {
+	// Reference is the image as the manifests refer to it.
+	Reference string `json:"reference"`
+	// Image is the image Reference was rendered as, once rewritten by the imageOverrides of the KfDef.
+	Image  string `json:"image"`
+	Digest string `json:"digest"`
+}
+
+// Pinned returns the image i is rendered as, by its digest.
+func (i LockedImage) Pinned() string {
+	name := i.Image
+	if at := strings.Index(name, "@"); at >= 0 {
+		name = name[:at]
+	}
+	// A colon before the last slash separates the port of the registry, not a tag.
+	if colon := strings.LastIndex(name, ":"); colon > strings.LastIndex(name, "/") {
+		name = name[:colon]
+	}
+	return name + "@" + i.Digest
+}
+
+// CreateLock returns the lock of the repos of c, as they were last synced, and of images, which maps the images
+// of the manifests to the images they're rendered as. Images not rendered by digest are resolved to the digest
+// their tag points to in their registry.
+func (c *KfConfig) CreateLock(ctx context.Context, images map[string]string) (*Lock, error) {
+	lock := &Lock{Version: LockVersion}
+	for _, r := range c.Spec.Repos {
+		repo, err := c.lockRepo(r)
+		if err != nil {
+			return nil, err
+		}
+		lock.Repos = append(lock.Repos, repo)
+	}
+
+	references := []string{}
+	for reference := range images {
+		references = append(references, reference)
+	}
+	sort.Strings(references)
+	digests := map[string]string{}
+	for _, reference := range references {
+		image := images[reference]
+		if _, ok := digests[image]; !ok {
+			digest, err := c.imageDigest(ctx, image)
+			if err != nil {
+				return nil, &kfapis.KfError{
+					Code:    int(kfapis.INVALID_ARGUMENT),
+					Message: fmt.Sprintf("couldn't resolve the digest of image %v: %v", image, err),
+				}
+			}
+			digests[image] = digest
+		}
+		lock.Images = append(lock.Images, LockedImage{Reference: reference, Image: image, Digest: digests[image]})
+	}
+	return lock, nil
+}
+
+// lockRepo returns the pin of r at the version its cache was synced at.
+func (c *KfConfig) lockRepo(r Repo) (LockedRepo, error) {
+	source := r.URI
+	if r.Git != nil {
+		source = r.Git.URL
+	}
+	cache, ok := c.getCache(r.Name)
+	if !ok || !sameSource(cache, source) {
+		return LockedRepo{}, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("repo %v hasn't been synced from %v", r.Name, source),
+		}
+	}
+	repo := LockedRepo{Name: r.Name, Source: source}
+	switch {
+	case r.Git != nil:
+		repo.Commit = cache.Commit
+	default:
+		repo.Digest = cache.Digest
+	}
+	if repo.Commit == "" && repo.Digest == "" {
+		return LockedRepo{}, &kfapis.KfError{
+			Code: int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("repo %v can't be locked: only archives, git repos and OCI artifacts can, "+
+				"not local directories", r.Name),
+		}
+	}
+	return repo, nil
+}
+
+// imageDigest returns the digest of image: the one it's pinned to, or else the one its tag points to.
+func (c *KfConfig) imageDigest(ctx context.Context, image string) (string, error) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.digest != "" {
+		return ref.digest, nil
+	}
+	client, err := c.imageClient(ref)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Resolving the digest of image %v", image)
+	return client.manifestDigest(ctx, ref, imageManifestAccept)
+}
+
+// ParseLock parses the lock data holds.
+func ParseLock(data []byte) (*Lock, error) {
+	lock := &Lock{}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't parse lock: %v", err),
+		}
+	}
+	if lock.Version != LockVersion {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("lock has version %q; only %v is supported", lock.Version, LockVersion),
+		}
+	}
+	return lock, nil
+}
+
+// Marshal returns l as YAML.
+func (l *Lock) Marshal() ([]byte, error) {
+	return yaml.Marshal(l)
+}
+
+// pinRepos returns repos pinned to the versions l locks them at. Every repo must be locked, from the source it
+// has, and l must lock no other repo.
+func (l *Lock) pinRepos(repos []Repo) ([]Repo, error) {
+	locked := map[string]LockedRepo{}
+	for _, repo := range l.Repos {
+		locked[repo.Name] = repo
+	}
+	pinned := []Repo{}
+	for _, r := range repos {
+		repo, ok := locked[r.Name]
+		if !ok {
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("repo %v isn't locked", r.Name),
+			}
+		}
+		delete(locked, r.Name)
+		r = *r.DeepCopy()
+		source := r.URI
+		if r.Git != nil {
+			source = r.Git.URL
+		}
+		if repo.Source != source {
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("repo %v is locked from %v but is fetched from %v", r.Name, repo.Source, source),
+			}
+		}
+		switch {
+		case r.Git != nil && repo.Commit != "":
+			r.Git.Ref = repo.Commit
+		case strings.HasPrefix(r.URI, OCIScheme) && repo.Digest != "":
+			if at := strings.Index(r.URI, "@"); at >= 0 {
+				r.URI = r.URI[:at]
+			}
+			r.URI += "@" + repo.Digest
+		case r.Git == nil && !strings.HasPrefix(r.URI, OCIScheme) && strings.HasPrefix(repo.Digest, sha256DigestPrefix):
+			r.SHA256 = strings.TrimPrefix(repo.Digest, sha256DigestPrefix)
+		default:
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("repo %v has no version locked", r.Name),
+			}
+		}
+		pinned = append(pinned, r)
+	}
+	for _, repo := range l.Repos {
+		if _, ok := locked[repo.Name]; ok {
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("repo %v is locked but isn't a repo of the KfDef", repo.Name),
+			}
+		}
+	}
+	return pinned, nil
+}
+
+// Image returns the image reference, as the manifests refer to it, is rendered as by its locked digest, and
+// whether l locks it at all.
+func (l *Lock) Image(reference string) (string, bool) {
+	for _, image := range l.Images {
+		if image.Reference == reference {
+			return image.Pinned(), true
+		}
+	}
+	return "", false
+}
+
+type lockKey struct{}
+
+// WithLock returns a copy of ctx that carries lock. Repos synced and manifests rendered with the returned context
+// are pinned to the versions lock locks them at, and fail if they can't be.
+func WithLock(ctx context.Context, lock *Lock) context.Context {
+	return context.WithValue(ctx, lockKey{}, lock)
+}
+
+// LockFrom returns the lock ctx carries, or nil if it carries none.
+func LockFrom(ctx context.Context) *Lock {
+	lock, _ := ctx.Value(lockKey{}).(*Lock)
+	return lock
+}
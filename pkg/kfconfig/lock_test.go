@@ -0,0 +1,150 @@
+package kfconfig
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-lock")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	defer os.Setenv(SharedCacheDirEnv, os.Getenv(SharedCacheDirEnv))
+	os.Setenv(SharedCacheDirEnv, path.Join(testDir, "cache"))
+
+	// The server serves the archive of version, as a branch tarball moving along would.
+	version := "v1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(testArchive(version))
+	}))
+	defer server.Close()
+	registry := newFakeRegistry()
+	defer registry.server.Close()
+	host := strings.TrimPrefix(registry.server.URL, "http://")
+	dockerConfig := fmt.Sprintf(`{"auths": {"%v": {"auth": "a2ZjdGw6c2VjcmV0"}}}`, host)
+	if err := ioutil.WriteFile(path.Join(testDir, "config.json"), []byte(dockerConfig), 0600); err != nil {
+		t.Fatalf("Failed to write docker config; %v", err)
+	}
+	defer os.Setenv(dockerConfigDirEnv, os.Getenv(dockerConfigDirEnv))
+	os.Setenv(dockerConfigDirEnv, testDir)
+	registry.pushImage(t, "v1")
+
+	newConfig := func(app string) *KfConfig {
+		return &KfConfig{
+			Spec: KfConfigSpec{
+				AppDir: path.Join(testDir, app),
+				Repos:  []Repo{{Name: "manifests", URI: server.URL + "/master.tar.gz"}},
+			},
+		}
+	}
+	config := newConfig("app")
+	if err := config.SyncCache(); err != nil {
+		t.Fatalf("Could not sync cache; %v", err)
+	}
+
+	image := host + "/manifests:v1"
+	pinned := "gcr.io/kubeflow-images-public/busybox@sha256:" + strings.Repeat("a", 64)
+	lock, err := config.CreateLock(context.Background(), map[string]string{"notebook:v1": image, pinned: pinned})
+	if err != nil {
+		t.Fatalf("Could not create lock; %v", err)
+	}
+	expected := &Lock{
+		Version: LockVersion,
+		Repos:   []LockedRepo{{Name: "manifests", Source: server.URL + "/master.tar.gz", Digest: sha256Digest(testArchive("v1"))}},
+		Images: []LockedImage{
+			{Reference: pinned, Image: pinned, Digest: "sha256:" + strings.Repeat("a", 64)},
+			{Reference: "notebook:v1", Image: image, Digest: sha256Digest(registry.manifests["v1"])},
+		},
+	}
+	if !reflect.DeepEqual(lock, expected) {
+		t.Fatalf("Got lock %+v; want %+v", lock, expected)
+	}
+	lockBytes, err := lock.Marshal()
+	if err != nil {
+		t.Fatalf("Could not marshal lock; %v", err)
+	}
+	parsed, err := ParseLock(lockBytes)
+	if err != nil || !reflect.DeepEqual(parsed, lock) {
+		t.Fatalf("Got parsed lock %+v (%v); want %+v", parsed, err, lock)
+	}
+	if actual, ok := parsed.Image("notebook:v1"); !ok || actual != host+"/manifests@"+expected.Images[1].Digest {
+		t.Errorf("Got image %v (%v); want it pinned to %v", actual, ok, expected.Images[1].Digest)
+	}
+	if _, ok := parsed.Image("notebook:v2"); ok {
+		t.Errorf("Expected notebook:v2 not to be locked")
+	}
+
+	// Once the branch moved, the locked version is still taken from the shared cache.
+	version = "v2"
+	locked := newConfig("locked")
+	if err := locked.SyncCacheContext(WithLock(context.Background(), parsed)); err != nil {
+		t.Fatalf("Could not sync cache with the lock; %v", err)
+	}
+	content, err := ioutil.ReadFile(path.Join(locked.Status.Caches[0].LocalPath, "version"))
+	if err != nil || string(content) != "v1" {
+		t.Errorf("Got content %q (%v) with the lock; want v1", content, err)
+	}
+	if spec := locked.Spec.Repos[0]; spec.SHA256 != "" {
+		t.Errorf("Expected the lock to leave the spec alone; got sha256 %v", spec.SHA256)
+	}
+
+	// Without it, it's downloaded again and no longer matches the lock.
+	os.Setenv(SharedCacheDirEnv, path.Join(testDir, "other-cache"))
+	if err := newConfig("moved").SyncCacheContext(WithLock(context.Background(), parsed)); err == nil {
+		t.Errorf("Expected an error syncing the moved branch with the lock")
+	}
+}
+
+func TestPinRepos(t *testing.T) {
+	commit := strings.Repeat("c", 40)
+	digest := "sha256:" + strings.Repeat("d", 64)
+	lock := &Lock{
+		Version: LockVersion,
+		Repos: []LockedRepo{
+			{Name: "git", Source: "https://github.com/kubeflow/manifests.git", Commit: commit},
+			{Name: "oci", Source: "oci://registry.example.com/manifests:v1", Digest: digest},
+			{Name: "archive", Source: "https://example.com/master.tar.gz", Digest: digest},
+		},
+	}
+	repos := []Repo{
+		{Name: "git", Git: &GitSource{URL: "https://github.com/kubeflow/manifests.git", Ref: "master"}},
+		{Name: "oci", URI: "oci://registry.example.com/manifests:v1"},
+		{Name: "archive", URI: "https://example.com/master.tar.gz"},
+	}
+	pinned, err := lock.pinRepos(repos)
+	if err != nil {
+		t.Fatalf("Could not pin repos; %v", err)
+	}
+	expected := []Repo{
+		{Name: "git", Git: &GitSource{URL: "https://github.com/kubeflow/manifests.git", Ref: commit}},
+		{Name: "oci", URI: "oci://registry.example.com/manifests:v1@" + digest},
+		{Name: "archive", URI: "https://example.com/master.tar.gz", SHA256: strings.Repeat("d", 64)},
+	}
+	if !reflect.DeepEqual(pinned, expected) {
+		t.Errorf("Got repos %+v; want %+v", pinned, expected)
+	}
+	if repos[0].Git.Ref != "master" {
+		t.Errorf("Expected the repos to be left alone; got ref %v", repos[0].Git.Ref)
+	}
+
+	cases := map[string][]Repo{
+		"missing":  append(repos, Repo{Name: "other", URI: "https://example.com/other.tar.gz"}),
+		"unlisted": repos[:2],
+		"moved":    {repos[0], repos[1], {Name: "archive", URI: "https://example.com/v2.tar.gz"}},
+	}
+	for name, repos := range cases {
+		if _, err := lock.pinRepos(repos); err == nil {
+			t.Errorf("%v: expected an error pinning the repos", name)
+		}
+	}
+}
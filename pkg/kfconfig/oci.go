@@ -0,0 +1,500 @@
+package kfconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/homedir"
+)
+
+const (
+	// OCIScheme is the scheme of the URIs of repos published as OCI artifacts,
+	// e.g. oci://quay.io/opendatahub/manifests:v1.0@sha256:<digest>.
+	OCIScheme = "oci://"
+
+	ociManifestMediaType       = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType    = "application/vnd.docker.distribution.manifest.v2+json"
+	ociLayerMediaType          = "application/vnd.oci.image.layer.v1.tar+gzip"
+	dockerLayerMediaType       = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	dockerContentDigestHeader  = "Docker-Content-Digest"
+	sha256DigestPrefix         = "sha256:"
+	defaultOCITag              = "latest"
+	dockerConfigFileName       = "config.json"
+	dockerConfigDirEnv         = "DOCKER_CONFIG"
+	registryAuthenticateHeader = "Www-Authenticate"
+	ociUserAgent               = "kfctl"
+)
+
+// authChallengeParam matches the parameters of a WWW-Authenticate challenge, e.g. realm="https://auth.io/token".
+var authChallengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// ociReference is a parsed oci:// URI.
+type ociReference struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+// parseOCIReference parses uri of the form oci://<registry>/<repository>[:<tag>][@<digest>].
+// The tag defaults to latest; when a digest is given it is what's pulled and the tag is informative.
+func parseOCIReference(uri string) (*ociReference, error) {
+	rest := strings.TrimPrefix(uri, OCIScheme)
+	slash := strings.Index(rest, "/")
+	if slash <= 0 || slash == len(rest)-1 {
+		return nil, fmt.Errorf("%v isn't of the form %v<registry>/<repository>[:<tag>][@<digest>]", uri, OCIScheme)
+	}
+	ref := &ociReference{registry: rest[:slash]}
+	rest = rest[slash+1:]
+	if at := strings.Index(rest, "@"); at >= 0 {
+		ref.digest = rest[at+1:]
+		rest = rest[:at]
+		if !strings.HasPrefix(ref.digest, sha256DigestPrefix) {
+			return nil, fmt.Errorf("digest %v of %v isn't a %v digest", ref.digest, uri, strings.TrimSuffix(sha256DigestPrefix, ":"))
+		}
+	}
+	ref.repository, ref.tag = rest, defaultOCITag
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		ref.repository, ref.tag = rest[:colon], rest[colon+1:]
+	}
+	if ref.repository == "" || ref.tag == "" {
+		return nil, fmt.Errorf("%v has an empty repository or tag", uri)
+	}
+	return ref, nil
+}
+
+// manifestReference returns the digest of the manifest to pull if one is pinned, or else its tag.
+func (r *ociReference) manifestReference() string {
+	if r.digest != "" {
+		return r.digest
+	}
+	return r.tag
+}
+
+// baseURL returns the URL of the registry API. Registries on the local host are reached over plain HTTP,
+// as docker does, so that a local registry can stand in for a remote one.
+func (r *ociReference) baseURL() string {
+	host := r.registry
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	scheme := "https"
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%v://%v/v2/%v", scheme, r.registry, r.repository)
+}
+
+// ociManifest is the part of an OCI image manifest, or a docker v2 schema 2 manifest, describing its blobs.
+type ociManifest struct {
+	MediaType string          `json:"mediaType,omitempty"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// registryClient pulls from a registry, authenticating with a bearer token or basic credentials as the
+// registry challenges it to.
+type registryClient struct {
+	client   *http.Client
+	username string
+	password string
+	// authorization is the Authorization header the registry accepted.
+	authorization string
+}
+
+// syncOCIRepo pulls the OCI artifact r.URI refers to, unpacks its layers into cacheDir and returns its cache,
+// recording the digest of its manifest. An existing cache is kept as long as the URI of the repository doesn't
+// change, until its refresh policy says otherwise; the recorded digest is pulled again if the policy is never.
+func (c *KfConfig) syncOCIRepo(ctx context.Context, r Repo, cacheDir string) (*Cache, error) {
+	ref, err := parseOCIReference(r.URI)
+	if err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("invalid URI of repo %v: %v", r.Name, err),
+		}
+	}
+	client := &registryClient{client: &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}}
+	if client.username, client.password, err = c.registryCredentials(r, ref.registry); err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't read the credentials of registry %v for repo %v: %v", ref.registry, r.Name, err),
+		}
+	}
+	if cache, ok := c.recordedCache(r.Name, cacheDir); ok && cache.Digest != "" && cache.Ref == r.URI {
+		if _, err := os.Stat(cacheDir); err == nil && cache.LocalPath != "" && !ociChanged(ctx, r, ref, client, cache) {
+			log.Infof("%v is up to date at digest %v; not resyncing", cacheDir, cache.Digest)
+			return &cache, nil
+		}
+		if policy, _ := r.refreshPolicy(); policy == RefreshNever {
+			ref.digest = cache.Digest
+		}
+	}
+
+	if err := os.RemoveAll(cacheDir); err != nil {
+		log.Errorf("There was a problem deleting directory %v; error %v", cacheDir, err)
+		return nil, err
+	}
+	// A reference pinned by digest can be looked up in the shared cache before pulling.
+	digest := ref.digest
+	scope := c.cacheScope(r, ref.registry+"/"+ref.repository)
+	key := ""
+	if digest != "" {
+		key = sharedCacheKey("oci", scope, digest)
+	}
+	log.Infof("Pulling %v at %v to %v", r.URI, ref.manifestReference(), cacheDir)
+	err = populateCache(r.URI, key, cacheDir, func(dir string) (string, error) {
+		reportProgress(ctx, kftypes.ProgressEvent{Type: kftypes.RepoFetchStarted, Repo: r.Name})
+		err := retryFetch(ctx, r, dir, func(ctx context.Context) error {
+			var err error
+			digest, err = client.pull(ctx, r.Name, ref, dir)
+			return err
+		})
+		reportProgress(ctx, kftypes.ProgressEvent{Type: kftypes.RepoFetched, Repo: r.Name, Err: err})
+		if err != nil {
+			return "", &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("couldn't pull repo %v from %v: %v", r.Name, r.URI, err),
+			}
+		}
+		return sharedCacheKey("oci", scope, digest), nil
+	})
+	if err != nil {
+		os.RemoveAll(cacheDir)
+		return nil, err
+	}
+
+	log.Infof("Pull succeeded; LocalPath %v at digest %v", cacheDir, digest)
+	now := metav1.Now()
+	return &Cache{
+		Name:      r.Name,
+		LocalPath: cacheDir,
+		Ref:       r.URI,
+		Digest:    digest,
+		Source:    r.URI,
+		FetchedAt: &now,
+	}, nil
+}
+
+// registryCredentials returns the credentials for registry: from the pull secret of r if it has one, or else
+// from the docker config of the user. Credential helpers of the docker config aren't supported.
+func (c *KfConfig) registryCredentials(r Repo, registry string) (string, string, error) {
+	var config []byte
+	if r.PullSecret != nil {
+		value, err := ReadSecretKeyRef(c.Namespace, &v1.SecretKeySelector{
+			LocalObjectReference: *r.PullSecret,
+			Key:                  v1.DockerConfigJsonKey,
+		})
+		if err != nil {
+			return "", "", err
+		}
+		config = []byte(value)
+	} else {
+		dir := os.Getenv(dockerConfigDirEnv)
+		if dir == "" {
+			dir = filepath.Join(homedir.HomeDir(), ".docker")
+		}
+		var err error
+		if config, err = ioutil.ReadFile(filepath.Join(dir, dockerConfigFileName)); err != nil {
+			if os.IsNotExist(err) {
+				return "", "", nil
+			}
+			return "", "", err
+		}
+	}
+	return dockerConfigCredentials(config, registry)
+}
+
+// dockerConfigCredentials returns the credentials for registry in the docker config file config.
+func dockerConfigCredentials(config []byte, registry string) (string, string, error) {
+	var parsed struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth,omitempty"`
+			Username string `json:"username,omitempty"`
+			Password string `json:"password,omitempty"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return "", "", fmt.Errorf("couldn't parse docker config: %v", err)
+	}
+	for key, auth := range parsed.Auths {
+		host := key
+		if u, err := url.Parse(key); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		if host != registry {
+			continue
+		}
+		if auth.Auth == "" {
+			return auth.Username, auth.Password, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("couldn't decode the auth of %v: %v", key, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("the auth of %v isn't of the form <username>:<password>", key)
+		}
+		return parts[0], parts[1], nil
+	}
+	return "", "", nil
+}
+
+// pull unpacks the layers of the artifact ref refers to into dir and returns the digest of its manifest.
+// Layers are written next to dir as they're downloaded and only unpacked once their digest is checked.
+// Download progress is reported as that of repo.
+func (rc *registryClient) pull(ctx context.Context, repo string, ref *ociReference, dir string) (string, error) {
+	body, header, err := rc.get(ctx, ref.baseURL()+"/manifests/"+ref.manifestReference(),
+		ociManifestMediaType+", "+dockerManifestMediaType)
+	if err != nil {
+		return "", err
+	}
+	digest := header.Get(dockerContentDigestHeader)
+	if ref.digest != "" || digest == "" {
+		digest = sha256Digest(body)
+	}
+	if ref.digest != "" && digest != ref.digest {
+		return "", fmt.Errorf("manifest has digest %v; want %v", digest, ref.digest)
+	}
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(body, manifest); err != nil {
+		return "", fmt.Errorf("couldn't parse manifest: %v", err)
+	}
+	if len(manifest.Layers) == 0 {
+		return "", fmt.Errorf("manifest %v has no layers", digest)
+	}
+	total := int64(0)
+	for _, layer := range manifest.Layers {
+		total += layer.Size
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	blob := dir + ".layer"
+	defer os.Remove(blob)
+	progress := &progressReader{ctx: ctx, repo: repo, total: total, reported: time.Now()}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != ociLayerMediaType && layer.MediaType != dockerLayerMediaType {
+			return "", fmt.Errorf("layer %v has unsupported media type %v", layer.Digest, layer.MediaType)
+		}
+		if err := rc.pullLayer(ctx, ref, layer.Digest, blob, dir, progress); err != nil {
+			return "", err
+		}
+	}
+	return digest, nil
+}
+
+// pullLayer downloads the layer digest of ref to blob, reading it through progress, and unpacks it into dir
+// once its digest is checked.
+func (rc *registryClient) pullLayer(ctx context.Context, ref *ociReference, digest string, blob string, dir string,
+	progress *progressReader) error {
+	f, err := os.Create(blob)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := rc.fetchBlob(ctx, ref, digest, f, progress); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := extractArchiveReader(f, dir); err != nil {
+		return fmt.Errorf("couldn't unpack layer %v: %v", digest, err)
+	}
+	return nil
+}
+
+// fetchBlob writes the blob digest of ref to w, reading it through progress if it's set, and checks it has
+// that digest.
+func (rc *registryClient) fetchBlob(ctx context.Context, ref *ociReference, digest string, w io.Writer,
+	progress *progressReader) error {
+	resp, err := rc.open(ctx, ref.baseURL()+"/blobs/"+digest, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	hash := sha256.New()
+	var r io.Reader = &networkReader{r: resp.Body}
+	if progress != nil {
+		progress.r = r
+		r = progress
+	}
+	if _, err := io.Copy(io.MultiWriter(w, hash), r); err != nil {
+		return err
+	}
+	if actual := sha256DigestPrefix + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		return fmt.Errorf("blob has digest %v; want %v", actual, digest)
+	}
+	return nil
+}
+
+// get returns the body and header of the response to a GET of u; see open.
+func (rc *registryClient) get(ctx context.Context, u string, accept string) ([]byte, http.Header, error) {
+	resp, err := rc.open(ctx, u, accept)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(&networkReader{r: resp.Body})
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, resp.Header, nil
+}
+
+// open returns the successful response to a GET of u; see request.
+func (rc *registryClient) open(ctx context.Context, u string, accept string) (*http.Response, error) {
+	return rc.request(ctx, http.MethodGet, u, accept)
+}
+
+// manifestDigest returns the digest of the manifest ref refers to, of one of the media types accept lists, as
+// the registry answers a HEAD of it.
+func (rc *registryClient) manifestDigest(ctx context.Context, ref *ociReference, accept string) (string, error) {
+	u := ref.baseURL() + "/manifests/" + ref.manifestReference()
+	resp, err := rc.request(ctx, http.MethodHead, u, accept)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get(dockerContentDigestHeader); digest != "" {
+		return digest, nil
+	}
+	// Registries needn't send the digest; the manifest is small enough to be digested instead.
+	body, _, err := rc.get(ctx, u, accept)
+	if err != nil {
+		return "", err
+	}
+	return sha256Digest(body), nil
+}
+
+// request returns the successful response to a request of u with method, answering the authentication
+// challenge of the registry if it makes one. Network errors and server errors are transient.
+func (rc *registryClient) request(ctx context.Context, method string, u string, accept string) (*http.Response, error) {
+	resp, err := rc.do(ctx, method, u, accept)
+	if err != nil {
+		return nil, transientIfNetwork(err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized && rc.authorization == "" {
+		challenge := resp.Header.Get(registryAuthenticateHeader)
+		resp.Body.Close()
+		if err := rc.authenticate(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = rc.do(ctx, method, u, accept); err != nil {
+			return nil, transientIfNetwork(err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, statusError(method, u, resp)
+	}
+	return resp, nil
+}
+
+func (rc *registryClient) do(ctx context.Context, method string, u string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", ociUserAgent)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if rc.authorization != "" {
+		req.Header.Set("Authorization", rc.authorization)
+	}
+	return rc.client.Do(req.WithContext(ctx))
+}
+
+// authenticate sets the authorization answering challenge, the WWW-Authenticate header of the registry.
+func (rc *registryClient) authenticate(ctx context.Context, challenge string) error {
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	switch scheme {
+	case "basic":
+		if rc.username == "" {
+			return fmt.Errorf("the registry requires credentials but none are configured")
+		}
+		rc.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(rc.username+":"+rc.password))
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("the registry requires unsupported authentication %q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, m := range authChallengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("the registry challenge %q has no valid realm", challenge)
+	}
+	query := tokenURL.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	tokenURL.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", ociUserAgent)
+	if rc.username != "" {
+		req.SetBasicAuth(rc.username, rc.password)
+	}
+	resp, err := rc.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("couldn't get a token from %v: %v", tokenURL.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("couldn't get a token from %v: %v", tokenURL.Host, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("couldn't parse the token from %v: %v", tokenURL.Host, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	rc.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// sha256Digest returns the OCI digest of content.
+func sha256Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return sha256DigestPrefix + hex.EncodeToString(sum[:])
+}
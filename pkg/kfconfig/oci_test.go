@@ -0,0 +1,250 @@
+package kfconfig
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOCIReference(t *testing.T) {
+	type testCase struct {
+		URI      string
+		Expected *ociReference
+	}
+	digest := "sha256:" + strings.Repeat("a", 64)
+	cases := []testCase{
+		{
+			URI:      "oci://quay.io/opendatahub/manifests:v1.0",
+			Expected: &ociReference{registry: "quay.io", repository: "opendatahub/manifests", tag: "v1.0"},
+		},
+		{
+			URI:      "oci://localhost:5000/manifests@" + digest,
+			Expected: &ociReference{registry: "localhost:5000", repository: "manifests", tag: "latest", digest: digest},
+		},
+		{
+			URI:      "oci://quay.io/opendatahub/manifests:v1.0@" + digest,
+			Expected: &ociReference{registry: "quay.io", repository: "opendatahub/manifests", tag: "v1.0", digest: digest},
+		},
+		{URI: "oci://quay.io"},
+		{URI: "oci://quay.io/manifests:"},
+		{URI: "oci://quay.io/manifests@md5:abc"},
+	}
+	for _, c := range cases {
+		actual, err := parseOCIReference(c.URI)
+		if c.Expected == nil {
+			if err == nil {
+				t.Errorf("%v: expected an error got %+v", c.URI, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", c.URI, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, c.Expected) {
+			t.Errorf("%v: got %+v; want %+v", c.URI, actual, c.Expected)
+		}
+	}
+}
+
+// fakeRegistry serves the artifacts of a single repository, requiring a bearer token it hands out for the
+// credentials kfctl:secret.
+type fakeRegistry struct {
+	server    *httptest.Server
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	pulls     int
+	heads     int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	r := &fakeRegistry{manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			if user, password, ok := req.BasicAuth(); !ok || user != "kfctl" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token": "t0ken"}`)
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer realm="%v/token",service="fake",scope="repository:manifests:pull"`, r.server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var content []byte
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/v2/manifests/manifests/"):
+			content = r.manifests[path.Base(req.URL.Path)]
+			w.Header().Set("Content-Type", ociManifestMediaType)
+			if content != nil {
+				w.Header().Set(dockerContentDigestHeader, sha256Digest(content))
+			}
+			if req.Method == http.MethodHead {
+				r.heads++
+			} else {
+				r.pulls++
+			}
+		case strings.HasPrefix(req.URL.Path, "/v2/manifests/blobs/"):
+			content = r.blobs[path.Base(req.URL.Path)]
+		}
+		if content == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	}))
+	return r
+}
+
+// testArchive returns a tar.gz archive holding kustomize/version.
+func testArchive(version string) []byte {
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "kustomize/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "kustomize/version", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(version))})
+	tw.Write([]byte(version))
+	tw.Close()
+	gz.Close()
+	return archive.Bytes()
+}
+
+// push publishes an artifact with a single layer holding kustomize/version as tag and returns its digest.
+func (r *fakeRegistry) push(t *testing.T, tag string, version string) string {
+	layer := testArchive(version)
+	layerDigest := sha256Digest(layer)
+	r.blobs[layerDigest] = layer
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestMediaType,
+		"layers": []ociDescriptor{{
+			MediaType: ociLayerMediaType,
+			Digest:    layerDigest,
+			Size:      int64(len(layer)),
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest; %v", err)
+	}
+	digest := sha256Digest(manifest)
+	r.manifests[tag] = manifest
+	r.manifests[digest] = manifest
+	return digest
+}
+
+func TestSyncCacheOCI(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-sync-oci")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	registry := newFakeRegistry()
+	defer registry.server.Close()
+	host := strings.TrimPrefix(registry.server.URL, "http://")
+
+	dockerConfig := fmt.Sprintf(`{"auths": {"%v": {"auth": "a2ZjdGw6c2VjcmV0"}}}`, host)
+	if err := ioutil.WriteFile(path.Join(testDir, "config.json"), []byte(dockerConfig), 0600); err != nil {
+		t.Fatalf("Failed to write docker config; %v", err)
+	}
+	defer os.Setenv(dockerConfigDirEnv, os.Getenv(dockerConfigDirEnv))
+	os.Setenv(dockerConfigDirEnv, testDir)
+
+	v1 := registry.push(t, "v1", "v1")
+	uri := OCIScheme + host + "/manifests:v1"
+	config := &KfConfig{
+		Spec: KfConfigSpec{
+			AppDir: path.Join(testDir, "app"),
+			Repos:  []Repo{{Name: "manifests", URI: uri, Refresh: RefreshNever}},
+		},
+	}
+	cacheDir := path.Join(testDir, "app", DefaultCacheDir, "manifests")
+	check := func(step string, digest string, content string, pulls int) {
+		if err := config.SyncCache(); err != nil {
+			t.Fatalf("%v: could not sync cache; %v", step, err)
+		}
+		expected := []Cache{{
+			Name:      "manifests",
+			LocalPath: cacheDir,
+			Ref:       config.Spec.Repos[0].URI,
+			Digest:    digest,
+			Source:    config.Spec.Repos[0].URI,
+		}}
+		caches := []Cache{}
+		for _, cache := range config.Status.Caches {
+			if cache.FetchedAt == nil {
+				t.Fatalf("%v: cache %v has no fetch time", step, cache.Name)
+			}
+			cache.FetchedAt = nil
+			caches = append(caches, cache)
+		}
+		if !reflect.DeepEqual(caches, expected) {
+			t.Fatalf("%v: caches; got %+v; want %+v", step, caches, expected)
+		}
+		actual, err := ioutil.ReadFile(path.Join(cacheDir, "kustomize", "version"))
+		if err != nil || string(actual) != content {
+			t.Fatalf("%v: got content %q (%v); want %q", step, actual, err, content)
+		}
+		if registry.pulls != pulls {
+			t.Fatalf("%v: got %v pulls; want %v", step, registry.pulls, pulls)
+		}
+	}
+
+	check("tag", v1, "v1", 1)
+
+	// Under the never policy, the recorded digest is kept while the URI doesn't change, even if the cache has
+	// to be pulled again; the shared cache serves it as long as it holds the digest.
+	retagged := registry.push(t, "v1", "v1-retagged")
+	check("unchanged", v1, "v1", 1)
+	os.RemoveAll(cacheDir)
+	check("shared", v1, "v1", 1)
+	if err := NewSharedCache("").Remove(sharedCacheKey("oci", config.cacheScope(config.Spec.Repos[0], host+"/manifests"), v1)); err != nil {
+		t.Fatalf("Failed to remove cache entry; %v", err)
+	}
+	check("pinned", v1, "v1", 2)
+
+	v2 := registry.push(t, "v2", "v2")
+	config.Spec.Repos[0].URI = OCIScheme + host + "/manifests:v2@" + v2
+	check("digest", v2, "v2", 3)
+
+	config.Spec.Repos[0].URI = OCIScheme + host + "/manifests@" + sha256Digest([]byte("unknown"))
+	if err := config.SyncCache(); err == nil {
+		t.Fatalf("unknown digest: expected an error got none")
+	}
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Fatalf("unknown digest: expected the cache to be removed")
+	}
+
+	os.Setenv(dockerConfigDirEnv, path.Join(testDir, "missing"))
+	config.Spec.Repos[0].URI = uri
+	if err := config.SyncCache(); err == nil {
+		t.Fatalf("no credentials: expected an error got none")
+	}
+
+	// Under ifChanged, the digest of the tag is looked up with a HEAD of its manifest and the tag pulled again
+	// once it moves.
+	os.Setenv(dockerConfigDirEnv, testDir)
+	config.Spec.Repos[0].Refresh = RefreshIfChanged
+	check("retagged", retagged, "v1-retagged", registry.pulls+1)
+	heads := registry.heads
+	check("fresh", retagged, "v1-retagged", registry.pulls)
+	if registry.heads != heads+1 {
+		t.Fatalf("fresh: got %v HEAD requests; want 1", registry.heads-heads)
+	}
+	moved := registry.push(t, "v1", "v1-moved")
+	check("moved", moved, "v1-moved", registry.pulls+1)
+}
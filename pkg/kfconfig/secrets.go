@@ -0,0 +1,340 @@
+package kfconfig
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// DefaultGeneratedLength is the length of generated secrets that don't set one.
+	DefaultGeneratedLength = 32
+	// DefaultGeneratedCharset is the charset of generated secrets that don't set one.
+	DefaultGeneratedCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// GeneratedSecretKey is the key of the value in the Secret a generated secret is stored in.
+	GeneratedSecretKey = "value"
+	// ParameterSourceLabel marks the ConfigMaps and Secrets the operator watches to apply a KfDef again when
+	// the values it reads from them change. Generated secrets carry it.
+	ParameterSourceLabel = "kfctl.kubeflow.io/parameter-source"
+)
+
+func init() {
+	// The v1 API resolves the secrets of a KfDef stored in the cluster through the readers of this package.
+	kfdefv1.ReadSecretKeyRef = ReadSecretKeyRef
+	kfdefv1.ReadGeneratedSecret = ReadGeneratedSecret
+}
+
+// defaultClientConfig returns the config of the current kubeconfig context, falling back to the pod when
+// running in one.
+func defaultClientConfig() clientcmd.ClientConfig {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{})
+}
+
+// contextNamespace returns the namespace of the current kubeconfig context, or of the pod when running in one.
+var contextNamespace = func() (string, error) {
+	namespace, _, err := defaultClientConfig().Namespace()
+	return namespace, err
+}
+
+// ResolveNamespace returns the namespace the objects a KfDef of namespace refers to are read from: namespace,
+// or the namespace of the current kubeconfig context if namespace is empty.
+func ResolveNamespace(namespace string) (string, error) {
+	if namespace != "" {
+		return namespace, nil
+	}
+	namespace, err := contextNamespace()
+	if err != nil {
+		return "", &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't resolve the namespace of the current context: %v", err),
+		}
+	}
+	return namespace, nil
+}
+
+// newCoreClient returns a client of the cluster of the current kubeconfig context, falling back to the
+// cluster of the pod when running in one, along with the namespace of the context.
+var newCoreClient = func() (corev1.CoreV1Interface, string, error) {
+	clientConfig := defaultClientConfig()
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := corev1.NewForConfig(config)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, namespace, nil
+}
+
+// coreClient returns a client to read the object kind name from, along with its namespace: namespace, or the
+// namespace of the current kubeconfig context if namespace is empty.
+func coreClient(namespace string, kind string, name string) (corev1.CoreV1Interface, string, error) {
+	client, contextNamespace, err := newCoreClient()
+	if err != nil {
+		return nil, "", &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't create a client to read %v %v: %v", kind, name, err),
+		}
+	}
+	if namespace == "" {
+		namespace = contextNamespace
+	}
+	return client, namespace, nil
+}
+
+// ReadSecretKeyRef reads the key ref refers to from a Secret of namespace, or of the namespace of the current
+// kubeconfig context if namespace is empty. kfctl reads it through the kubeconfig and the operator from the
+// cluster it runs in. The value of an optional ref whose Secret or key doesn't exist is empty.
+func ReadSecretKeyRef(namespace string, ref *v1.SecretKeySelector) (string, error) {
+	value, _, err := readSecretKeyRef(namespace, ref)
+	return value, err
+}
+
+// readSecretKeyRef is ReadSecretKeyRef; found is false if ref is optional and its Secret or key doesn't exist.
+func readSecretKeyRef(namespace string, ref *v1.SecretKeySelector) (value string, found bool, err error) {
+	client, namespace, err := coreClient(namespace, "Secret", ref.Name)
+	if err != nil {
+		return "", false, err
+	}
+	optional := ref.Optional != nil && *ref.Optional
+	secret, err := client.Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		if optional && apierrors.IsNotFound(err) {
+			return "", false, nil
+		}
+		return "", false, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't read Secret %v/%v: %v", namespace, ref.Name, err),
+		}
+	}
+	data, ok := secret.Data[ref.Key]
+	if !ok && !optional {
+		return "", false, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("Secret %v/%v has no key %v", namespace, ref.Name, ref.Key),
+		}
+	}
+	return string(data), ok, nil
+}
+
+// ReadSecret reads the data of the Secret name of namespace, or of the namespace of the current kubeconfig
+// context if namespace is empty.
+func ReadSecret(namespace string, name string) (map[string][]byte, error) {
+	client, namespace, err := coreClient(namespace, "Secret", name)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := client.Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't read Secret %v/%v: %v", namespace, name, err),
+		}
+	}
+	return secret.Data, nil
+}
+
+// ReadConfigMapKeyRef reads the key ref refers to from a ConfigMap of namespace, or of the namespace of the
+// current kubeconfig context if namespace is empty. The value of an optional ref whose ConfigMap or key
+// doesn't exist is empty.
+func ReadConfigMapKeyRef(namespace string, ref *v1.ConfigMapKeySelector) (string, error) {
+	value, err := ReadConfigMapBinaryKeyRef(namespace, ref)
+	return string(value), err
+}
+
+// ReadConfigMapBinaryKeyRef reads the key ref refers to from the binaryData of a ConfigMap of namespace, or
+// from its data if it isn't binary. The value of an optional ref whose ConfigMap or key doesn't exist is nil.
+func ReadConfigMapBinaryKeyRef(namespace string, ref *v1.ConfigMapKeySelector) ([]byte, error) {
+	value, _, err := readConfigMapKeyRef(namespace, ref)
+	return value, err
+}
+
+// readConfigMapKeyRef is ReadConfigMapBinaryKeyRef; found is false if ref is optional and its ConfigMap or key
+// doesn't exist.
+func readConfigMapKeyRef(namespace string, ref *v1.ConfigMapKeySelector) (value []byte, found bool, err error) {
+	client, namespace, err := coreClient(namespace, "ConfigMap", ref.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	optional := ref.Optional != nil && *ref.Optional
+	configMap, err := client.ConfigMaps(namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		if optional && apierrors.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, false, &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("couldn't read ConfigMap %v/%v: %v", namespace, ref.Name, err),
+		}
+	}
+	if value, ok := configMap.BinaryData[ref.Key]; ok {
+		return value, true, nil
+	}
+	if value, ok := configMap.Data[ref.Key]; ok {
+		return []byte(value), true, nil
+	}
+	if optional {
+		return nil, false, nil
+	}
+	return nil, false, &kfapis.KfError{
+		Code:    int(kfapis.INVALID_ARGUMENT),
+		Message: fmt.Sprintf("ConfigMap %v/%v has no key %v", namespace, ref.Name, ref.Key),
+	}
+}
+
+// ReadValueSource reads the value the ConfigMap or Secret key of source refers to from namespace, or from the
+// namespace of the current kubeconfig context if namespace is empty. found is false if the source is optional
+// and either it or its key doesn't exist, so that the default of the value applies.
+func ReadValueSource(namespace string, source *ValueSource) (value string, found bool, err error) {
+	switch {
+	case source.ConfigMapKeyRef != nil:
+		data, found, err := readConfigMapKeyRef(namespace, source.ConfigMapKeyRef)
+		return string(data), found, err
+	case source.SecretKeyRef != nil:
+		return readSecretKeyRef(namespace, source.SecretKeyRef)
+	}
+	return "", false, &kfapis.KfError{
+		Code:    int(kfapis.INVALID_ARGUMENT),
+		Message: "valueFrom sets neither configMapKeyRef nor secretKeyRef",
+	}
+}
+
+// GeneratedSecretName returns the name of the Kubernetes Secret the generated secret secretName of the KfDef
+// kfdefName is stored in.
+func GeneratedSecretName(kfdefName string, secretName string) string {
+	return kfdefName + "-" + secretName
+}
+
+// ReadGeneratedSecret returns the value of the generated secret secretName of the KfDef kfdefName, stored in a
+// Secret of namespace by EnsureGeneratedSecret. It never creates the Secret.
+func ReadGeneratedSecret(namespace string, kfdefName string, secretName string) (string, error) {
+	client, namespace, err := coreClient(namespace, "generated secret", secretName)
+	if err != nil {
+		return "", err
+	}
+	name := GeneratedSecretName(kfdefName, secretName)
+	secret, err := client.Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", &kfapis.KfError{
+				Code:    int(kfapis.NOT_FOUND),
+				Message: fmt.Sprintf("generated secret %v hasn't been generated: Secret %v/%v doesn't exist", secretName, namespace, name),
+			}
+		}
+		return "", &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't read Secret %v/%v: %v", namespace, name, err),
+		}
+	}
+	value, ok := secret.Data[GeneratedSecretKey]
+	if !ok {
+		return "", &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("Secret %v/%v of generated secret %v has no key %v", namespace, name, secretName, GeneratedSecretKey),
+		}
+	}
+	return string(value), nil
+}
+
+// EnsureGeneratedSecret creates the Secret of namespace holding the generated secret secretName of the KfDef
+// kfdefName, with a value drawn from source, unless it exists already; so the value is the same on every
+// render. The Secret is owned by owner when it is set, so it is deleted along with the KfDef.
+func EnsureGeneratedSecret(namespace string, kfdefName string, secretName string, source *GeneratedSource,
+	owner *metav1.OwnerReference) error {
+	client, namespace, err := coreClient(namespace, "generated secret", secretName)
+	if err != nil {
+		return err
+	}
+	name := GeneratedSecretName(kfdefName, secretName)
+	if _, err := client.Secrets(namespace).Get(name, metav1.GetOptions{}); err == nil {
+		return nil
+	} else if !apierrors.IsNotFound(err) {
+		return &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't read Secret %v/%v: %v", namespace, name, err),
+		}
+	}
+
+	value, err := generateSecretValue(source)
+	if err != nil {
+		return err
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{ParameterSourceLabel: "true"},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{GeneratedSecretKey: []byte(value)},
+	}
+	if owner != nil {
+		secret.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	// Another reconcile may have created it in the meantime; the value it stored is kept.
+	if _, err := client.Secrets(namespace).Create(secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		return &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("couldn't create Secret %v/%v: %v", namespace, name, err),
+		}
+	}
+	return nil
+}
+
+// EnsureGeneratedSecrets creates the Secrets holding the generated secrets of c that don't exist yet, owned by
+// owner when it is set, so that GetSecret can read them.
+func (c *KfConfig) EnsureGeneratedSecrets(owner *metav1.OwnerReference) error {
+	for _, s := range c.Spec.Secrets {
+		if s.SecretSource == nil || s.SecretSource.Generated == nil {
+			continue
+		}
+		if err := EnsureGeneratedSecret(c.Namespace, c.Name, s.Name, s.SecretSource.Generated, owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateSecretValue returns a random value drawn from the charset of source.
+func generateSecretValue(source *GeneratedSource) (string, error) {
+	length := source.Length
+	if length == 0 {
+		length = DefaultGeneratedLength
+	}
+	charset := []rune(source.Charset)
+	if len(charset) == 0 {
+		charset = []rune(DefaultGeneratedCharset)
+	}
+	if length < 0 {
+		return "", &kfapis.KfError{
+			Code:    int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("length of generated secrets must be positive; got %v", length),
+		}
+	}
+	value := make([]rune, length)
+	for i := range value {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", &kfapis.KfError{
+				Code:    int(kfapis.INTERNAL_ERROR),
+				Message: fmt.Sprintf("couldn't generate a random value: %v", err),
+			}
+		}
+		value[i] = charset[n.Int64()]
+	}
+	return string(value), nil
+}
@@ -0,0 +1,311 @@
+package kfconfig
+
+import (
+	"strings"
+	"testing"
+
+	kfdefv1 "github.com/kubeflow/kfctl/v3/pkg/apis/apps/kfdef/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+func TestReadKeyRefs(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "kubeflow"},
+			Data:       map[string][]byte{"password": []byte("clustersecret")},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "kubeflow"},
+			Data:       map[string]string{"host": "example.com"},
+			BinaryData: map[string][]byte{"bundle": []byte("binary")},
+		},
+	)
+	defer func(old func() (corev1.CoreV1Interface, string, error)) { newCoreClient = old }(newCoreClient)
+	newCoreClient = func() (corev1.CoreV1Interface, string, error) {
+		return clientset.CoreV1(), "default", nil
+	}
+	optional := true
+	secretRef := func(name string, key string, optional *bool) *v1.SecretKeySelector {
+		return &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: name}, Key: key, Optional: optional}
+	}
+	configMapRef := func(name string, key string, optional *bool) *v1.ConfigMapKeySelector {
+		return &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: name}, Key: key, Optional: optional}
+	}
+
+	type testCase struct {
+		Name     string
+		Read     func() (string, error)
+		Expected string
+		Err      bool
+	}
+	cases := []testCase{
+		{
+			Name:     "secret",
+			Read:     func() (string, error) { return ReadSecretKeyRef("kubeflow", secretRef("creds", "password", nil)) },
+			Expected: "clustersecret",
+		},
+		{
+			Name: "secret-missing-key",
+			Read: func() (string, error) { return ReadSecretKeyRef("kubeflow", secretRef("creds", "token", nil)) },
+			Err:  true,
+		},
+		{
+			Name: "secret-optional-missing-key",
+			Read: func() (string, error) { return ReadSecretKeyRef("kubeflow", secretRef("creds", "token", &optional)) },
+		},
+		{
+			Name: "secret-missing",
+			Read: func() (string, error) { return ReadSecretKeyRef("kubeflow", secretRef("other", "password", nil)) },
+			Err:  true,
+		},
+		{
+			Name: "secret-optional-missing",
+			Read: func() (string, error) { return ReadSecretKeyRef("kubeflow", secretRef("other", "password", &optional)) },
+		},
+		{
+			Name:     "configmap",
+			Read:     func() (string, error) { return ReadConfigMapKeyRef("kubeflow", configMapRef("params", "host", nil)) },
+			Expected: "example.com",
+		},
+		{
+			Name:     "configmap-binary",
+			Read:     func() (string, error) { return ReadConfigMapKeyRef("kubeflow", configMapRef("params", "bundle", nil)) },
+			Expected: "binary",
+		},
+		{
+			Name: "configmap-missing",
+			Read: func() (string, error) { return ReadConfigMapKeyRef("kubeflow", configMapRef("other", "host", nil)) },
+			Err:  true,
+		},
+		{
+			Name: "configmap-optional-missing",
+			Read: func() (string, error) {
+				return ReadConfigMapKeyRef("kubeflow", configMapRef("other", "host", &optional))
+			},
+		},
+	}
+	for _, c := range cases {
+		actual, err := c.Read()
+		if c.Err {
+			if err == nil {
+				t.Errorf("Case %v; expected an error got none", c.Name)
+			}
+			continue
+		}
+		if err != nil || actual != c.Expected {
+			t.Errorf("Case %v; got %q (%v); want %q", c.Name, actual, err, c.Expected)
+		}
+	}
+	if value, err := ReadConfigMapBinaryKeyRef("kubeflow", configMapRef("other", "host", &optional)); err != nil || value != nil {
+		t.Errorf("Optional missing binary key; got %v (%v); want nil", value, err)
+	}
+
+	config := &KfConfig{
+		Spec: KfConfigSpec{
+			Secrets: []Secret{
+				{Name: "s1", SecretSource: &SecretSource{SecretKeyRef: secretRef("creds", "password", nil)}},
+				{Name: "s2", SecretSource: &SecretSource{Generated: &GeneratedSource{Length: 16, Charset: "ab"}}},
+			},
+		},
+	}
+	config.Name = "kf"
+	config.Namespace = "kubeflow"
+	if actual, err := config.GetSecret("s1"); err != nil || actual != "clustersecret" {
+		t.Errorf("Secret s1; got %q (%v); want clustersecret", actual, err)
+	}
+	if _, err := config.GetSecret("s2"); err == nil {
+		t.Errorf("Secret s2 hasn't been generated; expected an error got none")
+	}
+	if _, err := clientset.CoreV1().Secrets("kubeflow").Get("kf-s2", metav1.GetOptions{}); err == nil {
+		t.Errorf("Reading secret s2 created Secret kf-s2")
+	}
+	if err := config.EnsureGeneratedSecrets(nil); err != nil {
+		t.Fatalf("Error generating secret s2; error %v", err)
+	}
+	generated, err := config.GetSecret("s2")
+	if err != nil {
+		t.Fatalf("Error reading secret s2; error %v", err)
+	}
+	if len(generated) != 16 || strings.Trim(generated, "ab") != "" {
+		t.Errorf("Generated secret %v isn't 16 characters of the charset", generated)
+	}
+	if err := config.EnsureGeneratedSecrets(nil); err != nil {
+		t.Fatalf("Error generating secret s2 again; error %v", err)
+	}
+	again, err := config.GetSecret("s2")
+	if err != nil || again != generated {
+		t.Errorf("Generated secret changed; got %v, %v; want %v", again, err, generated)
+	}
+	stored, err := clientset.CoreV1().Secrets("kubeflow").Get("kf-s2", metav1.GetOptions{})
+	if err != nil || string(stored.Data[GeneratedSecretKey]) != generated {
+		t.Errorf("Generated secret isn't stored in Secret kf-s2; error %v", err)
+	} else if _, ok := stored.Labels[ParameterSourceLabel]; !ok {
+		t.Errorf("Secret kf-s2 isn't labeled %v, so the operator wouldn't watch it", ParameterSourceLabel)
+	}
+}
+
+func TestKfDefGetSecretFromCluster(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "kubeflow"},
+			Data:       map[string][]byte{"password": []byte("clustersecret")},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: GeneratedSecretName("kf", "token"), Namespace: "kubeflow"},
+			Data:       map[string][]byte{GeneratedSecretKey: []byte("generatedsecret")},
+		},
+	)
+	defer func(old func() (corev1.CoreV1Interface, string, error)) { newCoreClient = old }(newCoreClient)
+	newCoreClient = func() (corev1.CoreV1Interface, string, error) {
+		return clientset.CoreV1(), "default", nil
+	}
+
+	d := &kfdefv1.KfDef{
+		ObjectMeta: metav1.ObjectMeta{Name: "kf", Namespace: "kubeflow"},
+		Spec: kfdefv1.KfDefSpec{
+			Secrets: []kfdefv1.Secret{
+				{
+					Name: "password",
+					SecretSource: &kfdefv1.SecretSource{
+						SecretKeyRef: &v1.SecretKeySelector{
+							LocalObjectReference: v1.LocalObjectReference{Name: "creds"},
+							Key:                  "password",
+						},
+					},
+				},
+				{
+					Name:         "token",
+					SecretSource: &kfdefv1.SecretSource{Generated: &kfdefv1.GeneratedSource{}},
+				},
+			},
+		},
+	}
+	// The v1 API reads the secrets stored in the cluster through the readers of this package.
+	for name, expected := range map[string]string{"password": "clustersecret", "token": "generatedsecret"} {
+		value, err := d.GetSecret(name)
+		if err != nil || value != expected {
+			t.Errorf("Secret %v of the KfDef is %q, error %v; want %q", name, value, err, expected)
+		}
+	}
+}
+
+func TestReadValueSource(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "kubeflow"},
+			Data:       map[string]string{"host": "mysql.kubeflow"},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "oauth", Namespace: "kubeflow"},
+			Data:       map[string][]byte{"clientID": []byte("kubeflow-client")},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "context"},
+			Data:       map[string]string{"host": "mysql.context"},
+		},
+	)
+	defer func(old func() (corev1.CoreV1Interface, string, error)) { newCoreClient = old }(newCoreClient)
+	newCoreClient = func() (corev1.CoreV1Interface, string, error) {
+		return clientset.CoreV1(), "context", nil
+	}
+	optional := true
+	configMapRef := func(name string, key string, optional *bool) *ValueSource {
+		return &ValueSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: name},
+			Key:                  key,
+			Optional:             optional,
+		}}
+	}
+	secretRef := func(name string, key string, optional *bool) *ValueSource {
+		return &ValueSource{SecretKeyRef: &v1.SecretKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: name},
+			Key:                  key,
+			Optional:             optional,
+		}}
+	}
+
+	type testCase struct {
+		Name          string
+		Namespace     string
+		Source        *ValueSource
+		Expected      string
+		ExpectedFound bool
+		Err           bool
+	}
+	cases := []testCase{
+		{
+			Name:          "configmap",
+			Namespace:     "kubeflow",
+			Source:        configMapRef("db", "host", nil),
+			Expected:      "mysql.kubeflow",
+			ExpectedFound: true,
+		},
+		{
+			Name:          "context-namespace",
+			Source:        configMapRef("db", "host", nil),
+			Expected:      "mysql.context",
+			ExpectedFound: true,
+		},
+		{
+			Name:          "secret",
+			Namespace:     "kubeflow",
+			Source:        secretRef("oauth", "clientID", nil),
+			Expected:      "kubeflow-client",
+			ExpectedFound: true,
+		},
+		{
+			Name:      "missing-key",
+			Namespace: "kubeflow",
+			Source:    configMapRef("db", "port", nil),
+			Err:       true,
+		},
+		{
+			Name:      "missing-secret",
+			Namespace: "kubeflow",
+			Source:    secretRef("missing", "clientID", nil),
+			Err:       true,
+		},
+		{
+			Name:      "optional-missing-key",
+			Namespace: "kubeflow",
+			Source:    configMapRef("db", "port", &optional),
+		},
+		{
+			Name:      "optional-missing-secret",
+			Namespace: "kubeflow",
+			Source:    secretRef("missing", "clientID", &optional),
+		},
+		{
+			Name:      "empty",
+			Namespace: "kubeflow",
+			Source:    &ValueSource{},
+			Err:       true,
+		},
+	}
+	for _, c := range cases {
+		value, found, err := ReadValueSource(c.Namespace, c.Source)
+		if (err != nil) != c.Err {
+			t.Errorf("Case %v; got error %v; want error %v", c.Name, err, c.Err)
+			continue
+		}
+		if value != c.Expected || found != c.ExpectedFound {
+			t.Errorf("Case %v; got %q, %v; want %q, %v", c.Name, value, found, c.Expected, c.ExpectedFound)
+		}
+	}
+}
+
+func TestResolveNamespace(t *testing.T) {
+	defer func(old func() (string, error)) { contextNamespace = old }(contextNamespace)
+	contextNamespace = func() (string, error) {
+		return "context", nil
+	}
+	if namespace, err := ResolveNamespace("kubeflow"); err != nil || namespace != "kubeflow" {
+		t.Errorf("Got namespace %q (%v); want kubeflow", namespace, err)
+	}
+	if namespace, err := ResolveNamespace(""); err != nil || namespace != "context" {
+		t.Errorf("Got namespace %q (%v); want the namespace of the context", namespace, err)
+	}
+}
@@ -0,0 +1,263 @@
+package kfconfig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// RepoConcurrencyEnv is the number of repos SyncCache fetches at once.
+	RepoConcurrencyEnv = "KFCTL_REPO_CONCURRENCY"
+	// RepoTimeoutEnv bounds each attempt to fetch a repo, e.g. 10m.
+	RepoTimeoutEnv = "KFCTL_REPO_TIMEOUT"
+	// RepoAttemptsEnv is the number of times fetching a repo is attempted before giving up.
+	RepoAttemptsEnv = "KFCTL_REPO_ATTEMPTS"
+
+	defaultRepoConcurrency = 4
+	defaultRepoTimeout     = 10 * time.Minute
+	defaultRepoAttempts    = 3
+	// progressInterval is the minimum interval between two RepoFetchProgress events of a repo.
+	progressInterval = time.Second
+)
+
+// repoRetryBackoff is the delay before the second attempt to fetch a repo; it doubles with every attempt.
+var repoRetryBackoff = 2 * time.Second
+
+// progressMu serializes the progress events of the repos fetched concurrently.
+var progressMu sync.Mutex
+
+func reportProgress(ctx context.Context, event kftypes.ProgressEvent) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	kftypes.ReportProgress(ctx, event)
+}
+
+// transientError is an error which may not happen again, e.g. a network error; fetches failing with it are
+// retried.
+type transientError struct {
+	error
+}
+
+func isTransient(err error) bool {
+	_, ok := err.(*transientError)
+	return ok
+}
+
+// transientIfNetwork marks err, returned by an http.Client, as transient if it's a network error.
+func transientIfNetwork(err error) error {
+	cause := err
+	if u, ok := err.(*url.Error); ok {
+		cause = u.Err
+	}
+	if _, ok := cause.(net.Error); ok || cause == io.EOF || cause == io.ErrUnexpectedEOF {
+		return &transientError{err}
+	}
+	return err
+}
+
+// statusError returns the error of a response with an unexpected status; server errors and throttling are
+// transient.
+func statusError(method string, uri string, resp *http.Response) error {
+	err := fmt.Errorf("%v %v: %v", method, uri, resp.Status)
+	switch {
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusRequestTimeout:
+		return &transientError{err}
+	}
+	return err
+}
+
+// networkReader marks the errors reading the body of a response as transient, so that a download cut short
+// is retried even though the error surfaces from whatever consumes the body.
+type networkReader struct {
+	r   io.Reader
+	err error
+}
+
+func (n *networkReader) Read(p []byte) (int, error) {
+	count, err := n.r.Read(p)
+	if err != nil && err != io.EOF {
+		n.err = &transientError{err}
+		err = n.err
+	}
+	return count, err
+}
+
+// progressReader reports the bytes read of a repo as RepoFetchProgress events.
+type progressReader struct {
+	ctx      context.Context
+	repo     string
+	r        io.Reader
+	bytes    int64
+	total    int64
+	reported time.Time
+}
+
+func newProgressReader(ctx context.Context, repo string, r io.Reader, total int64) *progressReader {
+	return &progressReader{ctx: ctx, repo: repo, r: r, total: total, reported: time.Now()}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	count, err := p.r.Read(b)
+	p.bytes += int64(count)
+	if now := time.Now(); now.Sub(p.reported) >= progressInterval || err == io.EOF {
+		p.reported = now
+		reportProgress(p.ctx, kftypes.ProgressEvent{
+			Type:  kftypes.RepoFetchProgress,
+			Repo:  p.repo,
+			Bytes: p.bytes,
+			Total: p.total,
+		})
+	}
+	return count, err
+}
+
+// retryFetch calls fetch until it succeeds, fails with an error which isn't transient, or has been attempted
+// as many times as r allows, backing off between attempts. Each attempt is bounded by the timeout of r and
+// starts with dir, where fetch writes the repo, removed so that no partial content is left over.
+func retryFetch(ctx context.Context, r Repo, dir string, fetch func(ctx context.Context) error) error {
+	repo := r.Name
+	attempts := r.Attempts
+	if attempts <= 0 {
+		attempts = intFromEnv(RepoAttemptsEnv, defaultRepoAttempts)
+	}
+	timeout := DurationFromEnv(RepoTimeoutEnv, defaultRepoTimeout)
+	if r.Timeout != nil && r.Timeout.Duration > 0 {
+		timeout = r.Timeout.Duration
+	}
+	backoff := repoRetryBackoff
+	for attempt := 1; ; attempt++ {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := fetch(attemptCtx)
+		timedOut := attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if timedOut {
+			err = &transientError{fmt.Errorf("timed out after %v: %v", timeout, err)}
+		}
+		if !isTransient(err) || attempt >= attempts || ctx.Err() != nil {
+			if t, ok := err.(*transientError); ok {
+				return t.error
+			}
+			return err
+		}
+		log.Warnf("Attempt %v of %v to fetch repo %v failed; retrying in %v: %v", attempt, attempts, repo, backoff, err)
+		reportProgress(ctx, kftypes.ProgressEvent{
+			Type:    kftypes.RepoRetryScheduled,
+			Repo:    repo,
+			RetryIn: backoff,
+			Err:     err,
+		})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// syncRepos calls syncRepo for each repo, $KFCTL_REPO_CONCURRENCY at a time, and returns their caches in the
+// order of repos. Once a repo fails the others are cancelled and the error of the first failure is returned;
+// the caches of the repos synced by then are still returned.
+func syncRepos(ctx context.Context, repos []Repo, syncRepo func(ctx context.Context, r Repo) (*Cache, error)) ([]*Cache, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	caches := make([]*Cache, len(repos))
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, intFromEnv(RepoConcurrencyEnv, defaultRepoConcurrency))
+	for i, r := range repos {
+		wg.Add(1)
+		go func(i int, r Repo) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			cache, err := syncRepo(ctx, r)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			caches[i] = cache
+		}(i, r)
+	}
+	wg.Wait()
+	if firstErr == nil {
+		// The parent context was cancelled before any repo failed.
+		firstErr = ctx.Err()
+	}
+	return caches, firstErr
+}
+
+// intFromEnv returns the positive integer in the environment variable name, or def if it's unset or invalid.
+func intFromEnv(name string, def int) int {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i <= 0 {
+		log.Errorf("Ignoring invalid %v %v; using %v", name, s, def)
+		return def
+	}
+	return i
+}
+
+// DurationFromEnv returns the positive duration in the environment variable name, or def if it's unset or
+// invalid.
+func DurationFromEnv(name string, def time.Duration) time.Duration {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		log.Errorf("Ignoring invalid %v %v; using %v", name, s, def)
+		return def
+	}
+	return d
+}
+
+// wrapTransient returns a KfError explaining err with msg, which is transient if err is.
+func wrapTransient(err error, msg string) error {
+	kfErr := &kfapis.KfError{
+		Code:    int(kfapis.INVALID_ARGUMENT),
+		Message: fmt.Sprintf("%v: %v", msg, err),
+	}
+	if isTransient(err) {
+		kfErr.Code = int(kfapis.INTERNAL_ERROR)
+		return &transientError{kfErr}
+	}
+	return kfErr
+}
@@ -0,0 +1,128 @@
+package kfconfig
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	kftypes "github.com/kubeflow/kfctl/v3/pkg/apis/apps"
+)
+
+func TestSyncCacheRetries(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-sync-retries")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Each archive fails as many times as its path says before it's served; /missing is never found.
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests[req.URL.Path]++
+		count := requests[req.URL.Path]
+		mu.Unlock()
+		switch {
+		case req.URL.Path == "/missing":
+			http.NotFound(w, req)
+		case strings.HasPrefix(req.URL.Path, "/fail-once/") && count == 1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			w.Write(testArchive(path.Base(req.URL.Path)))
+		}
+	}))
+	defer server.Close()
+
+	var events []kftypes.ProgressEvent
+	ctx := kftypes.WithProgressSink(context.Background(), kftypes.ProgressSinkFunc(func(e kftypes.ProgressEvent) {
+		events = append(events, e)
+	}))
+	config := &KfConfig{
+		Spec: KfConfigSpec{
+			AppDir: path.Join(testDir, "app"),
+			Repos: []Repo{
+				{Name: "first", URI: server.URL + "/fail-once/first"},
+				{Name: "second", URI: server.URL + "/second"},
+			},
+		},
+	}
+	if err := config.SyncCacheContext(ctx); err != nil {
+		t.Fatalf("Could not sync cache; %v", err)
+	}
+	var names []string
+	for i, cache := range config.Status.Caches {
+		names = append(names, cache.Name)
+		content, err := ioutil.ReadFile(path.Join(cache.LocalPath, "version"))
+		if err != nil || string(content) != config.Spec.Repos[i].Name {
+			t.Errorf("Cache %v: got content %q (%v); want %q", cache.Name, content, err, config.Spec.Repos[i].Name)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"first", "second"}) {
+		t.Errorf("Caches: got %v; want them in the order of the repos", names)
+	}
+	if requests["/fail-once/first"] != 2 || requests["/second"] != 1 {
+		t.Errorf("Requests: got %v; want first retried once", requests)
+	}
+	retries, fetched := 0, 0
+	for _, e := range events {
+		switch e.Type {
+		case kftypes.RepoRetryScheduled:
+			retries++
+			if e.Repo != "first" || e.Err == nil {
+				t.Errorf("Got retry %+v; want a retry of first", e)
+			}
+		case kftypes.RepoFetched:
+			fetched++
+			if e.Err != nil {
+				t.Errorf("Got fetch %+v; want it to succeed", e)
+			}
+		}
+	}
+	if retries != 1 || fetched != 2 {
+		t.Errorf("Got %v retries and %v fetches; want 1 and 2", retries, fetched)
+	}
+
+	// Errors which aren't transient aren't retried, and leave nothing behind.
+	missing := &KfConfig{
+		Spec: KfConfigSpec{
+			AppDir: path.Join(testDir, "missing"),
+			Repos:  []Repo{{Name: "missing", URI: server.URL + "/missing"}},
+		},
+	}
+	if err := missing.SyncCache(); err == nil {
+		t.Fatalf("Missing archive: expected an error got none")
+	}
+	if requests["/missing"] != 1 {
+		t.Errorf("Missing archive: got %v requests; want 1", requests["/missing"])
+	}
+	if _, err := os.Stat(path.Join(testDir, "missing", DefaultCacheDir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("Missing archive: expected no cache to be left; got %v", err)
+	}
+	if len(missing.Status.Caches) != 0 {
+		t.Errorf("Missing archive: got caches %+v; want none", missing.Status.Caches)
+	}
+
+	// A repo attempted only once gives up on a transient error, which isn't blamed on its spec.
+	once := &KfConfig{
+		Spec: KfConfigSpec{
+			AppDir: path.Join(testDir, "once"),
+			Repos:  []Repo{{Name: "once", URI: server.URL + "/fail-once/once", Attempts: 1}},
+		},
+	}
+	err = once.SyncCache()
+	if kfErr, ok := err.(*kfapis.KfError); !ok || kfErr.Code != int(kfapis.INTERNAL_ERROR) {
+		t.Errorf("Single attempt: got error %v; want an internal error", err)
+	}
+	if requests["/fail-once/once"] != 1 {
+		t.Errorf("Single attempt: got %v requests; want 1", requests["/fail-once/once"])
+	}
+}
@@ -1,9 +1,9 @@
 package kfconfig
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/ghodss/yaml"
 	"github.com/hashicorp/go-getter/helper/url"
@@ -75,20 +75,130 @@ type KfConfigSpec struct {
 	Plugins      []Plugin      `json:"plugins,omitempty"`
 	Secrets      []Secret      `json:"secrets,omitempty"`
 	Repos        []Repo        `json:"repos,omitempty"`
+	// ApplyPolicy is the default apply policy for all applications.
+	ApplyPolicy *ApplyPolicy `json:"applyPolicy,omitempty"`
+	// ImageOverrides rewrites the images of all applications, e.g. to pull them from a mirror registry.
+	ImageOverrides *ImageOverrides `json:"imageOverrides,omitempty"`
+	// GlobalParameters are set on every application; the parameters of an application override them.
+	GlobalParameters []NameValue `json:"globalParameters,omitempty"`
+	// Variables are user-defined variables that parameter values can refer to as ${<name>}.
+	Variables []NameValue `json:"variables,omitempty"`
 }
 
 // Application defines an application to install
 type Application struct {
 	Name            string           `json:"name,omitempty"`
 	KustomizeConfig *KustomizeConfig `json:"kustomizeConfig,omitempty"`
+	// Enabled set to false skips the application and deletes the resources it applied before.
+	// Applications are enabled by default.
+	Enabled *bool `json:"enabled,omitempty"`
+	// When holds conditions on the cluster that must all hold for the application to be applied.
+	When *ApplicationConditions `json:"when,omitempty"`
+}
+
+// ApplicationConditions are evaluated against the discovery API of the cluster before render.
+// Unset conditions always hold.
+type ApplicationConditions struct {
+	// APIResourceExists is a resource that must be served, as <group>/<version>/<kind>,
+	// e.g. route.openshift.io/v1/Route, or <version>/<kind> for the core group.
+	APIResourceExists string `json:"apiResourceExists,omitempty"`
+	// ClusterVersion constrains the Kubernetes version of the cluster, e.g. ">= 1.20".
+	// The operator is one of >=, >, <=, <, == and !=, and defaults to ==.
+	ClusterVersion string `json:"clusterVersion,omitempty"`
+	// Platform is the platform of the cluster, openshift or kubernetes.
+	Platform string `json:"platform,omitempty"`
+}
+
+// IsEnabled tells whether the application is rendered and applied.
+func (a *Application) IsEnabled() bool {
+	return a.Enabled == nil || *a.Enabled
 }
 
 type KustomizeConfig struct {
 	RepoRef    *RepoRef    `json:"repoRef,omitempty"`
 	Overlays   []string    `json:"overlays,omitempty"`
 	Parameters []NameValue `json:"parameters,omitempty"`
+	// PatchesStrategicMerge are strategic merge patches, written inline as YAML, applied on top of the application.
+	PatchesStrategicMerge []string `json:"patchesStrategicMerge,omitempty"`
+	// PatchesJson6902 are JSON patches applied to single objects of the application.
+	PatchesJson6902 []PatchJson6902 `json:"patchesJson6902,omitempty"`
+	// Images overrides the names, tags or digests of the images used by the application.
+	Images []Image `json:"images,omitempty"`
+	// Fields of the apply policy set here take precedence over the KfDef-wide ApplyPolicy.
+	ApplyPolicy `json:",inline"`
+}
+
+// PatchJson6902 is a JSON patch (RFC 6902) applied to the object identified by Target.
+type PatchJson6902 struct {
+	Target PatchTarget `json:"target"`
+	// Patch is the list of patch operations, as YAML or JSON.
+	Patch string `json:"patch"`
+}
+
+// PatchTarget identifies an object of an application by its name before any name prefix or suffix is added.
+type PatchTarget struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Image overrides an image used by an application.
+type Image struct {
+	// Name is the image name to override, without tag or digest.
+	Name string `json:"name"`
+	// NewName replaces the name of the image.
+	NewName string `json:"newName,omitempty"`
+	// NewTag replaces the tag of the image.
+	NewTag string `json:"newTag,omitempty"`
+	// Digest replaces the tag of the image with a digest; NewTag is ignored if it is set.
+	Digest string `json:"digest,omitempty"`
+}
+
+// ApplyPolicy controls how an application is applied to the cluster.
+type ApplyPolicy struct {
+	// Timeout bounds the total time spent applying the application, including retries and waiting.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Retry controls how failed applies are retried.
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Wait controls what to wait for once the resources have been applied.
+	Wait WaitPolicy `json:"wait,omitempty"`
 }
 
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of apply attempts; 0 means retry until the timeout.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// InitialInterval is the delay before the first retry; later delays grow exponentially.
+	InitialInterval *metav1.Duration `json:"initialInterval,omitempty"`
+	// MaxInterval caps the delay between two retries.
+	MaxInterval *metav1.Duration `json:"maxInterval,omitempty"`
+}
+
+// ImageOverrides rewrites the images of the containers, init containers and image parameters of all applications.
+// An image matching an exact mapping isn't rewritten by the prefix mappings.
+type ImageOverrides struct {
+	// Prefixes maps an image prefix to its replacement, e.g. gcr.io/kubeflow-images-public to
+	// registry.example.com/kubeflow. The longest matching prefix wins.
+	Prefixes map[string]string `json:"prefixes,omitempty"`
+	// Images maps an image to its replacement. An image given with a tag or digest only matches that tag or
+	// digest; otherwise the tag or digest of the matched image is kept unless the replacement sets one.
+	Images map[string]string `json:"images,omitempty"`
+	// Digests pins images, named as they are once rewritten and without a tag, to a digest such as sha256:<hex>.
+	Digests map[string]string `json:"digests,omitempty"`
+}
+
+type WaitPolicy string
+
+const (
+	// WaitNone doesn't wait once the resources have been applied.
+	WaitNone WaitPolicy = "none"
+	// WaitCreated waits until every resource exists in the cluster.
+	WaitCreated WaitPolicy = "created"
+	// WaitReady waits until workloads are available, jobs have completed and CRDs are established.
+	WaitReady WaitPolicy = "ready"
+)
+
 type RepoRef struct {
 	Name string `json:"name,omitempty"`
 	Path string `json:"path,omitempty"`
@@ -97,6 +207,16 @@ type RepoRef struct {
 type NameValue struct {
 	Name  string `json:"name,omitempty"`
 	Value string `json:"value,omitempty"`
+	// ValueFrom reads the value from a ConfigMap or Secret in the namespace of the KfDef instead of Value.
+	ValueFrom *ValueSource `json:"valueFrom,omitempty"`
+}
+
+// ValueSource refers to the key of a ConfigMap or Secret holding the value of a parameter, or to one of the
+// secrets of the KfDef. Exactly one of its fields must be set.
+type ValueSource struct {
+	ConfigMapKeyRef *v1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	SecretKeyRef    *v1.SecretKeySelector    `json:"secretKeyRef,omitempty"`
+	KfDefSecretRef  *v1.LocalObjectReference `json:"kfdefSecretRef,omitempty"`
 }
 
 type Plugin struct {
@@ -117,6 +237,16 @@ type SecretSource struct {
 	LiteralSource *LiteralSource `json:"literalSource,omitempty"`
 	HashedSource  *HashedSource  `json:"hashedSource,omitempty"`
 	EnvSource     *EnvSource     `json:"envSource,omitempty"`
+	// SecretKeyRef reads the secret from a Kubernetes Secret in the namespace of the KfDef.
+	SecretKeyRef *v1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+	// Generated generates a random secret once, before the KfDef is rendered, and stores it in a Kubernetes Secret
+	// owned by the KfDef.
+	Generated *GeneratedSource `json:"generated,omitempty"`
+}
+
+type GeneratedSource struct {
+	Length  int    `json:"length,omitempty"`
+	Charset string `json:"charset,omitempty"`
 }
 
 type LiteralSource struct {
@@ -146,11 +276,70 @@ type Repo struct {
 	// Can use any URI understood by go-getter:
 	// https://github.com/hashicorp/go-getter/blob/master/README.md#installation-and-usage
 	URI string `json:"uri,omitempty"`
+	// Git clones the repository from git instead of fetching URI.
+	Git *GitSource `json:"git,omitempty"`
+	// PullSecret refers to a Secret of type kubernetes.io/dockerconfigjson in the namespace of the KfDef holding
+	// the credentials of the registry of an oci:// URI. Defaults to the docker config of the user.
+	PullSecret *v1.LocalObjectReference `json:"pullSecret,omitempty"`
+	// CredentialsSecret refers to a Secret in the namespace of the KfDef holding the credentials URI, or the URL
+	// of Git, is downloaded with over http(s): a bearer token, a username and password, a client certificate
+	// and key as tls.crt and tls.key, and a CA bundle as ca.crt. Defaults to the credentials kfctl reads from
+	// the environment or the netrc file of the user.
+	CredentialsSecret *v1.LocalObjectReference `json:"credentialsSecret,omitempty"`
+	// SHA256 is the hex-encoded sha256 checksum the archive downloaded from URI must have.
+	SHA256 string `json:"sha256,omitempty"`
+	// Signature is a detached signature the archive downloaded from URI must match.
+	Signature *RepoSignature `json:"signature,omitempty"`
+	// Refresh is when the repository is fetched again once synced. Defaults to $KFCTL_REPO_REFRESH, or else
+	// ifChanged.
+	Refresh RefreshPolicy `json:"refresh,omitempty"`
+	// Timeout bounds each attempt to fetch the repository. Defaults to $KFCTL_REPO_TIMEOUT, or else 10m.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Attempts is the number of times fetching the repository is attempted before giving up. Defaults to
+	// $KFCTL_REPO_ATTEMPTS, or else 3.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// RefreshPolicy is when a synced repository is fetched again.
+type RefreshPolicy string
+
+const (
+	// RefreshAlways fetches the repository on every sync.
+	RefreshAlways RefreshPolicy = "always"
+	// RefreshIfChanged fetches the repository again when a cheap check finds it changed: a conditional GET of
+	// its archive, git ls-remote of its ref or a HEAD of the manifest of its tag. Repositories pinned by checksum,
+	// commit or digest don't change.
+	RefreshIfChanged RefreshPolicy = "ifChanged"
+	// RefreshNever keeps the repository as first synced until its source changes.
+	RefreshNever RefreshPolicy = "never"
+)
+
+// RepoSignature is a detached signature of the archive of a Repo, checked before it's unpacked.
+type RepoSignature struct {
+	// URI of the signature, raw or base64-encoded. RSA and ECDSA signatures are of the sha256 digest of the
+	// archive, as made by openssl dgst -sha256 -sign; Ed25519 signatures are of the archive itself.
+	URI string `json:"uri,omitempty"`
+	// PublicKey is the PEM-encoded RSA, ECDSA or Ed25519 public key the signature is checked with.
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// GitSource is a git repository a Repo is cloned from.
+type GitSource struct {
+	// URL of the repository: any URL git can fetch from, including the path of a local repository.
+	URL string `json:"url,omitempty"`
+	// Ref is the branch, tag or commit to check out. Defaults to the HEAD of the repository.
+	// The commit a branch or tag resolves to is recorded; it is fetched again as the Refresh of the repo says.
+	Ref string `json:"ref,omitempty"`
+	// SubDir is the directory of the repository holding the manifests. Defaults to its root.
+	SubDir string `json:"subDir,omitempty"`
 }
 
 type Status struct {
-	Conditions []Condition `json:"conditions,omitempty"`
-	Caches     []Cache     `json:"caches,omitempty"`
+	Conditions   []Condition         `json:"conditions,omitempty"`
+	Caches       []Cache             `json:"caches,omitempty"`
+	Applications []ApplicationStatus `json:"applications,omitempty"`
+	// Variables records the values the variables and cluster facts referred to by parameters were resolved to.
+	Variables []NameValue `json:"variables,omitempty"`
 }
 
 type Condition struct {
@@ -171,6 +360,35 @@ type Condition struct {
 type Cache struct {
 	Name      string `json:"name,omitempty"`
 	LocalPath string `json:"localPath,omitempty"`
+	// Ref is the git ref the cache was cloned at, or the oci:// URI it was pulled from.
+	Ref string `json:"ref,omitempty"`
+	// Commit is the commit Ref resolved to when the cache was cloned.
+	Commit string `json:"commit,omitempty"`
+	// Digest is the digest of the manifest of the OCI artifact the cache was pulled from, or the digest of the
+	// archive it was downloaded from.
+	Digest string `json:"digest,omitempty"`
+	// Verified is set when the archive was verified against the sha256 or signature of its repo.
+	Verified bool `json:"verified,omitempty"`
+	// Source is the URI, or git URL, the cache was fetched from.
+	Source string `json:"source,omitempty"`
+	// ETag and LastModified are the validators of the archive the cache was downloaded from, if it had any.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// FetchedAt is when the cache was fetched.
+	FetchedAt *metav1.Time `json:"fetchedAt,omitempty"`
+}
+
+// ApplicationStatus is the state of an application as of its last successful apply.
+type ApplicationStatus struct {
+	Name string `json:"name"`
+	// Hash aggregates the desired-state hashes of all objects of the application.
+	Hash string `json:"hash,omitempty"`
+	// Disabled is set once the application is disabled and its resources are deleted.
+	Disabled bool `json:"disabled,omitempty"`
+	// Skipped is set when the when conditions of the application didn't hold at the last apply.
+	Skipped bool `json:"skipped,omitempty"`
+	// Reason explains the outcome of the when conditions of the application.
+	Reason string `json:"reason,omitempty"`
 }
 
 type PluginKindType string
@@ -227,6 +445,69 @@ func (c *KfConfig) GetRepoCache(repoName string) (Cache, bool) {
 	return Cache{}, false
 }
 
+// GetApplicationStatus returns the status of the application with the name and true if it was recorded.
+func (c *KfConfig) GetApplicationStatus(appName string) (ApplicationStatus, bool) {
+	for _, a := range c.Status.Applications {
+		if a.Name == appName {
+			return a, true
+		}
+	}
+	return ApplicationStatus{}, false
+}
+
+// GetResolvedVariable returns the value variable name was resolved to by the last render recording it.
+func (c *KfConfig) GetResolvedVariable(name string) (string, bool) {
+	for _, v := range c.Status.Variables {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetResolvedVariable records the value variable name was resolved to.
+func (c *KfConfig) SetResolvedVariable(name string, value string) {
+	for i, v := range c.Status.Variables {
+		if v.Name == name {
+			c.Status.Variables[i].Value = value
+			return
+		}
+	}
+	c.Status.Variables = append(c.Status.Variables, NameValue{Name: name, Value: value})
+}
+
+// getCache returns the cache of the repo name.
+func (c *KfConfig) getCache(name string) (Cache, bool) {
+	for _, cache := range c.Status.Caches {
+		if cache.Name == name {
+			return cache, true
+		}
+	}
+	return Cache{}, false
+}
+
+// setCache records cache, replacing the cache of the repo with the same name.
+func (c *KfConfig) setCache(cache Cache) {
+	for i, existing := range c.Status.Caches {
+		if existing.Name == cache.Name {
+			c.Status.Caches[i] = cache
+			return
+		}
+	}
+	c.Status.Caches = append(c.Status.Caches, cache)
+}
+
+// SetApplicationStatus records status, replacing the status of the application with the same name.
+func (c *KfConfig) SetApplicationStatus(status ApplicationStatus) {
+	for i, a := range c.Status.Applications {
+		if a.Name == status.Name {
+			c.Status.Applications[i] = status
+			return
+		}
+	}
+	c.Status.Applications = append(c.Status.Applications, status)
+}
+
 func (c *KfConfig) GetPluginSpec(pluginKind PluginKindType, s interface{}) error {
 	for _, p := range c.Spec.Plugins {
 		if p.Kind != pluginKind {
@@ -406,6 +687,11 @@ func (c *KfConfig) SetPluginFailed(pluginKind PluginKindType, msg string) {
 
 // SyncCache will synchronize the local cache of any repositories.
 // On success the status is updated with pointers to the cache.
+// Repos with a git source are cloned at their ref rather than downloaded, which avoids the problems with
+// GitHub archive paths described below; the commit they were cloned at is recorded in their cache.
+// Repos with an oci:// URI are pulled from a registry; the digest of their manifest is recorded in their cache.
+// Downloaded, cloned and pulled repos are stored in the shared cache (see SharedCache) and the cache of the repo
+// in the AppDir links to their entry; repos pinned by checksum, commit or digest are only fetched once per host.
 //
 // TODO(jlewi): I'm not sure this handles head references correctly.
 // e.g. suppose we have a URI like
@@ -436,15 +722,21 @@ func (c *KfConfig) SetPluginFailed(pluginKind PluginKindType, msg string) {
 // kubeflow-manifests-${COMMIT}
 //
 func (c *KfConfig) SyncCache() error {
+	return c.SyncCacheContext(context.Background())
+}
+
+// SyncCacheContext is SyncCache with downloads bound to ctx.
+// Repos are fetched concurrently, each attempt bounded by a timeout and failed attempts retried with backoff;
+// see retryFetch and syncRepos. Their progress is reported to the ProgressSink of ctx.
+// A repo whose fetch fails or is interrupted leaves nothing in the cache so the next sync starts over.
+// If ctx carries a Lock (see WithLock), repos are synced at the versions it locks them at and the sync fails if
+// they can't be.
+func (c *KfConfig) SyncCacheContext(ctx context.Context) error {
 	if c.Spec.AppDir == "" {
 		return fmt.Errorf("AppDir must be specified")
 	}
 
 	appDir := c.Spec.AppDir
-	// Loop over all the repos and download them.
-	// TODO(https://github.com/kubeflow/kubeflow/issues/3545): We should check if we already have a local copy and
-	// not redownload it.
-
 	baseCacheDir := path.Join(appDir, DefaultCacheDir)
 	if _, err := os.Stat(baseCacheDir); os.IsNotExist(err) {
 		log.Infof("Creating directory %v", baseCacheDir)
@@ -455,189 +747,283 @@ func (c *KfConfig) SyncCache() error {
 		}
 	}
 
+	names := map[string]bool{}
 	for _, r := range c.Spec.Repos {
-		cacheDir := path.Join(baseCacheDir, r.Name)
-
-		// Can we use a checksum or other mechanism to verify if the existing location is good?
-		// If there was a problem the first time around then removing it might provide a way to recover.
-		if _, err := os.Stat(cacheDir); err == nil {
-			// Check if the cache is up to date.
-			shouldSkip := false
-			for _, cache := range c.Status.Caches {
-				if cache.Name == r.Name && cache.LocalPath != "" {
-					shouldSkip = true
-					break
-				}
+		if names[r.Name] {
+			return &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("repo %v is listed more than once", r.Name),
 			}
-			if shouldSkip {
-				log.Infof("%v exists; not resyncing ", cacheDir)
-				continue
+		}
+		names[r.Name] = true
+		if _, err := r.refreshPolicy(); err != nil {
+			return &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("repo %v: %v", r.Name, err),
 			}
-
-			log.Infof("Deleting cachedir %v because Status.ReposCache is out of date", cacheDir)
-
-			// TODO(jlewi): The reason the cachedir might exist but not be stored in KfDef.status
-			// is because of a backwards compatibility path in which we download the cache to construct
-			// the KfDef. Specifically coordinator.CreateKfDefFromOptions is calling kftypes.DownloadFromCache
-			// We don't want to rely on that method to set the cache because we have logic
-			// below to set LocalPath that we don't want to duplicate.
-			// Unfortunately this means we end up fetching the repo twice which is very inefficient.
-			if err := os.RemoveAll(cacheDir); err != nil {
-				log.Errorf("There was a problem deleting directory %v; error %v", cacheDir, err)
-				return errors.WithStack(err)
+		}
+		if r.verifiesArchive() && (r.Git != nil || strings.HasPrefix(r.URI, OCIScheme)) {
+			return &kfapis.KfError{
+				Code: int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("repo %v sets sha256 or signature, which verify archives downloaded from uri; "+
+					"pin git repos to a commit and OCI artifacts to a digest instead", r.Name),
 			}
 		}
+	}
 
-		u, err := url.Parse(r.URI)
-
+	repos := c.Spec.Repos
+	if lock := LockFrom(ctx); lock != nil {
+		pinned, err := lock.pinRepos(repos)
 		if err != nil {
-			log.Errorf("Could not parse URI %v; error %v", r.URI, err)
-			return errors.WithStack(err)
+			return err
 		}
+		repos = pinned
+	}
 
-		log.Infof("Fetching %v to %v", r.URI, cacheDir)
-		if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
-			log.Errorf("Could not create dir %v; error %v", cacheDir, err)
-			return errors.WithStack(err)
+	// The caches are only recorded once all repos are synced; until then they're only read.
+	caches, err := syncRepos(ctx, repos, func(ctx context.Context, r Repo) (*Cache, error) {
+		cacheDir := path.Join(baseCacheDir, r.Name)
+		var cache *Cache
+		var err error
+		switch {
+		case r.Git != nil:
+			cache, err = c.syncGitRepo(ctx, r, cacheDir)
+		case strings.HasPrefix(r.URI, OCIScheme):
+			cache, err = c.syncOCIRepo(ctx, r, cacheDir)
+		default:
+			cache, err = c.syncArchiveRepo(ctx, r, cacheDir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The cache is also recorded next to it so that it's kept when the status isn't.
+		if err := writeCacheRecord(cacheDir, cache); err != nil {
+			log.Warnf("Couldn't record the cache of repo %v: %v", r.Name, err)
 		}
+		return cache, nil
+	})
+	for _, cache := range caches {
+		if cache != nil {
+			c.setCache(*cache)
+		}
+	}
+	return err
+}
 
-		// Manifests are local dir
-		if fi, err := os.Stat(r.URI); err == nil && fi.Mode().IsDir() {
-			// check whether the cache directory is a sub directory of manifests
-			absCacheDir, err := filepath.Abs(cacheDir)
-			if err != nil {
-				return errors.WithStack(err)
+// syncArchiveRepo downloads and unpacks the archive r.URI refers to into cacheDir, or copies it if it's a
+// local directory. Archives are streamed into the extractor, unless they must be verified first in which case
+// they're spooled to the shared cache and verified before they're unpacked.
+func (c *KfConfig) syncArchiveRepo(ctx context.Context, r Repo, cacheDir string) (*Cache, error) {
+	// Can we use a checksum or other mechanism to verify if the existing location is good?
+	// If there was a problem the first time around then removing it might provide a way to recover.
+	if _, err := os.Stat(cacheDir); err == nil {
+		// Check if the cache is up to date.
+		// A cache that wasn't verified, or was verified against another checksum, is out of date.
+		if cache, ok := c.recordedCache(r.Name, cacheDir); ok && cache.LocalPath != "" && sameSource(cache, r.URI) {
+			if !r.verifiesArchive() || cache.Verified && (r.SHA256 == "" || cache.Digest == r.expectedDigest()) {
+				if !c.archiveChanged(ctx, r, cache) {
+					log.Infof("%v is up to date; not resyncing ", cacheDir)
+					return &cache, nil
+				}
 			}
+		}
 
-			absURI, err := filepath.Abs(r.URI)
-			if err != nil {
-				return errors.WithStack(err)
-			}
+		log.Infof("Deleting cachedir %v because it is out of date", cacheDir)
+
+		// TODO(jlewi): The reason the cachedir might exist but not be stored in KfDef.status
+		// is because of a backwards compatibility path in which we download the cache to construct
+		// the KfDef. Specifically coordinator.CreateKfDefFromOptions is calling kftypes.DownloadFromCache
+		// We don't want to rely on that method to set the cache because we have logic
+		// below to set LocalPath that we don't want to duplicate.
+		// Unfortunately this means we end up fetching the repo twice which is very inefficient.
+		if err := os.RemoveAll(cacheDir); err != nil {
+			log.Errorf("There was a problem deleting directory %v; error %v", cacheDir, err)
+			return nil, errors.WithStack(err)
+		}
+	}
 
-			relDir, err := filepath.Rel(absURI, absCacheDir)
-			if err != nil {
-				return errors.WithStack(err)
-			}
+	u, err := url.Parse(r.URI)
 
-			if !strings.HasPrefix(relDir, ".."+string(filepath.Separator)) {
-				return errors.WithStack(errors.New("SyncCache: could not sync cache when the cache path " + cacheDir + " is sub directory of manifests " + r.URI))
-			}
+	if err != nil {
+		log.Errorf("Could not parse URI %v; error %v", r.URI, err)
+		return nil, errors.WithStack(err)
+	}
+
+	log.Infof("Fetching %v to %v", r.URI, cacheDir)
 
-			if err := copy.Copy(r.URI, cacheDir); err != nil {
-				return errors.WithStack(err)
+	// Manifests are local dir
+	digest := ""
+	var etag, lastModified string
+	if fi, err := os.Stat(r.URI); err == nil && fi.Mode().IsDir() {
+		if r.verifiesArchive() {
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("repo %v sets sha256 or signature but %v is a directory, not an archive", r.Name, r.URI),
 			}
-		} else {
-			t := &http.Transport{
-				Proxy: http.ProxyFromEnvironment,
+		}
+		// check whether the cache directory is a sub directory of manifests
+		absCacheDir, err := filepath.Abs(cacheDir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		absURI, err := filepath.Abs(r.URI)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		relDir, err := filepath.Rel(absURI, absCacheDir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if !strings.HasPrefix(relDir, ".."+string(filepath.Separator)) {
+			return nil, errors.WithStack(errors.New("SyncCache: could not sync cache when the cache path " + cacheDir + " is sub directory of manifests " + r.URI))
+		}
+
+		if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+			log.Errorf("Could not create dir %v; error %v", cacheDir, err)
+			return nil, errors.WithStack(err)
+		}
+		if err := copy.Copy(r.URI, cacheDir); err != nil {
+			os.RemoveAll(cacheDir)
+			return nil, errors.WithStack(err)
+		}
+	} else {
+		creds, err := ReadCredentials(c.Namespace, r.CredentialsSecret, r.URI)
+		if err != nil {
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("couldn't read the credentials of repo %v: %v", r.Name, err),
 			}
-			t.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
-			t.RegisterProtocol("", http.NewFileTransport(http.Dir("/")))
-			hclient := &http.Client{Transport: t}
-			req, _ := http.NewRequest("GET", r.URI, nil)
-			req.Header.Set("User-Agent", "kfctl")
-			resp, err := hclient.Do(req)
-			if err != nil {
-				return &kfapis.KfError{
-					Code:    int(kfapis.INVALID_ARGUMENT),
-					Message: fmt.Sprintf("couldn't download URI %v: %v", r.URI, err),
-				}
+		}
+		hclient, err := creds.HTTPClient()
+		if err != nil {
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("invalid credentials of repo %v: %v", r.Name, err),
 			}
-			defer resp.Body.Close()
-
-			body, err := ioutil.ReadAll(resp.Body)
+		}
+		// Only an archive pinned by its checksum can be looked up before it's downloaded, and only among those
+		// downloaded from the same URI with the same credentials.
+		key := ""
+		var header http.Header
+		if r.SHA256 != "" {
+			digest = r.expectedDigest()
+			key = sharedCacheKey("archive", c.cacheScope(r, r.URI), digest)
+		}
+		err = populateCache(r.URI, key, cacheDir, func(dir string) (string, error) {
+			reportProgress(ctx, kftypesv3.ProgressEvent{Type: kftypesv3.RepoFetchStarted, Repo: r.Name})
+			var sum []byte
+			err := retryFetch(ctx, r, dir, func(ctx context.Context) error {
+				var err error
+				sum, digest, header, err = fetchArchive(ctx, hclient, creds, r, dir)
+				return err
+			})
+			reportProgress(ctx, kftypesv3.ProgressEvent{Type: kftypesv3.RepoFetched, Repo: r.Name, Err: err})
 			if err != nil {
-				log.Errorf("Could not read response body; error %v", err)
-				return errors.WithStack(err)
-			}
-			if err := untar(body, cacheDir); err != nil {
-				log.Errorf("Could not untar file %v; error %v", r.URI, err)
-				return errors.WithStack(err)
+				return "", err
 			}
+			// Unverified archives record their digest too, so that they can be locked.
+			digest = sha256DigestPrefix + hex.EncodeToString(sum)
+			return sharedCacheKey("archive", c.cacheScope(r, r.URI), digest), nil
+		})
+		if err != nil {
+			os.RemoveAll(cacheDir)
+			return nil, err
 		}
+		etag, lastModified = header.Get("ETag"), header.Get("Last-Modified")
+	}
 
-		// This is a bit of a hack to deal with the fact that GitHub tarballs
-		// can unpack to a directory containing the commit.
-		localPath := cacheDir
-		files, filesErr := ioutil.ReadDir(cacheDir)
-		if filesErr != nil {
-			log.Errorf("Error reading cachedir; error %v", filesErr)
-			return errors.WithStack(filesErr)
-		}
-		if u.Scheme == "http" || u.Scheme == "https" {
+	// This is a bit of a hack to deal with the fact that GitHub tarballs
+	// can unpack to a directory containing the commit.
+	localPath := cacheDir
+	files, filesErr := ioutil.ReadDir(cacheDir)
+	if filesErr != nil {
+		log.Errorf("Error reading cachedir; error %v", filesErr)
+		return nil, errors.WithStack(filesErr)
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		subdir := files[0].Name()
+		localPath = path.Join(cacheDir, subdir)
+		log.Infof("Updating localPath to %v", localPath)
+	} else if u.Scheme == "file" {
+		filePath := strings.TrimPrefix(r.URI, "file:")
+		log.Infof("Probing file path: %v", filePath)
+		if fileInfo, err := os.Stat(filePath); err != nil {
+			return nil, &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("couldn't stat the path %v: %v", filePath, err),
+			}
+		} else if !fileInfo.IsDir() {
 			subdir := files[0].Name()
 			localPath = path.Join(cacheDir, subdir)
 			log.Infof("Updating localPath to %v", localPath)
-		} else if u.Scheme == "file" {
-			filePath := strings.TrimPrefix(r.URI, "file:")
-			log.Infof("Probing file path: %v", filePath)
-			if fileInfo, err := os.Stat(filePath); err != nil {
-				return &kfapis.KfError{
-					Code:    int(kfapis.INVALID_ARGUMENT),
-					Message: fmt.Sprintf("couldn't stat the path %v: %v", filePath, err),
-				}
-			} else if !fileInfo.IsDir() {
-				subdir := files[0].Name()
-				localPath = path.Join(cacheDir, subdir)
-				log.Infof("Updating localPath to %v", localPath)
-			}
 		}
-
-		c.Status.Caches = append(c.Status.Caches, Cache{
-			Name:      r.Name,
-			LocalPath: localPath,
-		})
-
-		log.Infof("Fetch succeeded; LocalPath %v", localPath)
 	}
-	return nil
+
+	log.Infof("Fetch succeeded; LocalPath %v", localPath)
+	now := metav1.Now()
+	return &Cache{
+		Name:         r.Name,
+		LocalPath:    localPath,
+		Digest:       digest,
+		Verified:     digest != "" && r.verifiesArchive(),
+		Source:       r.URI,
+		ETag:         etag,
+		LastModified: lastModified,
+		FetchedAt:    &now,
+	}, nil
 }
 
-func untar(body []byte, cacheDir string) error {
-	gzf, err := gzip.NewReader(bytes.NewReader(body))
+// fetchArchive downloads the archive of r and unpacks it into dir, returning its sha256 checksum, if r
+// verifies its archive its digest, and the header of the response. Unverified archives are unpacked as they're downloaded; the others are
+// written next to dir and only unpacked once verified.
+func fetchArchive(ctx context.Context, client *http.Client, creds *Credentials, r Repo, dir string) ([]byte, string, http.Header, error) {
+	body, size, header, err := openDownload(ctx, client, r.URI, creds)
 	if err != nil {
-		return err
+		return nil, "", nil, wrapTransient(err, fmt.Sprintf("couldn't download URI %v", r.URI))
 	}
+	defer body.Close()
+	hash := sha256.New()
+	download := io.TeeReader(newProgressReader(ctx, r.Name, body, size), hash)
 
-	tarReader := tar.NewReader(gzf)
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
+	unpackErr := func(err error) error {
+		return wrapTransient(err, fmt.Sprintf("couldn't unpack the archive of repo %v downloaded from %v", r.Name, r.URI))
+	}
+	if !r.verifiesArchive() {
+		if err := extractArchiveReader(download, dir); err != nil {
+			return nil, "", nil, unpackErr(err)
 		}
-		if err != nil {
-			return err
+		// Drain what the extractor didn't need, e.g. the padding of a tar archive, to checksum all of it.
+		if _, err := io.Copy(ioutil.Discard, download); err != nil {
+			return nil, "", nil, unpackErr(err)
 		}
-		if header == nil {
-			continue
-		}
-
-		target := filepath.Join(cacheDir, header.Name)
-
-		switch header.Typeflag {
-
-		case tar.TypeDir:
-			if _, err := os.Stat(target); err != nil {
-				if err := os.MkdirAll(target, 0755); err != nil {
-					return err
-				}
-			}
-
-		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-
-			if _, err := io.Copy(f, tarReader); err != nil {
-				return err
-			}
+		return hash.Sum(nil), "", header, nil
+	}
 
-			if err := f.Close(); err != nil {
-				return err
-			}
-		}
+	archive := dir + ".archive"
+	defer os.Remove(archive)
+	f, err := os.Create(archive)
+	if err != nil {
+		return nil, "", nil, err
 	}
-	return nil
+	defer f.Close()
+	if _, err := io.Copy(f, download); err != nil {
+		return nil, "", nil, wrapTransient(err, fmt.Sprintf("couldn't download URI %v", r.URI))
+	}
+	sum := hash.Sum(nil)
+	digest, err := verifyArchive(ctx, client, creds, r, sum, archive)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, "", nil, err
+	}
+	if err := extractArchiveReader(f, dir); err != nil {
+		return nil, "", nil, unpackErr(err)
+	}
+	return sum, digest, header, nil
 }
 
 // GetSecret returns the specified secret or an error if the secret isn't specified.
@@ -655,6 +1041,12 @@ func (c *KfConfig) GetSecret(name string) (string, error) {
 		if s.SecretSource.EnvSource != nil {
 			return os.Getenv(s.SecretSource.EnvSource.Name), nil
 		}
+		if s.SecretSource.SecretKeyRef != nil {
+			return ReadSecretKeyRef(c.Namespace, s.SecretSource.SecretKeyRef)
+		}
+		if s.SecretSource.Generated != nil {
+			return ReadGeneratedSecret(c.Namespace, c.Name, s.Name)
+		}
 
 		return "", fmt.Errorf("No secret source provided for secret %v", name)
 	}
@@ -685,6 +1077,63 @@ func (c *KfConfig) GetApplicationParameter(appName string, paramName string) (st
 	return "", false
 }
 
+// GetApplyPolicy returns the apply policy of app. Fields set on the application take precedence over
+// Spec.ApplyPolicy; fields set on neither are left empty so the caller can apply its own defaults.
+func (c *KfConfig) GetApplyPolicy(app Application) ApplyPolicy {
+	policy := ApplyPolicy{}
+	if c.Spec.ApplyPolicy != nil {
+		c.Spec.ApplyPolicy.DeepCopyInto(&policy)
+	}
+	if app.KustomizeConfig == nil {
+		return policy
+	}
+
+	appPolicy := app.KustomizeConfig.ApplyPolicy.DeepCopy()
+	if appPolicy.Timeout != nil {
+		policy.Timeout = appPolicy.Timeout
+	}
+	if appPolicy.Wait != "" {
+		policy.Wait = appPolicy.Wait
+	}
+	if appPolicy.Retry != nil {
+		if policy.Retry == nil {
+			policy.Retry = &RetryPolicy{}
+		}
+		if appPolicy.Retry.MaxAttempts != 0 {
+			policy.Retry.MaxAttempts = appPolicy.Retry.MaxAttempts
+		}
+		if appPolicy.Retry.InitialInterval != nil {
+			policy.Retry.InitialInterval = appPolicy.Retry.InitialInterval
+		}
+		if appPolicy.Retry.MaxInterval != nil {
+			policy.Retry.MaxInterval = appPolicy.Retry.MaxInterval
+		}
+	}
+	return policy
+}
+
+// GetApplicationParameters returns the effective parameters of app: Spec.GlobalParameters, overridden by the
+// parameters of the application's KustomizeConfig.
+func (c *KfConfig) GetApplicationParameters(app Application) []NameValue {
+	params := append([]NameValue{}, c.Spec.GlobalParameters...)
+	if app.KustomizeConfig == nil {
+		return params
+	}
+	for _, p := range app.KustomizeConfig.Parameters {
+		overridden := false
+		for i := range params {
+			if params[i].Name == p.Name {
+				params[i] = p
+				overridden = true
+			}
+		}
+		if !overridden {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
 // addPatchStratgicMerge adds the patchFile to the strategic merge if it isn't already present.
 // Returns true if it is added
 func addPatchStratgicMerge(k *types.Kustomization, patchFile string) bool {
@@ -785,9 +1234,13 @@ func setApplicationParameterInConfigMap(kustomizeDir string, appName string, par
 		return errors.WithStack(errors.Wrapf(err, "Error while marshaling patch for configMap %v", configMapPath))
 	}
 
-	if err := ioutil.WriteFile(configMapPath, newContents, os.ModePerm); err != nil {
+	// Only the owner can read the patch since parameters may be read from Secrets.
+	if err := ioutil.WriteFile(configMapPath, newContents, 0600); err != nil {
 		return errors.WithStack(errors.Wrapf(err, "Error while writing patch file: %v", configMapPath))
 	}
+	if err := os.Chmod(configMapPath, 0600); err != nil {
+		return errors.WithStack(errors.Wrapf(err, "Error while restricting patch file: %v", configMapPath))
+	}
 
 	return nil
 }
@@ -1054,6 +1507,7 @@ func setParameter(parameters []NameValue, paramName string, value string) []Name
 
 	parameters[pIndex].Name = paramName
 	parameters[pIndex].Value = value
+	parameters[pIndex].ValueFrom = nil
 
 	return parameters
 }
@@ -15,6 +15,7 @@
 package kfconfig
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/ghodss/yaml"
 	"github.com/google/go-cmp/cmp"
@@ -25,11 +26,13 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"reflect"
 	"sigs.k8s.io/kustomize/v3/pkg/types"
 	"testing"
+	"time"
 )
 
 func TestSyncCache(t *testing.T) {
@@ -203,6 +206,139 @@ func TestSyncCache(t *testing.T) {
 
 }
 
+func TestSyncCacheGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	ctx := context.Background()
+	testDir, err := ioutil.TempDir("", "kfctl-sync-git")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	git := func(dir string, args ...string) string {
+		args = append([]string{"-c", "user.name=kfctl", "-c", "user.email=kfctl@example.com"}, args...)
+		out, err := runGit(ctx, dir, args...)
+		if err != nil {
+			t.Fatalf("Failed to run git; %v", err)
+		}
+		return out
+	}
+	workDir := path.Join(testDir, "work")
+	remote := path.Join(testDir, "manifests.git")
+	commit := func(content string) string {
+		os.MkdirAll(path.Join(workDir, "kustomize"), os.ModePerm)
+		ioutil.WriteFile(path.Join(workDir, "kustomize", "version"), []byte(content), os.ModePerm)
+		git(workDir, "add", "-A")
+		git(workDir, "commit", "--quiet", "-m", content)
+		git(workDir, "push", "--quiet", "--tags", remote, "master")
+		return git(workDir, "rev-parse", "HEAD")
+	}
+	git("", "init", "--quiet", "--bare", remote)
+	git("", "init", "--quiet", workDir)
+	git(workDir, "symbolic-ref", "HEAD", "refs/heads/master")
+	v1 := commit("v1")
+	git(workDir, "tag", "v1")
+	v2 := commit("v2")
+
+	config := &KfConfig{
+		Spec: KfConfigSpec{
+			AppDir: path.Join(testDir, "app"),
+			Repos: []Repo{{
+				Name: "manifests",
+				Git:     &GitSource{URL: remote, Ref: "master", SubDir: "kustomize"},
+				Refresh: RefreshNever,
+			}},
+		},
+	}
+	cacheDir := path.Join(testDir, "app", DefaultCacheDir, "manifests")
+	// check returns when the cache was fetched.
+	check := func(step string, ref string, commit string, content string) metav1.Time {
+		if err := config.SyncCache(); err != nil {
+			t.Fatalf("%v: could not sync cache; %v", step, err)
+		}
+		expected := []Cache{{
+			Name:      "manifests",
+			LocalPath: path.Join(cacheDir, "kustomize"),
+			Ref:       ref,
+			Commit:    commit,
+			Source:    remote,
+		}}
+		caches := []Cache{}
+		for _, cache := range config.Status.Caches {
+			if cache.FetchedAt == nil {
+				t.Fatalf("%v: cache %v has no fetch time", step, cache.Name)
+			}
+			cache.FetchedAt = nil
+			caches = append(caches, cache)
+		}
+		if !reflect.DeepEqual(caches, expected) {
+			t.Fatalf("%v: caches; got %+v; want %+v", step, caches, expected)
+		}
+		actual, err := ioutil.ReadFile(path.Join(cacheDir, "kustomize", "version"))
+		if err != nil || string(actual) != content {
+			t.Fatalf("%v: got content %q (%v); want %q", step, actual, err, content)
+		}
+		return *config.Status.Caches[0].FetchedAt
+	}
+
+	check("branch", "master", v2, "v2")
+
+	// Under the never policy, the recorded commit is kept while the ref doesn't change, even if the cache has
+	// to be cloned again.
+	v3 := commit("v3")
+	check("unchanged", "master", v2, "v2")
+	os.RemoveAll(cacheDir)
+	check("pinned", "master", v2, "v2")
+
+	config.Spec.Repos[0].Git.Ref = "v1"
+	check("tag", "v1", v1, "v1")
+
+	config.Spec.Repos[0].Git.Ref = v2[:12]
+	check("commit", v2[:12], v2, "v2")
+
+	// Under ifChanged, the ref is resolved with git ls-remote and cloned again once it moves.
+	config.Spec.Repos[0].Refresh = RefreshIfChanged
+	fetched := check("abbreviated", v2[:12], v2, "v2")
+	config.Spec.Repos[0].Git.Ref = "master"
+	check("moved", "master", v3, "v3")
+	v4 := commit("v4")
+	fetched = check("ifChanged", "master", v4, "v4")
+	if check("fresh", "master", v4, "v4") != fetched {
+		t.Fatalf("fresh: expected the clone to be kept")
+	}
+	config.Spec.Repos[0].Git.Ref = "v1"
+	fetched = check("tag", "v1", v1, "v1")
+	if check("fresh tag", "v1", v1, "v1") != fetched {
+		t.Fatalf("fresh tag: expected the clone to be kept")
+	}
+
+	config.Spec.Repos[0].Git.SubDir = "../kustomize"
+	config.Spec.Repos[0].Git.Ref = "master"
+	if err := config.SyncCache(); err == nil {
+		t.Fatalf("subDir outside of the repository: expected an error got none")
+	}
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Fatalf("subDir outside of the repository: expected the cache to be removed")
+	}
+
+	// Refs and URLs that git would take for options are rejected.
+	config.Spec.Repos[0].Git.SubDir = ""
+	config.Spec.Repos[0].Git.Ref = "--upload-pack=touch " + path.Join(testDir, "injected")
+	if err := config.SyncCache(); err == nil {
+		t.Fatalf("ref starting with a dash: expected an error got none")
+	}
+	if _, err := os.Stat(path.Join(testDir, "injected")); !os.IsNotExist(err) {
+		t.Fatalf("ref starting with a dash: expected git not to run the upload pack")
+	}
+	config.Spec.Repos[0].Git.Ref = "master"
+	config.Spec.Repos[0].Git.URL = "--upload-pack=touch " + path.Join(testDir, "injected")
+	if err := config.SyncCache(); err == nil {
+		t.Fatalf("url starting with a dash: expected an error got none")
+	}
+}
+
 type FakePluginSpec struct {
 	Param     string `json:"param,omitempty"`
 	BoolParam bool   `json:"boolParam,omitempty"`
@@ -1230,3 +1366,120 @@ func Pformat(value interface{}) (string, error) {
 	}
 	return string(valueJson), nil
 }
+
+func TestKfConfig_GetApplyPolicy(t *testing.T) {
+	type testCase struct {
+		spec     *ApplyPolicy
+		app      Application
+		expected ApplyPolicy
+	}
+	minute := &metav1.Duration{Duration: time.Minute}
+	second := &metav1.Duration{Duration: time.Second}
+	testCases := []testCase{
+		{
+			app:      Application{Name: "app"},
+			expected: ApplyPolicy{},
+		},
+		{
+			spec:     &ApplyPolicy{Timeout: minute, Wait: WaitCreated},
+			app:      Application{Name: "app", KustomizeConfig: &KustomizeConfig{}},
+			expected: ApplyPolicy{Timeout: minute, Wait: WaitCreated},
+		},
+		{
+			spec: &ApplyPolicy{
+				Timeout: minute,
+				Wait:    WaitCreated,
+				Retry:   &RetryPolicy{MaxAttempts: 5, MaxInterval: minute},
+			},
+			app: Application{
+				Name: "app",
+				KustomizeConfig: &KustomizeConfig{
+					ApplyPolicy: ApplyPolicy{
+						Wait:  WaitReady,
+						Retry: &RetryPolicy{InitialInterval: second},
+					},
+				},
+			},
+			expected: ApplyPolicy{
+				Timeout: minute,
+				Wait:    WaitReady,
+				Retry:   &RetryPolicy{MaxAttempts: 5, InitialInterval: second, MaxInterval: minute},
+			},
+		},
+	}
+	for _, c := range testCases {
+		config := &KfConfig{Spec: KfConfigSpec{ApplyPolicy: c.spec}}
+		actual := config.GetApplyPolicy(c.app)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("GetApplyPolicy(%v) got %v; want %v", c.app.Name, actual, c.expected)
+		}
+	}
+}
+
+func TestKfConfig_GetApplicationParameters(t *testing.T) {
+	type testCase struct {
+		global   []NameValue
+		app      Application
+		expected []NameValue
+	}
+	testCases := []testCase{
+		{
+			app:      Application{Name: "app"},
+			expected: []NameValue{},
+		},
+		{
+			global:   []NameValue{{Name: "hostname", Value: "kf.example.com"}},
+			app:      Application{Name: "app"},
+			expected: []NameValue{{Name: "hostname", Value: "kf.example.com"}},
+		},
+		{
+			global: []NameValue{
+				{Name: "hostname", Value: "kf.example.com"},
+				{Name: "storageClass", Value: "standard"},
+			},
+			app: Application{
+				Name: "app",
+				KustomizeConfig: &KustomizeConfig{
+					Parameters: []NameValue{
+						{Name: "storageClass", Value: "fast"},
+						{Name: "replicas", Value: "2"},
+					},
+				},
+			},
+			expected: []NameValue{
+				{Name: "hostname", Value: "kf.example.com"},
+				{Name: "storageClass", Value: "fast"},
+				{Name: "replicas", Value: "2"},
+			},
+		},
+		{
+			global: []NameValue{
+				{
+					Name: "clientID",
+					ValueFrom: &ValueSource{SecretKeyRef: &v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: "oauth"},
+						Key:                  "clientID",
+					}},
+				},
+			},
+			app: Application{
+				Name: "app",
+				KustomizeConfig: &KustomizeConfig{
+					Parameters: []NameValue{{Name: "clientID", Value: "app-client"}},
+				},
+			},
+			expected: []NameValue{{Name: "clientID", Value: "app-client"}},
+		},
+	}
+	for _, c := range testCases {
+		config := &KfConfig{Spec: KfConfigSpec{GlobalParameters: c.global}}
+		actual := config.GetApplicationParameters(c.app)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("GetApplicationParameters(%v) got %v; want %v", c.app.Name, actual, c.expected)
+		}
+	}
+	// The global parameters must not be modified by the applications overriding them.
+	if len(testCases[2].global) != 2 || testCases[2].global[1].Value != "standard" {
+		t.Errorf("GetApplicationParameters modified the global parameters: %v", testCases[2].global)
+	}
+}
@@ -0,0 +1,164 @@
+package kfconfig
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+	log "github.com/sirupsen/logrus"
+)
+
+// verifiesArchive tells whether the archive of r must be verified before it's unpacked.
+func (r Repo) verifiesArchive() bool {
+	return r.SHA256 != "" || r.Signature != nil
+}
+
+// expectedDigest returns the digest of the archive of r its checksum requires.
+func (r Repo) expectedDigest() string {
+	return sha256DigestPrefix + strings.ToLower(strings.TrimPrefix(r.SHA256, sha256DigestPrefix))
+}
+
+// verifyArchive checks the archive downloaded from the URI of r, whose sha256 checksum is sum, against the
+// checksum and signature of r and returns its digest. archive is the path of the archive; it's only read to
+// check ed25519 signatures, which sign the whole archive rather than its checksum.
+func verifyArchive(ctx context.Context, client *http.Client, creds *Credentials, r Repo, sum []byte, archive string) (string, error) {
+	digest := sha256DigestPrefix + hex.EncodeToString(sum)
+	if r.SHA256 != "" && digest != r.expectedDigest() {
+		return "", &kfapis.KfError{
+			Code: int(kfapis.INVALID_ARGUMENT),
+			Message: fmt.Sprintf("the archive of repo %v downloaded from %v has sha256 %v; want %v",
+				r.Name, r.URI, strings.TrimPrefix(digest, sha256DigestPrefix), strings.TrimPrefix(r.expectedDigest(), sha256DigestPrefix)),
+		}
+	}
+	if r.Signature != nil {
+		if err := verifySignature(ctx, client, creds, r.Signature, archive, sum); err != nil {
+			if isTransient(err) {
+				return "", err
+			}
+			return "", &kfapis.KfError{
+				Code:    int(kfapis.INVALID_ARGUMENT),
+				Message: fmt.Sprintf("the signature of the archive of repo %v downloaded from %v is invalid: %v", r.Name, r.URI, err),
+			}
+		}
+	}
+	log.Infof("Verified the archive of repo %v; digest %v", r.Name, digest)
+	return digest, nil
+}
+
+// verifySignature checks the detached signature of archive, the path of an archive whose sha256 checksum is sum.
+func verifySignature(ctx context.Context, client *http.Client, creds *Credentials, signature *RepoSignature,
+	archive string, sum []byte) error {
+	block, _ := pem.Decode([]byte(signature.PublicKey))
+	if block == nil {
+		return fmt.Errorf("the public key isn't PEM-encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("couldn't parse the public key: %v", err)
+	}
+	sig, err := download(ctx, client, signature.URI, creds)
+	if err != nil {
+		if isTransient(err) {
+			return &transientError{fmt.Errorf("couldn't download the signature: %v", err)}
+		}
+		return fmt.Errorf("couldn't download the signature: %v", err)
+	}
+	// Signatures may be stored either raw or base64-encoded.
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+
+	verified := false
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		verified = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum, sig) == nil
+	case *ecdsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &rs); err == nil && len(rest) == 0 {
+			verified = ecdsa.Verify(key, sum, rs.R, rs.S)
+		}
+	case ed25519.PublicKey:
+		message, err := ioutil.ReadFile(archive)
+		if err != nil {
+			return err
+		}
+		verified = ed25519.Verify(key, message, sig)
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	if !verified {
+		return fmt.Errorf("the archive doesn't match the signature %v", signature.URI)
+	}
+	return nil
+}
+
+// newDownloadClient returns a client downloading http(s) and file URIs as well as local paths.
+func newDownloadClient() *http.Client {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+	t.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	t.RegisterProtocol("", http.NewFileTransport(http.Dir("/")))
+	return &http.Client{Transport: t}
+}
+
+// download returns the content of uri, an http(s) or file URI or a local path. The authorization of creds,
+// which may be nil, is only sent to their host.
+func download(ctx context.Context, client *http.Client, uri string, creds *Credentials) ([]byte, error) {
+	body, _, _, err := openDownload(ctx, client, uri, creds)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// openDownload starts downloading uri, as download does, and returns the body of the response, its length,
+// -1 if unknown, and its header. Network errors, including those reading the body, and server errors are
+// transient.
+func openDownload(ctx context.Context, client *http.Client, uri string, creds *Credentials) (io.ReadCloser, int64, http.Header, error) {
+	req, err := newDownloadRequest(uri, creds)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, nil, transientIfNetwork(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, nil, statusError("GET", uri, resp)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&networkReader{r: resp.Body}, resp.Body}, resp.ContentLength, resp.Header, nil
+}
+
+// newDownloadRequest returns a GET of uri sending the authorization of creds, which may be nil, if uri is on
+// their host.
+func newDownloadRequest(uri string, creds *Credentials) (*http.Request, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "kfctl")
+	if authorization := creds.authorization(); authorization != "" && req.URL.Host == creds.Host {
+		req.Header.Set("Authorization", authorization)
+	}
+	return req, nil
+}
@@ -0,0 +1,136 @@
+package kfconfig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	kfapis "github.com/kubeflow/kfctl/v3/pkg/apis"
+)
+
+func TestSyncCacheVerify(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "kfctl-sync-verify")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	archive := testArchive("v1")
+	archivePath := path.Join(testDir, "manifests.tar.gz")
+	if err := ioutil.WriteFile(archivePath, archive, 0644); err != nil {
+		t.Fatalf("Failed to write archive; %v", err)
+	}
+	sum := sha256.Sum256(archive)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	// signature writes sig, base64-encoded if encode is set, and returns a signature checked with key.
+	signature := func(name string, key crypto.PublicKey, sig []byte, encode bool) *RepoSignature {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatalf("Failed to marshal public key; %v", err)
+		}
+		if encode {
+			sig = []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+		}
+		sigPath := path.Join(testDir, name+".sig")
+		if err := ioutil.WriteFile(sigPath, sig, 0644); err != nil {
+			t.Fatalf("Failed to write signature; %v", err)
+		}
+		return &RepoSignature{
+			URI:       "file:" + sigPath,
+			PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		}
+	}
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaSig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:])
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r, s, _ := ecdsa.Sign(rand.Reader, ecdsaKey, sum[:])
+	ecdsaSig, _ := asn1.Marshal(struct{ R, S interface{} }{r, s})
+	ed25519Public, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	ed25519Sig := ed25519.Sign(ed25519Key, archive)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	type testCase struct {
+		Name string
+		Repo Repo
+		Err  bool
+	}
+	cases := []testCase{
+		{
+			Name: "sha256",
+			Repo: Repo{URI: "file:" + archivePath, SHA256: hex.EncodeToString(sum[:])},
+		},
+		{
+			Name: "sha256-mismatch",
+			Repo: Repo{URI: "file:" + archivePath, SHA256: hex.EncodeToString(make([]byte, sha256.Size))},
+			Err:  true,
+		},
+		{
+			Name: "rsa",
+			Repo: Repo{URI: "file:" + archivePath, Signature: signature("rsa", &rsaKey.PublicKey, rsaSig, false)},
+		},
+		{
+			Name: "ecdsa",
+			Repo: Repo{URI: "file:" + archivePath, Signature: signature("ecdsa", &ecdsaKey.PublicKey, ecdsaSig, true)},
+		},
+		{
+			Name: "ed25519",
+			Repo: Repo{URI: "file:" + archivePath, Signature: signature("ed25519", ed25519Public, ed25519Sig, true)},
+		},
+		{
+			Name: "wrong-key",
+			Repo: Repo{URI: "file:" + archivePath, Signature: signature("wrong-key", &otherKey.PublicKey, rsaSig, false)},
+			Err:  true,
+		},
+		{
+			Name: "directory",
+			Repo: Repo{URI: testDir, SHA256: hex.EncodeToString(sum[:])},
+			Err:  true,
+		},
+	}
+
+	for _, c := range cases {
+		c.Repo.Name = "manifests"
+		config := &KfConfig{
+			Spec: KfConfigSpec{
+				AppDir: path.Join(testDir, "app-"+c.Name),
+				Repos:  []Repo{c.Repo},
+			},
+		}
+		cacheDir := path.Join(testDir, "app-"+c.Name, DefaultCacheDir, "manifests")
+		err := config.SyncCache()
+		if c.Err {
+			kfErr, ok := err.(*kfapis.KfError)
+			if !ok || kfErr.Code != int(kfapis.INVALID_ARGUMENT) {
+				t.Errorf("Case %v; expected an invalid argument error got %v", c.Name, err)
+			}
+			if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+				t.Errorf("Case %v; expected the cache to be removed", c.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case %v; unexpected error: %v", c.Name, err)
+			continue
+		}
+		if len(config.Status.Caches) != 1 || config.Status.Caches[0].Digest != digest {
+			t.Errorf("Case %v; got caches %+v; want digest %v", c.Name, config.Status.Caches, digest)
+		}
+		if _, err := os.Stat(path.Join(cacheDir, "kustomize", "version")); err != nil {
+			t.Errorf("Case %v; archive wasn't unpacked: %v", c.Name, err)
+		}
+	}
+}
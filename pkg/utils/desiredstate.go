@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// DesiredHashAnnotation is the annotation recording the hash of the desired state an object was last applied with.
+const DesiredHashAnnotation = KfDefAnnotation + "/" + DesiredStateHash
+
+// DesiredHash returns the hash of the desired state of obj. The DesiredHashAnnotation itself isn't part of it.
+func DesiredHash(obj *unstructured.Unstructured) (string, error) {
+	content := obj.Object
+	if _, ok := obj.GetAnnotations()[DesiredHashAnnotation]; ok {
+		copied := obj.DeepCopy()
+		anns := copied.GetAnnotations()
+		delete(anns, DesiredHashAnnotation)
+		if len(anns) == 0 {
+			anns = nil
+		}
+		copied.SetAnnotations(anns)
+		content = copied.Object
+	}
+	// encoding/json sorts map keys, so equal objects always encode to the same bytes.
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("couldn't encode %v %v: %v", obj.GetKind(), obj.GetName(), err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SetDesiredHash records the hash of the desired state of obj in its DesiredHashAnnotation and returns it.
+func SetDesiredHash(obj *unstructured.Unstructured) (string, error) {
+	hash, err := DesiredHash(obj)
+	if err != nil {
+		return "", err
+	}
+	anns := obj.GetAnnotations()
+	if anns == nil {
+		anns = map[string]string{}
+	}
+	anns[DesiredHashAnnotation] = hash
+	obj.SetAnnotations(anns)
+	return hash, nil
+}
+
+// AggregateHash combines the desired hashes of the objects of an application, keyed by object, into one hash.
+func AggregateHash(objectHashes map[string]string) string {
+	keys := make([]string, 0, len(objectHashes))
+	for k := range objectHashes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%v=%v\n", k, objectHashes[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IsUpToDate tells whether live was applied with the desired state of desired and hasn't drifted from it since.
+// desired must carry its DesiredHashAnnotation. Drift means a field set in desired has another value in live;
+// fields only present in live, e.g. defaults and status, are ignored.
+func IsUpToDate(live *unstructured.Unstructured, desired *unstructured.Unstructured) bool {
+	hash, ok := desired.GetAnnotations()[DesiredHashAnnotation]
+	if !ok || live.GetAnnotations()[DesiredHashAnnotation] != hash {
+		return false
+	}
+	return containsFields(live.Object, desired.Object)
+}
+
+// containsFields tells whether every field set in desired has the same value in live.
+// Lists must have the same length and match element-wise. Nulls in desired match anything.
+func containsFields(live interface{}, desired interface{}) bool {
+	switch d := desired.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range d {
+			lv, found := l[k]
+			if !found {
+				if v == nil {
+					continue
+				}
+				return false
+			}
+			if !containsFields(lv, v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return false
+		}
+		for i := range d {
+			if !containsFields(l[i], d[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		if dn, ok := toFloat(desired); ok {
+			ln, ok := toFloat(live)
+			return ok && ln == dn
+		}
+		return reflect.DeepEqual(live, desired)
+	}
+}
+
+// toFloat converts the numeric types produced by the JSON and YAML decoders to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newDeployment(replicas interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":              "app",
+			"creationTimestamp": nil,
+		},
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app", "image": "app:v1"},
+					},
+				},
+			},
+		},
+	}}
+}
+
+func TestSetDesiredHash(t *testing.T) {
+	obj := newDeployment(int64(1))
+	hash, err := SetDesiredHash(obj)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if obj.GetAnnotations()[DesiredHashAnnotation] != hash {
+		t.Errorf("Annotation %v not set to %v", DesiredHashAnnotation, hash)
+	}
+	// Hashing an annotated object again must not change the hash.
+	again, err := SetDesiredHash(obj)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if again != hash {
+		t.Errorf("Hash changed after annotating: %v != %v", again, hash)
+	}
+	other, _ := DesiredHash(newDeployment(int64(2)))
+	if other == hash {
+		t.Errorf("Different objects have the same hash %v", hash)
+	}
+}
+
+func TestIsUpToDate(t *testing.T) {
+	type testCase struct {
+		name     string
+		live     func(live *unstructured.Unstructured)
+		expected bool
+	}
+	testCases := []testCase{
+		{
+			name:     "unchanged",
+			live:     func(live *unstructured.Unstructured) {},
+			expected: true,
+		},
+		{
+			name: "server-defaults",
+			live: func(live *unstructured.Unstructured) {
+				live.SetCreationTimestamp(metav1.Now())
+				unstructured.SetNestedField(live.Object, "RollingUpdate", "spec", "strategy", "type")
+				unstructured.SetNestedField(live.Object, int64(1), "status", "readyReplicas")
+			},
+			expected: true,
+		},
+		{
+			name: "float-replicas",
+			live: func(live *unstructured.Unstructured) {
+				unstructured.SetNestedField(live.Object, float64(1), "spec", "replicas")
+			},
+			expected: true,
+		},
+		{
+			name: "drifted",
+			live: func(live *unstructured.Unstructured) {
+				unstructured.SetNestedField(live.Object, int64(3), "spec", "replicas")
+			},
+			expected: false,
+		},
+		{
+			name: "other-hash",
+			live: func(live *unstructured.Unstructured) {
+				anns := live.GetAnnotations()
+				anns[DesiredHashAnnotation] = "outdated"
+				live.SetAnnotations(anns)
+			},
+			expected: false,
+		},
+	}
+	for _, c := range testCases {
+		desired := newDeployment(int64(1))
+		if _, err := SetDesiredHash(desired); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		live := desired.DeepCopy()
+		c.live(live)
+		if actual := IsUpToDate(live, desired); actual != c.expected {
+			t.Errorf("Case %v: IsUpToDate got %v; want %v", c.name, actual, c.expected)
+		}
+	}
+}
+
+func TestAggregateHash(t *testing.T) {
+	a := AggregateHash(map[string]string{"Deployment//a": "1", "Service//b": "2"})
+	b := AggregateHash(map[string]string{"Service//b": "2", "Deployment//a": "1"})
+	if a != b {
+		t.Errorf("AggregateHash depends on the order of the objects")
+	}
+	if a == AggregateHash(map[string]string{"Deployment//a": "1", "Service//b": "3"}) {
+		t.Errorf("AggregateHash ignores object hashes")
+	}
+}
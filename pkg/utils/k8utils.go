@@ -34,6 +34,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	k8stypes "k8s.io/apimachinery/pkg/types"
 	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
@@ -67,6 +68,7 @@ const (
 	SetAnnotation              = "set-kubeflow-annotation"
 	KfDefInstance              = "kfdef-instance"
 	InstallByOperator          = "install-by-operator"
+	DesiredStateHash           = "desired-hash"
 )
 
 func generateRandStr(length int) string {
@@ -259,6 +261,18 @@ type Apply struct {
 	options                     *kubectlapply.ApplyOptions
 	tmpfile                     *os.File
 	stdin                       *os.File
+	observer                    ApplyObserver
+	// keepTmpfile tells cleanup to leave tmpfile in place, for files ApplyFile was given.
+	keepTmpfile bool
+}
+
+// ApplyObserver is called for every object kubectl applied with the operation it performed:
+// created, configured, unchanged or serverside-applied.
+type ApplyObserver func(obj runtime.Object, operation string)
+
+// SetObserver registers observer for the objects applied by subsequent applies. A nil observer removes it.
+func (a *Apply) SetObserver(observer ApplyObserver) {
+	a.observer = observer
 }
 
 func NewApply(namespace string, restConfig *rest.Config) (*Apply, error) {
@@ -297,6 +311,18 @@ func NewApply(namespace string, restConfig *rest.Config) (*Apply, error) {
 	return apply, nil
 }
 
+// DefaultNamespace returns the namespace kubectl applies objects without a namespace to: the namespace of the
+// current kubeconfig context or, in a pod, the namespace of the pod.
+func (a *Apply) DefaultNamespace() (string, error) {
+	namespace, _, err := a.factory.ToRawKubeConfigLoader().Namespace()
+	return namespace, err
+}
+
+// RESTMapper returns the mapper kubectl uses to resolve the kinds of the objects it applies.
+func (a *Apply) RESTMapper() (meta.RESTMapper, error) {
+	return a.factory.ToRESTMapper()
+}
+
 func (a *Apply) IfNamespaceExist(name string) bool {
 	_, nsMissingErr := a.clientset.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
 	if nsMissingErr != nil {
@@ -307,6 +333,51 @@ func (a *Apply) IfNamespaceExist(name string) bool {
 
 func (a *Apply) Apply(data []byte) error {
 	a.tmpfile = a.tempFile(data)
+	return a.applyTempFile()
+}
+
+// ApplyObjects applies every object of the stream. Objects are encoded into the temporary file
+// as they are produced so the manifests are never held in memory as a single document.
+func (a *Apply) ApplyObjects(objects ObjectStream) error {
+	tmpfile, err := ioutil.TempFile("/tmp", "kout")
+	if err != nil {
+		return &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("could not create temporary file: %v", err),
+		}
+	}
+	discard := func() {
+		tmpfile.Close()
+		os.Remove(tmpfile.Name())
+	}
+	if err := WriteObjects(tmpfile, objects); err != nil {
+		discard()
+		return err
+	}
+	if _, err := tmpfile.Seek(0, 0); err != nil {
+		discard()
+		return err
+	}
+	a.tmpfile = tmpfile
+	return a.applyTempFile()
+}
+
+// ApplyFile applies the manifests of the file name. The file is left in place so that it can be applied again,
+// e.g. on retries, without encoding its objects again.
+func (a *Apply) ApplyFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return &kfapis.KfError{
+			Code:    int(kfapis.INTERNAL_ERROR),
+			Message: fmt.Sprintf("could not open manifests %v: %v", name, err),
+		}
+	}
+	a.tmpfile = f
+	a.keepTmpfile = true
+	return a.applyTempFile()
+}
+
+func (a *Apply) applyTempFile() error {
 	a.stdin = os.Stdin
 	os.Stdin = a.tmpfile
 	defer a.cleanup()
@@ -349,9 +420,14 @@ func (a *Apply) run() error {
 func (a *Apply) cleanup() error {
 	os.Stdin = a.stdin
 	if a.tmpfile != nil {
+		keep := a.keepTmpfile
+		a.keepTmpfile = false
 		if err := a.tmpfile.Close(); err != nil {
 			return err
 		}
+		if keep {
+			return nil
+		}
 		return os.Remove(a.tmpfile.Name())
 	}
 	return nil
@@ -376,7 +452,14 @@ func (a *Apply) init() error {
 				return nil, err
 			}
 		}
-		return o.PrintFlags.ToPrinter()
+		printer, err := o.PrintFlags.ToPrinter()
+		if err != nil || a.observer == nil {
+			return printer, err
+		}
+		return printers.ResourcePrinterFunc(func(obj runtime.Object, w io.Writer) error {
+			a.observer(obj, operation)
+			return printer.PrintObj(obj, w)
+		}), nil
 	}
 	o.DiscoveryClient, err = f.ToDiscoveryClient()
 	if err != nil {
@@ -537,16 +620,20 @@ func DeleteResource(resourceBytes []byte, kubeclient client.Client, timeout time
 	if err != nil {
 		return err
 	}
-	unstructuredObject := &unstructured.Unstructured{
-		Object: resourceMap,
-	}
+	return DeleteObject(context.TODO(), &unstructured.Unstructured{Object: resourceMap}, kubeclient, timeout, byOperator)
+}
+
+// DeleteObject is DeleteResource for an already decoded object. Waiting for the deletion stops when ctx is cancelled.
+// The object is used as scratch space for the lookup and must not be reused by the caller.
+func DeleteObject(ctx context.Context, unstructuredObject *unstructured.Unstructured, kubeclient client.Client,
+	timeout time.Duration, byOperator bool) error {
 	name, namespace := unstructuredObject.GetName(), unstructuredObject.GetNamespace()
 
 	log.Infof("Deleting Kind '%s' in APIVersion '%s' with name '%s' in namespace '%s'",
 		unstructuredObject.GetKind(), unstructuredObject.GetAPIVersion(), name, namespace)
 
 	// Check if resource exists
-	err = kubeclient.Get(context.TODO(), k8stypes.NamespacedName{Name: name, Namespace: namespace}, unstructuredObject)
+	err := kubeclient.Get(ctx, k8stypes.NamespacedName{Name: name, Namespace: namespace}, unstructuredObject)
 	if k8serrors.IsNotFound(err) {
 		log.Warnf("Resource %s/%s not found", namespace, name)
 		return nil
@@ -571,23 +658,24 @@ func DeleteResource(resourceBytes []byte, kubeclient client.Client, timeout time
 
 	// Resource exists, try to delete
 	if unstructuredObject.GetDeletionTimestamp().IsZero() {
-		err = kubeclient.Delete(context.TODO(), unstructuredObject)
+		err = kubeclient.Delete(ctx, unstructuredObject)
 		if err != nil {
 			return errors.Wrapf(err, "Failed to delete resource %s/%s", namespace, name)
 		}
 	}
 
 	// Delete succeeded, poll until the delete is completed
-	interval := 5 * time.Second
-	b := backoff.WithMaxRetries(backoff.NewConstantBackOff(interval), uint64(timeout/interval+1))
-	err = backoff.Retry(func() error {
-		err := kubeclient.Get(context.TODO(), k8stypes.NamespacedName{Name: name, Namespace: namespace}, unstructuredObject.DeepCopy())
+	err = poll(ctx, timeout, func() error {
+		err := kubeclient.Get(ctx, k8stypes.NamespacedName{Name: name, Namespace: namespace}, unstructuredObject.DeepCopy())
 		if !k8serrors.IsNotFound(err) {
 			return errors.New("deleted resource is not cleaned up yet")
 		}
 		return nil
-	}, b)
+	})
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return errors.New(fmt.Sprintf("Timed out waiting for resource %s/%s to be deleted. Error %v", namespace, name, err))
 	}
 
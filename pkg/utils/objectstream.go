@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"io"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ObjectStream produces Kubernetes objects one at a time.
+// visit is called for every object in order; iteration stops at the first error returned by visit
+// and that error is returned to the caller. Streams over rendered manifests hand out the rendered objects
+// themselves and can only be consumed once; streams over a slice may be consumed more than once.
+type ObjectStream func(visit func(*unstructured.Unstructured) error) error
+
+// ObjectsFromSlice returns a stream over objs.
+func ObjectsFromSlice(objs []*unstructured.Unstructured) ObjectStream {
+	return func(visit func(*unstructured.Unstructured) error) error {
+		for _, obj := range objs {
+			if err := visit(obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ObjectWriter encodes objects as YAML documents to a writer one at a time.
+// Documents are separated by "---" so the output can be fed to kubectl.
+type ObjectWriter struct {
+	w       io.Writer
+	written int
+}
+
+// NewObjectWriter returns an ObjectWriter encoding to w.
+func NewObjectWriter(w io.Writer) *ObjectWriter {
+	return &ObjectWriter{w: w}
+}
+
+// Write encodes obj as the next document.
+func (o *ObjectWriter) Write(obj *unstructured.Unstructured) error {
+	out, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+	if o.written > 0 {
+		if _, err := io.WriteString(o.w, "---\n"); err != nil {
+			return err
+		}
+	}
+	if _, err := o.w.Write(out); err != nil {
+		return err
+	}
+	o.written++
+	return nil
+}
+
+// Written returns the number of objects written so far.
+func (o *ObjectWriter) Written() int {
+	return o.written
+}
+
+// WriteObjects encodes every object of the stream as a YAML document to w.
+// Documents are separated by "---" so the output can be fed to kubectl.
+func WriteObjects(w io.Writer, objects ObjectStream) error {
+	return objects(NewObjectWriter(w).Write)
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestWriteObjects(t *testing.T) {
+	objs := []*unstructured.Unstructured{
+		{Object: map[string]interface{}{"kind": "ConfigMap", "metadata": map[string]interface{}{"name": "a"}}},
+		{Object: map[string]interface{}{"kind": "Service", "metadata": map[string]interface{}{"name": "b"}}},
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteObjects(buf, ObjectsFromSlice(objs)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "kind: ConfigMap\nmetadata:\n  name: a\n---\nkind: Service\nmetadata:\n  name: b\n"
+	if buf.String() != expected {
+		t.Fatalf("Got '%s', Want '%s'.", buf.String(), expected)
+	}
+
+	resources, err := SplitYAML(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(resources) != len(objs) {
+		t.Fatalf("Got %v documents, Want %v.", len(resources), len(objs))
+	}
+}
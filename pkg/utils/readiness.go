@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IsObjectReady reports whether the live object obj is ready to serve.
+// Deployments, StatefulSets and DaemonSets are ready once all replicas of the current generation are available,
+// Jobs once they have succeeded, Pods and CRDs once their Ready/Established condition is true.
+// Objects of any other kind are ready as soon as they exist.
+func IsObjectReady(obj *unstructured.Unstructured) bool {
+	if generation, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration"); found &&
+		generation < obj.GetGeneration() {
+		return false
+	}
+
+	switch obj.GetKind() {
+	case "Deployment":
+		replicas := desiredReplicas(obj)
+		updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas")
+		available, _, _ := unstructured.NestedInt64(obj.Object, "status", "availableReplicas")
+		return updated >= replicas && available >= replicas
+	case "StatefulSet":
+		ready, _, _ := unstructured.NestedInt64(obj.Object, "status", "readyReplicas")
+		return ready >= desiredReplicas(obj)
+	case "DaemonSet":
+		desired, _, _ := unstructured.NestedInt64(obj.Object, "status", "desiredNumberScheduled")
+		ready, _, _ := unstructured.NestedInt64(obj.Object, "status", "numberReady")
+		return ready >= desired
+	case "Job":
+		completions, found, _ := unstructured.NestedInt64(obj.Object, "spec", "completions")
+		if !found {
+			completions = 1
+		}
+		succeeded, _, _ := unstructured.NestedInt64(obj.Object, "status", "succeeded")
+		return succeeded >= completions
+	case "Pod":
+		return hasTrueCondition(obj, "Ready")
+	case "CustomResourceDefinition":
+		return hasTrueCondition(obj, "Established")
+	}
+	return true
+}
+
+func desiredReplicas(obj *unstructured.Unstructured) int64 {
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		return 1
+	}
+	return replicas
+}
+
+func hasTrueCondition(obj *unstructured.Unstructured, condType string) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if cond["type"] == condType {
+			return cond["status"] == "True"
+		}
+	}
+	return false
+}
+
+// WaitForObject polls until obj exists in the cluster or, if ready is true, until IsObjectReady reports it ready.
+// It gives up after timeout or when ctx is cancelled.
+func WaitForObject(ctx context.Context, obj *unstructured.Unstructured, kubeclient client.Client, ready bool,
+	timeout time.Duration) error {
+	name, namespace := obj.GetName(), obj.GetNamespace()
+	err := poll(ctx, timeout, func() error {
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(obj.GroupVersionKind())
+		err := kubeclient.Get(ctx, k8stypes.NamespacedName{Name: name, Namespace: namespace}, live)
+		if k8serrors.IsNotFound(err) {
+			return fmt.Errorf("resource is not created yet")
+		}
+		if err != nil {
+			return err
+		}
+		if ready && !IsObjectReady(live) {
+			return fmt.Errorf("resource is not ready yet")
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("Timed out waiting for %v %s/%s. Error %v", obj.GetKind(), namespace, name, err)
+	}
+	return nil
+}
+
+// pollInterval is how often poll runs its check.
+const pollInterval = 5 * time.Second
+
+// poll runs check every pollInterval until it succeeds, timeout passes or ctx is cancelled, and returns the
+// last error of check. check runs at least once, even if timeout has already passed.
+func poll(ctx context.Context, timeout time.Duration, check func() error) error {
+	pollCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return backoff.Retry(check, backoff.WithContext(backoff.NewConstantBackOff(pollInterval), pollCtx))
+}
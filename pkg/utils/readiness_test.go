@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIsObjectReady(t *testing.T) {
+	type testCase struct {
+		name     string
+		obj      map[string]interface{}
+		expected bool
+	}
+	testCases := []testCase{
+		{
+			name: "configmap",
+			obj: map[string]interface{}{
+				"kind": "ConfigMap",
+			},
+			expected: true,
+		},
+		{
+			name: "deployment-ready",
+			obj: map[string]interface{}{
+				"kind":     "Deployment",
+				"metadata": map[string]interface{}{"generation": int64(2)},
+				"spec":     map[string]interface{}{"replicas": int64(2)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(2),
+					"updatedReplicas":    int64(2),
+					"availableReplicas":  int64(2),
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "deployment-stale-generation",
+			obj: map[string]interface{}{
+				"kind":     "Deployment",
+				"metadata": map[string]interface{}{"generation": int64(3)},
+				"spec":     map[string]interface{}{"replicas": int64(2)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(2),
+					"updatedReplicas":    int64(2),
+					"availableReplicas":  int64(2),
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "deployment-unavailable",
+			obj: map[string]interface{}{
+				"kind":   "Deployment",
+				"spec":   map[string]interface{}{"replicas": int64(2)},
+				"status": map[string]interface{}{"updatedReplicas": int64(2), "availableReplicas": int64(1)},
+			},
+			expected: false,
+		},
+		{
+			name: "crd-established",
+			obj: map[string]interface{}{
+				"kind": "CustomResourceDefinition",
+				"status": map[string]interface{}{
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Established", "status": "True"},
+					},
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "crd-pending",
+			obj: map[string]interface{}{
+				"kind": "CustomResourceDefinition",
+			},
+			expected: false,
+		},
+	}
+	for _, c := range testCases {
+		actual := IsObjectReady(&unstructured.Unstructured{Object: c.obj})
+		if actual != c.expected {
+			t.Errorf("Case %v: IsObjectReady got %v; want %v", c.name, actual, c.expected)
+		}
+	}
+}
+
+func TestPollPastDeadline(t *testing.T) {
+	checks := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- poll(context.Background(), -10*time.Second, func() error {
+			checks++
+			return errors.New("not ready")
+		})
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expected an error once the deadline passed")
+		}
+		if checks != 1 {
+			t.Errorf("got %v checks; want 1", checks)
+		}
+	case <-time.After(time.Minute):
+		t.Fatalf("poll didn't return once the deadline passed")
+	}
+}